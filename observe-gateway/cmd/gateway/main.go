@@ -22,6 +22,8 @@ import (
 	"github.com/xscopehub/observe-gateway/internal/config"
 	"github.com/xscopehub/observe-gateway/internal/limiter"
 	"github.com/xscopehub/observe-gateway/internal/server"
+	"github.com/xscopehub/observe-gateway/internal/telemetry"
+	"github.com/xscopehub/observe-gateway/internal/templates"
 )
 
 func main() {
@@ -33,16 +35,33 @@ func main() {
 	if err != nil {
 		log.Fatalf("load config: %v", err)
 	}
+	if _, err := templates.Globals(cfg.QueryTemplates); err != nil {
+		log.Fatalf("load config: %v", err)
+	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	shutdownTracing, err := telemetry.SetupTracing(ctx, cfg.Telemetry.Tracing)
+	if err != nil {
+		log.Fatalf("init tracing: %v", err)
+	}
+	defer func() {
+		// Flush the spans of the last requests after ctx is cancelled.
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			log.Printf("flush traces: %v", err)
+		}
+	}()
+
 	authenticator, err := auth.New(cfg.Auth)
 	if err != nil {
 		log.Fatalf("init auth: %v", err)
 	}
 
-	redisClient, err := buildRedisClient(cfg.RateLimiter)
+	needRedis := cfg.RateLimiter.Enabled || cfg.RateLimiter.CostBudget.Enabled || (cfg.Cache.Enabled && cfg.Cache.L2.Enabled)
+	redisClient, err := buildRedisClient(cfg.RateLimiter, needRedis)
 	if err != nil {
 		log.Fatalf("init redis: %v", err)
 	}
@@ -56,20 +75,19 @@ func main() {
 		MaxCost:     cfg.Cache.MaxCost,
 		BufferItems: cfg.Cache.BufferItems,
 		TTL:         cfg.Cache.TTL,
+		LangTTL:     cfg.Cache.LangTTL,
+		TemplateTTL: cfg.Cache.TemplateTTL,
+		KeyPrefix:   cfg.Cache.L2.KeyPrefix,
+		Compression: cfg.Cache.L2.Compression,
+	}
+	if cfg.Cache.L2.Enabled {
+		cacheCfg.Redis = redisClient
 	}
 	cacheStore, err := cache.New(cacheCfg)
 	if err != nil {
 		log.Fatalf("init cache: %v", err)
 	}
-
-	limiterCfg := limiter.Config{
-		Enabled:           cfg.RateLimiter.Enabled,
-		RequestsPerSecond: cfg.RateLimiter.RequestsPerSecond,
-		Burst:             cfg.RateLimiter.Burst,
-		Window:            cfg.RateLimiter.Window,
-		Redis:             redisClient,
-	}
-	limit := limiter.New(limiterCfg)
+	defer cacheStore.Close()
 
 	backendClient, err := backend.New(ctx, cfg.Backends)
 	if err != nil {
@@ -77,9 +95,54 @@ func main() {
 	}
 	defer backendClient.Close()
 
-	auditLogger := audit.New(cfg.Audit.Enabled, os.Stdout)
+	var keyStore auth.KeyStore
+	if cfg.Auth.APIKeys.Enabled {
+		pool := backendClient.MetadataPool()
+		if pool == nil {
+			log.Fatalf("init api keys: backends.metadata must be enabled")
+		}
+		store, err := auth.NewPGKeyStore(ctx, pool, cfg.Auth.APIKeys.Table)
+		if err != nil {
+			log.Fatalf("init api keys: %v", err)
+		}
+		keyStore = store
+	}
+	apiKeys := auth.NewAPIKeys(keyStore, cfg.Auth.APIKeys.CacheTTL)
 
-	srv := server.New(cfg, authenticator, backendClient, cacheStore, limit, auditLogger)
+	var templateStore templates.Store
+	if cfg.TemplateStore.Enabled {
+		pool := backendClient.MetadataPool()
+		if pool == nil {
+			log.Fatalf("init template store: backends.metadata must be enabled")
+		}
+		store, err := templates.NewPGStore(ctx, pool, cfg.TemplateStore.Table)
+		if err != nil {
+			log.Fatalf("init template store: %v", err)
+		}
+		templateStore = store
+	}
+	templateRegistry := templates.NewRegistry(templateStore, cfg.TemplateStore.CacheTTL)
+
+	rateLimiter := limiter.New(limiterConfig(cfg.RateLimiter, backendClient, redisClient))
+
+	auditLogger, err := buildAuditLogger(ctx, cfg.Audit, backendClient)
+	if err != nil {
+		log.Fatalf("init audit: %v", err)
+	}
+	defer auditLogger.Close()
+
+	srv := server.New(cfg, auth.Chain{apiKeys, authenticator}, apiKeys, templateRegistry, backendClient, cacheStore, rateLimiter, auditLogger)
+
+	reloader := &reloader{
+		path:    configPath,
+		current: cfg,
+		backend: backendClient,
+		limiter: rateLimiter,
+		cache:   cacheStore,
+		server:  srv,
+		redis:   redisClient,
+	}
+	go reloader.watch(ctx, cfg.Server.ConfigWatchInterval)
 
 	log.Printf("query gateway listening on %s", cfg.Server.Address)
 	if err := srv.Run(ctx); err != nil {
@@ -89,8 +152,88 @@ func main() {
 	}
 }
 
-func buildRedisClient(cfg config.RateLimiterConfig) (redis.UniversalClient, error) {
-	if !cfg.Enabled || cfg.RedisAddr == "" {
+// buildAuditLogger assembles the configured audit sinks. The Postgres sink
+// shares the metadata database pool.
+func buildAuditLogger(ctx context.Context, cfg config.AuditConfig, backendClient *backend.Client) (*audit.Logger, error) {
+	var sinks []audit.Sink
+	if cfg.Enabled {
+		if cfg.Stdout {
+			sinks = append(sinks, audit.NewWriterSink(os.Stdout))
+		}
+		if cfg.File.Enabled {
+			sink, err := audit.NewFileSink(cfg.File.Path, int64(cfg.File.MaxSizeMB)<<20, cfg.File.MaxBackups)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		}
+		if cfg.Postgres.Enabled {
+			pool := backendClient.MetadataPool()
+			if pool == nil {
+				return nil, errors.New("postgres audit sink requires backends.metadata")
+			}
+			sink, err := audit.NewPGSink(ctx, pool, cfg.Postgres.Table)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		}
+		if cfg.OTLP.Enabled {
+			sink, err := audit.NewOTLPSink(cfg.OTLP.Endpoint, cfg.OTLP.Headers, cfg.OTLP.Timeout)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		}
+	}
+	return audit.NewLogger(audit.Config{
+		Enabled:       cfg.Enabled,
+		QueueSize:     cfg.QueueSize,
+		BatchSize:     cfg.BatchSize,
+		FlushInterval: cfg.FlushInterval,
+		BlockTimeout:  cfg.BlockTimeout,
+	}, sinks...), nil
+}
+
+// limiterConfig maps the rate_limiter section onto the limiter. Tenant
+// overrides and budgets are looked up in the metadata store.
+func limiterConfig(cfg config.RateLimiterConfig, backendClient *backend.Client, redisClient redis.UniversalClient) limiter.Config {
+	return limiter.Config{
+		Enabled:           cfg.Enabled,
+		RequestsPerSecond: cfg.RequestsPerSecond,
+		Burst:             cfg.Burst,
+		Tenants:           limits(cfg.Tenants),
+		Source:            backendClient,
+		PerUser:           limit(cfg.PerUser),
+		Users:             limits(cfg.Users),
+		Templates:         limits(cfg.Templates),
+		Langs:             limits(cfg.Langs),
+		Redis:             redisClient,
+		Budget: limiter.BudgetConfig{
+			Enabled: cfg.CostBudget.Enabled,
+			Limit:   cfg.CostBudget.Limit,
+			Window:  cfg.CostBudget.Window,
+			Source:  backendClient,
+		},
+	}
+}
+
+func limit(rule config.RateLimitRule) limiter.Limit {
+	return limiter.Limit{RequestsPerSecond: rule.RequestsPerSecond, Burst: rule.Burst}
+}
+
+func limits(rules map[string]config.RateLimitRule) map[string]limiter.Limit {
+	out := make(map[string]limiter.Limit, len(rules))
+	for name, rule := range rules {
+		out[name] = limit(rule)
+	}
+	return out
+}
+
+// buildRedisClient connects to the Redis configured under rate_limiter, which
+// the limiter, the cost budget and the L2 cache share.
+func buildRedisClient(cfg config.RateLimiterConfig, needed bool) (redis.UniversalClient, error) {
+	if !needed || cfg.RedisAddr == "" {
 		return nil, nil
 	}
 
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/xscopehub/observe-gateway/internal/backend"
+	"github.com/xscopehub/observe-gateway/internal/cache"
+	"github.com/xscopehub/observe-gateway/internal/config"
+	"github.com/xscopehub/observe-gateway/internal/limiter"
+	"github.com/xscopehub/observe-gateway/internal/server"
+	"github.com/xscopehub/observe-gateway/internal/templates"
+)
+
+// reloader applies configuration file changes to the running gateway on
+// SIGHUP or when the file changes on disk. A file that fails to load or
+// validate is rejected and the running configuration stays in place.
+type reloader struct {
+	path    string
+	backend *backend.Client
+	limiter *limiter.Limiter
+	cache   *cache.Cache
+	server  *server.Server
+	redis   redis.UniversalClient
+
+	mu      sync.Mutex
+	current config.Config
+}
+
+// watch reloads on SIGHUP and, with a positive interval, whenever the
+// file's modification time or size changes.
+func (r *reloader) watch(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	last, _ := os.Stat(r.path)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.reload()
+		case <-tick:
+			info, err := os.Stat(r.path)
+			if err != nil || (last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size()) {
+				continue
+			}
+			last = info
+			r.reload()
+		}
+	}
+}
+
+// reload loads the file and swaps the reloadable settings. Backends are
+// swapped first because they are the only step that can fail.
+func (r *reloader) reload() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := config.Load(r.path)
+	if err == nil {
+		_, err = templates.Globals(next.QueryTemplates)
+	}
+	if err != nil {
+		log.Printf("config reload rejected: %v", err)
+		return
+	}
+	if next.Hash() == r.current.Hash() {
+		return
+	}
+	for _, section := range restartOnly(r.current, next) {
+		log.Printf("config reload: %s changed; restart to apply", section)
+	}
+
+	if !reflect.DeepEqual(r.current.Backends, next.Backends) {
+		if err := r.backend.Reload(next.Backends); err != nil {
+			log.Printf("config reload rejected: backends: %v", err)
+			return
+		}
+	}
+	r.limiter.Reload(limiterConfig(next.RateLimiter, r.backend, r.redis))
+	r.cache.SetTTLs(next.Cache.TTL, next.Cache.LangTTL, next.Cache.TemplateTTL)
+	r.server.Reload(next)
+
+	r.current = next
+	log.Printf("config reloaded from %s (%s)", r.path, next.Hash()[:12])
+}
+
+// restartOnly lists the changed settings that are fixed at startup.
+func restartOnly(old, next config.Config) []string {
+	var changed []string
+	check := func(name string, a, b any) {
+		if !reflect.DeepEqual(a, b) {
+			changed = append(changed, name)
+		}
+	}
+	listener := func(c config.ServerConfig) [4]any {
+		return [4]any{c.Address, c.ReadTimeout, c.WriteTimeout, c.IdleTimeout}
+	}
+	redisConn := func(c config.RateLimiterConfig) config.RateLimiterConfig {
+		return config.RateLimiterConfig{
+			RedisAddr:          c.RedisAddr,
+			RedisUsername:      c.RedisUsername,
+			RedisPassword:      c.RedisPassword,
+			RedisDB:            c.RedisDB,
+			RedisTLSInsecure:   c.RedisTLSInsecure,
+			RedisTLSCA:         c.RedisTLSCA,
+			RedisTLSSkipVerify: c.RedisTLSSkipVerify,
+		}
+	}
+	cacheStore := func(c config.CacheConfig) [5]any {
+		return [5]any{c.Enabled, c.NumCounters, c.MaxCost, c.BufferItems, c.L2}
+	}
+
+	check("server listener", listener(old.Server), listener(next.Server))
+	check("server.config_watch_interval", old.Server.ConfigWatchInterval, next.Server.ConfigWatchInterval)
+	check("auth", old.Auth, next.Auth)
+	check("rate_limiter redis connection", redisConn(old.RateLimiter), redisConn(next.RateLimiter))
+	check("cache sizing and l2", cacheStore(old.Cache), cacheStore(next.Cache))
+	check("audit", old.Audit, next.Audit)
+	check("backends.metadata", old.Backends.Metadata, next.Backends.Metadata)
+	check("template_store", old.TemplateStore, next.TemplateStore)
+	check("telemetry", old.Telemetry, next.Telemetry)
+	return changed
+}
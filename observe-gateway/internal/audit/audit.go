@@ -1,53 +1,302 @@
 package audit
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Audit events.
+const (
+	EventQuery       = "query"
+	EventAuthFailure = "auth_failure"
+)
+
 // Entry describes a single audit log record.
 type Entry struct {
-	Tenant   string        `json:"tenant"`
-	User     string        `json:"user"`
-	Lang     string        `json:"lang"`
-	Query    string        `json:"query"`
-	Cost     int64         `json:"cost"`
-	Duration time.Duration `json:"duration"`
-	Cached   bool          `json:"cached"`
-	Backend  string        `json:"backend"`
-	Error    string        `json:"error,omitempty"`
-	Time     time.Time     `json:"time"`
-}
-
-// Logger emits audit entries in JSON format.
+	// Event is EventQuery unless set otherwise.
+	Event     string        `json:"event"`
+	Tenant    string        `json:"tenant"`
+	User      string        `json:"user"`
+	Lang      string        `json:"lang"`
+	Query     string        `json:"query"`
+	Cost      int64         `json:"cost"`
+	Duration  time.Duration `json:"duration"`
+	Cached    bool          `json:"cached"`
+	CacheTier string        `json:"cache_tier,omitempty"`
+	Coalesced bool          `json:"coalesced,omitempty"`
+	Backend   string        `json:"backend"`
+	Breaker   string        `json:"breaker,omitempty"`
+	// RateLimitRule names the rate limit rule that rejected the request.
+	RateLimitRule string `json:"rate_limit_rule,omitempty"`
+	Bytes         int64  `json:"bytes,omitempty"`
+	Rows          int64  `json:"rows,omitempty"`
+	// Status, RemoteAddr, AuthMethod and Credential attribute failed
+	// authentication attempts; Credential is an API key ID or the unverified
+	// subject of a JWT, never a secret.
+	Status     int       `json:"status,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	AuthMethod string    `json:"auth_method,omitempty"`
+	Credential string    `json:"credential,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// Sink persists batches of audit entries. Sinks must not retain the slice.
+type Sink interface {
+	Write(ctx context.Context, entries []Entry) error
+	Close() error
+}
+
+// Filter selects audit entries for Query.
+type Filter struct {
+	Tenant string
+	User   string
+	Event  string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// Reader is implemented by sinks that can be queried.
+type Reader interface {
+	Query(ctx context.Context, f Filter) ([]Entry, error)
+}
+
+// ErrNotQueryable is returned by Query when no sink supports reading.
+var ErrNotQueryable = errors.New("no queryable audit sink configured")
+
+// Config tunes asynchronous delivery. A zero QueueSize writes synchronously.
+type Config struct {
+	Enabled       bool
+	QueueSize     int
+	BatchSize     int
+	FlushInterval time.Duration
+	// BlockTimeout is how long Log waits for room in a full queue before
+	// dropping the entry.
+	BlockTimeout time.Duration
+}
+
+// Logger fans audit entries out to its sinks.
 type Logger struct {
 	enabled bool
-	mu      sync.Mutex
-	out     io.Writer
+	cfg     Config
+	sinks   []Sink
+
+	mu      sync.RWMutex
+	closed  bool
+	queue   chan Entry
+	done    chan struct{}
+	dropped atomic.Int64
 }
 
-// New creates a new audit logger writing to the provided writer.
+// New creates a synchronous audit logger writing JSON lines to out.
 func New(enabled bool, out io.Writer) *Logger {
-	if out == nil {
-		out = log.Writer()
+	return NewLogger(Config{Enabled: enabled}, NewWriterSink(out))
+}
+
+// NewLogger creates a logger writing to sinks. With a QueueSize, entries are
+// queued and written in batches by a background goroutine; Close flushes
+// them.
+func NewLogger(cfg Config, sinks ...Sink) *Logger {
+	l := &Logger{enabled: cfg.Enabled, cfg: cfg, sinks: sinks}
+	if !cfg.Enabled || cfg.QueueSize <= 0 {
+		return l
+	}
+	if l.cfg.BatchSize <= 0 {
+		l.cfg.BatchSize = 500
 	}
-	return &Logger{enabled: enabled, out: out}
+	if l.cfg.FlushInterval <= 0 {
+		l.cfg.FlushInterval = time.Second
+	}
+	l.queue = make(chan Entry, cfg.QueueSize)
+	l.done = make(chan struct{})
+	go l.run()
+	return l
 }
 
-// Log writes an audit entry if enabled.
+// Log records an audit entry if enabled, stamping it with the current time
+// when the caller did not.
 func (l *Logger) Log(entry Entry) {
-	if !l.enabled {
+	if l == nil || !l.enabled {
 		return
 	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
 	entry.Time = entry.Time.UTC()
-	data, err := json.Marshal(entry)
-	if err != nil {
+	if entry.Event == "" {
+		entry.Event = EventQuery
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		l.dropped.Add(1)
+		return
+	}
+	if l.queue == nil {
+		l.write([]Entry{entry})
+		return
+	}
+
+	select {
+	case l.queue <- entry:
 		return
+	default:
+	}
+	// The queue is full: apply back-pressure for a bounded time rather than
+	// stalling requests behind a slow sink.
+	timer := time.NewTimer(l.cfg.BlockTimeout)
+	defer timer.Stop()
+	select {
+	case l.queue <- entry:
+	case <-timer.C:
+		l.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of entries discarded because the queue was
+// full or the logger closed.
+func (l *Logger) Dropped() int64 {
+	if l == nil {
+		return 0
+	}
+	return l.dropped.Load()
+}
+
+// Query reads entries from the first sink that supports it.
+func (l *Logger) Query(ctx context.Context, f Filter) ([]Entry, error) {
+	if r := l.reader(); r != nil {
+		return r.Query(ctx, f)
+	}
+	return nil, ErrNotQueryable
+}
+
+// Queryable reports whether Query is supported.
+func (l *Logger) Queryable() bool {
+	return l.reader() != nil
+}
+
+func (l *Logger) reader() Reader {
+	if l == nil {
+		return nil
+	}
+	for _, sink := range l.sinks {
+		if r, ok := sink.(Reader); ok {
+			return r
+		}
+	}
+	return nil
+}
+
+// Close flushes queued entries and closes the sinks.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
 	}
 	l.mu.Lock()
-	defer l.mu.Unlock()
-	l.out.Write(append(data, '\n'))
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	if l.queue != nil {
+		close(l.queue)
+	}
+	l.mu.Unlock()
+
+	if l.done != nil {
+		<-l.done
+	}
+	var errs []error
+	for _, sink := range l.sinks {
+		errs = append(errs, sink.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (l *Logger) run() {
+	defer close(l.done)
+	ticker := time.NewTicker(l.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Entry, 0, l.cfg.BatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			l.write(batch)
+			batch = batch[:0]
+		}
+	}
+	for {
+		select {
+		case entry, ok := <-l.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, entry)
+			if len(batch) >= l.cfg.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (l *Logger) write(entries []Entry) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, sink := range l.sinks {
+		if err := sink.Write(ctx, entries); err != nil {
+			log.Printf("audit: write %d entries: %v", len(entries), err)
+		}
+	}
+}
+
+// WriterSink writes entries as JSON lines.
+type WriterSink struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewWriterSink writes to out, or to the standard logger's writer if nil.
+func NewWriterSink(out io.Writer) *WriterSink {
+	if out == nil {
+		out = log.Writer()
+	}
+	return &WriterSink{out: out}
+}
+
+// Write implements Sink.
+func (s *WriterSink) Write(_ context.Context, entries []Entry) error {
+	buf, err := marshalLines(entries)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.out.Write(buf)
+	return err
+}
+
+// Close implements Sink.
+func (s *WriterSink) Close() error { return nil }
+
+func marshalLines(entries []Entry) ([]byte, error) {
+	var buf []byte
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(append(buf, data...), '\n')
+	}
+	return buf, nil
 }
@@ -0,0 +1,157 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingSink struct {
+	mu      sync.Mutex
+	batches [][]Entry
+	block   chan struct{}
+}
+
+func (s *recordingSink) Write(_ context.Context, entries []Entry) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, append([]Entry(nil), entries...))
+	return nil
+}
+
+func (s *recordingSink) Close() error { return nil }
+
+func TestLoggerBatchesAndStampsTime(t *testing.T) {
+	sink := &recordingSink{}
+	logger := NewLogger(Config{Enabled: true, QueueSize: 100, BatchSize: 2, FlushInterval: time.Hour}, sink)
+	before := time.Now()
+	for i := 0; i < 5; i++ {
+		logger.Log(Entry{Tenant: "tenant-a"})
+	}
+	if err := logger.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	var sizes []int
+	for _, batch := range sink.batches {
+		sizes = append(sizes, len(batch))
+		for _, e := range batch {
+			if e.Time.Before(before) || e.Event != EventQuery {
+				t.Fatalf("entry = %+v, want a stamped query event", e)
+			}
+		}
+	}
+	if len(sizes) != 3 || sizes[0] != 2 || sizes[1] != 2 || sizes[2] != 1 {
+		t.Fatalf("batch sizes = %v, want [2 2 1]", sizes)
+	}
+	logger.Log(Entry{})
+	if logger.Dropped() != 1 {
+		t.Fatalf("Dropped() = %d after close, want 1", logger.Dropped())
+	}
+}
+
+func TestLoggerDropsWhenQueueStaysFull(t *testing.T) {
+	sink := &recordingSink{block: make(chan struct{})}
+	logger := NewLogger(Config{Enabled: true, QueueSize: 1, BatchSize: 1, FlushInterval: time.Hour, BlockTimeout: 10 * time.Millisecond}, sink)
+
+	// The first entry is taken by the writer, which blocks in the sink; the
+	// second fills the queue and the third times out.
+	logger.Log(Entry{})
+	for len(logger.queue) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	logger.Log(Entry{})
+	logger.Log(Entry{})
+	if logger.Dropped() != 1 {
+		t.Fatalf("Dropped() = %d, want 1", logger.Dropped())
+	}
+	close(sink.block)
+	logger.Close()
+	if len(sink.batches) != 2 {
+		t.Fatalf("batches = %d, want 2", len(sink.batches))
+	}
+}
+
+func TestFileSinkRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "audit.log")
+	sink, err := NewFileSink(path, 200, 2)
+	if err != nil {
+		t.Fatalf("NewFileSink() error = %v", err)
+	}
+	for i := 0; i < 6; i++ {
+		if err := sink.Write(context.Background(), []Entry{{Tenant: "tenant-a", Query: strings.Repeat("x", 40)}}); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	sink.Close()
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		data, err := os.ReadFile(name)
+		if err != nil || len(data) == 0 || len(data) > 200 {
+			t.Fatalf("%s: %d bytes, err = %v", name, len(data), err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("%s.3 exists, want at most 2 backups", path)
+	}
+}
+
+func TestOTLPSinkExportsLogRecords(t *testing.T) {
+	var got struct {
+		ResourceLogs []struct {
+			ScopeLogs []struct {
+				LogRecords []struct {
+					SeverityText string `json:"severityText"`
+					Body         struct {
+						StringValue string `json:"stringValue"`
+					} `json:"body"`
+				} `json:"logRecords"`
+			} `json:"scopeLogs"`
+		} `json:"resourceLogs"`
+	}
+	var auth string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer collector.Close()
+
+	sink, err := NewOTLPSink(collector.URL+"/v1/logs", map[string]string{"Authorization": "Basic abc"}, time.Second)
+	if err != nil {
+		t.Fatalf("NewOTLPSink() error = %v", err)
+	}
+	err = sink.Write(context.Background(), []Entry{{Event: EventAuthFailure, Tenant: "tenant-a", Error: "invalid api key"}})
+	if err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if auth != "Basic abc" || len(got.ResourceLogs) != 1 {
+		t.Fatalf("auth = %q, payload = %+v", auth, got)
+	}
+	record := got.ResourceLogs[0].ScopeLogs[0].LogRecords[0]
+	if record.SeverityText != "WARN" || !strings.Contains(record.Body.StringValue, `"event":"auth_failure"`) {
+		t.Fatalf("record = %+v", record)
+	}
+}
+
+func TestPGRowCleansText(t *testing.T) {
+	row := pgRow(Entry{Tenant: "tenant-a", Query: "{app=\"api\"} |= \"a\x00b\"", Error: "upstream: \xff\xfebody"})
+	if got := row[5]; got != `{app="api"} |= "ab"` {
+		t.Fatalf("query = %q, want NUL removed", got)
+	}
+	if got := row[len(row)-1]; got != "upstream: �body" {
+		t.Fatalf("error = %q, want invalid UTF-8 replaced", got)
+	}
+	if len(row) != len(pgColumns) {
+		t.Fatalf("row has %d values, want %d", len(row), len(pgColumns))
+	}
+}
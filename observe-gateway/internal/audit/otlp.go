@@ -0,0 +1,112 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// OTLPSink exports entries as OTLP/HTTP JSON log records, e.g. to an
+// OpenTelemetry collector's /v1/logs endpoint.
+type OTLPSink struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+	client   *http.Client
+}
+
+// NewOTLPSink returns a sink posting to endpoint with the extra headers.
+func NewOTLPSink(endpoint string, headers map[string]string, timeout time.Duration) (*OTLPSink, error) {
+	if endpoint == "" {
+		return nil, fmt.Errorf("audit otlp endpoint required")
+	}
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &OTLPSink{endpoint: endpoint, headers: headers, service: "observe-gateway", client: &http.Client{Timeout: timeout}}, nil
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpRecord struct {
+	TimeUnixNano   string          `json:"timeUnixNano"`
+	SeverityNumber int             `json:"severityNumber"`
+	SeverityText   string          `json:"severityText"`
+	Body           otlpValue       `json:"body"`
+	Attributes     []otlpAttribute `json:"attributes"`
+}
+
+// Write implements Sink.
+func (s *OTLPSink) Write(ctx context.Context, entries []Entry) error {
+	records := make([]otlpRecord, 0, len(entries))
+	for _, e := range entries {
+		body, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		// OTLP severity numbers: 9 is INFO, 13 is WARN.
+		severity, text := 9, "INFO"
+		if e.Error != "" {
+			severity, text = 13, "WARN"
+		}
+		records = append(records, otlpRecord{
+			TimeUnixNano:   strconv.FormatInt(e.Time.UnixNano(), 10),
+			SeverityNumber: severity,
+			SeverityText:   text,
+			Body:           otlpValue{StringValue: string(body)},
+			Attributes: []otlpAttribute{
+				{Key: "audit.event", Value: otlpValue{e.Event}},
+				{Key: "tenant", Value: otlpValue{e.Tenant}},
+				{Key: "user", Value: otlpValue{e.User}},
+				{Key: "query.lang", Value: otlpValue{e.Lang}},
+			},
+		})
+	}
+	payload, err := json.Marshal(map[string]any{
+		"resourceLogs": []any{map[string]any{
+			"resource": map[string]any{"attributes": []otlpAttribute{{Key: "service.name", Value: otlpValue{s.service}}}},
+			"scopeLogs": []any{map[string]any{
+				"scope":      map[string]string{"name": "observe-gateway/audit"},
+				"logRecords": records,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("export audit logs: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("export audit logs: %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// Close implements Sink.
+func (s *OTLPSink) Close() error { return nil }
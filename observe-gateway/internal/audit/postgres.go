@@ -0,0 +1,150 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+var pgColumns = []string{
+	"time", "event", "tenant", "user_name", "lang", "query", "cost", "duration_ns",
+	"cached", "cache_tier", "coalesced", "backend", "breaker", "rate_limit_rule",
+	"bytes", "rows", "status", "remote_addr", "auth_method", "credential", "error",
+}
+
+// PGSink stores entries in a Postgres table and serves audit queries.
+type PGSink struct {
+	pool  *pgxpool.Pool
+	table string
+}
+
+// NewPGSink returns a sink writing to table, creating it if missing.
+func NewPGSink(ctx context.Context, pool *pgxpool.Pool, table string) (*PGSink, error) {
+	if table == "" {
+		table = "gateway_audit"
+	}
+	if !tableName.MatchString(table) {
+		return nil, fmt.Errorf("invalid audit table %q", table)
+	}
+	index := table[strings.LastIndex(table, ".")+1:] + "_tenant_time_idx"
+	_, err := pool.Exec(ctx, `CREATE TABLE IF NOT EXISTS `+table+` (
+	time            timestamptz NOT NULL,
+	event           text NOT NULL,
+	tenant          text NOT NULL DEFAULT '',
+	user_name       text NOT NULL DEFAULT '',
+	lang            text NOT NULL DEFAULT '',
+	query           text NOT NULL DEFAULT '',
+	cost            bigint NOT NULL DEFAULT 0,
+	duration_ns     bigint NOT NULL DEFAULT 0,
+	cached          boolean NOT NULL DEFAULT false,
+	cache_tier      text NOT NULL DEFAULT '',
+	coalesced       boolean NOT NULL DEFAULT false,
+	backend         text NOT NULL DEFAULT '',
+	breaker         text NOT NULL DEFAULT '',
+	rate_limit_rule text NOT NULL DEFAULT '',
+	bytes           bigint NOT NULL DEFAULT 0,
+	rows            bigint NOT NULL DEFAULT 0,
+	status          integer NOT NULL DEFAULT 0,
+	remote_addr     text NOT NULL DEFAULT '',
+	auth_method     text NOT NULL DEFAULT '',
+	credential      text NOT NULL DEFAULT '',
+	error           text NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS `+index+` ON `+table+` (tenant, time DESC)`)
+	if err != nil {
+		return nil, fmt.Errorf("create audit table: %w", err)
+	}
+	return &PGSink{pool: pool, table: table}, nil
+}
+
+// Write implements Sink with a single COPY per batch. A COPY fails as a
+// whole when one row is rejected, so the batch is then inserted row by row
+// to keep every entry Postgres accepts.
+func (s *PGSink) Write(ctx context.Context, entries []Entry) error {
+	rows := make([][]any, len(entries))
+	for i, e := range entries {
+		rows[i] = pgRow(e)
+	}
+	_, err := s.pool.CopyFrom(ctx, pgx.Identifier(strings.Split(s.table, ".")), pgColumns, pgx.CopyFromRows(rows))
+	if err == nil {
+		return nil
+	}
+
+	insert := `INSERT INTO ` + s.table + ` (` + strings.Join(pgColumns, ", ") + `) VALUES (`
+	for i := range pgColumns {
+		if i > 0 {
+			insert += ", "
+		}
+		insert += fmt.Sprintf("$%d", i+1)
+	}
+	insert += ")"
+	failed := 0
+	var last error
+	for _, row := range rows {
+		if _, err := s.pool.Exec(ctx, insert, row...); err != nil {
+			failed++
+			last = err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("copy audit entries: %v; inserting them one by one dropped %d of %d: %w", err, failed, len(rows), last)
+	}
+	return nil
+}
+
+// pgRow returns the column values of an entry. Text holds tenant queries
+// and upstream error bodies, which may contain bytes Postgres text rejects:
+// NUL and invalid UTF-8.
+func pgRow(e Entry) []any {
+	return []any{
+		e.Time, pgText(e.Event), pgText(e.Tenant), pgText(e.User), pgText(e.Lang), pgText(e.Query), e.Cost, int64(e.Duration),
+		e.Cached, pgText(e.CacheTier), e.Coalesced, pgText(e.Backend), pgText(e.Breaker), pgText(e.RateLimitRule),
+		e.Bytes, e.Rows, e.Status, pgText(e.RemoteAddr), pgText(e.AuthMethod), pgText(e.Credential), pgText(e.Error),
+	}
+}
+
+func pgText(s string) string {
+	return strings.ReplaceAll(strings.ToValidUTF8(s, "\uFFFD"), "\x00", "")
+}
+
+// Query implements Reader, returning the newest entries first.
+func (s *PGSink) Query(ctx context.Context, f Filter) ([]Entry, error) {
+	until := f.Until
+	if until.IsZero() {
+		until = time.Now().Add(time.Minute)
+	}
+	sql := `SELECT ` + strings.Join(pgColumns, ", ") + ` FROM ` + s.table + `
+WHERE ($1 = '' OR tenant = $1) AND ($2 = '' OR user_name = $2) AND ($3 = '' OR event = $3)
+  AND time >= $4 AND time < $5
+ORDER BY time DESC LIMIT $6`
+	rows, err := s.pool.Query(ctx, sql, f.Tenant, f.User, f.Event, f.Since, until, f.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		var e Entry
+		var duration int64
+		if err := rows.Scan(&e.Time, &e.Event, &e.Tenant, &e.User, &e.Lang, &e.Query, &e.Cost, &duration,
+			&e.Cached, &e.CacheTier, &e.Coalesced, &e.Backend, &e.Breaker, &e.RateLimitRule,
+			&e.Bytes, &e.Rows, &e.Status, &e.RemoteAddr, &e.AuthMethod, &e.Credential, &e.Error); err != nil {
+			return nil, err
+		}
+		e.Duration = time.Duration(duration)
+		e.Time = e.Time.UTC()
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Close implements Sink. The pool is owned by the caller.
+func (s *PGSink) Close() error { return nil }
@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiKeyPrefix marks gateway API keys so they can be told apart from JWTs.
+const apiKeyPrefix = "ogk_"
+
+var (
+	// ErrKeyNotFound is returned by a KeyStore for unknown key IDs.
+	ErrKeyNotFound = errors.New("api key not found")
+	// ErrKeyInactive is returned for expired or revoked keys.
+	ErrKeyInactive = errors.New("api key expired or revoked")
+)
+
+// APIKey is a stored API key. Only a hash of the secret is kept.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Tenant     string     `json:"tenant"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	SecretHash string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Active reports whether the key may be used at now.
+func (k APIKey) Active(now time.Time) bool {
+	if k.RevokedAt != nil && !k.RevokedAt.After(now) {
+		return false
+	}
+	return k.ExpiresAt == nil || k.ExpiresAt.After(now)
+}
+
+// KeyStore persists API keys.
+type KeyStore interface {
+	Create(ctx context.Context, key APIKey) error
+	Get(ctx context.Context, id string) (APIKey, error)
+	List(ctx context.Context, tenant string) ([]APIKey, error)
+	// Expire sets the expiry of a key, used when rotating it.
+	Expire(ctx context.Context, id string, at time.Time) error
+	Revoke(ctx context.Context, id string, at time.Time) error
+}
+
+// APIKeys authenticates service accounts with API keys of the form
+// ogk_<id>_<secret>, sent as a bearer token or in the X-API-Key header.
+type APIKeys struct {
+	store    KeyStore
+	cacheTTL time.Duration
+	now      func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]cachedKey
+}
+
+type cachedKey struct {
+	key       APIKey
+	fetchedAt time.Time
+}
+
+// NewAPIKeys returns an API key authenticator. Verified keys are cached for
+// cacheTTL, which bounds how long a revocation takes to reach other
+// replicas. A nil store disables API keys.
+func NewAPIKeys(store KeyStore, cacheTTL time.Duration) *APIKeys {
+	return &APIKeys{store: store, cacheTTL: cacheTTL, now: time.Now, cache: make(map[string]cachedKey)}
+}
+
+// Enabled returns whether API keys are accepted.
+func (k *APIKeys) Enabled() bool {
+	return k != nil && k.store != nil
+}
+
+// Authenticate verifies an API key.
+func (k *APIKeys) Authenticate(r *http.Request) (Identity, error) {
+	raw := r.Header.Get("X-API-Key")
+	if raw == "" {
+		raw = bearerToken(r)
+	}
+	if !strings.HasPrefix(raw, apiKeyPrefix) {
+		return Identity{}, ErrNoCredentials
+	}
+	id, secret, ok := strings.Cut(strings.TrimPrefix(raw, apiKeyPrefix), "_")
+	if !ok || id == "" || secret == "" {
+		return Identity{}, &Error{Method: "apikey", Credential: id, Err: errors.New("malformed api key")}
+	}
+
+	key, err := k.lookup(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			err = errors.New("invalid api key")
+		}
+		return Identity{}, &Error{Method: "apikey", Credential: id, Err: err}
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(key.SecretHash)) != 1 {
+		return Identity{}, &Error{Method: "apikey", Credential: id, Err: errors.New("invalid api key")}
+	}
+	if !key.Active(k.now()) {
+		return Identity{}, &Error{Method: "apikey", Credential: id, Err: ErrKeyInactive}
+	}
+
+	scopes := key.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return Identity{Tenant: key.Tenant, User: "apikey:" + key.Name, Scopes: scopes, Method: "apikey", Credential: key.ID}, nil
+}
+
+func (k *APIKeys) lookup(ctx context.Context, id string) (APIKey, error) {
+	now := k.now()
+	k.mu.Lock()
+	cached, ok := k.cache[id]
+	k.mu.Unlock()
+	if ok && now.Sub(cached.fetchedAt) < k.cacheTTL {
+		return cached.key, nil
+	}
+
+	key, err := k.store.Get(ctx, id)
+	if err != nil {
+		return APIKey{}, err
+	}
+	k.mu.Lock()
+	k.cache[id] = cachedKey{key: key, fetchedAt: now}
+	k.mu.Unlock()
+	return key, nil
+}
+
+func (k *APIKeys) forget(id string) {
+	k.mu.Lock()
+	delete(k.cache, id)
+	k.mu.Unlock()
+}
+
+// Issue creates a key and returns it with its secret, which is not stored
+// and cannot be recovered later.
+func (k *APIKeys) Issue(ctx context.Context, tenant, name string, scopes []string, expiresAt *time.Time) (APIKey, string, error) {
+	if tenant == "" {
+		return APIKey{}, "", errors.New("tenant is required")
+	}
+	id, err := randomString(8, hex.EncodeToString)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	secret, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	// base64url may contain '_', which separates the ID from the secret.
+	secret = strings.ReplaceAll(secret, "_", "-")
+
+	key := APIKey{
+		ID:         id,
+		Tenant:     tenant,
+		Name:       name,
+		Scopes:     scopes,
+		SecretHash: hashSecret(secret),
+		CreatedAt:  k.now().UTC(),
+		ExpiresAt:  expiresAt,
+	}
+	if err := k.store.Create(ctx, key); err != nil {
+		return APIKey{}, "", fmt.Errorf("store api key: %w", err)
+	}
+	return key, apiKeyPrefix + id + "_" + secret, nil
+}
+
+// Rotate issues a replacement for a key with the same tenant, name, scopes
+// and expiry. The old key keeps working for grace, then expires.
+func (k *APIKeys) Rotate(ctx context.Context, id string, grace time.Duration) (APIKey, string, error) {
+	old, err := k.store.Get(ctx, id)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	if !old.Active(k.now()) {
+		return APIKey{}, "", ErrKeyInactive
+	}
+	key, secret, err := k.Issue(ctx, old.Tenant, old.Name, old.Scopes, old.ExpiresAt)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	if err := k.store.Expire(ctx, id, k.now().Add(grace).UTC()); err != nil {
+		return APIKey{}, "", err
+	}
+	k.forget(id)
+	return key, secret, nil
+}
+
+// Revoke disables a key immediately.
+func (k *APIKeys) Revoke(ctx context.Context, id string) error {
+	if err := k.store.Revoke(ctx, id, k.now().UTC()); err != nil {
+		return err
+	}
+	k.forget(id)
+	return nil
+}
+
+// List returns the keys of a tenant, or of all tenants when tenant is empty.
+func (k *APIKeys) List(ctx context.Context, tenant string) ([]APIKey, error) {
+	return k.store.List(ctx, tenant)
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int, encode func([]byte) string) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return encode(buf), nil
+}
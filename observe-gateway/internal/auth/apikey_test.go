@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func keyRequest(header, value string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/query", nil)
+	if header != "" {
+		r.Header.Set(header, value)
+	}
+	return r
+}
+
+func TestAPIKeysIssueAndAuthenticate(t *testing.T) {
+	store := NewMemoryKeyStore()
+	keys := NewAPIKeys(store, time.Minute)
+	ctx := context.Background()
+
+	key, secret, err := keys.Issue(ctx, "tenant-a", "batch", []string{"query:logql"}, nil)
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	stored, _ := store.Get(ctx, key.ID)
+	if stored.SecretHash == "" || stored.SecretHash == secret {
+		t.Fatalf("stored hash = %q, want a hash of the secret", stored.SecretHash)
+	}
+
+	for _, r := range []*http.Request{keyRequest("X-API-Key", secret), keyRequest("Authorization", "Bearer "+secret)} {
+		id, err := keys.Authenticate(r)
+		if err != nil {
+			t.Fatalf("Authenticate() error = %v", err)
+		}
+		if id.Tenant != "tenant-a" || id.User != "apikey:batch" || id.Method != "apikey" {
+			t.Fatalf("identity = %+v, want tenant-a apikey:batch", id)
+		}
+		if !id.Allows("query:logql") || id.Allows("query:promql") || id.Allows("admin") {
+			t.Fatalf("scopes = %v, want only query:logql", id.Scopes)
+		}
+	}
+
+	if _, err := keys.Authenticate(keyRequest("X-API-Key", secret+"x")); err == nil {
+		t.Fatalf("Authenticate() with a wrong secret succeeded")
+	}
+	if _, err := keys.Authenticate(keyRequest("Authorization", "Bearer eyJhbGciOi")); !errors.Is(err, ErrNoCredentials) {
+		t.Fatalf("Authenticate() with a JWT error = %v, want ErrNoCredentials", err)
+	}
+}
+
+func TestAPIKeysRotateAndRevoke(t *testing.T) {
+	keys := NewAPIKeys(NewMemoryKeyStore(), time.Minute)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	keys.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	old, oldSecret, err := keys.Issue(ctx, "tenant-a", "batch", []string{"query"}, nil)
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	if _, err := keys.Authenticate(keyRequest("X-API-Key", oldSecret)); err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+
+	rotated, newSecret, err := keys.Rotate(ctx, old.ID, time.Hour)
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if rotated.ID == old.ID || rotated.Tenant != "tenant-a" || len(rotated.Scopes) != 1 {
+		t.Fatalf("rotated = %+v, want a new key with the same tenant and scopes", rotated)
+	}
+	if _, err := keys.Authenticate(keyRequest("X-API-Key", oldSecret)); err != nil {
+		t.Fatalf("old key during grace error = %v", err)
+	}
+	now = now.Add(2 * time.Hour)
+	if _, err := keys.Authenticate(keyRequest("X-API-Key", oldSecret)); !errors.Is(err, ErrKeyInactive) {
+		t.Fatalf("old key after grace error = %v, want ErrKeyInactive", err)
+	}
+	if _, err := keys.Authenticate(keyRequest("X-API-Key", newSecret)); err != nil {
+		t.Fatalf("new key error = %v", err)
+	}
+
+	if err := keys.Revoke(ctx, rotated.ID); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if _, err := keys.Authenticate(keyRequest("X-API-Key", newSecret)); !errors.Is(err, ErrKeyInactive) {
+		t.Fatalf("revoked key error = %v, want ErrKeyInactive", err)
+	}
+	if err := keys.Revoke(ctx, "missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Revoke(missing) error = %v, want ErrKeyNotFound", err)
+	}
+}
+
+func TestChainFallsThrough(t *testing.T) {
+	keys := NewAPIKeys(NewMemoryKeyStore(), time.Minute)
+	_, secret, err := keys.Issue(context.Background(), "tenant-a", "svc", nil, nil)
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	chain := Chain{&Authenticator{enabled: true}, keys}
+	if !chain.Enabled() {
+		t.Fatalf("chain not enabled")
+	}
+
+	// The JWT authenticator ignores gateway API keys, leaving them to the
+	// next provider.
+	id, err := chain.Authenticate(keyRequest("Authorization", "Bearer "+secret))
+	if err != nil || id.Method != "apikey" {
+		t.Fatalf("Authenticate() = %+v, %v, want an apikey identity", id, err)
+	}
+	if _, err := chain.Authenticate(keyRequest("", "")); err == nil {
+		t.Fatalf("Authenticate() without credentials succeeded")
+	}
+	if (Chain{&Authenticator{}, NewAPIKeys(nil, 0)}).Enabled() {
+		t.Fatalf("chain of disabled providers reports enabled")
+	}
+}
@@ -85,7 +85,24 @@ func (a *Authenticator) Verify(r *http.Request) (tenant, user string, err error)
 	if tokenString == "" {
 		return "", "", errors.New("empty bearer token")
 	}
+	return a.verifyToken(r, tokenString)
+}
+
+// Authenticate verifies a bearer JWT. Requests without a bearer token, or
+// whose token is a gateway API key, are left to the next provider.
+func (a *Authenticator) Authenticate(r *http.Request) (Identity, error) {
+	token := bearerToken(r)
+	if token == "" || strings.HasPrefix(token, apiKeyPrefix) {
+		return Identity{}, ErrNoCredentials
+	}
+	tenant, user, err := a.verifyToken(r, token)
+	if err != nil {
+		return Identity{}, &Error{Method: "jwt", Credential: unverifiedSubject(token), Err: err}
+	}
+	return Identity{Tenant: tenant, User: user, Method: "jwt"}, nil
+}
 
+func (a *Authenticator) verifyToken(r *http.Request, tokenString string) (tenant, user string, err error) {
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
@@ -169,6 +186,16 @@ func (a *Authenticator) refresh(ctx context.Context) error {
 	return nil
 }
 
+// unverifiedSubject returns the subject of a token without verifying it, to
+// attribute failed attempts in the audit log.
+func unverifiedSubject(token string) string {
+	parsed, err := jwt.ParseInsecure([]byte(token))
+	if err != nil {
+		return ""
+	}
+	return parsed.Subject()
+}
+
 func claimAsString(token jwt.Token, claim string, fallback string) string {
 	if claim == "" {
 		claim = fallback
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrNoCredentials is returned by a Provider when the request carries no
+// credentials it understands, so the next provider in a Chain may try.
+var ErrNoCredentials = errors.New("no credentials")
+
+// Error is an authentication failure attributed to the presented credential.
+type Error struct {
+	Method string
+	// Credential identifies the credential without revealing it: an API
+	// key ID or the unverified subject of a JWT.
+	Credential string
+	Err        error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Identity is an authenticated caller.
+type Identity struct {
+	Tenant string
+	User   string
+	// Scopes restricts what the caller may do; nil means unrestricted.
+	Scopes []string
+	// Method is the authenticator that accepted the request.
+	Method string
+	// Credential is the API key ID, if any.
+	Credential string
+}
+
+// Allows reports whether the identity holds scope. A scope "query" also
+// grants "query:<lang>", and "*" grants everything.
+func (id Identity) Allows(scope string) bool {
+	if id.Scopes == nil {
+		return true
+	}
+	for _, s := range id.Scopes {
+		if s == "*" || s == scope || strings.HasPrefix(scope, s+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// Provider authenticates requests.
+type Provider interface {
+	Enabled() bool
+	Authenticate(r *http.Request) (Identity, error)
+}
+
+// Chain tries enabled providers in order until one recognises the request's
+// credentials.
+type Chain []Provider
+
+// Enabled reports whether any provider is enabled.
+func (c Chain) Enabled() bool {
+	for _, p := range c {
+		if p != nil && p.Enabled() {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate returns the identity from the first provider that accepts
+// the credentials, or the first provider's rejection.
+func (c Chain) Authenticate(r *http.Request) (Identity, error) {
+	for _, p := range c {
+		if p == nil || !p.Enabled() {
+			continue
+		}
+		id, err := p.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return id, err
+	}
+	return Identity{}, errors.New("authorization required")
+}
+
+// bearerToken returns the bearer token of the request, if any.
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[7:])
+}
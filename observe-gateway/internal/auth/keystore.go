@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// PGKeyStore stores API keys in a Postgres table.
+type PGKeyStore struct {
+	pool  *pgxpool.Pool
+	table string
+}
+
+// NewPGKeyStore returns a key store using table, creating it if missing.
+func NewPGKeyStore(ctx context.Context, pool *pgxpool.Pool, table string) (*PGKeyStore, error) {
+	if table == "" {
+		table = "api_keys"
+	}
+	if !tableName.MatchString(table) {
+		return nil, fmt.Errorf("invalid api key table %q", table)
+	}
+	s := &PGKeyStore{pool: pool, table: table}
+	_, err := pool.Exec(ctx, `CREATE TABLE IF NOT EXISTS `+table+` (
+	id          text PRIMARY KEY,
+	tenant      text NOT NULL,
+	name        text NOT NULL DEFAULT '',
+	scopes      text[] NOT NULL DEFAULT '{}',
+	secret_hash text NOT NULL,
+	created_at  timestamptz NOT NULL,
+	expires_at  timestamptz,
+	revoked_at  timestamptz
+)`)
+	if err != nil {
+		return nil, fmt.Errorf("create api key table: %w", err)
+	}
+	return s, nil
+}
+
+const keyColumns = "id, tenant, name, scopes, secret_hash, created_at, expires_at, revoked_at"
+
+// Create inserts a key.
+func (s *PGKeyStore) Create(ctx context.Context, key APIKey) error {
+	scopes := key.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	_, err := s.pool.Exec(ctx, `INSERT INTO `+s.table+` (`+keyColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		key.ID, key.Tenant, key.Name, scopes, key.SecretHash, key.CreatedAt, key.ExpiresAt, key.RevokedAt)
+	return err
+}
+
+// Get returns a key by ID.
+func (s *PGKeyStore) Get(ctx context.Context, id string) (APIKey, error) {
+	row := s.pool.QueryRow(ctx, `SELECT `+keyColumns+` FROM `+s.table+` WHERE id = $1`, id)
+	key, err := scanKey(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return APIKey{}, ErrKeyNotFound
+	}
+	return key, err
+}
+
+// List returns the keys of a tenant, or all keys when tenant is empty.
+func (s *PGKeyStore) List(ctx context.Context, tenant string) ([]APIKey, error) {
+	rows, err := s.pool.Query(ctx, `SELECT `+keyColumns+` FROM `+s.table+` WHERE $1 = '' OR tenant = $1 ORDER BY created_at`, tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// Expire makes a key expire at the given time unless it already expires
+// earlier.
+func (s *PGKeyStore) Expire(ctx context.Context, id string, at time.Time) error {
+	return s.update(ctx, `UPDATE `+s.table+` SET expires_at = $2 WHERE id = $1 AND (expires_at IS NULL OR expires_at > $2)`, id, at)
+}
+
+// Revoke marks a key revoked at the given time.
+func (s *PGKeyStore) Revoke(ctx context.Context, id string, at time.Time) error {
+	return s.update(ctx, `UPDATE `+s.table+` SET revoked_at = $2 WHERE id = $1 AND revoked_at IS NULL`, id, at)
+}
+
+func (s *PGKeyStore) update(ctx context.Context, sql, id string, at time.Time) error {
+	tag, err := s.pool.Exec(ctx, sql, id, at)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		if _, err := s.Get(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanKey(row pgx.Row) (APIKey, error) {
+	var key APIKey
+	err := row.Scan(&key.ID, &key.Tenant, &key.Name, &key.Scopes, &key.SecretHash, &key.CreatedAt, &key.ExpiresAt, &key.RevokedAt)
+	return key, err
+}
+
+// MemoryKeyStore keeps API keys in memory, for tests and single-replica
+// development setups.
+type MemoryKeyStore struct {
+	mu   sync.Mutex
+	keys map[string]APIKey
+}
+
+// NewMemoryKeyStore returns an empty in-memory key store.
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{keys: make(map[string]APIKey)}
+}
+
+// Create inserts a key.
+func (s *MemoryKeyStore) Create(_ context.Context, key APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[key.ID]; ok {
+		return fmt.Errorf("api key %s already exists", key.ID)
+	}
+	s.keys[key.ID] = key
+	return nil
+}
+
+// Get returns a key by ID.
+func (s *MemoryKeyStore) Get(_ context.Context, id string) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return APIKey{}, ErrKeyNotFound
+	}
+	return key, nil
+}
+
+// List returns the keys of a tenant, or all keys when tenant is empty.
+func (s *MemoryKeyStore) List(_ context.Context, tenant string) ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []APIKey{}
+	for _, key := range s.keys {
+		if tenant == "" || key.Tenant == tenant {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+// Expire makes a key expire at the given time unless it already expires
+// earlier.
+func (s *MemoryKeyStore) Expire(_ context.Context, id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	if key.ExpiresAt == nil || key.ExpiresAt.After(at) {
+		key.ExpiresAt = &at
+		s.keys[id] = key
+	}
+	return nil
+}
+
+// Revoke marks a key revoked at the given time.
+func (s *MemoryKeyStore) Revoke(_ context.Context, id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	if key.RevokedAt == nil {
+		key.RevokedAt = &at
+		s.keys[id] = key
+	}
+	return nil
+}
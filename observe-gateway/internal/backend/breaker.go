@@ -0,0 +1,334 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/config"
+	"github.com/xscopehub/observe-gateway/internal/telemetry"
+)
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitOpenError is returned when a backend is short-circuited.
+type CircuitOpenError struct {
+	Backend string
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s circuit breaker is open", e.Backend)
+}
+
+// breakerBuckets is the resolution of the rolling error-rate window.
+const breakerBuckets = 10
+
+type breakerBucket struct {
+	index    int64
+	success  int
+	failures int
+}
+
+// breaker is a per-backend circuit breaker. It opens when the error rate
+// over a rolling window exceeds the threshold, lets a limited number of
+// trial requests through after a cool-down and closes again once they
+// succeed. A nil breaker always allows requests.
+type breaker struct {
+	name string
+	cfg  config.CircuitBreakerConfig
+	now  func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	openedAt time.Time
+	trials   int
+	passed   int
+	buckets  [breakerBuckets]breakerBucket
+}
+
+func newBreaker(name string, cfg config.CircuitBreakerConfig) *breaker {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 30 * time.Second
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 10
+	}
+	if cfg.ErrorRateThreshold <= 0 || cfg.ErrorRateThreshold > 1 {
+		cfg.ErrorRateThreshold = 0.5
+	}
+	if cfg.OpenDuration <= 0 {
+		cfg.OpenDuration = 30 * time.Second
+	}
+	if cfg.HalfOpenRequests <= 0 {
+		cfg.HalfOpenRequests = 1
+	}
+	b := &breaker{name: name, cfg: cfg, now: time.Now}
+	b.setState(BreakerClosed)
+	return b
+}
+
+// Allow reports whether a request may be sent to the backend.
+func (b *breaker) Allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.cfg.OpenDuration {
+		b.toHalfOpen()
+	}
+	switch b.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		if b.trials >= b.cfg.HalfOpenRequests {
+			return false
+		}
+		b.trials++
+		return true
+	default:
+		return true
+	}
+}
+
+// Record reports the outcome of a request that Allow let through.
+func (b *breaker) Record(success bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerHalfOpen:
+		if !success {
+			b.toOpen()
+			return
+		}
+		b.passed++
+		if b.passed >= b.cfg.HalfOpenRequests {
+			b.toClosed()
+		}
+	case BreakerClosed:
+		bucket := b.bucket()
+		if success {
+			bucket.success++
+		} else {
+			bucket.failures++
+		}
+		total, failures := b.totals()
+		if total >= b.cfg.MinRequests && float64(failures)/float64(total) >= b.cfg.ErrorRateThreshold {
+			b.toOpen()
+		}
+	}
+}
+
+// release gives back the half-open trial of a request that ended without
+// an outcome, leaving the state unchanged.
+func (b *breaker) release() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen && b.trials > 0 {
+		b.trials--
+	}
+}
+
+// probeResult feeds the outcome of an active health probe into the breaker.
+// A healthy probe lets an open breaker try requests again early; a failed
+// probe counts as a failed request.
+func (b *breaker) probeResult(healthy bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	if healthy {
+		if b.state == BreakerOpen {
+			b.toHalfOpen()
+		}
+		b.mu.Unlock()
+		return
+	}
+	b.mu.Unlock()
+	b.Record(false)
+}
+
+// State returns the current state.
+func (b *breaker) State() BreakerState {
+	if b == nil {
+		return BreakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.cfg.OpenDuration {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// stateName returns the state for reporting, or "" for a disabled breaker.
+func (b *breaker) stateName() string {
+	if b == nil {
+		return ""
+	}
+	return b.State().String()
+}
+
+func (b *breaker) bucket() *breakerBucket {
+	width := b.cfg.Window / breakerBuckets
+	if width <= 0 {
+		width = 1
+	}
+	idx := b.now().UnixNano() / int64(width)
+	bucket := &b.buckets[idx%breakerBuckets]
+	if bucket.index != idx {
+		*bucket = breakerBucket{index: idx}
+	}
+	return bucket
+}
+
+func (b *breaker) totals() (total, failures int) {
+	current := b.bucket().index
+	for _, bucket := range b.buckets {
+		if bucket.index > current-breakerBuckets {
+			total += bucket.success + bucket.failures
+			failures += bucket.failures
+		}
+	}
+	return total, failures
+}
+
+func (b *breaker) toOpen() {
+	b.setState(BreakerOpen)
+	b.openedAt = b.now()
+}
+
+func (b *breaker) toHalfOpen() {
+	b.setState(BreakerHalfOpen)
+	b.trials, b.passed = 0, 0
+}
+
+func (b *breaker) toClosed() {
+	b.setState(BreakerClosed)
+	b.buckets = [breakerBuckets]breakerBucket{}
+}
+
+// setState changes the state and exports it. An open breaker is exported
+// as open until a request or probe moves it to half-open.
+func (b *breaker) setState(state BreakerState) {
+	b.state = state
+	telemetry.BreakerState.WithLabelValues(b.name).Set(float64(state))
+}
+
+// isBackendFailure classifies an error for the circuit breaker. Queries the
+// backend rejects and requests cancelled by the caller say nothing about
+// backend health.
+func isBackendFailure(ctx context.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+	var unsupported *UnsupportedError
+	var invalid *QueryError
+	if errors.As(err, &unsupported) || errors.As(err, &invalid) {
+		return false
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return false
+	}
+	return true
+}
+
+// responseError is the error of a backend response with status >= 400.
+// Client errors other than 429 reject the query and say nothing about
+// backend health; server errors count against the breaker.
+func responseError(backend string, status int, body []byte) error {
+	err := fmt.Errorf("%s error: %s", backend, body)
+	if status < 500 && status != http.StatusTooManyRequests {
+		return &QueryError{Status: status, Err: err}
+	}
+	return err
+}
+
+// finish records the outcome of a request that Allow let through. A
+// request whose context ended before the backend answered says nothing
+// about the backend and only frees its half-open trial.
+func (b *breaker) finish(ctx context.Context, err error) {
+	if ctx.Err() != nil {
+		b.release()
+		return
+	}
+	b.Record(!isBackendFailure(ctx, err))
+}
+
+// guard runs call if the breaker allows it and records the outcome.
+func guard(ctx context.Context, b *breaker, call func() (Result, error)) (Result, error) {
+	if !b.Allow() {
+		return Result{}, &CircuitOpenError{Backend: b.name}
+	}
+	res, err := call()
+	b.finish(ctx, err)
+	return res, err
+}
+
+// healthProbe periodically checks a backend health endpoint.
+type healthProbe struct {
+	breaker  *breaker
+	url      string
+	client   *http.Client
+	interval time.Duration
+}
+
+func (p healthProbe) run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.breaker.probeResult(p.check(ctx))
+		}
+	}
+}
+
+func (p healthProbe) check(ctx context.Context) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode < 300
+}
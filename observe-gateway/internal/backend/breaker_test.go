@@ -0,0 +1,205 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/config"
+	"github.com/xscopehub/observe-gateway/internal/query"
+)
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := newBreaker("openobserve", config.CircuitBreakerConfig{
+		Enabled:            true,
+		Window:             10 * time.Second,
+		MinRequests:        4,
+		ErrorRateThreshold: 0.5,
+		OpenDuration:       5 * time.Second,
+		HalfOpenRequests:   1,
+	})
+	b.now = func() time.Time { return now }
+
+	for _, ok := range []bool{true, false, true, false} {
+		if !b.Allow() {
+			t.Fatal("Allow() = false while closed")
+		}
+		b.Record(ok)
+	}
+	if got := b.State(); got != BreakerOpen {
+		t.Fatalf("state = %s, want open", got)
+	}
+	if b.Allow() {
+		t.Fatal("Allow() = true while open")
+	}
+
+	now = now.Add(5 * time.Second)
+	if !b.Allow() {
+		t.Fatal("Allow() = false for half-open trial")
+	}
+	if b.Allow() {
+		t.Fatal("Allow() = true beyond half-open trial budget")
+	}
+	b.Record(false)
+	if got := b.State(); got != BreakerOpen {
+		t.Fatalf("state = %s after failed trial, want open", got)
+	}
+
+	b.probeResult(true)
+	if !b.Allow() {
+		t.Fatal("Allow() = false after healthy probe")
+	}
+	b.Record(true)
+	if got := b.State(); got != BreakerClosed {
+		t.Fatalf("state = %s after successful trial, want closed", got)
+	}
+}
+
+func TestBreakerForgetsOldFailures(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := newBreaker("openobserve", config.CircuitBreakerConfig{Enabled: true, Window: 10 * time.Second, MinRequests: 2})
+	b.now = func() time.Time { return now }
+
+	b.Record(false)
+	now = now.Add(11 * time.Second)
+	b.Record(false)
+	if got := b.State(); got != BreakerClosed {
+		t.Fatalf("state = %s, want closed once the first failure left the window", got)
+	}
+}
+
+func TestQueryPromQLFailsOverOnOutage(t *testing.T) {
+	var primaryCalls int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&primaryCalls, 1)
+		http.Error(w, "upstream down", http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+	}))
+	defer fallback.Close()
+
+	cfg := config.BackendConfig{
+		OpenObserve: config.OpenObserveConfig{BaseURL: primary.URL, Org: "default", PromQueryEndpoint: "/api/%s/promql/query"},
+		Fallback:    config.FallbackConfig{Enabled: true, BaseURL: fallback.URL},
+		CircuitBreaker: config.CircuitBreakerConfig{
+			Enabled:      true,
+			MinRequests:  2,
+			OpenDuration: time.Minute,
+		},
+	}
+	client, err := New(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		res, err := client.QueryPromQL(context.Background(), "tenant-a", query.Request{Lang: "promql", Query: "up"})
+		if err != nil {
+			t.Fatalf("QueryPromQL() error = %v", err)
+		}
+		if res.Backend != "fallback-promql" {
+			t.Fatalf("backend = %q, want fallback-promql", res.Backend)
+		}
+		// The breaker reported is the fallback's, which served the query.
+		if res.Breaker != "closed" {
+			t.Fatalf("breaker = %q, want the fallback's closed breaker", res.Breaker)
+		}
+	}
+	if got := client.up.Load().oo.breaker.State(); got != BreakerOpen {
+		t.Fatalf("openobserve breaker = %v, want open", got)
+	}
+	if got := atomic.LoadInt32(&primaryCalls); got != 2 {
+		t.Fatalf("primary calls = %d, want 2 before the breaker opened", got)
+	}
+
+	client.up.Load().fallback = nil
+	_, err = client.QueryPromQL(context.Background(), "tenant-a", query.Request{Lang: "promql", Query: "up"})
+	var open *CircuitOpenError
+	if !errors.As(err, &open) {
+		t.Fatalf("error = %v, want *CircuitOpenError", err)
+	}
+}
+
+func TestRejectedQueriesLeaveBreakerClosed(t *testing.T) {
+	var primaryCalls, fallbackCalls int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&primaryCalls, 1)
+		http.Error(w, "unknown column", http.StatusBadRequest)
+	}))
+	defer primary.Close()
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fallbackCalls, 1)
+		http.Error(w, "bad_data: parse error", http.StatusUnprocessableEntity)
+	}))
+	defer fallback.Close()
+
+	cfg := config.BackendConfig{
+		OpenObserve: config.OpenObserveConfig{BaseURL: primary.URL, Org: "default", PromQueryEndpoint: "/api/%s/promql/query", LogSearchEndpoint: "/api/%s/_search", LogTable: "logs"},
+		Fallback:    config.FallbackConfig{Enabled: true, BaseURL: fallback.URL},
+		CircuitBreaker: config.CircuitBreakerConfig{
+			Enabled:      true,
+			MinRequests:  2,
+			OpenDuration: time.Minute,
+		},
+	}
+	client, err := New(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer client.Close()
+
+	start := time.Unix(1700000000, 0)
+	for i := 0; i < 5; i++ {
+		_, err := client.QueryLogQL(context.Background(), "tenant-a", query.Request{Lang: "logql", Query: `{service="x"} | json | bad="y"`, Start: start, End: start.Add(time.Hour)})
+		var invalid *QueryError
+		if !errors.As(err, &invalid) || invalid.Status != http.StatusBadRequest {
+			t.Fatalf("QueryLogQL() error = %v, want a *QueryError with status 400", err)
+		}
+		_, err = client.QueryPromQL(context.Background(), "tenant-a", query.Request{Lang: "promql", Query: "up{"})
+		if !errors.As(err, &invalid) || invalid.Status != http.StatusUnprocessableEntity {
+			t.Fatalf("QueryPromQL() error = %v, want a *QueryError with status 422", err)
+		}
+	}
+	up := client.up.Load()
+	if got := up.oo.breaker.State(); got != BreakerClosed {
+		t.Fatalf("openobserve breaker = %s, want closed", got)
+	}
+	if got := up.fallback.breaker.State(); got != BreakerClosed {
+		t.Fatalf("fallback breaker = %s, want closed", got)
+	}
+	if primaryCalls != 10 || fallbackCalls != 5 {
+		t.Fatalf("calls = %d primary, %d fallback, want every query sent", primaryCalls, fallbackCalls)
+	}
+}
+
+func TestCancelledTrialKeepsBreakerHalfOpen(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := newBreaker("openobserve", config.CircuitBreakerConfig{Enabled: true, MinRequests: 1, OpenDuration: time.Second, HalfOpenRequests: 1})
+	b.now = func() time.Time { return now }
+	b.Record(false)
+	now = now.Add(time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := guard(ctx, b, func() (Result, error) {
+		cancel()
+		return Result{}, ctx.Err()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("guard() error = %v, want context.Canceled", err)
+	}
+	if got := b.State(); got != BreakerHalfOpen {
+		t.Fatalf("state = %s after a cancelled trial, want half-open", got)
+	}
+	// The cancelled request gave its trial back.
+	if !b.Allow() {
+		t.Fatal("Allow() = false, want the trial slot released")
+	}
+}
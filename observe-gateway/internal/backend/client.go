@@ -10,30 +10,71 @@ import (
 	"net/http"
 	"net/url"
 	"path"
-	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgxpool"
+
 	"github.com/xscopehub/observe-gateway/internal/config"
+	"github.com/xscopehub/observe-gateway/internal/frame"
+	"github.com/xscopehub/observe-gateway/internal/limiter"
+	"github.com/xscopehub/observe-gateway/internal/logql"
+	"github.com/xscopehub/observe-gateway/internal/promapi"
 	"github.com/xscopehub/observe-gateway/internal/query"
+	"github.com/xscopehub/observe-gateway/internal/sqlbuilder"
+	"github.com/xscopehub/observe-gateway/internal/telemetry"
 )
 
 // Client aggregates integrations with the different OpenObserve APIs.
 type Client struct {
+	// up holds the endpoints swapped by Reload; each request loads it once
+	// so in-flight requests finish against the endpoints they started with.
+	up       atomic.Pointer[upstreams]
+	metadata *metadataStore
+
+	probeMu    sync.Mutex
+	stopProbes context.CancelFunc
+}
+
+// upstreams are the reloadable parts of the backend configuration.
+type upstreams struct {
+	cfg               config.BackendConfig
 	oo                *openObserveClient
 	fallback          *promFallbackClient
-	metadata          *metadataStore
+	tempo             *tempoClient
+	labels            *labelEnforcer
 	defaultLogTable   string
 	defaultTraceTable string
+	traceLookback     time.Duration
 }
 
 // New creates a backend client based on configuration.
 func New(ctx context.Context, cfg config.BackendConfig) (*Client, error) {
+	up, err := newUpstreams(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	metadataStore, err := newMetadataStore(ctx, cfg.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &Client{metadata: metadataStore}
+	client.up.Store(up)
+	client.startHealthProbes()
+	return client, nil
+}
+
+func newUpstreams(cfg config.BackendConfig) (*upstreams, error) {
 	oo, err := newOpenObserveClient(cfg.OpenObserve)
 	if err != nil {
 		return nil, err
 	}
+	oo.breaker = newBreaker("openobserve", cfg.CircuitBreaker)
 
 	var fb *promFallbackClient
 	if cfg.Fallback.Enabled {
@@ -41,95 +82,332 @@ func New(ctx context.Context, cfg config.BackendConfig) (*Client, error) {
 		if err != nil {
 			return nil, err
 		}
+		fb.breaker = newBreaker("fallback", cfg.CircuitBreaker)
 	}
 
-	metadataStore, err := newMetadataStore(ctx, cfg.Metadata)
+	var tempo *tempoClient
+	if cfg.Tempo.Enabled {
+		tempo, err = newTempoClient(cfg.Tempo)
+		if err != nil {
+			return nil, err
+		}
+		tempo.breaker = newBreaker("tempo", cfg.CircuitBreaker)
+	}
+
+	enforcer, err := newLabelEnforcer(cfg.LabelEnforcement)
 	if err != nil {
 		return nil, err
 	}
 
-	client := &Client{
+	up := &upstreams{
+		cfg:               cfg,
 		oo:                oo,
 		fallback:          fb,
-		metadata:          metadataStore,
+		tempo:             tempo,
+		labels:            enforcer,
 		defaultLogTable:   cfg.OpenObserve.LogTable,
 		defaultTraceTable: cfg.OpenObserve.TraceTable,
+		traceLookback:     cfg.OpenObserve.TraceLookback,
+	}
+	if up.defaultLogTable == "" {
+		up.defaultLogTable = "logs"
+	}
+	if up.defaultTraceTable == "" {
+		up.defaultTraceTable = "traces"
 	}
+	if up.traceLookback <= 0 {
+		up.traceLookback = 24 * time.Hour
+	}
+	return up, nil
+}
 
-	if client.defaultLogTable == "" {
-		client.defaultLogTable = "logs"
+// Reload swaps the OpenObserve, fallback and Tempo endpoints, circuit
+// breakers and label enforcement for new requests. The metadata store is
+// kept; changing it requires a restart.
+func (c *Client) Reload(cfg config.BackendConfig) error {
+	up, err := newUpstreams(cfg)
+	if err != nil {
+		return err
 	}
-	if client.defaultTraceTable == "" {
-		client.defaultTraceTable = "traces"
+	c.up.Store(up)
+	c.startHealthProbes()
+	return nil
+}
+
+// startHealthProbes (re)starts probing the current endpoints.
+func (c *Client) startHealthProbes() {
+	c.probeMu.Lock()
+	defer c.probeMu.Unlock()
+	if c.stopProbes != nil {
+		c.stopProbes()
+		c.stopProbes = nil
 	}
 
-	return client, nil
+	up := c.up.Load()
+	cfg := up.cfg
+	cb := cfg.CircuitBreaker
+	if !cb.Enabled || cb.ProbeInterval <= 0 {
+		return
+	}
+	timeout := cb.ProbeTimeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	probeClient := &http.Client{Timeout: timeout}
+
+	var probes []healthProbe
+	if cfg.OpenObserve.HealthEndpoint != "" {
+		probes = append(probes, healthProbe{breaker: up.oo.breaker, url: up.oo.resolve(cfg.OpenObserve.HealthEndpoint), client: probeClient, interval: cb.ProbeInterval})
+	}
+	if up.fallback != nil && cfg.Fallback.HealthEndpoint != "" {
+		probes = append(probes, healthProbe{breaker: up.fallback.breaker, url: up.fallback.resolve(cfg.Fallback.HealthEndpoint), client: probeClient, interval: cb.ProbeInterval})
+	}
+	if up.tempo != nil && cfg.Tempo.HealthEndpoint != "" {
+		probes = append(probes, healthProbe{breaker: up.tempo.breaker, url: up.tempo.resolve(cfg.Tempo.HealthEndpoint), client: probeClient, interval: cb.ProbeInterval})
+	}
+	if len(probes) == 0 {
+		return
+	}
+
+	var ctx context.Context
+	ctx, c.stopProbes = context.WithCancel(context.Background())
+	for _, p := range probes {
+		go p.run(ctx)
+	}
 }
 
-// QueryPromQL dispatches a PromQL request to OpenObserve with optional fallback.
+// QueryPromQL dispatches a PromQL request to OpenObserve with optional
+// fallback, after restricting it to the tenant's series when label
+// enforcement is enabled.
 func (c *Client) QueryPromQL(ctx context.Context, tenant string, req query.Request) (Result, error) {
-	meta, err := c.resolveTenantMetadata(ctx, tenant)
+	up := c.up.Load()
+	req, err := up.labels.enforce(tenant, req)
+	if err != nil {
+		return Result{}, err
+	}
+
+	meta, err := c.resolveTenantMetadata(ctx, up, tenant)
 	if err != nil {
 		return Result{}, err
 	}
 
-	res, err := c.oo.queryPromQL(ctx, meta.Org, tenant, req)
+	return up.withFallback(ctx, func() (Result, error) {
+		return up.oo.queryPromQL(ctx, meta.Org, tenant, req)
+	}, func() (Result, error) {
+		return up.fallback.queryPromQL(ctx, tenant, req)
+	})
+}
+
+// QueryPromMetadata lists series, label names or label values through the
+// Prometheus API of OpenObserve, falling back like QueryPromQL. With label
+// enforcement every selector is restricted to the tenant's series.
+func (c *Client) QueryPromMetadata(ctx context.Context, tenant string, req query.MetadataRequest) (Result, error) {
+	up := c.up.Load()
+	req, err := up.labels.enforceSelectors(tenant, req)
+	if err != nil {
+		return Result{}, err
+	}
+
+	meta, err := c.resolveTenantMetadata(ctx, up, tenant)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return up.withFallback(ctx, func() (Result, error) {
+		return up.oo.queryPromMetadata(ctx, meta.Org, tenant, req)
+	}, func() (Result, error) {
+		return up.fallback.queryPromMetadata(ctx, tenant, req)
+	})
+}
+
+// withFallback runs a Prometheus API call against OpenObserve and retries it
+// on the fallback backend.
+func (up *upstreams) withFallback(ctx context.Context, primary, fallback func() (Result, error)) (Result, error) {
+	res, err := primary()
 	if err == nil {
 		res.Backend = "openobserve-promql"
+		res.Breaker = up.oo.breaker.stateName()
 		return res, nil
 	}
 
+	// Fall back both for queries OpenObserve cannot run and for OpenObserve
+	// outages, including an open circuit breaker.
 	var unsupported *UnsupportedError
-	if errors.As(err, &unsupported) && c.fallback != nil {
-		fbRes, fbErr := c.fallback.queryPromQL(ctx, tenant, req)
+	if up.fallback != nil && (errors.As(err, &unsupported) || isBackendFailure(ctx, err)) {
+		fbRes, fbErr := fallback()
 		if fbErr == nil {
 			fbRes.Backend = "fallback-promql"
+			fbRes.Breaker = up.fallback.breaker.stateName()
 			return fbRes, nil
 		}
-		if fbErr != nil {
-			return Result{}, fbErr
-		}
+		return Result{}, fbErr
 	}
 
 	return Result{}, err
 }
 
 // QueryLogQL handles LogQL by translating into SQL and invoking OpenObserve search.
+// Metric queries are compiled into bucketed aggregations and returned as a
+// Prometheus matrix.
 func (c *Client) QueryLogQL(ctx context.Context, tenant string, req query.Request) (Result, error) {
-	meta, err := c.resolveTenantMetadata(ctx, tenant)
+	up := c.up.Load()
+	meta, err := c.resolveTenantMetadata(ctx, up, tenant)
 	if err != nil {
 		return Result{}, err
 	}
 
-	sql, err := translateLogQL(req.Query, meta.LogTable)
+	expr, err := parseLogQL(req.Query)
 	if err != nil {
 		return Result{}, err
 	}
-
-	body := map[string]any{
-		"sql":    sql,
-		"start":  req.Start,
-		"end":    req.End,
-		"tenant": tenant,
+	logQuery, ok := expr.(*logql.LogQuery)
+	if !ok {
+		return up.queryLogQLMetric(ctx, tenant, meta, expr, req)
 	}
 
-	payload, err := json.Marshal(body)
+	sql, err := compileLogQuery(logQuery, meta.LogTable, req.Forward())
 	if err != nil {
 		return Result{}, err
 	}
 
-	res, err := c.oo.postJSON(ctx, tenant, c.oo.logSearchURL(meta.Org), payload)
+	res, err := up.search(ctx, tenant, up.oo.logSearchURL(meta.Org), sql, req)
 	if err != nil {
 		return Result{}, err
 	}
 
 	res.Backend = "openobserve-logsql"
+	res.Breaker = up.oo.breaker.stateName()
 	return res, nil
 }
 
+// QueryLogLabels lists the label names, or the values of req.Label, of the
+// log records matching the LogQL selector in req.Matchers, as a Loki label
+// response.
+func (c *Client) QueryLogLabels(ctx context.Context, tenant string, req query.MetadataRequest) (Result, error) {
+	up := c.up.Load()
+	meta, err := c.resolveTenantMetadata(ctx, up, tenant)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var selector string
+	if len(req.Matchers) > 0 {
+		selector = req.Matchers[0]
+	}
+	sql, err := compileLabelQuery(selector, req.Label, meta.LogTable)
+	if err != nil {
+		return Result{}, err
+	}
+
+	res, err := up.search(ctx, tenant, up.oo.logSearchURL(meta.Org), sql, query.Request{Start: req.Start, End: req.End})
+	if err != nil {
+		return Result{}, err
+	}
+	hits, err := frame.DecodeHits(res.Payload)
+	if err != nil {
+		return Result{}, fmt.Errorf("decode openobserve search: %w", err)
+	}
+	column := "name"
+	if req.Label != "" {
+		column = "value"
+	}
+	values := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		values = append(values, frame.String(hit[column]))
+	}
+	payload, err := labelPayload(values, req.Limit)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{Payload: payload, Backend: "openobserve-logsql", Cost: res.Cost, Breaker: up.oo.breaker.stateName()}, nil
+}
+
+// StreamLogQL runs a LogQL log query and returns its records incrementally.
+func (c *Client) StreamLogQL(ctx context.Context, tenant string, req query.Request) (*Stream, error) {
+	up := c.up.Load()
+	meta, err := c.resolveTenantMetadata(ctx, up, tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	expr, err := parseLogQL(req.Query)
+	if err != nil {
+		return nil, err
+	}
+	logQuery, ok := expr.(*logql.LogQuery)
+	if !ok {
+		return nil, unsupportedLogQL("streaming is only supported for log queries")
+	}
+
+	sql, err := compileLogQuery(logQuery, meta.LogTable, req.Forward())
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := up.openSearch(ctx, tenant, up.oo.logSearchURL(meta.Org), sql, req)
+	if err != nil {
+		return nil, err
+	}
+	stream.Backend = "openobserve-logsql"
+	stream.Breaker = up.oo.breaker.stateName()
+	return stream, nil
+}
+
+func (up *upstreams) queryLogQLMetric(ctx context.Context, tenant string, meta tenantMetadata, expr logql.Expr, req query.Request) (Result, error) {
+	if !req.HasTimeRange() {
+		return Result{}, &QueryError{Lang: "logql", Err: fmt.Errorf("metric queries require start and end")}
+	}
+	step, err := metricStep(req)
+	if err != nil {
+		return Result{}, err
+	}
+
+	plan, err := compileMetricQuery(expr, meta.LogTable, step)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// Pagination applies to records, not to the aggregated buckets. The
+	// samples of the first range after Start aggregate buckets before it.
+	search := req
+	search.Limit, search.Offset = 0, 0
+	search.Start = plan.lookback(req.Start)
+	payload, err := searchBody(plan.SQL, tenant, search)
+	if err != nil {
+		return Result{}, err
+	}
+
+	res, err := up.oo.postJSON(ctx, tenant, up.oo.logSearchURL(meta.Org), payload)
+	if err != nil {
+		return Result{}, err
+	}
+
+	hits, err := frame.DecodeHits(res.Payload)
+	if err != nil {
+		return Result{}, fmt.Errorf("decode openobserve search: %w", err)
+	}
+
+	series, err := plan.evaluate(hits, req.Start, req.End)
+	if err != nil {
+		return Result{}, fmt.Errorf("evaluate logql metric: %w", err)
+	}
+	matrix, err := promapi.NewMatrix(series)
+	if err != nil {
+		return Result{}, err
+	}
+	out, err := json.Marshal(matrix)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{Payload: out, Backend: "openobserve-logsql-metric", Cost: res.Cost, Breaker: up.oo.breaker.stateName()}, nil
+}
+
 // QueryTraceQL handles TraceQL translations.
 func (c *Client) QueryTraceQL(ctx context.Context, tenant string, req query.Request) (Result, error) {
-	meta, err := c.resolveTenantMetadata(ctx, tenant)
+	up := c.up.Load()
+	meta, err := c.resolveTenantMetadata(ctx, up, tenant)
 	if err != nil {
 		return Result{}, err
 	}
@@ -139,39 +417,151 @@ func (c *Client) QueryTraceQL(ctx context.Context, tenant string, req query.Requ
 		return Result{}, err
 	}
 
+	res, err := up.search(ctx, tenant, up.oo.traceSearchURL(meta.Org), sql, req)
+	if err != nil {
+		return Result{}, err
+	}
+
+	res.Backend = "openobserve-tracesql"
+	res.Breaker = up.oo.breaker.stateName()
+	return res, nil
+}
+
+// StreamTraceQL runs a TraceQL query and returns its spans incrementally.
+func (c *Client) StreamTraceQL(ctx context.Context, tenant string, req query.Request) (*Stream, error) {
+	up := c.up.Load()
+	meta, err := c.resolveTenantMetadata(ctx, up, tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	sql, err := translateTraceQL(req.Query, meta.TraceTable)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := up.openSearch(ctx, tenant, up.oo.traceSearchURL(meta.Org), sql, req)
+	if err != nil {
+		return nil, err
+	}
+	stream.Backend = "openobserve-tracesql"
+	stream.Breaker = up.oo.breaker.stateName()
+	return stream, nil
+}
+
+// searchBody builds an OpenObserve search request. Paginated requests map
+// the gateway limit and cursor offset onto OpenObserve from/size.
+func searchBody(sql, tenant string, req query.Request) ([]byte, error) {
 	body := map[string]any{
 		"sql":    sql,
 		"start":  req.Start,
 		"end":    req.End,
 		"tenant": tenant,
 	}
+	if req.Limit > 0 {
+		body["from"] = req.Offset
+		body["size"] = req.Limit
+	}
+	return json.Marshal(body)
+}
 
-	payload, err := json.Marshal(body)
+func (up *upstreams) search(ctx context.Context, tenant, url, sql string, req query.Request) (Result, error) {
+	payload, err := searchBody(sql, tenant, req)
 	if err != nil {
 		return Result{}, err
 	}
 
-	res, err := c.oo.postJSON(ctx, tenant, c.oo.traceSearchURL(meta.Org), payload)
+	res, err := up.oo.postJSON(ctx, tenant, url, payload)
 	if err != nil {
 		return Result{}, err
 	}
-
-	res.Backend = "openobserve-tracesql"
+	if req.Limit > 0 {
+		var page struct {
+			Hits  []json.RawMessage `json:"hits"`
+			Total *int              `json:"total"`
+		}
+		if err := json.Unmarshal(res.Payload, &page); err != nil {
+			return Result{}, fmt.Errorf("decode openobserve search: %w", err)
+		}
+		res.Rows = len(page.Hits)
+		res.HasMore = len(page.Hits) == req.Limit
+		if page.Total != nil {
+			res.HasMore = req.Offset+len(page.Hits) < *page.Total
+		}
+	}
 	return res, nil
 }
 
+func (up *upstreams) openSearch(ctx context.Context, tenant, url, sql string, req query.Request) (*Stream, error) {
+	payload, err := searchBody(sql, tenant, req)
+	if err != nil {
+		return nil, err
+	}
+	return up.oo.postJSONStream(ctx, tenant, url, payload)
+}
+
 // Close releases any backend resources.
 func (c *Client) Close() {
+	c.probeMu.Lock()
+	if c.stopProbes != nil {
+		c.stopProbes()
+	}
+	c.probeMu.Unlock()
 	if c.metadata != nil {
 		c.metadata.Close()
 	}
 }
 
-func (c *Client) resolveTenantMetadata(ctx context.Context, tenant string) (tenantMetadata, error) {
+// MetadataPool returns the metadata database pool, or nil when the metadata
+// store is disabled.
+func (c *Client) MetadataPool() *pgxpool.Pool {
+	if c.metadata == nil {
+		return nil
+	}
+	return c.metadata.pool
+}
+
+// TenantBudget returns the tenant's query cost budget from the metadata
+// store, if one is configured.
+func (c *Client) TenantBudget(ctx context.Context, tenant string) (int64, bool, error) {
+	return c.metadata.LookupBudget(ctx, tenant)
+}
+
+// TenantLimit returns the tenant's rate limit from the metadata store, if
+// one is configured.
+func (c *Client) TenantLimit(ctx context.Context, tenant string) (limiter.Limit, bool, error) {
+	rps, burst, ok, err := c.metadata.LookupRateLimit(ctx, tenant)
+	return limiter.Limit{RequestsPerSecond: rps, Burst: burst}, ok, err
+}
+
+// tenantMetadataKey is the context key of metadata resolved ahead of the
+// queries using it.
+type tenantMetadataKey struct{}
+
+type resolvedMetadata struct {
+	tenant string
+	meta   tenantMetadata
+}
+
+// WithTenantMetadata looks up the tenant's metadata once and returns a
+// context whose queries for the tenant reuse it, such as the queries of a
+// batch.
+func (c *Client) WithTenantMetadata(ctx context.Context, tenant string) (context.Context, error) {
+	meta, err := c.resolveTenantMetadata(ctx, c.up.Load(), tenant)
+	if err != nil {
+		return nil, err
+	}
+	return context.WithValue(ctx, tenantMetadataKey{}, resolvedMetadata{tenant: tenant, meta: meta}), nil
+}
+
+func (c *Client) resolveTenantMetadata(ctx context.Context, up *upstreams, tenant string) (tenantMetadata, error) {
+	if resolved, ok := ctx.Value(tenantMetadataKey{}).(resolvedMetadata); ok && resolved.tenant == tenant {
+		return resolved.meta, nil
+	}
 	meta := tenantMetadata{
-		Org:        c.oo.defaultOrg,
-		LogTable:   c.defaultLogTable,
-		TraceTable: c.defaultTraceTable,
+		Org:        up.oo.defaultOrg,
+		LogTable:   up.defaultLogTable,
+		TraceTable: up.defaultTraceTable,
 	}
 
 	if c.metadata == nil {
@@ -202,14 +592,24 @@ func (c *Client) resolveTenantMetadata(ctx context.Context, tenant string) (tena
 // ---- OpenObserve client implementation ----
 
 type openObserveClient struct {
-	baseURL     *url.URL
-	defaultOrg  string
-	apiKey      string
-	http        *http.Client
+	baseURL    *url.URL
+	defaultOrg string
+	apiKey     string
+	http       *http.Client
+	// stream reads streamed searches, whose bodies outlast the timeout of
+	// http; only the wait for response headers is bounded.
+	stream      *http.Client
 	promQuery   string
 	promRange   string
 	logSearch   string
 	traceSearch string
+	breaker     *breaker
+
+	// schema lists the fields of a stream named by {stream}.
+	schema string
+
+	// promMetadata maps a metadata request kind to its endpoint.
+	promMetadata map[string]string
 }
 
 func newOpenObserveClient(cfg config.OpenObserveConfig) (*openObserveClient, error) {
@@ -227,15 +627,25 @@ func newOpenObserveClient(cfg config.OpenObserveConfig) (*openObserveClient, err
 		timeout = 30 * time.Second
 	}
 
+	streamTransport := http.DefaultTransport.(*http.Transport).Clone()
+	streamTransport.ResponseHeaderTimeout = timeout
+
 	return &openObserveClient{
 		baseURL:     parsed,
 		defaultOrg:  cfg.Org,
 		apiKey:      cfg.APIKey,
-		http:        &http.Client{Timeout: timeout},
+		http:        &http.Client{Timeout: timeout, Transport: telemetry.Transport("openobserve", nil)},
+		stream:      &http.Client{Transport: telemetry.Transport("openobserve", streamTransport)},
 		promQuery:   cfg.PromQueryEndpoint,
 		promRange:   cfg.PromRangeEndpoint,
 		logSearch:   cfg.LogSearchEndpoint,
 		traceSearch: cfg.TraceSearchEndpoint,
+		schema:      cfg.StreamSchemaEndpoint,
+		promMetadata: map[string]string{
+			query.MetadataSeries:      cfg.PromSeriesEndpoint,
+			query.MetadataLabels:      cfg.PromLabelsEndpoint,
+			query.MetadataLabelValues: cfg.PromLabelValuesEndpoint,
+		},
 	}, nil
 }
 
@@ -255,6 +665,19 @@ func (c *openObserveClient) promURL(org string, isRange bool) (string, error) {
 	return c.resolve(rel), nil
 }
 
+// promMetadataURL resolves the endpoint of a metadata request. A missing
+// endpoint is reported as unsupported so the fallback can serve it.
+func (c *openObserveClient) promMetadataURL(org string, req query.MetadataRequest) (string, error) {
+	endpoint := c.promMetadata[req.Kind]
+	if endpoint == "" {
+		return "", &UnsupportedError{Status: http.StatusNotImplemented, Message: fmt.Sprintf("openobserve %s endpoint not configured", req.Kind)}
+	}
+	if strings.Contains(endpoint, "%s") {
+		endpoint = fmt.Sprintf(endpoint, c.resolveOrg(org))
+	}
+	return c.resolve(strings.ReplaceAll(endpoint, "{name}", url.PathEscape(req.Label))), nil
+}
+
 func (c *openObserveClient) logSearchURL(org string) string {
 	endpoint := c.logSearch
 	resolvedOrg := c.resolveOrg(org)
@@ -290,6 +713,12 @@ func (c *openObserveClient) resolve(rel string) string {
 }
 
 func (c *openObserveClient) queryPromQL(ctx context.Context, org, tenant string, req query.Request) (Result, error) {
+	return guard(ctx, c.breaker, func() (Result, error) {
+		return c.doPromQL(ctx, org, tenant, req)
+	})
+}
+
+func (c *openObserveClient) doPromQL(ctx context.Context, org, tenant string, req query.Request) (Result, error) {
 	isRange := req.HasTimeRange()
 	endpoint, err := c.promURL(org, isRange)
 	if err != nil {
@@ -308,7 +737,11 @@ func (c *openObserveClient) queryPromQL(ctx context.Context, org, tenant string,
 				params.Set("step", strconv.FormatFloat(step.Seconds(), 'f', -1, 64))
 			}
 		} else {
-			params.Set("time", strconv.FormatFloat(float64(time.Now().UnixNano())/1e9, 'f', -1, 64))
+			at := req.Time
+			if at.IsZero() {
+				at = time.Now()
+			}
+			params.Set("time", unixSeconds(at))
 		}
 		u.RawQuery = params.Encode()
 		q = u.String()
@@ -335,7 +768,7 @@ func (c *openObserveClient) queryPromQL(ctx context.Context, org, tenant string,
 		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusNotImplemented {
 			return Result{}, &UnsupportedError{Status: resp.StatusCode, Message: string(body)}
 		}
-		return Result{}, fmt.Errorf("openobserve promql error: %s", string(body))
+		return Result{}, responseError("openobserve promql", resp.StatusCode, body)
 	}
 
 	return Result{
@@ -344,7 +777,51 @@ func (c *openObserveClient) queryPromQL(ctx context.Context, org, tenant string,
 	}, nil
 }
 
+func (c *openObserveClient) queryPromMetadata(ctx context.Context, org, tenant string, req query.MetadataRequest) (Result, error) {
+	return guard(ctx, c.breaker, func() (Result, error) {
+		endpoint, err := c.promMetadataURL(org, req)
+		if err != nil {
+			return Result{}, err
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return Result{}, err
+		}
+		u.RawQuery = metadataParams(u.Query(), req).Encode()
+
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return Result{}, err
+		}
+		c.applyHeaders(httpReq, tenant)
+
+		resp, err := c.http.Do(httpReq)
+		if err != nil {
+			return Result{}, err
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return Result{}, err
+		}
+		if resp.StatusCode >= 400 {
+			if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusNotImplemented {
+				return Result{}, &UnsupportedError{Status: resp.StatusCode, Message: string(body)}
+			}
+			return Result{}, responseError("openobserve "+req.Kind, resp.StatusCode, body)
+		}
+		return Result{Payload: json.RawMessage(body), Cost: parseCost(resp.Header)}, nil
+	})
+}
+
 func (c *openObserveClient) postJSON(ctx context.Context, tenant, url string, payload []byte) (Result, error) {
+	return guard(ctx, c.breaker, func() (Result, error) {
+		return c.doPostJSON(ctx, tenant, url, payload)
+	})
+}
+
+func (c *openObserveClient) doPostJSON(ctx context.Context, tenant, url string, payload []byte) (Result, error) {
 	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
 	if err != nil {
 		return Result{}, err
@@ -364,12 +841,43 @@ func (c *openObserveClient) postJSON(ctx context.Context, tenant, url string, pa
 	}
 
 	if resp.StatusCode >= 400 {
-		return Result{}, fmt.Errorf("openobserve error: %s", string(body))
+		return Result{}, responseError("openobserve", resp.StatusCode, body)
 	}
 
 	return Result{Payload: json.RawMessage(body), Cost: parseCost(resp.Header)}, nil
 }
 
+// postJSONStream posts a search and hands the response body to a Stream
+// without buffering it.
+func (c *openObserveClient) postJSONStream(ctx context.Context, tenant, url string, payload []byte) (*Stream, error) {
+	if !c.breaker.Allow() {
+		return nil, &CircuitOpenError{Backend: c.breaker.name}
+	}
+	stream, err := c.doPostJSONStream(ctx, tenant, url, payload)
+	c.breaker.finish(ctx, err)
+	return stream, err
+}
+
+func (c *openObserveClient) doPostJSONStream(ctx context.Context, tenant, url string, payload []byte) (*Stream, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	c.applyHeaders(httpReq, tenant)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.stream.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		return nil, responseError("openobserve", resp.StatusCode, body)
+	}
+	return NewStream(resp.Body, parseCost(resp.Header)), nil
+}
+
 func (c *openObserveClient) applyHeaders(req *http.Request, tenant string) {
 	if c.apiKey != "" {
 		req.Header.Set("Authorization", "Bearer "+c.apiKey)
@@ -387,6 +895,9 @@ type promFallbackClient struct {
 	queryPath string
 	rangePath string
 	apiKey    string
+	breaker   *breaker
+
+	promMetadata map[string]string
 }
 
 func newPromFallbackClient(cfg config.FallbackConfig) (*promFallbackClient, error) {
@@ -403,10 +914,15 @@ func newPromFallbackClient(cfg config.FallbackConfig) (*promFallbackClient, erro
 	}
 	return &promFallbackClient{
 		baseURL:   parsed,
-		http:      &http.Client{Timeout: timeout},
+		http:      &http.Client{Timeout: timeout, Transport: telemetry.Transport("fallback", nil)},
 		queryPath: cfg.QueryEndpoint,
 		rangePath: cfg.RangeEndpoint,
 		apiKey:    cfg.APIKey,
+		promMetadata: map[string]string{
+			query.MetadataSeries:      cfg.SeriesEndpoint,
+			query.MetadataLabels:      cfg.LabelsEndpoint,
+			query.MetadataLabelValues: cfg.LabelValuesEndpoint,
+		},
 	}, nil
 }
 
@@ -421,15 +937,40 @@ func (c *promFallbackClient) promURL(isRange bool) (string, error) {
 			endpoint = "/api/v1/query_range"
 		}
 	}
+	return c.resolve(endpoint), nil
+}
+
+func (c *promFallbackClient) promMetadataURL(req query.MetadataRequest) string {
+	endpoint := c.promMetadata[req.Kind]
+	if endpoint == "" {
+		switch req.Kind {
+		case query.MetadataSeries:
+			endpoint = "/api/v1/series"
+		case query.MetadataLabels:
+			endpoint = "/api/v1/labels"
+		default:
+			endpoint = "/api/v1/label/{name}/values"
+		}
+	}
+	return c.resolve(strings.ReplaceAll(endpoint, "{name}", url.PathEscape(req.Label)))
+}
+
+func (c *promFallbackClient) resolve(endpoint string) string {
 	if strings.HasPrefix(endpoint, "http") {
-		return endpoint, nil
+		return endpoint
 	}
 	u := *c.baseURL
 	u.Path = path.Join(c.baseURL.Path, endpoint)
-	return u.String(), nil
+	return u.String()
 }
 
 func (c *promFallbackClient) queryPromQL(ctx context.Context, tenant string, req query.Request) (Result, error) {
+	return guard(ctx, c.breaker, func() (Result, error) {
+		return c.doPromQL(ctx, tenant, req)
+	})
+}
+
+func (c *promFallbackClient) doPromQL(ctx context.Context, tenant string, req query.Request) (Result, error) {
 	isRange := req.HasTimeRange()
 	endpoint, err := c.promURL(isRange)
 	if err != nil {
@@ -447,6 +988,8 @@ func (c *promFallbackClient) queryPromQL(ctx context.Context, tenant string, req
 		if step, err := req.StepDuration(); err == nil && step > 0 {
 			params.Set("step", strconv.FormatFloat(step.Seconds(), 'f', -1, 64))
 		}
+	} else if !req.Time.IsZero() {
+		params.Set("time", unixSeconds(req.Time))
 	}
 	u.RawQuery = params.Encode()
 
@@ -473,12 +1016,70 @@ func (c *promFallbackClient) queryPromQL(ctx context.Context, tenant string, req
 	}
 
 	if resp.StatusCode >= 400 {
-		return Result{}, fmt.Errorf("fallback promql error: %s", string(body))
+		return Result{}, responseError("fallback promql", resp.StatusCode, body)
 	}
 
 	return Result{Payload: json.RawMessage(body), Cost: parseCost(resp.Header)}, nil
 }
 
+func (c *promFallbackClient) queryPromMetadata(ctx context.Context, tenant string, req query.MetadataRequest) (Result, error) {
+	return guard(ctx, c.breaker, func() (Result, error) {
+		u, err := url.Parse(c.promMetadataURL(req))
+		if err != nil {
+			return Result{}, err
+		}
+		u.RawQuery = metadataParams(u.Query(), req).Encode()
+
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return Result{}, err
+		}
+		if c.apiKey != "" {
+			httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+		}
+		if tenant != "" {
+			httpReq.Header.Set("X-Tenant", tenant)
+		}
+
+		resp, err := c.http.Do(httpReq)
+		if err != nil {
+			return Result{}, err
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return Result{}, err
+		}
+		if resp.StatusCode >= 400 {
+			return Result{}, responseError("fallback "+req.Kind, resp.StatusCode, body)
+		}
+		return Result{Payload: json.RawMessage(body), Cost: parseCost(resp.Header)}, nil
+	})
+}
+
+// metadataParams adds the Prometheus API parameters of a metadata request.
+func metadataParams(params url.Values, req query.MetadataRequest) url.Values {
+	for _, selector := range req.Matchers {
+		params.Add("match[]", selector)
+	}
+	if !req.Start.IsZero() {
+		params.Set("start", unixSeconds(req.Start))
+	}
+	if !req.End.IsZero() {
+		params.Set("end", unixSeconds(req.End))
+	}
+	if req.Limit > 0 {
+		params.Set("limit", strconv.Itoa(req.Limit))
+	}
+	return params
+}
+
+// unixSeconds formats t as fractional Unix seconds.
+func unixSeconds(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano())/1e9, 'f', -1, 64)
+}
+
 func parseCost(h http.Header) int64 {
 	if h == nil {
 		return 0
@@ -499,138 +1100,12 @@ func parseCost(h http.Header) int64 {
 
 // --- Translators ---
 
-func translateLogQL(q, table string) (string, error) {
-	q = strings.TrimSpace(q)
-	if q == "" {
-		return "", fmt.Errorf("empty logql")
+// streamTable validates a stream name taken from tenant metadata, falling
+// back to def when none is configured.
+func streamTable(name, def string) (sqlbuilder.Identifier, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = def
 	}
-
-	table = sanitizeSQLIdentifier(table)
-	if table == "" {
-		table = "logs"
-	}
-
-	var conditions []string
-	if strings.HasPrefix(q, "{") {
-		idx := strings.Index(q, "}")
-		if idx == -1 {
-			return "", fmt.Errorf("invalid logql selector")
-		}
-		selector := q[1:idx]
-		q = strings.TrimSpace(q[idx+1:])
-		if selector != "" {
-			parts := strings.Split(selector, ",")
-			for _, part := range parts {
-				part = strings.TrimSpace(part)
-				if part == "" {
-					continue
-				}
-				switch {
-				case strings.Contains(part, "!="):
-					kv := strings.SplitN(part, "!=", 2)
-					if len(kv) != 2 {
-						continue
-					}
-					conditions = append(conditions, fmt.Sprintf("labels->>'%s' <> '%s'", sanitizeSQLIdentifier(kv[0]), escapeSQLValue(kv[1])))
-				case strings.Contains(part, "="):
-					kv := strings.SplitN(part, "=", 2)
-					if len(kv) != 2 {
-						continue
-					}
-					conditions = append(conditions, fmt.Sprintf("labels->>'%s' = '%s'", sanitizeSQLIdentifier(kv[0]), escapeSQLValue(kv[1])))
-				}
-			}
-		}
-	}
-
-	matches := logPipelineRegex.FindAllStringSubmatch(q, -1)
-	for _, m := range matches {
-		op := m[1]
-		val := escapeSQLValue(m[2])
-		switch op {
-		case "=":
-			conditions = append(conditions, fmt.Sprintf("message ILIKE '%%%s%%'", val))
-		case "!=":
-			conditions = append(conditions, fmt.Sprintf("message NOT ILIKE '%%%s%%'", val))
-		case "~":
-			conditions = append(conditions, fmt.Sprintf("message ~ '%s'", val))
-		case "!~":
-			conditions = append(conditions, fmt.Sprintf("message !~ '%s'", val))
-		}
-	}
-
-	if len(conditions) == 0 {
-		conditions = append(conditions, "1=1")
-	}
-
-	return fmt.Sprintf("SELECT * FROM %s WHERE %s", table, strings.Join(conditions, " AND ")), nil
+	return sqlbuilder.Ident(name)
 }
-
-func translateTraceQL(q, table string) (string, error) {
-	q = strings.TrimSpace(q)
-	if q == "" {
-		return "", fmt.Errorf("empty traceql")
-	}
-
-	table = sanitizeSQLIdentifier(table)
-	if table == "" {
-		table = "traces"
-	}
-
-	lower := strings.ToLower(q)
-	if !strings.HasPrefix(lower, "from") {
-		return "", fmt.Errorf("traceql must start with FROM")
-	}
-
-	tokens := strings.Fields(q)
-	if len(tokens) < 2 {
-		return "", fmt.Errorf("traceql missing stream")
-	}
-	stream := tokens[1]
-	conditions := []string{fmt.Sprintf("trace_stream = '%s'", escapeSQLValue(stream))}
-
-	whereIdx := strings.Index(lower, " where ")
-	if whereIdx != -1 {
-		condExpr := strings.TrimSpace(q[whereIdx+7:])
-		if condExpr != "" {
-			parts := strings.Split(condExpr, " and ")
-			for _, part := range parts {
-				part = strings.TrimSpace(part)
-				if part == "" {
-					continue
-				}
-				switch {
-				case strings.Contains(part, "!="):
-					kv := strings.SplitN(part, "!=", 2)
-					conditions = append(conditions, fmt.Sprintf("attributes->>'%s' <> '%s'", sanitizeSQLIdentifier(kv[0]), escapeSQLValue(kv[1])))
-				case strings.Contains(part, "="):
-					kv := strings.SplitN(part, "=", 2)
-					conditions = append(conditions, fmt.Sprintf("attributes->>'%s' = '%s'", sanitizeSQLIdentifier(kv[0]), escapeSQLValue(kv[1])))
-				case strings.Contains(part, ">"):
-					kv := strings.SplitN(part, ">", 2)
-					conditions = append(conditions, fmt.Sprintf("%s > %s", sanitizeSQLIdentifier(kv[0]), strings.TrimSpace(kv[1])))
-				case strings.Contains(part, "<"):
-					kv := strings.SplitN(part, "<", 2)
-					conditions = append(conditions, fmt.Sprintf("%s < %s", sanitizeSQLIdentifier(kv[0]), strings.TrimSpace(kv[1])))
-				}
-			}
-		}
-	}
-
-	return fmt.Sprintf("SELECT * FROM %s WHERE %s", table, strings.Join(conditions, " AND ")), nil
-}
-
-func sanitizeSQLIdentifier(in string) string {
-	in = strings.TrimSpace(in)
-	in = strings.Trim(in, "\"`'")
-	in = strings.ReplaceAll(in, " ", "_")
-	return in
-}
-
-func escapeSQLValue(in string) string {
-	in = strings.TrimSpace(in)
-	in = strings.Trim(in, "\"`'")
-	return strings.ReplaceAll(in, "'", "''")
-}
-
-var logPipelineRegex = regexp.MustCompile(`\|([=!~]{1,2})\s*"([^"]*)"`)
@@ -0,0 +1,90 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/xscopehub/observe-gateway/internal/logql"
+	"github.com/xscopehub/observe-gateway/internal/query"
+)
+
+// Plan describes how a query would be run, without running it.
+type Plan struct {
+	// Query is the query sent to the backend, after tenant label
+	// enforcement.
+	Query string
+	// SQL is the OpenObserve SQL a LogQL or TraceQL query translates to.
+	SQL string
+	// Backend names the backend that would serve the query, as reported in
+	// Result.Backend.
+	Backend string
+	Breaker string
+	// Org is the tenant's OpenObserve organization.
+	Org string
+	// Matchers are the label matchers enforced on the tenant's PromQL
+	// queries.
+	Matchers []string
+}
+
+// Explain translates a query the way the matching Query method would and
+// reports where it would be sent. A PromQL query is planned for the
+// fallback backend while the OpenObserve circuit breaker is open.
+func (c *Client) Explain(ctx context.Context, tenant string, req query.Request) (Plan, error) {
+	up := c.up.Load()
+	meta, err := c.resolveTenantMetadata(ctx, up, tenant)
+	if err != nil {
+		return Plan{}, err
+	}
+	plan := Plan{Query: req.Query, Org: meta.Org, Breaker: up.oo.breaker.stateName(), Matchers: []string{}}
+
+	switch req.Lang {
+	case "promql":
+		if up.labels != nil {
+			matchers, err := up.labels.matchers(tenant)
+			if err != nil {
+				return Plan{}, &QueryError{Lang: "promql", Err: err}
+			}
+			for _, m := range matchers {
+				plan.Matchers = append(plan.Matchers, m.String())
+			}
+		}
+		enforced, err := up.labels.enforce(tenant, req)
+		if err != nil {
+			return Plan{}, err
+		}
+		plan.Query = enforced.Query
+		plan.Backend = "openobserve-promql"
+		if up.fallback != nil && up.oo.breaker.State() == BreakerOpen {
+			plan.Backend = "fallback-promql"
+		}
+	case "logql":
+		expr, err := parseLogQL(req.Query)
+		if err != nil {
+			return Plan{}, err
+		}
+		if logQuery, ok := expr.(*logql.LogQuery); ok {
+			if plan.SQL, err = compileLogQuery(logQuery, meta.LogTable, req.Forward()); err != nil {
+				return Plan{}, err
+			}
+			plan.Backend = "openobserve-logsql"
+			break
+		}
+		step, err := metricStep(req)
+		if err != nil {
+			return Plan{}, err
+		}
+		metric, err := compileMetricQuery(expr, meta.LogTable, step)
+		if err != nil {
+			return Plan{}, err
+		}
+		plan.SQL, plan.Backend = metric.SQL, "openobserve-logsql-metric"
+	case "traceql":
+		if plan.SQL, err = translateTraceQL(req.Query, meta.TraceTable); err != nil {
+			return Plan{}, err
+		}
+		plan.Backend = "openobserve-tracesql"
+	default:
+		return Plan{}, fmt.Errorf("unsupported language: %s", req.Lang)
+	}
+	return plan, nil
+}
@@ -0,0 +1,84 @@
+package backend
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/config"
+	"github.com/xscopehub/observe-gateway/internal/logql"
+	"github.com/xscopehub/observe-gateway/internal/query"
+)
+
+func TestExplain(t *testing.T) {
+	calls := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer upstream.Close()
+
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatalf("config.Load() error = %v", err)
+	}
+	cfg.Backends.OpenObserve.BaseURL = upstream.URL
+	cfg.Backends.LabelEnforcement = config.LabelEnforcementConfig{
+		Enabled:     true,
+		TenantLabel: "tenant",
+		Tenants:     map[string]string{"tenant-b": `{cluster=~"eu-.*"}`},
+	}
+	client, err := New(context.Background(), cfg.Backends)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	plan, err := client.Explain(ctx, "tenant-b", query.Request{Lang: "promql", Query: "sum(rate(http_requests_total[5m]))"})
+	if err != nil {
+		t.Fatalf("Explain(promql) error = %v", err)
+	}
+	if want := `sum(rate(http_requests_total{cluster=~"eu-.*",tenant="tenant-b"}[5m]))`; plan.Query != want {
+		t.Fatalf("query = %q, want %q", plan.Query, want)
+	}
+	if strings.Join(plan.Matchers, ",") != `tenant="tenant-b",cluster=~"eu-.*"` || plan.Backend != "openobserve-promql" || plan.SQL != "" {
+		t.Fatalf("plan = %+v", plan)
+	}
+
+	start := time.Unix(1700000000, 0)
+	req := query.Request{Lang: "logql", Query: `{app="api"} |= "timeout"`, Start: start, End: start.Add(time.Hour)}
+	if plan, err = client.Explain(ctx, "tenant-a", req); err != nil {
+		t.Fatalf("Explain(logql) error = %v", err)
+	}
+	expr, _ := logql.ParseExpr(req.Query)
+	want, _ := compileLogQuery(expr.(*logql.LogQuery), cfg.Backends.OpenObserve.LogTable, false)
+	if plan.SQL != want || plan.Backend != "openobserve-logsql" || len(plan.Matchers) != 0 {
+		t.Fatalf("plan = %+v, want sql %q", plan, want)
+	}
+
+	req.Query = `sum by (app) (count_over_time({app="api"}[5m]))`
+	if plan, err = client.Explain(ctx, "tenant-a", req); err != nil {
+		t.Fatalf("Explain(logql metric) error = %v", err)
+	}
+	if plan.Backend != "openobserve-logsql-metric" || !strings.Contains(plan.SQL, "GROUP BY") {
+		t.Fatalf("plan = %+v, want a bucketed aggregation", plan)
+	}
+
+	req.Lang, req.Query = "traceql", `{ resource.service.name = "api" }`
+	if plan, err = client.Explain(ctx, "tenant-a", req); err != nil {
+		t.Fatalf("Explain(traceql) error = %v", err)
+	}
+	if want, _ := translateTraceQL(req.Query, cfg.Backends.OpenObserve.TraceTable); plan.SQL != want || plan.Backend != "openobserve-tracesql" {
+		t.Fatalf("plan = %+v, want sql %q", plan, want)
+	}
+
+	if _, err := client.Explain(ctx, "tenant-a", query.Request{Lang: "logql", Query: `{app=`}); err == nil {
+		t.Fatal("Explain() of an invalid query error = nil")
+	}
+	if calls != 0 {
+		t.Fatalf("backend calls = %d, want none", calls)
+	}
+}
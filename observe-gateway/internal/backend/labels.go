@@ -0,0 +1,101 @@
+package backend
+
+import (
+	"fmt"
+
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/xscopehub/observe-gateway/internal/config"
+	"github.com/xscopehub/observe-gateway/internal/promlabel"
+	"github.com/xscopehub/observe-gateway/internal/query"
+)
+
+// labelEnforcer rewrites PromQL queries so that every vector selector is
+// restricted to the tenant's series. A nil enforcer leaves queries untouched.
+type labelEnforcer struct {
+	tenantLabel string
+	tenants     map[string][]*labels.Matcher
+}
+
+func newLabelEnforcer(cfg config.LabelEnforcementConfig) (*labelEnforcer, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	e := &labelEnforcer{tenantLabel: cfg.TenantLabel, tenants: make(map[string][]*labels.Matcher, len(cfg.Tenants))}
+	for tenant, selector := range cfg.Tenants {
+		matchers, err := promlabel.ParseMatchers(selector)
+		if err != nil {
+			return nil, fmt.Errorf("label enforcement for tenant %s: %w", tenant, err)
+		}
+		e.tenants[tenant] = matchers
+	}
+	return e, nil
+}
+
+// matchers returns the matchers enforced for tenant. Per-tenant matchers on
+// the tenant label take precedence over the tenant ID. A tenant without
+// matchers is refused rather than given every tenant's series.
+func (e *labelEnforcer) matchers(tenant string) ([]*labels.Matcher, error) {
+	extra := e.tenants[tenant]
+	out := make([]*labels.Matcher, 0, len(extra)+1)
+	if e.tenantLabel != "" {
+		overridden := false
+		for _, m := range extra {
+			overridden = overridden || m.Name == e.tenantLabel
+		}
+		if !overridden {
+			m, err := labels.NewMatcher(labels.MatchEqual, e.tenantLabel, tenant)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, m)
+		}
+	}
+	out = append(out, extra...)
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no label enforcement configured for tenant %s", tenant)
+	}
+	return out, nil
+}
+
+// enforce returns req with the tenant's matchers injected into the query.
+func (e *labelEnforcer) enforce(tenant string, req query.Request) (query.Request, error) {
+	if e == nil {
+		return req, nil
+	}
+	matchers, err := e.matchers(tenant)
+	if err != nil {
+		return req, &QueryError{Lang: "promql", Err: err}
+	}
+	rewritten, err := promlabel.Enforce(req.Query, matchers)
+	if err != nil {
+		return req, &QueryError{Lang: "promql", Err: err}
+	}
+	req.Query = rewritten
+	return req, nil
+}
+
+// enforceSelectors restricts the series selectors of a metadata request to
+// the tenant's series. A request without selectors gets the tenant's
+// matchers as its only selector, so other tenants' labels are not listed.
+func (e *labelEnforcer) enforceSelectors(tenant string, req query.MetadataRequest) (query.MetadataRequest, error) {
+	if e == nil {
+		return req, nil
+	}
+	matchers, err := e.matchers(tenant)
+	if err != nil {
+		return req, &QueryError{Lang: "promql", Err: err}
+	}
+	if len(req.Matchers) == 0 {
+		req.Matchers = []string{promlabel.Selector(matchers)}
+		return req, nil
+	}
+	selectors := make([]string, len(req.Matchers))
+	for i, selector := range req.Matchers {
+		if selectors[i], err = promlabel.EnforceSelector(selector, matchers); err != nil {
+			return req, &QueryError{Lang: "promql", Err: err}
+		}
+	}
+	req.Matchers = selectors
+	return req, nil
+}
@@ -0,0 +1,166 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/xscopehub/observe-gateway/internal/config"
+	"github.com/xscopehub/observe-gateway/internal/query"
+)
+
+func TestQueryPromQLEnforcesTenantLabels(t *testing.T) {
+	var forwarded []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = append(forwarded, r.URL.Query().Get("query"))
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+	}))
+	defer upstream.Close()
+
+	client, err := New(context.Background(), config.BackendConfig{
+		OpenObserve: config.OpenObserveConfig{BaseURL: upstream.URL, Org: "default", PromQueryEndpoint: "/api/%s/promql/query"},
+		LabelEnforcement: config.LabelEnforcementConfig{
+			Enabled:     true,
+			TenantLabel: "tenant",
+			Tenants:     map[string]string{"tenant-b": `{cluster=~"eu-.*"}`},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	for tenant, want := range map[string]string{
+		"tenant-a": `sum(rate(http_requests_total{tenant="tenant-a"}[5m]))`,
+		"tenant-b": `sum(rate(http_requests_total{cluster=~"eu-.*",tenant="tenant-b"}[5m]))`,
+	} {
+		forwarded = nil
+		if _, err := client.QueryPromQL(ctx, tenant, query.Request{Lang: "promql", Query: "sum(rate(http_requests_total[5m]))"}); err != nil {
+			t.Fatalf("QueryPromQL(%s) error = %v", tenant, err)
+		}
+		if len(forwarded) != 1 || forwarded[0] != want {
+			t.Fatalf("forwarded = %q, want %q", forwarded, want)
+		}
+	}
+
+	forwarded = nil
+	_, err = client.QueryPromQL(ctx, "tenant-a", query.Request{Lang: "promql", Query: `up{tenant="tenant-b"}`})
+	var invalid *QueryError
+	if !errors.As(err, &invalid) || len(forwarded) != 0 {
+		t.Fatalf("error = %v, forwarded = %q, want a QueryError without dispatch", err, forwarded)
+	}
+}
+
+func TestEnforcementRefusesUnlistedTenants(t *testing.T) {
+	var forwarded int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded++
+		w.Write([]byte(`{"status":"success","data":[]}`))
+	}))
+	defer upstream.Close()
+
+	// Without a tenant label only listed tenants have matchers.
+	client, err := New(context.Background(), config.BackendConfig{
+		OpenObserve: config.OpenObserveConfig{BaseURL: upstream.URL, Org: "default", PromQueryEndpoint: "/api/%s/promql/query"},
+		LabelEnforcement: config.LabelEnforcementConfig{
+			Enabled: true,
+			Tenants: map[string]string{"tenant-b": `{cluster=~"eu-.*"}`},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	var invalid *QueryError
+	_, err = client.QueryPromQL(ctx, "tenant-a", query.Request{Lang: "promql", Query: "up"})
+	if !errors.As(err, &invalid) {
+		t.Fatalf("QueryPromQL() error = %v, want a QueryError", err)
+	}
+	_, err = client.QueryPromMetadata(ctx, "tenant-a", query.MetadataRequest{Kind: query.MetadataLabels})
+	if !errors.As(err, &invalid) {
+		t.Fatalf("QueryPromMetadata() error = %v, want a QueryError", err)
+	}
+	if forwarded != 0 {
+		t.Fatalf("forwarded %d requests of an unlisted tenant", forwarded)
+	}
+}
+
+func TestNewRejectsInvalidEnforcedMatchers(t *testing.T) {
+	_, err := New(context.Background(), config.BackendConfig{
+		OpenObserve:      config.OpenObserveConfig{BaseURL: "http://localhost", Org: "default"},
+		LabelEnforcement: config.LabelEnforcementConfig{Enabled: true, Tenants: map[string]string{"tenant-a": `{cluster=`}},
+	})
+	if err == nil {
+		t.Fatalf("New() accepted an invalid selector")
+	}
+}
+
+func TestQueryPromMetadataEnforcesSelectors(t *testing.T) {
+	var paths []string
+	var matches [][]string
+	openobserve := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		matches = append(matches, r.URL.Query()["match[]"])
+		if r.URL.Path == "/api/default/promql/series" {
+			http.Error(w, "not implemented", http.StatusNotImplemented)
+			return
+		}
+		w.Write([]byte(`{"status":"success","data":[]}`))
+	}))
+	defer openobserve.Close()
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		matches = append(matches, r.URL.Query()["match[]"])
+		w.Write([]byte(`{"status":"success","data":[]}`))
+	}))
+	defer fallback.Close()
+
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatalf("config.Load() error = %v", err)
+	}
+	cfg.Backends.OpenObserve.BaseURL = openobserve.URL
+	cfg.Backends.Fallback = config.FallbackConfig{Enabled: true, BaseURL: fallback.URL}
+	cfg.Backends.LabelEnforcement = config.LabelEnforcementConfig{Enabled: true, TenantLabel: "tenant"}
+	client, err := New(context.Background(), cfg.Backends)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	res, err := client.QueryPromMetadata(ctx, "tenant-a", query.MetadataRequest{Kind: query.MetadataLabelValues, Label: "job"})
+	if err != nil || res.Backend != "openobserve-promql" {
+		t.Fatalf("QueryPromMetadata(label_values) = %q, %v", res.Backend, err)
+	}
+	res, err = client.QueryPromMetadata(ctx, "tenant-a", query.MetadataRequest{Kind: query.MetadataSeries, Matchers: []string{`up`, `{job="api"}`}})
+	if err != nil || res.Backend != "fallback-promql" {
+		t.Fatalf("QueryPromMetadata(series) = %q, %v; want the fallback", res.Backend, err)
+	}
+	wantPaths := []string{"/api/default/promql/label/job/values", "/api/default/promql/series", "/api/v1/series"}
+	if len(paths) != len(wantPaths) {
+		t.Fatalf("paths = %q, want %q", paths, wantPaths)
+	}
+	for i := range wantPaths {
+		if paths[i] != wantPaths[i] {
+			t.Fatalf("paths = %q, want %q", paths, wantPaths)
+		}
+	}
+	if len(matches[0]) != 1 || matches[0][0] != `{tenant="tenant-a"}` {
+		t.Fatalf("label values match[] = %q, want the tenant selector", matches[0])
+	}
+	if got := matches[2]; len(got) != 2 || got[0] != `up{tenant="tenant-a"}` || got[1] != `{job="api",tenant="tenant-a"}` {
+		t.Fatalf("series match[] = %q, want enforced selectors", got)
+	}
+
+	_, err = client.QueryPromMetadata(ctx, "tenant-a", query.MetadataRequest{Kind: query.MetadataSeries, Matchers: []string{`{tenant="tenant-b"}`}})
+	var invalid *QueryError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("error = %v, want a QueryError", err)
+	}
+}
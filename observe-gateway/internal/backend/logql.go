@@ -0,0 +1,402 @@
+package backend
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+
+	"github.com/xscopehub/observe-gateway/internal/logql"
+	"github.com/xscopehub/observe-gateway/internal/sqlbuilder"
+)
+
+// translateLogQL parses a LogQL log query and compiles it into OpenObserve SQL.
+func translateLogQL(q, table string) (string, error) {
+	expr, err := parseLogQL(q)
+	if err != nil {
+		return "", err
+	}
+
+	switch e := expr.(type) {
+	case *logql.LogQuery:
+		return compileLogQuery(e, table, false)
+	default:
+		return "", unsupportedLogQL("metric query %s requires a time range", expr)
+	}
+}
+
+func parseLogQL(q string) (logql.Expr, error) {
+	q = strings.TrimSpace(q)
+	if q == "" {
+		return nil, &QueryError{Lang: "logql", Err: fmt.Errorf("empty logql")}
+	}
+
+	expr, err := logql.ParseExpr(q)
+	if err != nil {
+		return nil, &QueryError{Lang: "logql", Err: err}
+	}
+	return expr, nil
+}
+
+// Columns of the OpenObserve log stream used by the LogQL compiler.
+var (
+	logColLabels    = sqlbuilder.MustIdent("labels")
+	logColMessage   = sqlbuilder.MustIdent("message")
+	logColTimestamp = sqlbuilder.MustIdent("_timestamp")
+)
+
+// logFields resolves label names to SQL expressions as the pipeline
+// introduces parsers and renames.
+type logFields struct {
+	parser  *logql.ParserStage
+	renamed map[string]sqlbuilder.Expr
+}
+
+func (f *logFields) expr(name string) (sqlbuilder.Expr, error) {
+	if expr, ok := f.renamed[name]; ok {
+		return expr, nil
+	}
+	label := sqlbuilder.JSONText(logColLabels, name)
+	if f.parser == nil {
+		return label, nil
+	}
+
+	switch f.parser.Parser {
+	case logql.ParserJSON, logql.ParserUnpack:
+		doc := sqlbuilder.Cast(logColMessage, sqlbuilder.JSONB)
+		if len(f.parser.Params) == 0 {
+			return sqlbuilder.JSONText(doc, name), nil
+		}
+		for _, param := range f.parser.Params {
+			if param.Name == name {
+				path := strings.Split(strings.Trim(param.Expression, "."), ".")
+				return sqlbuilder.JSONPathText(doc, path), nil
+			}
+		}
+		return label, nil
+	case logql.ParserLogfmt:
+		pattern := `(?:^|\s)` + regexp.QuoteMeta(name) + `="?([^"\s]*)`
+		return sqlbuilder.Substring(logColMessage, pattern), nil
+	case logql.ParserRegexp:
+		pattern, ok := captureOnly(f.parser.Expression, name)
+		if !ok {
+			return label, nil
+		}
+		return sqlbuilder.Substring(logColMessage, pattern), nil
+	case logql.ParserPattern:
+		pattern, ok := patternToRegexp(f.parser.Expression, name)
+		if !ok {
+			return label, nil
+		}
+		return sqlbuilder.Substring(logColMessage, pattern), nil
+	default:
+		return nil, unsupportedLogQL("parser %q is not supported", f.parser.Parser)
+	}
+}
+
+// compileLogQuery compiles a log query into a search of table ordered by
+// timestamp, newest first unless forward is set.
+func compileLogQuery(q *logql.LogQuery, table string, forward bool) (string, error) {
+	from, err := streamTable(table, "logs")
+	if err != nil {
+		return "", err
+	}
+
+	pipe, err := compilePipeline(q, false)
+	if err != nil {
+		return "", err
+	}
+
+	order := sqlbuilder.Desc(logColTimestamp)
+	if forward {
+		order = sqlbuilder.Asc(logColTimestamp)
+	}
+	stmt := &sqlbuilder.Select{From: sqlbuilder.Table(from), Where: pipe.where(), OrderBy: []sqlbuilder.Expr{order}}
+	if len(pipe.projections) > 0 {
+		stmt.Columns = append([]sqlbuilder.Expr{sqlbuilder.Star("")}, pipe.projections...)
+	}
+	return stmt.SQL(), nil
+}
+
+// compileLabelQuery lists the distinct label names of the matching log
+// records or, when label is set, the distinct values of that label. An empty
+// selector matches every record.
+func compileLabelQuery(selector, label, table string) (string, error) {
+	from, err := streamTable(table, "logs")
+	if err != nil {
+		return "", err
+	}
+	var conditions []sqlbuilder.Expr
+	if strings.TrimSpace(selector) != "" {
+		expr, err := parseLogQL(selector)
+		if err != nil {
+			return "", err
+		}
+		logQuery, ok := expr.(*logql.LogQuery)
+		if !ok {
+			return "", &QueryError{Lang: "logql", Err: fmt.Errorf("label queries take a log selector, not a metric query")}
+		}
+		pipe, err := compilePipeline(logQuery, false)
+		if err != nil {
+			return "", err
+		}
+		conditions = pipe.conditions
+	}
+
+	column := sqlbuilder.As(sqlbuilder.Call(sqlbuilder.FuncJSONBObjectKeys, sqlbuilder.Cast(logColLabels, sqlbuilder.JSONB)), sqlbuilder.MustIdent("name"))
+	if label != "" {
+		value := sqlbuilder.JSONText(logColLabels, label)
+		column = sqlbuilder.As(value, sqlbuilder.MustIdent("value"))
+		conditions = append(conditions, sqlbuilder.IsNotNull(value))
+	}
+	stmt := &sqlbuilder.Select{Distinct: true, Columns: []sqlbuilder.Expr{column}, From: sqlbuilder.Table(from), Where: sqlbuilder.Conjunction(conditions...)}
+	return stmt.SQL(), nil
+}
+
+// compiledPipeline is the SQL form of a stream selector and its pipeline.
+type compiledPipeline struct {
+	conditions  []sqlbuilder.Expr
+	projections []sqlbuilder.Expr
+	fields      *logFields
+	unwrap      sqlbuilder.Expr
+}
+
+func (c *compiledPipeline) where() sqlbuilder.Expr {
+	return sqlbuilder.Conjunction(c.conditions...)
+}
+
+func compilePipeline(q *logql.LogQuery, allowUnwrap bool) (*compiledPipeline, error) {
+	out := &compiledPipeline{fields: &logFields{renamed: map[string]sqlbuilder.Expr{}}}
+	for _, m := range q.Matchers {
+		cond, err := compileMatcher(sqlbuilder.JSONText(logColLabels, m.Name), m.Type, m.Value)
+		if err != nil {
+			return nil, err
+		}
+		out.conditions = append(out.conditions, cond)
+	}
+
+	fields := out.fields
+	for _, stage := range q.Pipeline {
+		switch s := stage.(type) {
+		case *logql.LineFilter:
+			out.conditions = append(out.conditions, compileLineFilter(s))
+		case *logql.ParserStage:
+			if s.Parser == logql.ParserPattern {
+				if _, ok := patternToRegexp(s.Expression, ""); !ok {
+					return nil, unsupportedLogQL("pattern %q cannot be translated", s.Expression)
+				}
+			}
+			if fields.parser != nil {
+				return nil, unsupportedLogQL("multiple parser stages are not supported")
+			}
+			fields.parser = s
+		case *logql.LabelFilterStage:
+			cond, err := compileLabelFilter(fields, s.Filter)
+			if err != nil {
+				return nil, err
+			}
+			out.conditions = append(out.conditions, cond)
+		case *logql.LabelFormatStage:
+			for _, rule := range s.Rules {
+				dst, err := sqlbuilder.Ident(rule.Dst)
+				if err != nil {
+					return nil, unsupportedLogQL("label_format target %q is not a valid column name", rule.Dst)
+				}
+				src := rule.Src
+				if rule.Template != "" {
+					name, ok := simpleTemplateField(rule.Template)
+					if !ok {
+						return nil, unsupportedLogQL("label_format template %q is not supported", rule.Template)
+					}
+					src = name
+				}
+				expr, err := fields.expr(src)
+				if err != nil {
+					return nil, err
+				}
+				fields.renamed[rule.Dst] = expr
+				out.projections = append(out.projections, sqlbuilder.As(expr, dst))
+			}
+		case *logql.UnwrapStage:
+			if !allowUnwrap {
+				return nil, unsupportedLogQL("unwrap is only valid inside a range aggregation")
+			}
+			expr, err := fields.expr(s.Label)
+			if err != nil {
+				return nil, err
+			}
+			out.unwrap = expr
+		default:
+			return nil, unsupportedLogQL("pipeline stage %q is not supported", strings.TrimPrefix(stage.String(), "| "))
+		}
+	}
+	return out, nil
+}
+
+func compileMatcher(expr sqlbuilder.Expr, typ logql.MatchType, value string) (sqlbuilder.Expr, error) {
+	switch typ {
+	case logql.MatchEqual:
+		return sqlbuilder.Compare(expr, sqlbuilder.Eq, sqlbuilder.String(value)), nil
+	case logql.MatchNotEqual:
+		return sqlbuilder.Compare(expr, sqlbuilder.Ne, sqlbuilder.String(value)), nil
+	case logql.MatchRegexp:
+		return sqlbuilder.Compare(expr, sqlbuilder.Match, sqlbuilder.String(anchorRegexp(value))), nil
+	case logql.MatchNotRegexp:
+		return sqlbuilder.Compare(expr, sqlbuilder.NotMatch, sqlbuilder.String(anchorRegexp(value))), nil
+	default:
+		return nil, unsupportedLogQL("matcher %s is not supported", typ)
+	}
+}
+
+func compileLineFilter(f *logql.LineFilter) sqlbuilder.Expr {
+	value := sqlbuilder.String(f.Value)
+	switch f.Op {
+	case logql.LineNotContains:
+		return sqlbuilder.Compare(sqlbuilder.Call(sqlbuilder.FuncStrpos, logColMessage, value), sqlbuilder.Eq, sqlbuilder.Int(0))
+	case logql.LineMatch:
+		return sqlbuilder.Compare(logColMessage, sqlbuilder.Match, value)
+	case logql.LineNotMatch:
+		return sqlbuilder.Compare(logColMessage, sqlbuilder.NotMatch, value)
+	default:
+		return sqlbuilder.Compare(sqlbuilder.Call(sqlbuilder.FuncStrpos, logColMessage, value), sqlbuilder.Gt, sqlbuilder.Int(0))
+	}
+}
+
+func compileLabelFilter(fields *logFields, filter logql.LabelFilter) (sqlbuilder.Expr, error) {
+	switch f := filter.(type) {
+	case *logql.BinaryLabelFilter:
+		left, err := compileLabelFilter(fields, f.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := compileLabelFilter(fields, f.Right)
+		if err != nil {
+			return nil, err
+		}
+		if f.Or {
+			return sqlbuilder.Or(left, right), nil
+		}
+		return sqlbuilder.And(left, right), nil
+	case *logql.LabelComparison:
+		expr, err := fields.expr(f.Name)
+		if err != nil {
+			return nil, err
+		}
+		switch f.Value.Kind {
+		case logql.ValueString:
+			switch f.Op {
+			case logql.CompareEqual:
+				return compileMatcher(expr, logql.MatchEqual, f.Value.Raw)
+			case logql.CompareNotEqual:
+				return compileMatcher(expr, logql.MatchNotEqual, f.Value.Raw)
+			case logql.CompareRegexp:
+				return compileMatcher(expr, logql.MatchRegexp, f.Value.Raw)
+			case logql.CompareNotRegexp:
+				return compileMatcher(expr, logql.MatchNotRegexp, f.Value.Raw)
+			}
+		case logql.ValueNumber:
+			op, ok := numericOps[f.Op]
+			if !ok {
+				break
+			}
+			n, err := sqlbuilder.Float(f.Value.Number)
+			if err != nil {
+				return nil, unsupportedLogQL("label filter %s: %v", f, err)
+			}
+			return sqlbuilder.Compare(sqlbuilder.Cast(expr, sqlbuilder.Double), op, n), nil
+		case logql.ValueDuration, logql.ValueBytes:
+			return nil, unsupportedLogQL("duration and byte label filters are not supported: %s", f)
+		}
+		return nil, unsupportedLogQL("label filter %s is not supported", f)
+	default:
+		return nil, unsupportedLogQL("label filter %s is not supported", filter)
+	}
+}
+
+var numericOps = map[logql.CompareOp]sqlbuilder.Op{
+	logql.CompareEqual:        sqlbuilder.Eq,
+	logql.CompareNotEqual:     sqlbuilder.Ne,
+	logql.CompareGreater:      sqlbuilder.Gt,
+	logql.CompareGreaterEqual: sqlbuilder.Ge,
+	logql.CompareLess:         sqlbuilder.Lt,
+	logql.CompareLessEqual:    sqlbuilder.Le,
+}
+
+// anchorRegexp mirrors LogQL/Prometheus semantics where label regexps must
+// match the whole value.
+func anchorRegexp(re string) string {
+	return "^(?:" + re + ")$"
+}
+
+var simpleTemplateRe = regexp.MustCompile(`^\{\{\s*\.([A-Za-z_][A-Za-z0-9_]*)\s*\}\}$`)
+
+func simpleTemplateField(tmpl string) (string, bool) {
+	m := simpleTemplateRe.FindStringSubmatch(strings.TrimSpace(tmpl))
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// captureOnly rewrites a regexp parser expression so that only the named
+// group captures, which is what SQL substring() extracts.
+func captureOnly(expr, name string) (string, bool) {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return "", false
+	}
+	found := false
+	var walk func(*syntax.Regexp) *syntax.Regexp
+	walk = func(r *syntax.Regexp) *syntax.Regexp {
+		for i, sub := range r.Sub {
+			r.Sub[i] = walk(sub)
+		}
+		if r.Op != syntax.OpCapture {
+			return r
+		}
+		if r.Name == name && !found {
+			found = true
+			r.Name = ""
+			return r
+		}
+		return r.Sub[0]
+	}
+	re = walk(re)
+	return re.String(), found
+}
+
+var patternCaptureRe = regexp.MustCompile(`<(_|[A-Za-z_][A-Za-z0-9_]*)>`)
+
+// patternToRegexp converts a pattern parser expression into a regular
+// expression capturing only the named field. An empty name validates the
+// pattern.
+func patternToRegexp(expr, name string) (string, bool) {
+	locs := patternCaptureRe.FindAllStringSubmatchIndex(expr, -1)
+	if len(locs) == 0 {
+		return "", false
+	}
+	var b strings.Builder
+	found := name == ""
+	last := 0
+	for _, loc := range locs {
+		b.WriteString(regexp.QuoteMeta(expr[last:loc[0]]))
+		capture := expr[loc[2]:loc[3]]
+		if capture == name && !strings.Contains(b.String(), "(.*?)") {
+			b.WriteString("(.*?)")
+			found = true
+		} else {
+			b.WriteString(".*?")
+		}
+		last = loc[1]
+	}
+	b.WriteString(regexp.QuoteMeta(expr[last:]))
+	if last == len(expr) {
+		b.WriteString("$")
+	}
+	return b.String(), found
+}
+
+func unsupportedLogQL(format string, args ...any) error {
+	return &UnsupportedError{Status: http.StatusBadRequest, Message: "logql: " + fmt.Sprintf(format, args...)}
+}
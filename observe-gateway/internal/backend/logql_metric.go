@@ -0,0 +1,431 @@
+package backend
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/frame"
+	"github.com/xscopehub/observe-gateway/internal/logql"
+	"github.com/xscopehub/observe-gateway/internal/promapi"
+	"github.com/xscopehub/observe-gateway/internal/query"
+	"github.com/xscopehub/observe-gateway/internal/sqlbuilder"
+)
+
+const (
+	// maxMetricPoints bounds the default step so a range query yields at
+	// most this many samples per series, mirroring Loki's default
+	// resolution.
+	maxMetricPoints = 250
+	// maxMetricResolution is the most samples per series a range query
+	// may ask for, the limit Prometheus and Loki apply.
+	maxMetricResolution = 11000
+)
+
+// metricPlan describes how a LogQL metric query is evaluated: the SQL
+// returns partial aggregates per step-aligned bucket and series, and the
+// gateway folds buckets into range windows and applies vector aggregations.
+type metricPlan struct {
+	SQL     string
+	Range   *logql.RangeAggregation
+	Vectors []*logql.VectorAggregation // innermost first
+	Step    time.Duration
+	Bucket  time.Duration
+	Labels  []string // extra grouping labels selected as lbl_<name>
+}
+
+// defaultMetricStep picks a step for range queries that did not supply one.
+func defaultMetricStep(start, end time.Time) time.Duration {
+	step := end.Sub(start) / maxMetricPoints
+	if step < time.Second {
+		return time.Second
+	}
+	return step.Round(time.Second)
+}
+
+// metricStep returns the step of a metric range query, defaulting it from
+// the range and rejecting resolutions above maxMetricResolution.
+func metricStep(req query.Request) (time.Duration, error) {
+	step, err := req.StepDuration()
+	if err != nil {
+		return 0, &QueryError{Lang: "logql", Err: err}
+	}
+	if step <= 0 {
+		step = defaultMetricStep(req.Start, req.End)
+	}
+	if req.End.Sub(req.Start)/step > maxMetricResolution {
+		return 0, &QueryError{Lang: "logql", Err: fmt.Errorf("exceeded maximum resolution of %d points per timeseries, increase the step", maxMetricResolution)}
+	}
+	return step, nil
+}
+
+func compileMetricQuery(expr logql.Expr, table string, step time.Duration) (*metricPlan, error) {
+	from, err := streamTable(table, "logs")
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &metricPlan{Step: step}
+	for {
+		if v, ok := expr.(*logql.VectorAggregation); ok {
+			plan.Vectors = append([]*logql.VectorAggregation{v}, plan.Vectors...)
+			expr = v.Expr
+			continue
+		}
+		break
+	}
+	rangeAgg, ok := expr.(*logql.RangeAggregation)
+	if !ok {
+		return nil, unsupportedLogQL("%s is not a supported metric expression", expr)
+	}
+	plan.Range = rangeAgg
+
+	if step < time.Second || step%time.Second != 0 {
+		return nil, unsupportedLogQL("step must be a whole number of seconds, got %s", step)
+	}
+	if rangeAgg.Range < time.Second || rangeAgg.Range%time.Second != 0 {
+		return nil, unsupportedLogQL("range must be a whole number of seconds, got %s", rangeAgg.Range)
+	}
+	plan.Bucket = time.Duration(gcd(int64(step/time.Second), int64(rangeAgg.Range/time.Second))) * time.Second
+
+	pipe, err := compilePipeline(rangeAgg.Query, true)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for _, v := range plan.Vectors {
+		if v.Grouping == nil || v.Grouping.Without {
+			continue
+		}
+		for _, name := range v.Grouping.Labels {
+			if !seen[name] {
+				seen[name] = true
+				plan.Labels = append(plan.Labels, name)
+			}
+		}
+	}
+
+	bucket := sqlbuilder.MustIdent("bucket")
+	stream := sqlbuilder.MustIdent("stream")
+	cols := []sqlbuilder.Expr{
+		sqlbuilder.As(sqlbuilder.Call(sqlbuilder.FuncHistogram, logColTimestamp, sqlbuilder.Interval(plan.Bucket)), bucket),
+		sqlbuilder.As(logColLabels, stream),
+	}
+	groupBy := []sqlbuilder.Expr{bucket, stream}
+	for _, name := range plan.Labels {
+		expr, err := pipe.fields.expr(name)
+		if err != nil {
+			return nil, err
+		}
+		alias, err := sqlbuilder.Ident("lbl_" + name)
+		if err != nil {
+			return nil, unsupportedLogQL("grouping label %q is not a valid column name", name)
+		}
+		cols = append(cols, sqlbuilder.As(expr, alias))
+		groupBy = append(groupBy, alias)
+	}
+	cols = append(cols, sqlbuilder.As(sqlbuilder.CountAll, sqlbuilder.MustIdent("samples")))
+
+	switch {
+	case pipe.unwrap != nil:
+		value := sqlbuilder.Cast(pipe.unwrap, sqlbuilder.Double)
+		cols = append(cols,
+			sqlbuilder.As(sqlbuilder.Call(sqlbuilder.FuncSum, value), sqlbuilder.MustIdent("total")),
+			sqlbuilder.As(sqlbuilder.Call(sqlbuilder.FuncMin, value), sqlbuilder.MustIdent("minimum")),
+			sqlbuilder.As(sqlbuilder.Call(sqlbuilder.FuncMax, value), sqlbuilder.MustIdent("maximum")),
+		)
+		pipe.conditions = append(pipe.conditions, sqlbuilder.Compare(pipe.unwrap, sqlbuilder.Match, sqlbuilder.String(`^-?[0-9]+(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)))
+	case rangeAgg.Op == "bytes_over_time" || rangeAgg.Op == "bytes_rate":
+		length := sqlbuilder.Call(sqlbuilder.FuncLength, logColMessage)
+		cols = append(cols, sqlbuilder.As(sqlbuilder.Call(sqlbuilder.FuncSum, length), sqlbuilder.MustIdent("total")))
+	}
+
+	plan.SQL = (&sqlbuilder.Select{
+		Columns: cols,
+		From:    sqlbuilder.Table(from),
+		Where:   pipe.where(),
+		GroupBy: groupBy,
+		OrderBy: []sqlbuilder.Expr{bucket},
+	}).SQL()
+	return plan, nil
+}
+
+// lookback returns the start of the search feeding samples from start on:
+// the bucket holding start minus the range.
+func (p *metricPlan) lookback(start time.Time) time.Time {
+	from := start.Add(-p.Range.Range).Unix()
+	return time.Unix(from-floorMod(from, int64(p.Bucket/time.Second)), 0).UTC()
+}
+
+// floorMod is a mod b with the sign of b, so that times before the Unix
+// epoch align to the same buckets.
+func floorMod(a, b int64) int64 {
+	return (a%b + b) % b
+}
+
+type bucketPartial struct {
+	samples float64
+	total   float64
+	min     float64
+	max     float64
+}
+
+type metricSeries struct {
+	labels  map[string]string
+	buckets map[int64]bucketPartial
+	samples []promapi.Sample
+}
+
+// evaluate turns OpenObserve search hits into a Prometheus matrix for the
+// [start, end] range.
+func (p *metricPlan) evaluate(hits []map[string]any, start, end time.Time) ([]promapi.Series, error) {
+	series := map[string]*metricSeries{}
+	for _, hit := range hits {
+		ts, err := frame.ParseTime(hit["bucket"])
+		if err != nil {
+			return nil, err
+		}
+		labels, err := frame.ParseLabels(hit["stream"])
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range p.Labels {
+			if v := frame.String(hit["lbl_"+name]); v != "" {
+				labels[name] = v
+			} else {
+				delete(labels, name)
+			}
+		}
+
+		key := labelsKey(labels)
+		s, ok := series[key]
+		if !ok {
+			s = &metricSeries{labels: labels, buckets: map[int64]bucketPartial{}}
+			series[key] = s
+		}
+		// Buckets are aligned to the Unix epoch, as OpenObserve's histogram
+		// is; time.Truncate would align them to year 1.
+		bucket := ts.Unix() - floorMod(ts.Unix(), int64(p.Bucket/time.Second))
+		part := s.buckets[bucket]
+		part.samples += frame.Float(hit["samples"])
+		total := frame.Float(hit["total"])
+		minimum, maximum := frame.Float(hit["minimum"]), frame.Float(hit["maximum"])
+		if _, seen := s.buckets[bucket]; !seen {
+			part.min, part.max = minimum, maximum
+		} else {
+			part.min, part.max = math.Min(part.min, minimum), math.Max(part.max, maximum)
+		}
+		part.total += total
+		s.buckets[bucket] = part
+	}
+
+	stepSec := int64(p.Step / time.Second)
+	rangeSec := int64(p.Range.Range / time.Second)
+	first := (start.Unix() + stepSec - 1) / stepSec * stepSec
+
+	out := make([]*metricSeries, 0, len(series))
+	for _, s := range series {
+		p.foldWindows(s, first, end.Unix(), stepSec, rangeSec)
+		if len(s.samples) > 0 {
+			out = append(out, s)
+		}
+	}
+
+	for _, v := range p.Vectors {
+		out = aggregateSeries(v, out)
+	}
+
+	result := make([]promapi.Series, 0, len(out))
+	for _, s := range out {
+		result = append(result, promapi.Series{Metric: s.labels, Values: s.samples})
+	}
+	sort.Slice(result, func(i, j int) bool { return labelsKey(result[i].Metric) < labelsKey(result[j].Metric) })
+	return result, nil
+}
+
+// foldWindows adds a sample of s at every step from first to last whose
+// range window [t-rangeSec, t) holds buckets. The window slides over the
+// sorted buckets, keeping running sums and monotonic queues of the
+// buckets holding the window's minimum and maximum.
+func (p *metricPlan) foldWindows(s *metricSeries, first, last, stepSec, rangeSec int64) {
+	keys := make([]int64, 0, len(s.buckets))
+	for b := range s.buckets {
+		keys = append(keys, b)
+	}
+	slices.Sort(keys)
+	parts := make([]bucketPartial, len(keys))
+	for i, b := range keys {
+		parts[i] = s.buckets[b]
+	}
+
+	var samples, total float64
+	var mins, maxs []int
+	lo, hi := 0, 0
+	for t := first; t <= last; t += stepSec {
+		for ; hi < len(keys) && keys[hi] < t; hi++ {
+			samples += parts[hi].samples
+			total += parts[hi].total
+			for len(mins) > 0 && parts[mins[len(mins)-1]].min >= parts[hi].min {
+				mins = mins[:len(mins)-1]
+			}
+			mins = append(mins, hi)
+			for len(maxs) > 0 && parts[maxs[len(maxs)-1]].max <= parts[hi].max {
+				maxs = maxs[:len(maxs)-1]
+			}
+			maxs = append(maxs, hi)
+		}
+		for ; lo < hi && keys[lo] < t-rangeSec; lo++ {
+			samples -= parts[lo].samples
+			total -= parts[lo].total
+		}
+		for len(mins) > 0 && mins[0] < lo {
+			mins = mins[1:]
+		}
+		for len(maxs) > 0 && maxs[0] < lo {
+			maxs = maxs[1:]
+		}
+		if lo == hi {
+			// Start the next window's sums afresh rather than carry
+			// rounding errors across a gap.
+			samples, total = 0, 0
+			continue
+		}
+		agg := bucketPartial{samples: samples, total: total, min: parts[mins[0]].min, max: parts[maxs[0]].max}
+		s.samples = append(s.samples, promapi.Sample{T: float64(t), V: p.rangeValue(agg)})
+	}
+}
+
+func (p *metricPlan) rangeValue(agg bucketPartial) float64 {
+	seconds := p.Range.Range.Seconds()
+	unwrapped := p.Range.Unwrap() != ""
+	switch p.Range.Op {
+	case "count_over_time":
+		return agg.samples
+	case "rate":
+		if unwrapped {
+			return agg.total / seconds
+		}
+		return agg.samples / seconds
+	case "bytes_over_time", "sum_over_time":
+		return agg.total
+	case "bytes_rate":
+		return agg.total / seconds
+	case "avg_over_time":
+		if agg.samples == 0 {
+			return math.NaN()
+		}
+		return agg.total / agg.samples
+	case "min_over_time":
+		return agg.min
+	case "max_over_time":
+		return agg.max
+	default:
+		return math.NaN()
+	}
+}
+
+func aggregateSeries(v *logql.VectorAggregation, in []*metricSeries) []*metricSeries {
+	type group struct {
+		labels map[string]string
+		values map[float64][]float64
+	}
+	groups := map[string]*group{}
+	for _, s := range in {
+		labels := groupLabels(v.Grouping, s.labels)
+		key := labelsKey(labels)
+		g, ok := groups[key]
+		if !ok {
+			g = &group{labels: labels, values: map[float64][]float64{}}
+			groups[key] = g
+		}
+		for _, sample := range s.samples {
+			g.values[sample.T] = append(g.values[sample.T], sample.V)
+		}
+	}
+
+	out := make([]*metricSeries, 0, len(groups))
+	for _, g := range groups {
+		s := &metricSeries{labels: g.labels}
+		for t, values := range g.values {
+			s.samples = append(s.samples, promapi.Sample{T: t, V: vectorValue(v.Op, values)})
+		}
+		sort.Slice(s.samples, func(i, j int) bool { return s.samples[i].T < s.samples[j].T })
+		out = append(out, s)
+	}
+	return out
+}
+
+func groupLabels(g *logql.Grouping, labels map[string]string) map[string]string {
+	out := map[string]string{}
+	if g == nil {
+		return out
+	}
+	if g.Without {
+		for k, v := range labels {
+			out[k] = v
+		}
+		for _, name := range g.Labels {
+			delete(out, name)
+		}
+		return out
+	}
+	for _, name := range g.Labels {
+		if v, ok := labels[name]; ok {
+			out[name] = v
+		}
+	}
+	return out
+}
+
+func vectorValue(op string, values []float64) float64 {
+	switch op {
+	case "count":
+		return float64(len(values))
+	case "min", "max":
+		out := values[0]
+		for _, v := range values[1:] {
+			if op == "min" {
+				out = math.Min(out, v)
+			} else {
+				out = math.Max(out, v)
+			}
+		}
+		return out
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	if op == "avg" {
+		return sum / float64(len(values))
+	}
+	return sum
+}
+
+func labelsKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[k]))
+		b.WriteByte(',')
+	}
+	return b.String()
+}
+
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
@@ -0,0 +1,335 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/config"
+	"github.com/xscopehub/observe-gateway/internal/promapi"
+	"github.com/xscopehub/observe-gateway/internal/query"
+)
+
+func TestTranslateLogQL(t *testing.T) {
+	cases := []struct {
+		query string
+		want  string
+	}{
+		{
+			query: `{service="api", env!="dev"} |= "error"`,
+			want:  `SELECT * FROM logs WHERE labels->>'service' = 'api' AND labels->>'env' <> 'dev' AND strpos(message, 'error') > 0 ORDER BY _timestamp DESC`,
+		},
+		{
+			query: `{service=~"api|web", team!~"core"} !~ "health.*"`,
+			want:  `SELECT * FROM logs WHERE labels->>'service' ~ '^(?:api|web)$' AND labels->>'team' !~ '^(?:core)$' AND message !~ 'health.*' ORDER BY _timestamp DESC`,
+		},
+		{
+			query: `{service="a,b"} |= "it's"`,
+			want:  `SELECT * FROM logs WHERE labels->>'service' = 'a,b' AND strpos(message, 'it''s') > 0 ORDER BY _timestamp DESC`,
+		},
+		{
+			query: `{service="api"} | json | level="error" or status >= 500`,
+			want:  `SELECT * FROM logs WHERE labels->>'service' = 'api' AND (CAST(message AS jsonb)->>'level' = 'error' OR CAST(CAST(message AS jsonb)->>'status' AS DOUBLE PRECISION) >= 500) ORDER BY _timestamp DESC`,
+		},
+		{
+			query: `{service="api"} | json code="response.status" | code="500"`,
+			want:  `SELECT * FROM logs WHERE labels->>'service' = 'api' AND CAST(message AS jsonb)#>>'{response,status}' = '500' ORDER BY _timestamp DESC`,
+		},
+		{
+			query: `{service="api"} | logfmt | level="warn"`,
+			want:  `SELECT * FROM logs WHERE labels->>'service' = 'api' AND substring(message from '(?:^|\s)level="?([^"\s]*)') = 'warn' ORDER BY _timestamp DESC`,
+		},
+		{
+			query: `{service="api"} | label_format svc=service | svc="api"`,
+			want:  `SELECT *, labels->>'service' AS svc FROM logs WHERE labels->>'service' = 'api' AND labels->>'service' = 'api' ORDER BY _timestamp DESC`,
+		},
+	}
+
+	for _, tc := range cases {
+		got, err := translateLogQL(tc.query, "logs")
+		if err != nil {
+			t.Fatalf("translateLogQL(%q) error = %v", tc.query, err)
+		}
+		if got != tc.want {
+			t.Errorf("translateLogQL(%q)\n got = %s\nwant = %s", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestTranslateLogQLRejectsUnsupported(t *testing.T) {
+	for _, q := range []string{
+		`{service="api"} | line_format "{{.msg}}"`,
+		`{service="api"} | json | latency > 2s`,
+		`{service="api"} | label_format x="{{.a}}-{{.b}}"`,
+		`{service="api"} | drop level`,
+	} {
+		_, err := translateLogQL(q, "logs")
+		var unsupported *UnsupportedError
+		if !errors.As(err, &unsupported) {
+			t.Errorf("translateLogQL(%q) error = %v, want *UnsupportedError", q, err)
+		}
+	}
+
+	_, err := translateLogQL(`{service="api"`, "logs")
+	var invalid *QueryError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("error = %v, want *QueryError", err)
+	}
+}
+
+func TestQueryLogQLDirection(t *testing.T) {
+	var sqls []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			SQL string `json:"sql"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		sqls = append(sqls, body.SQL)
+		w.Write([]byte(`{"hits":[]}`))
+	}))
+	defer upstream.Close()
+
+	client, err := New(context.Background(), config.BackendConfig{
+		OpenObserve: config.OpenObserveConfig{BaseURL: upstream.URL, Org: "default", LogSearchEndpoint: "/api/%s/_search", LogTable: "logs"},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer client.Close()
+
+	start := time.Unix(1700000000, 0).UTC()
+	for _, direction := range []string{"", query.DirectionForward} {
+		req := query.Request{Lang: "logql", Query: `{service="x"}`, Start: start, End: start.Add(time.Hour), Direction: direction}
+		if _, err := client.QueryLogQL(context.Background(), "tenant-a", req); err != nil {
+			t.Fatalf("QueryLogQL(%q) error = %v", direction, err)
+		}
+	}
+	want := []string{
+		`SELECT * FROM logs WHERE labels->>'service' = 'x' ORDER BY _timestamp DESC`,
+		`SELECT * FROM logs WHERE labels->>'service' = 'x' ORDER BY _timestamp ASC`,
+	}
+	if strings.Join(sqls, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("sql = %q, want %q", sqls, want)
+	}
+}
+
+func TestQueryLogQLMetric(t *testing.T) {
+	base := int64(1699999800)
+	hits := []struct {
+		offset int64
+		hit    string
+	}{
+		{300, `"stream":{"pod":"a"},"lbl_level":"error","samples":2`},
+		{360, `"stream":{"pod":"b"},"lbl_level":"error","samples":3`},
+		{540, `"stream":{"pod":"a"},"lbl_level":"warn","samples":1`},
+	}
+	var body map[string]any
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		// Like OpenObserve, return only the buckets in the searched range.
+		from, _ := time.Parse(time.RFC3339Nano, fmt.Sprint(body["start"]))
+		var out []string
+		for _, h := range hits {
+			if base+h.offset >= from.Unix() {
+				out = append(out, fmt.Sprintf(`{"bucket":%d,%s}`, (base+h.offset)*1e6, h.hit))
+			}
+		}
+		fmt.Fprintf(w, `{"hits":[%s]}`, strings.Join(out, ","))
+	}))
+	defer upstream.Close()
+
+	client, err := New(context.Background(), config.BackendConfig{
+		OpenObserve: config.OpenObserveConfig{BaseURL: upstream.URL, Org: "default", LogSearchEndpoint: "/api/%s/_search", LogTable: "logs"},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer client.Close()
+
+	start := time.Unix(base+600, 0).UTC()
+	req := query.Request{Lang: "logql", Query: `sum by (level) (count_over_time({service="x"} |= "error" [5m]))`, Start: start, End: start.Add(time.Minute), Step: "1m"}
+	res, err := client.QueryLogQL(context.Background(), "tenant-a", req)
+	if err != nil {
+		t.Fatalf("QueryLogQL() error = %v", err)
+	}
+	want := `SELECT histogram(_timestamp, '60 seconds') AS bucket, labels AS stream, labels->>'level' AS lbl_level, count(*) AS samples FROM logs WHERE labels->>'service' = 'x' AND strpos(message, 'error') > 0 GROUP BY bucket, stream, lbl_level ORDER BY bucket`
+	if body["sql"] != want {
+		t.Fatalf("sql\n got = %s\nwant = %s", body["sql"], want)
+	}
+	// The first sample's 5m window starts 5m before the requested start.
+	if got, want := body["start"], start.Add(-5*time.Minute).Format(time.RFC3339); got != want {
+		t.Fatalf("search start = %v, want %s", got, want)
+	}
+
+	resp, err := promapi.Parse(res.Payload)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	series, err := resp.Matrix()
+	if err != nil {
+		t.Fatalf("Matrix() error = %v", err)
+	}
+	if len(series) != 2 {
+		t.Fatalf("series = %d, want 2", len(series))
+	}
+	errSeries := series[0]
+	if errSeries.Metric["level"] != "error" || len(errSeries.Metric) != 1 {
+		t.Fatalf("metric = %v, want {level=error}", errSeries.Metric)
+	}
+	// At +600s the 5m window covers buckets +300..+540, at +660s it starts at +360.
+	if len(errSeries.Values) != 2 || errSeries.Values[0].V != 5 || errSeries.Values[1].V != 3 {
+		t.Fatalf("values = %v, want [600:5 660:3]", errSeries.Values)
+	}
+}
+
+func TestMetricBucketsAlignToEpoch(t *testing.T) {
+	expr, err := parseLogQL(`count_over_time({service="x"}[7s])`)
+	if err != nil {
+		t.Fatalf("parseLogQL() error = %v", err)
+	}
+	plan, err := compileMetricQuery(expr, "logs", 7*time.Second)
+	if err != nil {
+		t.Fatalf("compileMetricQuery() error = %v", err)
+	}
+	// 1699999994 is a multiple of 7 seconds since the Unix epoch.
+	bucket := json.Number(strconv.FormatInt(1699999994*1e6, 10))
+	hits := []map[string]any{{"bucket": bucket, "stream": map[string]any{"pod": "a"}, "samples": json.Number("4")}}
+	start := time.Unix(1700000001, 0)
+	series, err := plan.evaluate(hits, start, start)
+	if err != nil {
+		t.Fatalf("evaluate() error = %v", err)
+	}
+	if len(series) != 1 || len(series[0].Values) != 1 || series[0].Values[0].V != 4 {
+		t.Fatalf("series = %v, want one sample of 4", series)
+	}
+	if got := plan.lookback(start); got.Unix() != 1699999994 {
+		t.Fatalf("lookback = %d, want 1699999994", got.Unix())
+	}
+}
+
+func TestMetricWindowsSlide(t *testing.T) {
+	values := []float64{5, 1, 4, 1, 5, 9, 2, 6, 5, 3}
+	base := int64(1700000000)
+	var hits []map[string]any
+	for i, v := range values {
+		if i == 4 {
+			continue // a gap in the data
+		}
+		hits = append(hits, map[string]any{
+			"bucket": json.Number(strconv.FormatInt((base+int64(i))*1e6, 10)), "stream": map[string]any{"pod": "a"},
+			"samples": json.Number("1"), "total": v, "minimum": v, "maximum": v,
+		})
+	}
+	for _, op := range []string{"max_over_time", "min_over_time", "sum_over_time"} {
+		expr, err := parseLogQL(op + `({service="x"} | json | unwrap v [3s])`)
+		if err != nil {
+			t.Fatalf("parseLogQL() error = %v", err)
+		}
+		plan, err := compileMetricQuery(expr, "logs", time.Second)
+		if err != nil {
+			t.Fatalf("compileMetricQuery() error = %v", err)
+		}
+		series, err := plan.evaluate(hits, time.Unix(base+1, 0), time.Unix(base+12, 0))
+		if err != nil {
+			t.Fatalf("evaluate() error = %v", err)
+		}
+		// Recompute every window [t-3s, t) from scratch.
+		var want []promapi.Sample
+		for ts := base + 1; ts <= base+12; ts++ {
+			var window []float64
+			for i, v := range values {
+				if b := base + int64(i); i != 4 && b >= ts-3 && b < ts {
+					window = append(window, v)
+				}
+			}
+			if len(window) == 0 {
+				continue
+			}
+			var v float64
+			switch op {
+			case "max_over_time":
+				v = slices.Max(window)
+			case "min_over_time":
+				v = slices.Min(window)
+			case "sum_over_time":
+				for _, x := range window {
+					v += x
+				}
+			}
+			want = append(want, promapi.Sample{T: float64(ts), V: v})
+		}
+		if len(series) != 1 || !slices.Equal(series[0].Values, want) {
+			t.Fatalf("%s = %v, want %v", op, series, want)
+		}
+	}
+}
+
+func TestMetricResolutionLimit(t *testing.T) {
+	client, err := New(context.Background(), config.BackendConfig{
+		OpenObserve: config.OpenObserveConfig{BaseURL: "http://127.0.0.1:1", Org: "default", LogSearchEndpoint: "/api/%s/_search", LogTable: "logs"},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer client.Close()
+
+	start := time.Unix(1700000000, 0)
+	req := query.Request{Lang: "logql", Query: `count_over_time({service="x"}[1d])`, Start: start, End: start.Add(30 * 24 * time.Hour), Step: "1s"}
+	_, err = client.QueryLogQL(context.Background(), "tenant-a", req)
+	var invalid *QueryError
+	if !errors.As(err, &invalid) || !strings.Contains(err.Error(), "maximum resolution") {
+		t.Fatalf("QueryLogQL() error = %v, want a resolution QueryError", err)
+	}
+}
+
+func TestCompileMetricQueryRate(t *testing.T) {
+	expr, err := parseLogQL(`rate({service="x"} | json | unwrap latency [1m])`)
+	if err != nil {
+		t.Fatalf("parseLogQL() error = %v", err)
+	}
+	plan, err := compileMetricQuery(expr, "logs", 30*time.Second)
+	if err != nil {
+		t.Fatalf("compileMetricQuery() error = %v", err)
+	}
+	if plan.Bucket != 30*time.Second {
+		t.Fatalf("bucket = %s, want 30s", plan.Bucket)
+	}
+	if !strings.Contains(plan.SQL, "sum(CAST(CAST(message AS jsonb)->>'latency' AS DOUBLE PRECISION)) AS total") {
+		t.Fatalf("sql = %s, want unwrapped sum", plan.SQL)
+	}
+}
+
+func TestCompileLabelQuery(t *testing.T) {
+	cases := []struct {
+		selector, label, want string
+	}{
+		{"", "", `SELECT DISTINCT jsonb_object_keys(CAST(labels AS jsonb)) AS name FROM logs WHERE 1=1`},
+		{`{service="api"}`, "", `SELECT DISTINCT jsonb_object_keys(CAST(labels AS jsonb)) AS name FROM logs WHERE labels->>'service' = 'api'`},
+		{`{service="api"} |= "error"`, "pod", `SELECT DISTINCT labels->>'pod' AS value FROM logs WHERE labels->>'service' = 'api' AND strpos(message, 'error') > 0 AND labels->>'pod' IS NOT NULL`},
+	}
+	for _, tc := range cases {
+		got, err := compileLabelQuery(tc.selector, tc.label, "logs")
+		if err != nil {
+			t.Fatalf("compileLabelQuery(%q, %q) error = %v", tc.selector, tc.label, err)
+		}
+		if got != tc.want {
+			t.Errorf("compileLabelQuery(%q, %q)\n got = %s\nwant = %s", tc.selector, tc.label, got, tc.want)
+		}
+	}
+	var invalid *QueryError
+	if _, err := compileLabelQuery(`count_over_time({service="api"}[5m])`, "", "logs"); !errors.As(err, &invalid) {
+		t.Fatalf("compileLabelQuery(metric query) error = %v, want QueryError", err)
+	}
+}
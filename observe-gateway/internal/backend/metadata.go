@@ -9,12 +9,16 @@ import (
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel"
 
 	"github.com/xscopehub/observe-gateway/internal/config"
+	"github.com/xscopehub/observe-gateway/internal/telemetry"
 )
 
 var errTenantNotFound = errors.New("tenant metadata not found")
 
+var tracer = otel.Tracer("github.com/xscopehub/observe-gateway/internal/backend")
+
 type tenantMetadata struct {
 	Org        string
 	LogTable   string
@@ -24,6 +28,8 @@ type tenantMetadata struct {
 type metadataStore struct {
 	pool        *pgxpool.Pool
 	tenantQuery string
+	budgetQuery string
+	limitQuery  string
 }
 
 func newMetadataStore(ctx context.Context, cfg config.MetadataConfig) (*metadataStore, error) {
@@ -64,7 +70,7 @@ func newMetadataStore(ctx context.Context, cfg config.MetadataConfig) (*metadata
 		query = "SELECT org, log_table, trace_table FROM tenant_metadata WHERE tenant = $1"
 	}
 
-	return &metadataStore{pool: pool, tenantQuery: query}, nil
+	return &metadataStore{pool: pool, tenantQuery: query, budgetQuery: strings.TrimSpace(cfg.BudgetLookupQuery), limitQuery: strings.TrimSpace(cfg.RateLimitLookupQuery)}, nil
 }
 
 func (s *metadataStore) Lookup(ctx context.Context, tenant string) (tenantMetadata, error) {
@@ -72,6 +78,8 @@ func (s *metadataStore) Lookup(ctx context.Context, tenant string) (tenantMetada
 		return tenantMetadata{}, errTenantNotFound
 	}
 
+	ctx, done := startLookup(ctx, "tenant")
+	defer done()
 	row := s.pool.QueryRow(ctx, s.tenantQuery, tenant)
 
 	var meta tenantMetadata
@@ -85,6 +93,66 @@ func (s *metadataStore) Lookup(ctx context.Context, tenant string) (tenantMetada
 	return meta, nil
 }
 
+// LookupBudget returns the tenant's cost budget override. A missing row or a
+// NULL budget means the tenant has no override.
+func (s *metadataStore) LookupBudget(ctx context.Context, tenant string) (int64, bool, error) {
+	if s == nil || s.budgetQuery == "" {
+		return 0, false, nil
+	}
+
+	ctx, done := startLookup(ctx, "budget")
+	defer done()
+	var budget *int64
+	if err := s.pool.QueryRow(ctx, s.budgetQuery, tenant).Scan(&budget); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	if budget == nil {
+		return 0, false, nil
+	}
+	return *budget, true, nil
+}
+
+// LookupRateLimit returns the tenant's rate limit override. A missing row or
+// a NULL rate means the tenant has no override.
+func (s *metadataStore) LookupRateLimit(ctx context.Context, tenant string) (float64, int, bool, error) {
+	if s == nil || s.limitQuery == "" {
+		return 0, 0, false, nil
+	}
+
+	ctx, done := startLookup(ctx, "rate_limit")
+	defer done()
+	var rps *float64
+	var burst *int32
+	if err := s.pool.QueryRow(ctx, s.limitQuery, tenant).Scan(&rps, &burst); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, 0, false, nil
+		}
+		return 0, 0, false, err
+	}
+	if rps == nil {
+		return 0, 0, false, nil
+	}
+	var b int
+	if burst != nil {
+		b = int(*burst)
+	}
+	return *rps, b, true, nil
+}
+
+// startLookup traces a metadata query; the returned function ends the span
+// and records the lookup latency.
+func startLookup(ctx context.Context, lookup string) (context.Context, func()) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "metadata "+lookup)
+	return ctx, func() {
+		telemetry.MetadataLookupDuration.WithLabelValues(lookup).Observe(time.Since(start).Seconds())
+		span.End()
+	}
+}
+
 func (s *metadataStore) Close() {
 	if s == nil {
 		return
@@ -0,0 +1,163 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/xscopehub/observe-gateway/internal/frame"
+	"github.com/xscopehub/observe-gateway/internal/query"
+	"github.com/xscopehub/observe-gateway/internal/sqlbuilder"
+)
+
+// QueryTraceLabels lists the fields of the tenant's trace stream, taken from
+// the OpenObserve stream schema, or the values of the field req.Label seen
+// between req.Start and req.End. The payload has the shape of a Prometheus
+// label response.
+func (c *Client) QueryTraceLabels(ctx context.Context, tenant string, req query.MetadataRequest) (Result, error) {
+	up := c.up.Load()
+	meta, err := c.resolveTenantMetadata(ctx, up, tenant)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if req.Label == "" {
+		stream, err := streamTable(meta.TraceTable, "traces")
+		if err != nil {
+			return Result{}, err
+		}
+		res, fields, err := up.oo.streamSchema(ctx, meta.Org, tenant, stream.Name(), "traces")
+		if err != nil {
+			return Result{}, err
+		}
+		payload, err := labelPayload(fields, req.Limit)
+		if err != nil {
+			return Result{}, err
+		}
+		return Result{Payload: payload, Backend: "openobserve-schema", Cost: res.Cost, Breaker: up.oo.breaker.stateName()}, nil
+	}
+
+	sql, err := compileTraceLabelValues(req.Label, meta.TraceTable)
+	if err != nil {
+		return Result{}, err
+	}
+	res, err := up.search(ctx, tenant, up.oo.traceSearchURL(meta.Org), sql, query.Request{Start: req.Start, End: req.End})
+	if err != nil {
+		return Result{}, err
+	}
+	hits, err := frame.DecodeHits(res.Payload)
+	if err != nil {
+		return Result{}, fmt.Errorf("decode openobserve search: %w", err)
+	}
+	values := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		values = append(values, frame.String(hit["value"]))
+	}
+	payload, err := labelPayload(values, req.Limit)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Payload: payload, Backend: "openobserve-tracesql", Cost: res.Cost, Breaker: up.oo.breaker.stateName()}, nil
+}
+
+// compileTraceLabelValues selects the distinct values of a trace stream
+// field.
+func compileTraceLabelValues(label, table string) (string, error) {
+	from, err := streamTable(table, "traces")
+	if err != nil {
+		return "", err
+	}
+	field, err := sqlbuilder.Ident(label)
+	if err != nil {
+		return "", &QueryError{Lang: "traceql", Err: err}
+	}
+	stmt := &sqlbuilder.Select{
+		Distinct: true,
+		Columns:  []sqlbuilder.Expr{sqlbuilder.As(field, sqlbuilder.MustIdent("value"))},
+		From:     sqlbuilder.Table(from),
+		Where:    sqlbuilder.IsNotNull(field),
+	}
+	return stmt.SQL(), nil
+}
+
+// labelPayload sorts and deduplicates label names or values, drops empty
+// ones and keeps at most limit of them.
+func labelPayload(values []string, limit int) ([]byte, error) {
+	seen := make(map[string]bool, len(values))
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		out = append(out, v)
+	}
+	sort.Strings(out)
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return json.Marshal(map[string]any{"status": "success", "data": out})
+}
+
+// streamSchema lists the field names of an OpenObserve stream.
+func (c *openObserveClient) streamSchema(ctx context.Context, org, tenant, stream, streamType string) (Result, []string, error) {
+	res, err := guard(ctx, c.breaker, func() (Result, error) {
+		endpoint := c.schema
+		if endpoint == "" {
+			return Result{}, &UnsupportedError{Status: http.StatusNotImplemented, Message: "openobserve stream schema endpoint not configured"}
+		}
+		if strings.Contains(endpoint, "%s") {
+			endpoint = fmt.Sprintf(endpoint, c.resolveOrg(org))
+		}
+		u, err := url.Parse(c.resolve(strings.ReplaceAll(endpoint, "{stream}", url.PathEscape(stream))))
+		if err != nil {
+			return Result{}, err
+		}
+		params := u.Query()
+		params.Set("type", streamType)
+		u.RawQuery = params.Encode()
+
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return Result{}, err
+		}
+		c.applyHeaders(httpReq, tenant)
+
+		resp, err := c.http.Do(httpReq)
+		if err != nil {
+			return Result{}, err
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return Result{}, err
+		}
+		if resp.StatusCode >= 400 {
+			return Result{}, responseError("openobserve schema", resp.StatusCode, body)
+		}
+		return Result{Payload: json.RawMessage(body), Cost: parseCost(resp.Header)}, nil
+	})
+	if err != nil {
+		return Result{}, nil, err
+	}
+
+	var schema struct {
+		Schema []struct {
+			Name string `json:"name"`
+		} `json:"schema"`
+	}
+	if err := json.Unmarshal(res.Payload, &schema); err != nil {
+		return Result{}, nil, fmt.Errorf("decode openobserve schema: %w", err)
+	}
+	fields := make([]string, 0, len(schema.Schema))
+	for _, f := range schema.Schema {
+		fields = append(fields, f.Name)
+	}
+	return res, fields, nil
+}
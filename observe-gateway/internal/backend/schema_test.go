@@ -0,0 +1,72 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/config"
+	"github.com/xscopehub/observe-gateway/internal/query"
+)
+
+func TestQueryTraceLabels(t *testing.T) {
+	var schemaQuery, sql string
+	openobserve := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/default/streams/traces/schema":
+			schemaQuery = r.URL.RawQuery
+			w.Write([]byte(`{"name":"traces","schema":[{"name":"trace_id","type":"Utf8"},{"name":"service_name","type":"Utf8"},{"name":"duration","type":"Int64"}]}`))
+		case "/api/default/traces":
+			var body struct {
+				SQL string `json:"sql"`
+			}
+			data, _ := io.ReadAll(r.Body)
+			json.Unmarshal(data, &body)
+			sql = body.SQL
+			w.Write([]byte(`{"hits":[{"value":"web"},{"value":"api"},{"value":""}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer openobserve.Close()
+
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatalf("config.Load() error = %v", err)
+	}
+	cfg.Backends.OpenObserve.BaseURL = openobserve.URL
+	client, err := New(context.Background(), cfg.Backends)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	res, err := client.QueryTraceLabels(ctx, "tenant-a", query.MetadataRequest{Kind: query.MetadataLabels, Limit: 2})
+	if err != nil {
+		t.Fatalf("QueryTraceLabels() error = %v", err)
+	}
+	if string(res.Payload) != `{"data":["duration","service_name"],"status":"success"}` || schemaQuery != "type=traces" {
+		t.Fatalf("fields = %s (query %q), want the first two schema fields", res.Payload, schemaQuery)
+	}
+
+	end := time.Unix(1700003600, 0)
+	res, err = client.QueryTraceLabels(ctx, "tenant-a", query.MetadataRequest{Kind: query.MetadataLabelValues, Label: "service_name", Start: end.Add(-time.Hour), End: end})
+	if err != nil {
+		t.Fatalf("QueryTraceLabels() error = %v", err)
+	}
+	if want := "SELECT DISTINCT service_name AS value FROM traces WHERE service_name IS NOT NULL"; sql != want {
+		t.Fatalf("sql = %s, want %s", sql, want)
+	}
+	if string(res.Payload) != `{"data":["api","web"],"status":"success"}` {
+		t.Fatalf("values = %s", res.Payload)
+	}
+
+	if _, err := client.QueryTraceLabels(ctx, "tenant-a", query.MetadataRequest{Kind: query.MetadataLabelValues, Label: "x; DROP"}); err == nil {
+		t.Fatal("QueryTraceLabels() with an invalid field error = nil")
+	}
+}
@@ -0,0 +1,88 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Stream reads the hits of an OpenObserve search response one at a time so
+// large results never have to be held in memory.
+type Stream struct {
+	Backend string
+	Cost    int64
+	Breaker string
+
+	body   io.ReadCloser
+	dec    *json.Decoder
+	inHits bool
+	done   bool
+}
+
+// NewStream wraps an OpenObserve search response body.
+func NewStream(body io.ReadCloser, cost int64) *Stream {
+	return &Stream{body: body, dec: json.NewDecoder(body), Cost: cost}
+}
+
+// Next returns the next hit, or io.EOF once the hits array is exhausted.
+func (s *Stream) Next() (json.RawMessage, error) {
+	if s.done {
+		return nil, io.EOF
+	}
+	if !s.inHits {
+		if err := s.seekHits(); err != nil {
+			s.done = true
+			return nil, err
+		}
+	}
+	if !s.dec.More() {
+		s.done = true
+		return nil, io.EOF
+	}
+	var hit json.RawMessage
+	if err := s.dec.Decode(&hit); err != nil {
+		s.done = true
+		return nil, fmt.Errorf("decode openobserve search: %w", err)
+	}
+	return hit, nil
+}
+
+// seekHits advances the decoder to the first element of the top-level
+// "hits" array, skipping any other fields.
+func (s *Stream) seekHits() error {
+	tok, err := s.dec.Token()
+	if err != nil {
+		return fmt.Errorf("decode openobserve search: %w", err)
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return errors.New("decode openobserve search: expected object")
+	}
+	for s.dec.More() {
+		tok, err := s.dec.Token()
+		if err != nil {
+			return fmt.Errorf("decode openobserve search: %w", err)
+		}
+		if key, _ := tok.(string); key == "hits" {
+			tok, err := s.dec.Token()
+			if err != nil {
+				return fmt.Errorf("decode openobserve search: %w", err)
+			}
+			if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+				return errors.New("decode openobserve search: hits is not an array")
+			}
+			s.inHits = true
+			return nil
+		}
+		var skip json.RawMessage
+		if err := s.dec.Decode(&skip); err != nil {
+			return fmt.Errorf("decode openobserve search: %w", err)
+		}
+	}
+	return io.EOF
+}
+
+// Close releases the upstream response body.
+func (s *Stream) Close() error {
+	return s.body.Close()
+}
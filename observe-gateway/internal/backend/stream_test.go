@@ -0,0 +1,127 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/config"
+	"github.com/xscopehub/observe-gateway/internal/query"
+)
+
+func TestStreamDecodesHits(t *testing.T) {
+	body := `{"took":3,"meta":{"hits":["x"]},"hits":[{"message":"a"},{"message":"b","n":[1,2]}],"total":2}`
+	stream := NewStream(io.NopCloser(strings.NewReader(body)), 0)
+	defer stream.Close()
+
+	var got []string
+	for {
+		hit, err := stream.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		got = append(got, string(hit))
+	}
+	want := []string{`{"message":"a"}`, `{"message":"b","n":[1,2]}`}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("hits = %q, want %q", got, want)
+	}
+	if _, err := stream.Next(); err != io.EOF {
+		t.Fatalf("Next() after end error = %v, want io.EOF", err)
+	}
+}
+
+func TestStreamWithoutHits(t *testing.T) {
+	stream := NewStream(io.NopCloser(strings.NewReader(`{"total":0}`)), 0)
+	if _, err := stream.Next(); err != io.EOF {
+		t.Fatalf("Next() error = %v, want io.EOF", err)
+	}
+}
+
+func TestQueryLogQLPaginates(t *testing.T) {
+	var body map[string]any
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("decode body: %v", err)
+		}
+		w.Write([]byte(`{"hits":[{"message":"a"},{"message":"b"}],"total":5}`))
+	}))
+	defer upstream.Close()
+
+	client, err := New(context.Background(), config.BackendConfig{
+		OpenObserve: config.OpenObserveConfig{BaseURL: upstream.URL, Org: "default", LogSearchEndpoint: "/api/%s/_search", LogTable: "logs"},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer client.Close()
+
+	now := time.Now()
+	req := query.Request{Lang: "logql", Query: `{app="api"}`, Start: now.Add(-time.Hour), End: now, Limit: 2, Offset: 2}
+	res, err := client.QueryLogQL(context.Background(), "tenant-a", req)
+	if err != nil {
+		t.Fatalf("QueryLogQL() error = %v", err)
+	}
+	if body["from"] != float64(2) || body["size"] != float64(2) {
+		t.Fatalf("from/size = %v/%v, want 2/2", body["from"], body["size"])
+	}
+	if res.Rows != 2 || !res.HasMore {
+		t.Fatalf("rows = %d, more = %v, want 2, true", res.Rows, res.HasMore)
+	}
+
+	req.Offset = 4
+	res, err = client.QueryLogQL(context.Background(), "tenant-a", req)
+	if err != nil {
+		t.Fatalf("QueryLogQL() error = %v", err)
+	}
+	if res.HasMore {
+		t.Fatalf("more = true on the last page")
+	}
+}
+
+func TestStreamOutlivesClientTimeout(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"hits":[{"message":"a"},`))
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte(`{"message":"b"}]}`))
+	}))
+	defer upstream.Close()
+
+	client, err := New(context.Background(), config.BackendConfig{
+		OpenObserve: config.OpenObserveConfig{BaseURL: upstream.URL, Org: "default", LogSearchEndpoint: "/api/%s/_search", LogTable: "logs", Timeout: 50 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer client.Close()
+
+	now := time.Now()
+	stream, err := client.StreamLogQL(context.Background(), "tenant-a", query.Request{Lang: "logql", Query: `{app="api"}`, Start: now.Add(-time.Hour), End: now})
+	if err != nil {
+		t.Fatalf("StreamLogQL() error = %v", err)
+	}
+	defer stream.Close()
+	rows := 0
+	for {
+		_, err := stream.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() after %d rows error = %v", rows, err)
+		}
+		rows++
+	}
+	if rows != 2 {
+		t.Fatalf("rows = %d, want 2", rows)
+	}
+}
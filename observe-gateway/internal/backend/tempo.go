@@ -0,0 +1,187 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/config"
+	"github.com/xscopehub/observe-gateway/internal/frame"
+	"github.com/xscopehub/observe-gateway/internal/query"
+	"github.com/xscopehub/observe-gateway/internal/sqlbuilder"
+	"github.com/xscopehub/observe-gateway/internal/telemetry"
+	"github.com/xscopehub/observe-gateway/internal/tempoapi"
+)
+
+// ErrTraceNotFound is returned when no backend has the requested trace.
+var ErrTraceNotFound = errors.New("trace not found")
+
+// maxTraceSpans bounds the spans fetched for a single trace.
+const maxTraceSpans = 10000
+
+// QueryTraceByID returns a trace as OTLP/JSON. The trace is looked up in
+// OpenObserve and, when OpenObserve does not have it or is unavailable, in
+// the Tempo backend if one is configured.
+func (c *Client) QueryTraceByID(ctx context.Context, tenant string, req query.TraceRequest) (Result, error) {
+	up := c.up.Load()
+	meta, err := c.resolveTenantMetadata(ctx, up, tenant)
+	if err != nil {
+		return Result{}, err
+	}
+	if req.End.IsZero() {
+		req.End = time.Now()
+	}
+	if req.Start.IsZero() {
+		req.Start = req.End.Add(-up.traceLookback)
+	}
+
+	res, err := up.openObserveTrace(ctx, tenant, meta, req)
+	if err == nil {
+		return res, nil
+	}
+	if up.tempo == nil || (!errors.Is(err, ErrTraceNotFound) && !isBackendFailure(ctx, err)) {
+		return Result{}, err
+	}
+
+	tempoRes, tempoErr := up.tempo.trace(ctx, tenant, req.ID)
+	if errors.Is(tempoErr, ErrTraceNotFound) {
+		// Report an OpenObserve failure rather than claim the trace is
+		// missing.
+		return Result{}, err
+	}
+	if tempoErr != nil {
+		return Result{}, tempoErr
+	}
+	tempoRes.Backend = "tempo"
+	tempoRes.Breaker = up.tempo.breaker.stateName()
+	return tempoRes, nil
+}
+
+// openObserveTrace fetches the spans of a trace from the tenant's trace
+// stream and converts them into OTLP/JSON.
+func (up *upstreams) openObserveTrace(ctx context.Context, tenant string, meta tenantMetadata, req query.TraceRequest) (Result, error) {
+	sql, err := compileTraceByID(req.ID, meta.TraceTable)
+	if err != nil {
+		return Result{}, err
+	}
+	res, err := up.search(ctx, tenant, up.oo.traceSearchURL(meta.Org), sql, query.Request{Start: req.Start, End: req.End, Limit: maxTraceSpans})
+	if err != nil {
+		return Result{}, err
+	}
+	f, err := frame.FromSpanHits(res.Payload)
+	if err != nil {
+		return Result{}, err
+	}
+	if len(f.Spans) == 0 {
+		return Result{}, ErrTraceNotFound
+	}
+	payload, err := json.Marshal(tempoapi.NewTrace(f.Spans))
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Payload: payload, Backend: "openobserve-tracesql", Cost: res.Cost, Breaker: up.oo.breaker.stateName(), Rows: len(f.Spans)}, nil
+}
+
+// compileTraceByID selects the spans of one trace.
+func compileTraceByID(id, table string) (string, error) {
+	from, err := streamTable(table, "traces")
+	if err != nil {
+		return "", err
+	}
+	q := &sqlbuilder.Select{
+		From:  sqlbuilder.Table(from),
+		Where: sqlbuilder.Compare(sqlbuilder.Col("", traceColTraceID), sqlbuilder.Eq, sqlbuilder.String(id)),
+	}
+	return q.SQL(), nil
+}
+
+// ---- Tempo trace-by-ID client ----
+
+type tempoClient struct {
+	baseURL   *url.URL
+	http      *http.Client
+	tracePath string
+	apiKey    string
+	breaker   *breaker
+}
+
+func newTempoClient(cfg config.TempoConfig) (*tempoClient, error) {
+	if cfg.BaseURL == "" {
+		return nil, fmt.Errorf("tempo base_url required")
+	}
+	parsed, err := url.Parse(cfg.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse tempo base_url: %w", err)
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	tracePath := cfg.TraceEndpoint
+	if tracePath == "" {
+		tracePath = "/api/traces/{id}"
+	}
+	return &tempoClient{
+		baseURL:   parsed,
+		http:      &http.Client{Timeout: timeout, Transport: telemetry.Transport("tempo", nil)},
+		tracePath: tracePath,
+		apiKey:    cfg.APIKey,
+	}, nil
+}
+
+func (c *tempoClient) resolve(endpoint string) string {
+	if strings.HasPrefix(endpoint, "http") {
+		return endpoint
+	}
+	u := *c.baseURL
+	u.Path = path.Join(c.baseURL.Path, endpoint)
+	return u.String()
+}
+
+// trace fetches a trace in Tempo's OTLP/JSON format. The tenant is passed
+// as X-Scope-OrgID, Tempo's tenant header.
+func (c *tempoClient) trace(ctx context.Context, tenant, id string) (Result, error) {
+	res, err := guard(ctx, c.breaker, func() (Result, error) {
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, c.resolve(strings.ReplaceAll(c.tracePath, "{id}", id)), nil)
+		if err != nil {
+			return Result{}, err
+		}
+		httpReq.Header.Set("Accept", "application/json")
+		if c.apiKey != "" {
+			httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+		}
+		if tenant != "" {
+			httpReq.Header.Set("X-Scope-OrgID", tenant)
+		}
+
+		resp, err := c.http.Do(httpReq)
+		if err != nil {
+			return Result{}, err
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return Result{}, err
+		}
+		// A missing trace is an answer, not a failure of the backend.
+		if resp.StatusCode == http.StatusNotFound {
+			return Result{}, nil
+		}
+		if resp.StatusCode >= 400 {
+			return Result{}, responseError("tempo trace", resp.StatusCode, body)
+		}
+		return Result{Payload: json.RawMessage(body), Cost: parseCost(resp.Header)}, nil
+	})
+	if err == nil && res.Payload == nil {
+		return Result{}, ErrTraceNotFound
+	}
+	return res, err
+}
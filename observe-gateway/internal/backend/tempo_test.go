@@ -0,0 +1,102 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/xscopehub/observe-gateway/internal/config"
+	"github.com/xscopehub/observe-gateway/internal/query"
+	"github.com/xscopehub/observe-gateway/internal/tempoapi"
+)
+
+func TestQueryTraceByIDFallsBackToTempo(t *testing.T) {
+	const stored = "0123456789abcdef0123456789abcdef"
+	const archived = "fedcba9876543210fedcba9876543210"
+	var sqls []string
+	openobserve := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			SQL string `json:"sql"`
+		}
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+		sqls = append(sqls, body.SQL)
+		if !strings.Contains(body.SQL, stored) {
+			w.Write([]byte(`{"hits":[]}`))
+			return
+		}
+		w.Write([]byte(`{"hits":[` +
+			`{"trace_id":"` + stored + `","span_id":"b1","reference_parent_span_id":"a1","operation_name":"query","service_name":"db","span_kind":"3","span_status":"ERROR","start_time":1700000000100000,"duration":500},` +
+			`{"trace_id":"` + stored + `","span_id":"a1","operation_name":"GET /","service_name":"api","span_kind":"SPAN_KIND_SERVER","span_status":"OK","start_time":1700000000000000,"duration":1000,"attributes":{"http.method":"GET"}}]}`))
+	}))
+	defer openobserve.Close()
+	var tempoTenants []string
+	tempo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tempoTenants = append(tempoTenants, r.Header.Get("X-Scope-OrgID"))
+		if r.URL.Path != "/api/traces/"+archived {
+			http.Error(w, "trace not found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"batches":[]}`))
+	}))
+	defer tempo.Close()
+
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatalf("config.Load() error = %v", err)
+	}
+	cfg.Backends.OpenObserve.BaseURL = openobserve.URL
+	cfg.Backends.Tempo.Enabled, cfg.Backends.Tempo.BaseURL = true, tempo.URL
+	cfg.Backends.CircuitBreaker.Enabled = true
+	client, err := New(context.Background(), cfg.Backends)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	res, err := client.QueryTraceByID(ctx, "tenant-a", query.TraceRequest{ID: stored})
+	if err != nil || res.Backend != "openobserve-tracesql" || res.Rows != 2 {
+		t.Fatalf("stored trace = %+v, %v, want two spans from openobserve", res, err)
+	}
+	if want := "SELECT * FROM traces WHERE trace_id = '" + stored + "'"; sqls[0] != want {
+		t.Fatalf("sql = %s, want %s", sqls[0], want)
+	}
+	var trace tempoapi.Trace
+	if err := json.Unmarshal(res.Payload, &trace); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if len(trace.Batches) != 2 || trace.Batches[0].Resource.Attributes[0].Value.StringValue != "api" {
+		t.Fatalf("batches = %+v, want api and db", trace.Batches)
+	}
+	root := trace.Batches[0].ScopeSpans[0].Spans[0]
+	if root.Kind != 2 || root.Status.Code != 1 || root.EndTimeUnixNano != "1700000000001000000" || root.Attributes[0].Key != "http.method" {
+		t.Fatalf("root span = %+v", root)
+	}
+	if len(tempoTenants) != 0 {
+		t.Fatalf("tempo called for a stored trace")
+	}
+
+	// Without an OpenObserve breaker, a state can only be Tempo's.
+	client.up.Load().oo.breaker = nil
+	res, err = client.QueryTraceByID(ctx, "tenant-a", query.TraceRequest{ID: archived})
+	if err != nil || res.Backend != "tempo" || string(res.Payload) != `{"batches":[]}` {
+		t.Fatalf("archived trace = %+v, %v, want the tempo body", res, err)
+	}
+	if res.Breaker != "closed" {
+		t.Fatalf("breaker = %q, want tempo's closed breaker", res.Breaker)
+	}
+	if tempoTenants[0] != "tenant-a" {
+		t.Fatalf("tempo tenant = %q, want tenant-a", tempoTenants[0])
+	}
+
+	_, err = client.QueryTraceByID(ctx, "tenant-a", query.TraceRequest{ID: strings.Repeat("0", 31) + "1"})
+	if !errors.Is(err, ErrTraceNotFound) {
+		t.Fatalf("missing trace error = %v, want ErrTraceNotFound", err)
+	}
+}
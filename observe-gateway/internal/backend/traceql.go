@@ -0,0 +1,331 @@
+package backend
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/xscopehub/observe-gateway/internal/sqlbuilder"
+	"github.com/xscopehub/observe-gateway/internal/traceql"
+)
+
+// OpenObserve trace stream columns used by the TraceQL compiler.
+const (
+	traceColTraceID      = "trace_id"
+	traceColSpanID       = "span_id"
+	traceColParentSpanID = "reference_parent_span_id"
+	traceColStart        = "start_time"
+	traceColEnd          = "end_time"
+	traceColAttributes   = "attributes"
+	traceColResource     = "resource_attributes"
+)
+
+var traceIntrinsicColumns = map[string]string{
+	traceql.IntrinsicName:          "operation_name",
+	traceql.IntrinsicStatus:        "span_status",
+	traceql.IntrinsicStatusMessage: "status_message",
+	traceql.IntrinsicKind:          "span_kind",
+	traceql.IntrinsicDuration:      "duration",
+}
+
+// translateTraceQL parses a TraceQL query and compiles it into OpenObserve SQL.
+func translateTraceQL(q, table string) (string, error) {
+	q = strings.TrimSpace(q)
+	if q == "" {
+		return "", &QueryError{Lang: "traceql", Err: fmt.Errorf("empty traceql")}
+	}
+
+	parsed, err := traceql.Parse(q)
+	if err != nil {
+		return "", &QueryError{Lang: "traceql", Err: err}
+	}
+	if parsed.Pipeline != "" {
+		return "", unsupportedTraceQL("pipelines are not supported: | %s", parsed.Pipeline)
+	}
+
+	from, err := streamTable(table, "traces")
+	if err != nil {
+		return "", err
+	}
+
+	c := &traceCompiler{table: from}
+	set, err := c.compileSpanset(parsed.Spanset)
+	if err != nil {
+		return "", err
+	}
+	return c.query(set).SQL(), nil
+}
+
+// spansetSQL is a compiled spanset: either a plain filter over the trace
+// table or a full query returning span rows.
+type spansetSQL struct {
+	where sqlbuilder.Expr
+	query sqlbuilder.Query
+}
+
+type traceCompiler struct {
+	table sqlbuilder.Identifier
+}
+
+func (c *traceCompiler) query(s spansetSQL) sqlbuilder.Query {
+	if s.query != nil {
+		return s.query
+	}
+	return &sqlbuilder.Select{From: sqlbuilder.Table(c.table), Where: s.where}
+}
+
+func (c *traceCompiler) compileSpanset(expr traceql.SpansetExpr) (spansetSQL, error) {
+	switch e := expr.(type) {
+	case *traceql.SpansetFilter:
+		where, err := c.compileFilter(e, "")
+		if err != nil {
+			return spansetSQL{}, err
+		}
+		return spansetSQL{where: where}, nil
+	case *traceql.SpansetOperation:
+		return c.compileOperation(e)
+	default:
+		return spansetSQL{}, unsupportedTraceQL("spanset %s is not supported", expr)
+	}
+}
+
+func (c *traceCompiler) compileFilter(f *traceql.SpansetFilter, alias string) (sqlbuilder.Expr, error) {
+	if f.Expr == nil {
+		return sqlbuilder.True, nil
+	}
+	return c.compileField(f.Expr, alias)
+}
+
+func (c *traceCompiler) compileOperation(op *traceql.SpansetOperation) (spansetSQL, error) {
+	left, err := c.compileSpanset(op.Left)
+	if err != nil {
+		return spansetSQL{}, err
+	}
+
+	switch op.Op {
+	case traceql.SpansetOr, traceql.SpansetAnd:
+		right, err := c.compileSpanset(op.Right)
+		if err != nil {
+			return spansetSQL{}, err
+		}
+		if op.Op == traceql.SpansetOr {
+			if left.query == nil && right.query == nil {
+				return spansetSQL{where: sqlbuilder.Conjunction(sqlbuilder.Or(sqlbuilder.Paren(left.where), sqlbuilder.Paren(right.where)))}, nil
+			}
+			return spansetSQL{query: sqlbuilder.Union(c.query(left), c.query(right))}, nil
+		}
+		// Both spansets must match within the same trace; the result holds
+		// the spans of either side.
+		traceID := sqlbuilder.Col("", traceColTraceID)
+		if left.query == nil && right.query == nil {
+			return spansetSQL{where: sqlbuilder.Conjunction(
+				sqlbuilder.Or(sqlbuilder.Paren(left.where), sqlbuilder.Paren(right.where)),
+				sqlbuilder.In(traceID, &sqlbuilder.Select{Columns: []sqlbuilder.Expr{traceID}, From: sqlbuilder.Table(c.table), Where: left.where}),
+				sqlbuilder.In(traceID, &sqlbuilder.Select{Columns: []sqlbuilder.Expr{traceID}, From: sqlbuilder.Table(c.table), Where: right.where}),
+			)}, nil
+		}
+		return spansetSQL{query: &sqlbuilder.Select{
+			From: sqlbuilder.Subquery(sqlbuilder.Union(c.query(left), c.query(right)), sqlbuilder.MustIdent("s")),
+			Where: sqlbuilder.Conjunction(
+				sqlbuilder.In(sqlbuilder.Col("s", traceColTraceID), &sqlbuilder.Select{Columns: []sqlbuilder.Expr{traceID}, From: sqlbuilder.Subquery(c.query(left), sqlbuilder.MustIdent("l"))}),
+				sqlbuilder.In(sqlbuilder.Col("s", traceColTraceID), &sqlbuilder.Select{Columns: []sqlbuilder.Expr{traceID}, From: sqlbuilder.Subquery(c.query(right), sqlbuilder.MustIdent("r"))}),
+			),
+		}}, nil
+	}
+
+	filter, ok := op.Right.(*traceql.SpansetFilter)
+	if !ok {
+		return spansetSQL{}, unsupportedTraceQL("the right side of %s must be a spanset filter", op.Op)
+	}
+	where, err := c.compileFilter(filter, "r")
+	if err != nil {
+		return spansetSQL{}, err
+	}
+
+	r := func(col string) sqlbuilder.Expr { return sqlbuilder.Col("r", col) }
+	l := func(col string) sqlbuilder.Expr { return sqlbuilder.Col("l", col) }
+	var relation []sqlbuilder.Expr
+	switch op.Op {
+	case traceql.SpansetChild:
+		relation = []sqlbuilder.Expr{sqlbuilder.Compare(r(traceColParentSpanID), sqlbuilder.Eq, l(traceColSpanID))}
+	case traceql.SpansetParent:
+		relation = []sqlbuilder.Expr{sqlbuilder.Compare(l(traceColParentSpanID), sqlbuilder.Eq, r(traceColSpanID))}
+	case traceql.SpansetSibling:
+		relation = []sqlbuilder.Expr{
+			sqlbuilder.Compare(r(traceColParentSpanID), sqlbuilder.Eq, l(traceColParentSpanID)),
+			sqlbuilder.Compare(r(traceColSpanID), sqlbuilder.Ne, l(traceColSpanID)),
+		}
+	case traceql.SpansetDescendant:
+		// OpenObserve SQL has no recursive queries, so ancestry is derived
+		// from time containment within the same trace.
+		relation = []sqlbuilder.Expr{
+			sqlbuilder.Compare(r(traceColSpanID), sqlbuilder.Ne, l(traceColSpanID)),
+			sqlbuilder.Compare(r(traceColStart), sqlbuilder.Ge, l(traceColStart)),
+			sqlbuilder.Compare(r(traceColEnd), sqlbuilder.Le, l(traceColEnd)),
+		}
+	case traceql.SpansetAncestor:
+		relation = []sqlbuilder.Expr{
+			sqlbuilder.Compare(r(traceColSpanID), sqlbuilder.Ne, l(traceColSpanID)),
+			sqlbuilder.Compare(l(traceColStart), sqlbuilder.Ge, r(traceColStart)),
+			sqlbuilder.Compare(l(traceColEnd), sqlbuilder.Le, r(traceColEnd)),
+		}
+	default:
+		return spansetSQL{}, unsupportedTraceQL("spanset operator %s is not supported", op.Op)
+	}
+
+	on := append([]sqlbuilder.Expr{sqlbuilder.Compare(r(traceColTraceID), sqlbuilder.Eq, l(traceColTraceID))}, relation...)
+	return spansetSQL{query: &sqlbuilder.Select{
+		Distinct: true,
+		Columns:  []sqlbuilder.Expr{sqlbuilder.Star("r")},
+		From:     sqlbuilder.TableAs(c.table, sqlbuilder.MustIdent("r")),
+		Join:     &sqlbuilder.Join{Source: sqlbuilder.Subquery(c.query(left), sqlbuilder.MustIdent("l")), On: sqlbuilder.Conjunction(on...)},
+		Where:    where,
+	}}, nil
+}
+
+func (c *traceCompiler) compileField(expr traceql.FieldExpr, alias string) (sqlbuilder.Expr, error) {
+	switch e := expr.(type) {
+	case *traceql.BinaryFieldExpr:
+		left, err := c.compileField(e.Left, alias)
+		if err != nil {
+			return nil, err
+		}
+		right, err := c.compileField(e.Right, alias)
+		if err != nil {
+			return nil, err
+		}
+		if e.Or {
+			return sqlbuilder.Or(left, right), nil
+		}
+		return sqlbuilder.And(left, right), nil
+	case *traceql.NotExpr:
+		inner, err := c.compileField(e.Expr, alias)
+		if err != nil {
+			return nil, err
+		}
+		return sqlbuilder.Not(inner), nil
+	case traceql.BoolLiteral:
+		if e {
+			return sqlbuilder.True, nil
+		}
+		return sqlbuilder.False, nil
+	case *traceql.Comparison:
+		return c.compileComparison(e, alias)
+	default:
+		return nil, unsupportedTraceQL("expression %s is not supported", expr)
+	}
+}
+
+func (c *traceCompiler) column(attr traceql.Attribute, alias string) (sqlbuilder.Expr, error) {
+	switch attr.Scope {
+	case traceql.ScopeSpan:
+		return sqlbuilder.JSONText(sqlbuilder.Col(alias, traceColAttributes), attr.Name), nil
+	case traceql.ScopeResource:
+		return sqlbuilder.JSONText(sqlbuilder.Col(alias, traceColResource), attr.Name), nil
+	case traceql.ScopeUnscoped:
+		return sqlbuilder.Call(sqlbuilder.FuncCoalesce,
+			sqlbuilder.JSONText(sqlbuilder.Col(alias, traceColAttributes), attr.Name),
+			sqlbuilder.JSONText(sqlbuilder.Col(alias, traceColResource), attr.Name),
+		), nil
+	case traceql.ScopeIntrinsic:
+		col, ok := traceIntrinsicColumns[attr.Name]
+		if !ok {
+			return nil, unsupportedTraceQL("intrinsic %s is not supported", attr.Name)
+		}
+		return sqlbuilder.Col(alias, col), nil
+	}
+	return nil, unsupportedTraceQL("attribute %s is not supported", attr)
+}
+
+func (c *traceCompiler) compileComparison(cmp *traceql.Comparison, alias string) (sqlbuilder.Expr, error) {
+	col, err := c.column(cmp.Attr, alias)
+	if err != nil {
+		return nil, err
+	}
+	intrinsic := ""
+	if cmp.Attr.Scope == traceql.ScopeIntrinsic {
+		intrinsic = cmp.Attr.Name
+	}
+	value := cmp.Value
+
+	switch {
+	case intrinsic == traceql.IntrinsicStatus && value.Kind != traceql.StaticStatus,
+		intrinsic == traceql.IntrinsicKind && value.Kind != traceql.StaticKindEnum,
+		intrinsic == traceql.IntrinsicDuration && value.Kind != traceql.StaticDuration:
+		return nil, unsupportedTraceQL("%s cannot be compared with %s", cmp.Attr, value)
+	case intrinsic != traceql.IntrinsicStatus && value.Kind == traceql.StaticStatus,
+		intrinsic != traceql.IntrinsicKind && value.Kind == traceql.StaticKindEnum,
+		intrinsic != traceql.IntrinsicDuration && value.Kind == traceql.StaticDuration:
+		return nil, unsupportedTraceQL("%s cannot be compared with %s", cmp.Attr, value)
+	}
+
+	eqOp := func() (sqlbuilder.Op, error) {
+		switch cmp.Op {
+		case traceql.CompareEqual:
+			return sqlbuilder.Eq, nil
+		case traceql.CompareNotEqual:
+			return sqlbuilder.Ne, nil
+		}
+		return 0, unsupportedTraceQL("operator %s is not supported for %s", cmp.Op, value)
+	}
+
+	switch value.Kind {
+	case traceql.StaticNil:
+		switch cmp.Op {
+		case traceql.CompareEqual:
+			return sqlbuilder.IsNull(col), nil
+		case traceql.CompareNotEqual:
+			return sqlbuilder.IsNotNull(col), nil
+		}
+		return nil, unsupportedTraceQL("operator %s is not supported for nil", cmp.Op)
+	case traceql.StaticStatus, traceql.StaticKindEnum, traceql.StaticBool:
+		op, err := eqOp()
+		if err != nil {
+			return nil, err
+		}
+		literal := value.Raw
+		if value.Kind != traceql.StaticBool {
+			literal = strings.ToUpper(literal)
+		}
+		return sqlbuilder.Compare(col, op, sqlbuilder.String(literal)), nil
+	case traceql.StaticString:
+		switch cmp.Op {
+		case traceql.CompareEqual, traceql.CompareNotEqual:
+			op, _ := eqOp()
+			return sqlbuilder.Compare(col, op, sqlbuilder.String(value.Raw)), nil
+		case traceql.CompareRegexp:
+			return sqlbuilder.Compare(col, sqlbuilder.Match, sqlbuilder.String(anchorRegexp(value.Raw))), nil
+		case traceql.CompareNotRegexp:
+			return sqlbuilder.Compare(col, sqlbuilder.NotMatch, sqlbuilder.String(anchorRegexp(value.Raw))), nil
+		}
+		return nil, unsupportedTraceQL("operator %s is not supported for strings", cmp.Op)
+	case traceql.StaticNumber, traceql.StaticDuration:
+		op, ok := traceNumericOps[cmp.Op]
+		if !ok {
+			return nil, unsupportedTraceQL("operator %s is not supported for %s", cmp.Op, value)
+		}
+		if value.Kind == traceql.StaticDuration {
+			// OpenObserve stores span durations in microseconds.
+			return sqlbuilder.Compare(col, op, sqlbuilder.Micros(value.Duration)), nil
+		}
+		n, err := sqlbuilder.Float(value.Number)
+		if err != nil {
+			return nil, unsupportedTraceQL("value %s: %v", value, err)
+		}
+		return sqlbuilder.Compare(sqlbuilder.Cast(col, sqlbuilder.Double), op, n), nil
+	}
+	return nil, unsupportedTraceQL("value %s is not supported", value)
+}
+
+var traceNumericOps = map[traceql.CompareOp]sqlbuilder.Op{
+	traceql.CompareEqual:        sqlbuilder.Eq,
+	traceql.CompareNotEqual:     sqlbuilder.Ne,
+	traceql.CompareGreater:      sqlbuilder.Gt,
+	traceql.CompareGreaterEqual: sqlbuilder.Ge,
+	traceql.CompareLess:         sqlbuilder.Lt,
+	traceql.CompareLessEqual:    sqlbuilder.Le,
+}
+
+func unsupportedTraceQL(format string, args ...any) error {
+	return &UnsupportedError{Status: http.StatusBadRequest, Message: "traceql: " + fmt.Sprintf(format, args...)}
+}
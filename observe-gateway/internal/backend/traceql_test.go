@@ -0,0 +1,64 @@
+package backend
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTranslateTraceQL(t *testing.T) {
+	cases := []struct {
+		query string
+		want  string
+	}{
+		{
+			query: `{ span.http.status_code >= 500 && duration > 2s }`,
+			want:  `SELECT * FROM traces WHERE (CAST(attributes->>'http.status_code' AS DOUBLE PRECISION) >= 500 AND duration > 2000000)`,
+		},
+		{
+			query: `{ resource.service.name = "api" && (status = error || kind != server) }`,
+			want:  `SELECT * FROM traces WHERE (resource_attributes->>'service.name' = 'api' AND (span_status = 'ERROR' OR span_kind <> 'SERVER'))`,
+		},
+		{
+			query: `{ .region =~ "eu-.*" && name != "it's" }`,
+			want:  `SELECT * FROM traces WHERE (COALESCE(attributes->>'region', resource_attributes->>'region') ~ '^(?:eu-.*)$' AND operation_name <> 'it''s')`,
+		},
+		{
+			query: `{ resource.service.name = "api" } > { span.db.system = "postgresql" }`,
+			want:  `SELECT DISTINCT r.* FROM traces AS r JOIN (SELECT * FROM traces WHERE resource_attributes->>'service.name' = 'api') AS l ON r.trace_id = l.trace_id AND r.reference_parent_span_id = l.span_id WHERE r.attributes->>'db.system' = 'postgresql'`,
+		},
+		{
+			query: `{ name = "a" } || { name = "b" }`,
+			want:  `SELECT * FROM traces WHERE ((operation_name = 'a') OR (operation_name = 'b'))`,
+		},
+		{
+			query: `{ name = "a" } && { name = "b" }`,
+			want:  `SELECT * FROM traces WHERE ((operation_name = 'a') OR (operation_name = 'b')) AND trace_id IN (SELECT trace_id FROM traces WHERE operation_name = 'a') AND trace_id IN (SELECT trace_id FROM traces WHERE operation_name = 'b')`,
+		},
+	}
+
+	for _, tc := range cases {
+		got, err := translateTraceQL(tc.query, "traces")
+		if err != nil {
+			t.Fatalf("translateTraceQL(%q) error = %v", tc.query, err)
+		}
+		if got != tc.want {
+			t.Errorf("translateTraceQL(%q)\n got = %s\nwant = %s", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestTranslateTraceQLRejectsUnsupported(t *testing.T) {
+	for _, q := range []string{
+		`{ } | count() > 2`,
+		`{ status = 500 }`,
+		`{ span.x > "a" }`,
+		`{ rootServiceName = "api" }`,
+		`{ name = "a" } > ({ name = "b" } && { name = "c" })`,
+	} {
+		_, err := translateTraceQL(q, "traces")
+		var unsupported *UnsupportedError
+		if !errors.As(err, &unsupported) {
+			t.Errorf("translateTraceQL(%q) error = %v, want *UnsupportedError", q, err)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package backend
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// sqlShape replaces every string literal in sql with '?' so that statements
+// differing only in literal values compare equal. It reports false for an
+// unterminated literal.
+func sqlShape(sql string) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(sql); i++ {
+		if sql[i] != '\'' {
+			b.WriteByte(sql[i])
+			continue
+		}
+		closed := false
+		for i++; i < len(sql); i++ {
+			if sql[i] != '\'' {
+				continue
+			}
+			if i+1 < len(sql) && sql[i+1] == '\'' {
+				i++
+				continue
+			}
+			closed = true
+			break
+		}
+		if !closed {
+			return "", false
+		}
+		b.WriteString("'?'")
+	}
+	return b.String(), true
+}
+
+var injectionSeeds = []string{
+	"x", "'", "it's", `\`, "'; DROP TABLE logs; --", "a' OR '1'='1", "*/ 1=1 /*", "\n", "{}", `"`,
+}
+
+var identifierSeeds = []string{
+	"x", "level", "a_b", "a-b", "_x1", "or", "json", "x'y", "a b", "a;b",
+}
+
+var regexSeeds = []string{
+	"x", ".*", "a|b", "^a$", "it's", `\'`, "(?i)err", "[']", "a)|(b", "'; DROP TABLE logs; --",
+}
+
+// fuzzValues fuzzes value in the positions of a query template: every
+// query that translates must have the statement shape of the first seed.
+func fuzzValues(f *testing.F, seeds []string, build func(string) string, translate func(string) (string, error)) {
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	want, err := translate(build(seeds[0]))
+	if err != nil {
+		f.Fatalf("translate(%q) error = %v", build(seeds[0]), err)
+	}
+	wantShape, _ := sqlShape(want)
+
+	f.Fuzz(func(t *testing.T, value string) {
+		got, err := translate(build(value))
+		if err != nil {
+			return
+		}
+		shape, ok := sqlShape(got)
+		if !ok || shape != wantShape {
+			t.Fatalf("value %q changed the statement:\n got = %s\nwant = %s", value, got, want)
+		}
+	})
+}
+
+// checkStatement fails unless sql, outside its string literals, is a
+// single SELECT with balanced parentheses and no comments.
+func checkStatement(t *testing.T, q, sql string) {
+	t.Helper()
+	shape, ok := sqlShape(sql)
+	if !ok {
+		t.Fatalf("query %q left a literal open: %s", q, sql)
+	}
+	if !strings.HasPrefix(shape, "SELECT ") || strings.ContainsAny(shape, ";") || strings.Contains(shape, "--") || strings.Contains(shape, "/*") {
+		t.Fatalf("query %q escaped the statement: %s", q, sql)
+	}
+	if strings.Count(shape, "(") != strings.Count(shape, ")") {
+		t.Fatalf("query %q unbalanced the statement: %s", q, sql)
+	}
+}
+
+// FuzzTranslateLogQLValues checks that tenant supplied values cannot change
+// the structure of the generated SQL.
+func FuzzTranslateLogQLValues(f *testing.F) {
+	fuzzValues(f, injectionSeeds, func(v string) string {
+		q := strconv.Quote(v)
+		return `{service=` + q + `} |= ` + q + ` != ` + q + ` | json | level=` + q + ` or code != ` + q
+	}, translateLogQLLogs)
+}
+
+// FuzzTranslateLogQLIdentifiers is FuzzTranslateLogQLValues for label
+// names.
+func FuzzTranslateLogQLIdentifiers(f *testing.F) {
+	fuzzValues(f, identifierSeeds, func(v string) string {
+		return `{` + v + `="x"} | json ` + v + `="a.b" | ` + v + `="y" | label_format svc=` + v
+	}, translateLogQLLogs)
+}
+
+// FuzzTranslateLogQLRegexes is FuzzTranslateLogQLValues for regular
+// expressions.
+func FuzzTranslateLogQLRegexes(f *testing.F) {
+	fuzzValues(f, regexSeeds, func(v string) string {
+		q := strconv.Quote(v)
+		return `{service=~` + q + `, team!~` + q + `} |~ ` + q + ` !~ ` + q + ` | json | level=~` + q
+	}, translateLogQLLogs)
+}
+
+// FuzzTranslateLogQL checks that no query, however malformed, escapes the
+// generated statement.
+func FuzzTranslateLogQL(f *testing.F) {
+	for _, seed := range []string{
+		`{service="api", env!="dev"} |= "error"`,
+		`{service=~"api|web"} !~ "health.*" |~ "(?i)err"`,
+		`{service="api"} | json | level="error" or status >= 500`,
+		`{service="api"} | json code="response.status" | code="500"`,
+		`{service="api"} | logfmt | level="warn"`,
+		`{service="api"} | label_format svc=service | svc="api"`,
+		`{service="a'b"} |= "'; DROP TABLE logs; --"`,
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, q string) {
+		if sql, err := translateLogQLLogs(q); err == nil {
+			checkStatement(t, q, sql)
+		}
+	})
+}
+
+func translateLogQLLogs(q string) (string, error) { return translateLogQL(q, "logs") }
+
+// FuzzTranslateTraceQLValues is the TraceQL counterpart of
+// FuzzTranslateLogQLValues.
+func FuzzTranslateTraceQLValues(f *testing.F) {
+	fuzzValues(f, injectionSeeds, func(v string) string {
+		q := strconv.Quote(v)
+		return `{ resource.service.name = ` + q + ` } > { span.http.url != ` + q + ` && .region = ` + q + ` }`
+	}, translateTraceQLTraces)
+}
+
+// FuzzTranslateTraceQLIdentifiers is the TraceQL counterpart of
+// FuzzTranslateLogQLIdentifiers.
+func FuzzTranslateTraceQLIdentifiers(f *testing.F) {
+	fuzzValues(f, identifierSeeds, func(v string) string {
+		return `{ resource.` + v + ` = "x" } > { span.` + v + ` != "y" && .` + v + ` >= 500 }`
+	}, translateTraceQLTraces)
+}
+
+// FuzzTranslateTraceQLRegexes is the TraceQL counterpart of
+// FuzzTranslateLogQLRegexes.
+func FuzzTranslateTraceQLRegexes(f *testing.F) {
+	fuzzValues(f, regexSeeds, func(v string) string {
+		q := strconv.Quote(v)
+		return `{ resource.service.name =~ ` + q + ` } > { name !~ ` + q + ` && .region =~ ` + q + ` }`
+	}, translateTraceQLTraces)
+}
+
+// FuzzTranslateTraceQL is the TraceQL counterpart of FuzzTranslateLogQL.
+func FuzzTranslateTraceQL(f *testing.F) {
+	for _, seed := range []string{
+		`{ span.http.status_code >= 500 && duration > 2ms }`,
+		`{ resource.service.name = "api" && (status = error || kind != server) }`,
+		`{ .region =~ "eu-.*" && name != "it's" }`,
+		`{ resource.service.name = "api" } > { span.db.system = "postgresql" }`,
+		`{ name = "a" } || { name = "b" }`,
+		`{ name = "a" } && { name = "b" }`,
+		`{ name = "'; DROP TABLE traces; --" }`,
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, q string) {
+		if sql, err := translateTraceQLTraces(q); err == nil {
+			checkStatement(t, q, sql)
+		}
+	})
+}
+
+func translateTraceQLTraces(q string) (string, error) { return translateTraceQL(q, "traces") }
+
+func TestTranslateRejectsUnsafeTable(t *testing.T) {
+	for _, table := range []string{"logs; DROP TABLE x", `logs"`, "a b"} {
+		if _, err := translateLogQL(`{service="api"}`, table); err == nil {
+			t.Errorf("translateLogQL(table %q) error = nil, want error", table)
+		}
+		if _, err := translateTraceQL(`{ name = "a" }`, table); err == nil {
+			t.Errorf("translateTraceQL(table %q) error = nil, want error", table)
+		}
+	}
+}
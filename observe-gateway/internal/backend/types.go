@@ -7,6 +7,17 @@ type Result struct {
 	Payload json.RawMessage
 	Backend string
 	Cost    int64
+	// Breaker is the state of the circuit breaker of the backend that
+	// served the request, observed when it completed; empty when circuit
+	// breaking is disabled.
+	Breaker string
+	// Rows and HasMore describe the page returned for a paginated search.
+	Rows    int
+	HasMore bool
+	// Warnings describe data missing from a partial result, which is
+	// served but never cached.
+	Warnings []string
+	Partial  bool
 }
 
 // UnsupportedError indicates a query is unsupported by the backend.
@@ -21,3 +32,27 @@ func (e *UnsupportedError) Error() string {
 	}
 	return e.Message
 }
+
+// QueryError indicates a query could not be parsed or translated, or was
+// rejected by the backend.
+type QueryError struct {
+	Lang string
+	// Status is the HTTP status of the backend's rejection; zero when the
+	// gateway rejected the query.
+	Status int
+	Err    error
+}
+
+func (e *QueryError) Error() string {
+	if e == nil || e.Err == nil {
+		return "invalid query"
+	}
+	return e.Err.Error()
+}
+
+func (e *QueryError) Unwrap() error {
+	if e == nil {
+		return nil
+	}
+	return e.Err
+}
@@ -1,17 +1,66 @@
 package cache
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dgraph-io/ristretto"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/xscopehub/observe-gateway/internal/telemetry"
+)
+
+// Tier identifies the cache layer that served a hit.
+type Tier string
+
+const (
+	TierL1 Tier = "l1"
+	TierL2 Tier = "l2"
 )
 
-// Cache wraps a ristretto cache with feature toggle awareness.
+// Key identifies a cached entry. Tenant and Template namespace the entry so
+// it can be purged; Lang and Template select its TTL.
+type Key struct {
+	Tenant   string
+	Lang     string
+	Template string
+	ID       string
+}
+
+// Cache is a two-tier cache: an in-process ristretto cache (L1) in front of
+// an optional Redis cache (L2) shared by all gateway replicas.
 type Cache struct {
 	enabled bool
-	ttl     time.Duration
+	ttls    atomic.Pointer[ttls]
 	store   *ristretto.Cache
+
+	redis    redis.UniversalClient
+	prefix   string
+	compress bool
+	sub      *redis.PubSub
+
+	mu          sync.Mutex
+	generations map[string]uint64
+}
+
+// ttls are the reloadable expiry settings.
+type ttls struct {
+	ttl         time.Duration
+	langTTL     map[string]time.Duration
+	templateTTL map[string]time.Duration
 }
 
 // Config captures cache construction parameters.
@@ -21,8 +70,26 @@ type Config struct {
 	MaxCost     int64
 	BufferItems int64
 	TTL         time.Duration
+	// LangTTL and TemplateTTL override TTL per query language and per
+	// template; a template override wins over a language override.
+	LangTTL     map[string]time.Duration
+	TemplateTTL map[string]time.Duration
+
+	// Redis enables the L2 tier when non-nil.
+	Redis       redis.UniversalClient
+	KeyPrefix   string
+	Compression bool
 }
 
+// compressMinBytes is the smallest L2 value worth compressing.
+const compressMinBytes = 1024
+
+// L2 value encodings, stored as the first byte of the value.
+const (
+	encodingRaw  byte = 0
+	encodingGzip byte = 1
+)
+
 // New creates a Cache instance according to the configuration.
 func New(cfg Config) (*Cache, error) {
 	if !cfg.Enabled {
@@ -38,36 +105,283 @@ func New(cfg Config) (*Cache, error) {
 		return nil, err
 	}
 
-	ttl := cfg.TTL
-	if ttl <= 0 {
-		ttl = time.Minute
+	prefix := cfg.KeyPrefix
+	if prefix == "" {
+		prefix = "obsgw:cache:"
 	}
 
-	return &Cache{enabled: true, ttl: ttl, store: rc}, nil
+	c := &Cache{
+		enabled:     true,
+		store:       rc,
+		redis:       cfg.Redis,
+		prefix:      prefix,
+		compress:    cfg.Compression,
+		generations: make(map[string]uint64),
+	}
+	c.SetTTLs(cfg.TTL, cfg.LangTTL, cfg.TemplateTTL)
+	if c.redis != nil {
+		c.sub = c.redis.Subscribe(context.Background(), c.purgeChannel())
+		go c.watchPurges()
+	}
+	return c, nil
 }
 
-// Get returns cached bytes for the key, if available.
-func (c *Cache) Get(_ context.Context, key string) ([]byte, bool) {
+var tracer = otel.Tracer("github.com/xscopehub/observe-gateway/internal/cache")
+
+// Get returns cached bytes for the key and the tier that served them.
+func (c *Cache) Get(ctx context.Context, key Key) ([]byte, Tier, bool) {
 	if !c.enabled {
-		return nil, false
+		return nil, "", false
+	}
+	ctx, span := tracer.Start(ctx, "cache.get")
+	defer span.End()
+	val, tier, ok := c.get(ctx, key)
+	result := string(tier)
+	if !ok {
+		result = "miss"
 	}
-	if v, ok := c.store.Get(key); ok {
+	span.SetAttributes(attribute.String("cache.result", result))
+	telemetry.CacheLookups.WithLabelValues(result).Inc()
+	return val, tier, ok
+}
+
+// Peek returns cached bytes like Get without recording a cache lookup, for
+// diagnostics that must not skew the hit ratio.
+func (c *Cache) Peek(ctx context.Context, key Key) ([]byte, Tier, bool) {
+	if !c.enabled {
+		return nil, "", false
+	}
+	return c.get(ctx, key)
+}
+
+func (c *Cache) get(ctx context.Context, key Key) ([]byte, Tier, bool) {
+	if v, ok := c.store.Get(c.l1Key(key)); ok {
 		if b, ok := v.([]byte); ok {
-			return b, true
+			return b, TierL1, true
 		}
 	}
-	return nil, false
+	if c.redis == nil {
+		return nil, "", false
+	}
+
+	pipe := c.redis.Pipeline()
+	get := pipe.Get(ctx, c.l2Key(key))
+	ttl := pipe.PTTL(ctx, c.l2Key(key))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, "", false
+	}
+	val, err := decode([]byte(get.Val()))
+	if err != nil {
+		return nil, "", false
+	}
+	// Promote into L1 without outliving the L2 entry.
+	if remaining := ttl.Val(); remaining > 0 {
+		c.store.SetWithTTL(c.l1Key(key), val, int64(len(val)), min(remaining, c.ttlFor(key)))
+	}
+	return val, TierL2, true
 }
 
-// Set stores the payload in cache.
-func (c *Cache) Set(_ context.Context, key string, val []byte, cost int64) {
+// Set stores the payload in both tiers with the key's TTL.
+func (c *Cache) Set(ctx context.Context, key Key, val []byte, cost int64) {
+	c.SetWithTTL(ctx, key, val, cost, c.ttlFor(key))
+}
+
+// SetWithTTL stores the payload with an explicit expiry instead of the
+// configured default.
+func (c *Cache) SetWithTTL(ctx context.Context, key Key, val []byte, cost int64, ttl time.Duration) {
 	if !c.enabled {
 		return
 	}
 	if cost <= 0 {
 		cost = int64(len(val))
 	}
-	c.store.SetWithTTL(key, val, cost, c.ttl)
+	c.store.SetWithTTL(c.l1Key(key), val, cost, ttl)
+	if c.redis == nil {
+		return
+	}
+	data, err := c.encode(val)
+	if err != nil {
+		return
+	}
+	// L2 is best effort; a failed write only costs a later miss.
+	_ = c.redis.Set(ctx, c.l2Key(key), data, ttl).Err()
+}
+
+// Purge removes the entries of a tenant, of a template, or of a template
+// within a tenant. L2 entries are deleted and every replica is told to drop
+// its L1 entries. It returns the number of L2 entries deleted.
+func (c *Cache) Purge(ctx context.Context, tenant, template string) (int64, error) {
+	if tenant == "" && template == "" {
+		return 0, errors.New("purge requires a tenant or a template")
+	}
+	if !c.enabled {
+		return 0, nil
+	}
+	c.invalidate(tenant, template)
+	if c.redis == nil {
+		return 0, nil
+	}
+
+	pattern := c.prefix + namespace(tenant, "*") + ":" + namespace(template, "*") + ":*"
+	var deleted int64
+	iter := c.redis.Scan(ctx, 0, pattern, 500).Iterator()
+	var batch []string
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := c.redis.Del(ctx, batch...).Result()
+		deleted += n
+		batch = batch[:0]
+		return err
+	}
+	for iter.Next(ctx) {
+		batch = append(batch, iter.Val())
+		if len(batch) == 500 {
+			if err := flush(); err != nil {
+				return deleted, err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return deleted, err
+	}
+	if err := flush(); err != nil {
+		return deleted, err
+	}
+	if err := c.redis.Publish(ctx, c.purgeChannel(), tenant+"\n"+template).Err(); err != nil {
+		return deleted, fmt.Errorf("announce purge: %w", err)
+	}
+	return deleted, nil
+}
+
+// Wait blocks until pending writes are visible to Get.
+func (c *Cache) Wait() {
+	if !c.enabled {
+		return
+	}
+	c.store.Wait()
+}
+
+// Close stops listening for purges from other replicas.
+func (c *Cache) Close() {
+	if c.sub != nil {
+		c.sub.Close()
+	}
+}
+
+func (c *Cache) watchPurges() {
+	for msg := range c.sub.Channel() {
+		tenant, template, _ := strings.Cut(msg.Payload, "\n")
+		c.invalidate(tenant, template)
+	}
+}
+
+// invalidate drops L1 entries by bumping the tenant or template generation
+// that is part of every L1 key; ristretto cannot enumerate its keys.
+func (c *Cache) invalidate(tenant, template string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if tenant != "" && template != "" {
+		c.generations["tp\x00"+tenant+"\x00"+template]++
+		return
+	}
+	if tenant != "" {
+		c.generations["t\x00"+tenant]++
+	}
+	if template != "" {
+		c.generations["p\x00"+template]++
+	}
+}
+
+func (c *Cache) l1Key(key Key) string {
+	c.mu.Lock()
+	gen := fmt.Sprintf("%d.%d.%d",
+		c.generations["t\x00"+key.Tenant],
+		c.generations["p\x00"+key.Template],
+		c.generations["tp\x00"+key.Tenant+"\x00"+key.Template])
+	c.mu.Unlock()
+	return c.l2Key(key) + "#" + gen
+}
+
+// l2Key namespaces entries by tenant and template so they can be purged
+// with a key pattern.
+func (c *Cache) l2Key(key Key) string {
+	sum := sha256.Sum256([]byte(key.Lang + "\x00" + key.ID))
+	return c.prefix + namespace(key.Tenant, "") + ":" + namespace(key.Template, "") + ":" + hex.EncodeToString(sum[:16])
+}
+
+func (c *Cache) purgeChannel() string {
+	return c.prefix + "purge"
+}
+
+// SetTTLs replaces the expiry settings for entries stored from now on.
+func (c *Cache) SetTTLs(ttl time.Duration, langTTL, templateTTL map[string]time.Duration) {
+	if c == nil || !c.enabled {
+		return
+	}
+	if ttl <= 0 {
+		ttl = time.Minute
+	}
+	c.ttls.Store(&ttls{ttl: ttl, langTTL: langTTL, templateTTL: templateTTL})
+}
+
+func (c *Cache) ttlFor(key Key) time.Duration {
+	t := c.ttls.Load()
+	if t == nil {
+		return 0
+	}
+	if ttl, ok := t.templateTTL[key.Template]; ok && key.Template != "" && ttl > 0 {
+		return ttl
+	}
+	if ttl, ok := t.langTTL[key.Lang]; ok && ttl > 0 {
+		return ttl
+	}
+	return t.ttl
+}
+
+func (c *Cache) encode(val []byte) ([]byte, error) {
+	if !c.compress || len(val) < compressMinBytes {
+		return append([]byte{encodingRaw}, val...), nil
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(encodingGzip)
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(val); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decode(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("cache: empty value")
+	}
+	switch data[0] {
+	case encodingRaw:
+		return data[1:], nil
+	case encodingGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data[1:]))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	default:
+		return nil, fmt.Errorf("cache: unknown encoding %d", data[0])
+	}
+}
+
+// namespace escapes a key segment so it cannot contain pattern characters
+// or separators; an empty value becomes def.
+func namespace(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return url.QueryEscape(v)
 }
 
 func int64OrDefault(v, def int64) int64 {
@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTieredCache(t *testing.T, mr *miniredis.Miniredis) *Cache {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	c, err := New(Config{
+		Enabled:     true,
+		NumCounters: 1000,
+		MaxCost:     1 << 20,
+		TTL:         time.Minute,
+		LangTTL:     map[string]time.Duration{"promql": 30 * time.Second},
+		TemplateTTL: map[string]time.Duration{"slow": 5 * time.Minute},
+		Redis:       client,
+		Compression: true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+func TestCacheSharesEntriesThroughL2(t *testing.T) {
+	mr := miniredis.RunT(t)
+	writer := newTieredCache(t, mr)
+	reader := newTieredCache(t, mr)
+	ctx := context.Background()
+
+	key := Key{Tenant: "tenant-a", Lang: "logql", ID: "q"}
+	val := bytes.Repeat([]byte(`{"line":"compress me"}`), 100)
+	writer.Set(ctx, key, val, 0)
+
+	raw, err := mr.Get(writer.l2Key(key))
+	if err != nil {
+		t.Fatalf("L2 entry missing: %v", err)
+	}
+	if raw[0] != encodingGzip || len(raw) >= len(val) {
+		t.Fatalf("L2 entry is %d bytes with encoding %d, want a smaller gzip value", len(raw), raw[0])
+	}
+
+	got, tier, ok := reader.Get(ctx, key)
+	if !ok || tier != TierL2 || !bytes.Equal(got, val) {
+		t.Fatalf("Get() = %d bytes, %q, %v, want the value from L2", len(got), tier, ok)
+	}
+	reader.Wait()
+	if _, tier, _ := reader.Get(ctx, key); tier != TierL1 {
+		t.Fatalf("tier = %q after promotion, want l1", tier)
+	}
+}
+
+func TestCacheTTLOverrides(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := newTieredCache(t, mr)
+	ctx := context.Background()
+
+	tests := []struct {
+		key  Key
+		want time.Duration
+	}{
+		{Key{Tenant: "t", Lang: "logql", ID: "a"}, time.Minute},
+		{Key{Tenant: "t", Lang: "promql", ID: "b"}, 30 * time.Second},
+		{Key{Tenant: "t", Lang: "promql", Template: "slow", ID: "c"}, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		c.Set(ctx, tt.key, []byte("v"), 0)
+		if got := mr.TTL(c.l2Key(tt.key)); got != tt.want {
+			t.Fatalf("TTL(%+v) = %s, want %s", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestCachePurge(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := newTieredCache(t, mr)
+	other := newTieredCache(t, mr)
+	ctx := context.Background()
+
+	keys := []Key{
+		{Tenant: "tenant-a", Lang: "logql", ID: "1"},
+		{Tenant: "tenant-a", Lang: "promql", Template: "errors", ID: "2"},
+		{Tenant: "tenant-b", Lang: "promql", Template: "errors", ID: "3"},
+		{Tenant: "tenant-b", Lang: "logql", ID: "4"},
+	}
+	for _, k := range keys {
+		c.Set(ctx, k, []byte("v"), 0)
+		other.Set(ctx, k, []byte("v"), 0)
+	}
+	c.Wait()
+	other.Wait()
+
+	deleted, err := c.Purge(ctx, "tenant-a", "")
+	if err != nil || deleted != 2 {
+		t.Fatalf("Purge(tenant-a) = %d, %v, want 2", deleted, err)
+	}
+	deleted, err = c.Purge(ctx, "", "errors")
+	if err != nil || deleted != 1 {
+		t.Fatalf("Purge(template) = %d, %v, want 1", deleted, err)
+	}
+	if _, _, ok := c.Get(ctx, keys[0]); ok {
+		t.Fatalf("purged entry still served from L1")
+	}
+
+	// The other replica drops its L1 entries once it sees the purge.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		_, _, hitA := other.Get(ctx, keys[1])
+		_, _, hitB := other.Get(ctx, keys[2])
+		if !hitA && !hitB {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("replica still serves purged entries: %v %v", hitA, hitB)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, _, ok := other.Get(ctx, keys[3]); !ok {
+		t.Fatalf("unrelated entry was purged")
+	}
+	for _, k := range mr.Keys() {
+		if strings.Contains(k, "tenant-a") {
+			t.Fatalf("L2 still holds %q", k)
+		}
+	}
+
+	if _, err := c.Purge(ctx, "", ""); err == nil {
+		t.Fatalf("Purge() without scope succeeded")
+	}
+}
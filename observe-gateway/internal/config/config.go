@@ -3,7 +3,6 @@ package config
 import (
 	"fmt"
 	"os"
-	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -18,6 +17,11 @@ type Config struct {
 	Audit          AuditConfig                    `yaml:"audit"`
 	Backends       BackendConfig                  `yaml:"backends"`
 	QueryTemplates map[string]QueryTemplateConfig `yaml:"query_templates"`
+	TemplateStore  TemplateStoreConfig            `yaml:"template_store"`
+	Discovery      DiscoveryConfig                `yaml:"discovery"`
+	Sharding       ShardingConfig                 `yaml:"sharding"`
+	Batch          BatchConfig                    `yaml:"batch"`
+	Telemetry      TelemetryConfig                `yaml:"telemetry"`
 }
 
 // ServerConfig controls HTTP server settings.
@@ -28,6 +32,14 @@ type ServerConfig struct {
 	IdleTimeout  time.Duration `yaml:"idle_timeout"`
 	TenantHeader string        `yaml:"tenant_header"`
 	UserHeader   string        `yaml:"user_header"`
+	// MaxPageSize caps the limit of paginated and streamed searches.
+	MaxPageSize int `yaml:"max_page_size"`
+	// AdminToken authorises /admin endpoints as a bearer token; they are
+	// disabled when empty.
+	AdminToken string `yaml:"admin_token"`
+	// ConfigWatchInterval is how often the config file is checked for
+	// changes; zero reloads only on SIGHUP.
+	ConfigWatchInterval time.Duration `yaml:"config_watch_interval"`
 }
 
 // AuthConfig configures JWT based authentication.
@@ -40,21 +52,54 @@ type AuthConfig struct {
 	UserClaim   string        `yaml:"user_claim"`
 	CacheTTL    time.Duration `yaml:"cache_ttl"`
 	InsecureTLs bool          `yaml:"insecure_tls"`
+	// APIKeys enables API key authentication for service accounts next to
+	// JWT.
+	APIKeys APIKeyConfig `yaml:"api_keys"`
+}
+
+// APIKeyConfig configures API keys stored in the metadata database.
+type APIKeyConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Table   string `yaml:"table"`
+	// CacheTTL is how long a verified key is cached, which bounds how long a
+	// revocation takes to reach other replicas.
+	CacheTTL time.Duration `yaml:"cache_ttl"`
 }
 
 // RateLimiterConfig defines per-tenant rate limiting behaviour.
+// RequestsPerSecond and Burst are the default tenant limit; the remaining
+// rules are layered on top of it.
 type RateLimiterConfig struct {
-	Enabled            bool          `yaml:"enabled"`
-	RequestsPerSecond  float64       `yaml:"requests_per_second"`
-	Burst              int           `yaml:"burst"`
-	Window             time.Duration `yaml:"window"`
-	RedisAddr          string        `yaml:"redis_addr"`
-	RedisUsername      string        `yaml:"redis_username"`
-	RedisPassword      string        `yaml:"redis_password"`
-	RedisDB            int           `yaml:"redis_db"`
-	RedisTLSInsecure   bool          `yaml:"redis_tls_insecure"`
-	RedisTLSCA         string        `yaml:"redis_tls_ca"`
-	RedisTLSSkipVerify bool          `yaml:"redis_tls_skip_verify"`
+	Enabled            bool                     `yaml:"enabled"`
+	RequestsPerSecond  float64                  `yaml:"requests_per_second"`
+	Burst              int                      `yaml:"burst"`
+	Tenants            map[string]RateLimitRule `yaml:"tenants"`
+	PerUser            RateLimitRule            `yaml:"per_user"`
+	Users              map[string]RateLimitRule `yaml:"users"`
+	Templates          map[string]RateLimitRule `yaml:"templates"`
+	Langs              map[string]RateLimitRule `yaml:"langs"`
+	RedisAddr          string                   `yaml:"redis_addr"`
+	RedisUsername      string                   `yaml:"redis_username"`
+	RedisPassword      string                   `yaml:"redis_password"`
+	RedisDB            int                      `yaml:"redis_db"`
+	RedisTLSInsecure   bool                     `yaml:"redis_tls_insecure"`
+	RedisTLSCA         string                   `yaml:"redis_tls_ca"`
+	RedisTLSSkipVerify bool                     `yaml:"redis_tls_skip_verify"`
+	CostBudget         CostBudgetConfig         `yaml:"cost_budget"`
+}
+
+// RateLimitRule is a token bucket; a zero rate disables the rule.
+type RateLimitRule struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+}
+
+// CostBudgetConfig defines a rolling per-tenant budget of backend query
+// cost, as reported by OpenObserve cost headers (e.g. scanned bytes).
+type CostBudgetConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Limit   int64         `yaml:"limit"`
+	Window  time.Duration `yaml:"window"`
 }
 
 // CacheConfig configures ristretto caching behaviour.
@@ -64,18 +109,97 @@ type CacheConfig struct {
 	MaxCost     int64         `yaml:"max_cost"`
 	BufferItems int64         `yaml:"buffer_items"`
 	TTL         time.Duration `yaml:"ttl"`
+	// SplitInterval splits range queries into extents cached independently;
+	// zero disables the results cache.
+	SplitInterval time.Duration `yaml:"split_interval"`
+	MaxFreshness  time.Duration `yaml:"max_freshness"`
+	ExtentTTL     time.Duration `yaml:"extent_ttl"`
+	// LangTTL and TemplateTTL override TTL per query language and template.
+	LangTTL     map[string]time.Duration `yaml:"lang_ttl"`
+	TemplateTTL map[string]time.Duration `yaml:"template_ttl"`
+	L2          CacheL2Config            `yaml:"l2"`
+}
+
+// CacheL2Config configures the Redis cache tier shared by gateway replicas.
+// It uses the Redis connection settings of the rate limiter.
+type CacheL2Config struct {
+	Enabled     bool   `yaml:"enabled"`
+	KeyPrefix   string `yaml:"key_prefix"`
+	Compression bool   `yaml:"compression"`
 }
 
-// AuditConfig configures request auditing.
+// AuditConfig configures request auditing. Entries are queued and written to
+// the enabled sinks in batches; when the queue is full, requests wait up to
+// BlockTimeout before the entry is dropped.
 type AuditConfig struct {
-	Enabled bool `yaml:"enabled"`
+	Enabled       bool                `yaml:"enabled"`
+	Stdout        bool                `yaml:"stdout"`
+	QueueSize     int                 `yaml:"queue_size"`
+	BatchSize     int                 `yaml:"batch_size"`
+	FlushInterval time.Duration       `yaml:"flush_interval"`
+	BlockTimeout  time.Duration       `yaml:"block_timeout"`
+	File          AuditFileConfig     `yaml:"file"`
+	Postgres      AuditPostgresConfig `yaml:"postgres"`
+	OTLP          AuditOTLPConfig     `yaml:"otlp"`
+}
+
+// AuditFileConfig configures the rotating audit file.
+type AuditFileConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	Path       string `yaml:"path"`
+	MaxSizeMB  int    `yaml:"max_size_mb"`
+	MaxBackups int    `yaml:"max_backups"`
+}
+
+// AuditPostgresConfig stores audit entries in the metadata database, which
+// also backs GET /api/audit.
+type AuditPostgresConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Table   string `yaml:"table"`
+}
+
+// AuditOTLPConfig exports audit entries as OTLP/HTTP logs.
+type AuditOTLPConfig struct {
+	Enabled  bool              `yaml:"enabled"`
+	Endpoint string            `yaml:"endpoint"`
+	Headers  map[string]string `yaml:"headers"`
+	Timeout  time.Duration     `yaml:"timeout"`
 }
 
 // BackendConfig bundles configuration for upstream services.
 type BackendConfig struct {
-	OpenObserve OpenObserveConfig `yaml:"openobserve"`
-	Fallback    FallbackConfig    `yaml:"fallback"`
-	Metadata    MetadataConfig    `yaml:"metadata"`
+	OpenObserve    OpenObserveConfig    `yaml:"openobserve"`
+	Fallback       FallbackConfig       `yaml:"fallback"`
+	Tempo          TempoConfig          `yaml:"tempo"`
+	Metadata       MetadataConfig       `yaml:"metadata"`
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+	// LabelEnforcement injects tenant label matchers into PromQL queries.
+	LabelEnforcement LabelEnforcementConfig `yaml:"label_enforcement"`
+}
+
+// LabelEnforcementConfig configures the label matchers every PromQL vector
+// selector is rewritten to carry.
+type LabelEnforcementConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// TenantLabel is matched against the tenant ID; empty enforces only the
+	// per-tenant matchers.
+	TenantLabel string `yaml:"tenant_label"`
+	// Tenants holds additional matchers per tenant as a PromQL selector,
+	// e.g. {cluster=~"eu-.*"}.
+	Tenants map[string]string `yaml:"tenants"`
+}
+
+// CircuitBreakerConfig tunes the per-backend circuit breakers guarding
+// OpenObserve and the PromQL fallback.
+type CircuitBreakerConfig struct {
+	Enabled            bool          `yaml:"enabled"`
+	Window             time.Duration `yaml:"window"`
+	MinRequests        int           `yaml:"min_requests"`
+	ErrorRateThreshold float64       `yaml:"error_rate_threshold"`
+	OpenDuration       time.Duration `yaml:"open_duration"`
+	HalfOpenRequests   int           `yaml:"half_open_requests"`
+	ProbeInterval      time.Duration `yaml:"probe_interval"`
+	ProbeTimeout       time.Duration `yaml:"probe_timeout"`
 }
 
 // OpenObserveConfig defines endpoints for OpenObserve services.
@@ -90,16 +214,49 @@ type OpenObserveConfig struct {
 	TraceSearchEndpoint string        `yaml:"trace_search_endpoint"`
 	LogTable            string        `yaml:"log_table"`
 	TraceTable          string        `yaml:"trace_table"`
+	HealthEndpoint      string        `yaml:"health_endpoint"`
+
+	// PromSeriesEndpoint, PromLabelsEndpoint and PromLabelValuesEndpoint
+	// serve the Prometheus metadata API; {name} in PromLabelValuesEndpoint
+	// is replaced by the label name.
+	PromSeriesEndpoint      string `yaml:"prom_series_endpoint"`
+	PromLabelsEndpoint      string `yaml:"prom_labels_endpoint"`
+	PromLabelValuesEndpoint string `yaml:"prom_label_values_endpoint"`
+	// TraceLookback is how far back a trace-by-ID lookup searches when the
+	// request has no time range.
+	TraceLookback time.Duration `yaml:"trace_lookback"`
+	// StreamSchemaEndpoint lists the fields of a stream; {stream} is
+	// replaced by the stream name.
+	StreamSchemaEndpoint string `yaml:"stream_schema_endpoint"`
 }
 
 // FallbackConfig defines configuration for VM/Mimir PromQL fallback.
 type FallbackConfig struct {
-	Enabled       bool          `yaml:"enabled"`
-	BaseURL       string        `yaml:"base_url"`
-	APIKey        string        `yaml:"api_key"`
-	Timeout       time.Duration `yaml:"timeout"`
-	QueryEndpoint string        `yaml:"query_endpoint"`
-	RangeEndpoint string        `yaml:"range_endpoint"`
+	Enabled        bool          `yaml:"enabled"`
+	BaseURL        string        `yaml:"base_url"`
+	APIKey         string        `yaml:"api_key"`
+	Timeout        time.Duration `yaml:"timeout"`
+	QueryEndpoint  string        `yaml:"query_endpoint"`
+	RangeEndpoint  string        `yaml:"range_endpoint"`
+	HealthEndpoint string        `yaml:"health_endpoint"`
+	// SeriesEndpoint, LabelsEndpoint and LabelValuesEndpoint default to the
+	// standard Prometheus API paths.
+	SeriesEndpoint      string `yaml:"series_endpoint"`
+	LabelsEndpoint      string `yaml:"labels_endpoint"`
+	LabelValuesEndpoint string `yaml:"label_values_endpoint"`
+}
+
+// TempoConfig defines the Tempo backend trace-by-ID lookups fall back to
+// when OpenObserve does not have the trace.
+type TempoConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	BaseURL string        `yaml:"base_url"`
+	APIKey  string        `yaml:"api_key"`
+	Timeout time.Duration `yaml:"timeout"`
+	// TraceEndpoint is the trace-by-ID path; {id} is replaced by the trace
+	// ID.
+	TraceEndpoint  string `yaml:"trace_endpoint"`
+	HealthEndpoint string `yaml:"health_endpoint"`
 }
 
 // MetadataConfig describes PostgreSQL metadata lookup configuration.
@@ -109,13 +266,114 @@ type MetadataConfig struct {
 	MaxConnections    int32         `yaml:"max_connections"`
 	MaxConnIdleTime   time.Duration `yaml:"max_conn_idle_time"`
 	TenantLookupQuery string        `yaml:"tenant_lookup_query"`
+	// BudgetLookupQuery returns a tenant's cost budget override; empty
+	// disables per-tenant budgets.
+	BudgetLookupQuery string `yaml:"budget_lookup_query"`
+	// RateLimitLookupQuery returns a tenant's requests_per_second and burst
+	// override; empty disables per-tenant overrides from the database.
+	RateLimitLookupQuery string `yaml:"rate_limit_lookup_query"`
+}
+
+// DiscoveryConfig tunes the /api/metadata endpoints listing the services,
+// labels and label values of a tenant.
+type DiscoveryConfig struct {
+	// TTL is how long discovery results are cached.
+	TTL time.Duration `yaml:"ttl"`
+	// Lookback is the time range searched when a request has none.
+	Lookback time.Duration `yaml:"lookback"`
+	// ServiceLabel is the metric and log label naming the service.
+	ServiceLabel string `yaml:"service_label"`
+}
+
+// ShardingConfig splits long range queries into time shards that run in
+// parallel: PromQL range queries, LogQL metric queries and log searches.
+type ShardingConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Interval is the time range of one shard; queries spanning at most
+	// one interval run unsharded.
+	Interval time.Duration `yaml:"interval"`
+	// MaxShards widens the shards of queries that would need more.
+	MaxShards int `yaml:"max_shards"`
+	// TenantConcurrency caps the shards of one tenant running at once,
+	// across all of its queries.
+	TenantConcurrency int `yaml:"tenant_concurrency"`
+}
+
+// Batch rate limit modes.
+const (
+	// BatchRateLimitRequest counts a batch as one request against the
+	// tenant and user rate limits.
+	BatchRateLimitRequest = "request"
+	// BatchRateLimitQuery counts each query of a batch as a request.
+	BatchRateLimitQuery = "query"
+)
+
+// BatchConfig controls POST /api/query/batch, which runs several named
+// queries of one tenant in parallel.
+type BatchConfig struct {
+	// MaxQueries caps the queries of one batch.
+	MaxQueries int `yaml:"max_queries"`
+	// RateLimit is BatchRateLimitRequest or BatchRateLimitQuery. Template
+	// and language limits always count each query.
+	RateLimit string `yaml:"rate_limit"`
+}
+
+// TelemetryConfig configures the gateway's own metrics and traces.
+type TelemetryConfig struct {
+	Metrics MetricsConfig `yaml:"metrics"`
+	Tracing TracingConfig `yaml:"tracing"`
+}
+
+// MetricsConfig serves Prometheus metrics on the main listener.
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"`
+}
+
+// TracingConfig exports OpenTelemetry spans over OTLP/HTTP.
+type TracingConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Endpoint is the OTLP/HTTP traces URL, such as
+	// http://otel-collector:4318/v1/traces.
+	Endpoint    string            `yaml:"endpoint"`
+	Headers     map[string]string `yaml:"headers"`
+	Timeout     time.Duration     `yaml:"timeout"`
+	ServiceName string            `yaml:"service_name"`
+	// SampleRatio is the fraction of new traces recorded; requests carrying
+	// a sampled trace context are always recorded.
+	SampleRatio float64 `yaml:"sample_ratio"`
 }
 
 // QueryTemplateConfig defines a reusable query template resolved by name.
+// Templates from the configuration file are global: every tenant may use
+// them unless it stores a template of the same name.
 type QueryTemplateConfig struct {
-	Lang  string `yaml:"lang"`
-	Query string `yaml:"query"`
-	Step  string `yaml:"step"`
+	Lang        string `yaml:"lang"`
+	Query       string `yaml:"query"`
+	Step        string `yaml:"step"`
+	Description string `yaml:"description,omitempty"`
+	// Variables types the {{name}} placeholders. Undeclared placeholders are
+	// label values inside string literals and durations elsewhere.
+	Variables []TemplateVariableConfig `yaml:"variables,omitempty"`
+}
+
+// TemplateVariableConfig declares a typed template variable: label_value,
+// regex, duration or enum.
+type TemplateVariableConfig struct {
+	Name        string   `yaml:"name"`
+	Type        string   `yaml:"type"`
+	Values      []string `yaml:"values,omitempty"`
+	Default     string   `yaml:"default,omitempty"`
+	Description string   `yaml:"description,omitempty"`
+}
+
+// TemplateStoreConfig configures per-tenant templates stored in the
+// metadata database.
+type TemplateStoreConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Table   string `yaml:"table"`
+	// CacheTTL bounds how long other replicas serve a replaced template.
+	CacheTTL time.Duration `yaml:"cache_ttl"`
 }
 
 // Load reads configuration from the supplied path or returns defaults.
@@ -138,6 +396,9 @@ func Load(path string) (Config, error) {
 	if err := yaml.Unmarshal([]byte(expanded), &cfg); err != nil {
 		return Config{}, fmt.Errorf("unmarshal config: %w", err)
 	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid config: %w", err)
+	}
 
 	return cfg, nil
 }
@@ -151,27 +412,59 @@ func defaultConfig() Config {
 			IdleTimeout:  60 * time.Second,
 			TenantHeader: "X-Tenant",
 			UserHeader:   "X-User",
+			MaxPageSize:  5000,
+
+			ConfigWatchInterval: 10 * time.Second,
 		},
 		Auth: AuthConfig{
 			Enabled:     false,
 			TenantClaim: "tenant",
 			UserClaim:   "sub",
 			CacheTTL:    time.Hour,
+			APIKeys: APIKeyConfig{
+				Table:    "api_keys",
+				CacheTTL: 30 * time.Second,
+			},
 		},
 		RateLimiter: RateLimiterConfig{
 			Enabled:           false,
 			RequestsPerSecond: 10,
 			Burst:             20,
-			Window:            time.Minute,
+			CostBudget: CostBudgetConfig{
+				Enabled: false,
+				Window:  time.Hour,
+			},
 		},
 		Cache: CacheConfig{
-			Enabled:     false,
-			NumCounters: 1e4,
-			MaxCost:     1 << 28,
-			BufferItems: 64,
-			TTL:         time.Minute,
+			Enabled:       false,
+			NumCounters:   1e4,
+			MaxCost:       1 << 28,
+			BufferItems:   64,
+			TTL:           time.Minute,
+			SplitInterval: time.Hour,
+			MaxFreshness:  10 * time.Minute,
+			ExtentTTL:     time.Hour,
+			L2: CacheL2Config{
+				Enabled:     false,
+				KeyPrefix:   "obsgw:cache:",
+				Compression: true,
+			},
+		},
+		Audit: AuditConfig{
+			Enabled:       true,
+			Stdout:        true,
+			QueueSize:     10000,
+			BatchSize:     500,
+			FlushInterval: time.Second,
+			BlockTimeout:  50 * time.Millisecond,
+			File: AuditFileConfig{
+				Path:       "/var/log/observe-gateway/audit.log",
+				MaxSizeMB:  100,
+				MaxBackups: 5,
+			},
+			Postgres: AuditPostgresConfig{Table: "gateway_audit"},
+			OTLP:     AuditOTLPConfig{Timeout: 5 * time.Second},
 		},
-		Audit: AuditConfig{Enabled: true},
 		Backends: BackendConfig{
 			OpenObserve: OpenObserveConfig{
 				BaseURL:             "http://localhost:5080",
@@ -183,55 +476,97 @@ func defaultConfig() Config {
 				TraceSearchEndpoint: "/api/%s/traces",
 				LogTable:            "logs",
 				TraceTable:          "traces",
+				HealthEndpoint:      "/healthz",
+
+				PromSeriesEndpoint:      "/api/%s/promql/series",
+				PromLabelsEndpoint:      "/api/%s/promql/labels",
+				PromLabelValuesEndpoint: "/api/%s/promql/label/{name}/values",
+				TraceLookback:           24 * time.Hour,
+				StreamSchemaEndpoint:    "/api/%s/streams/{stream}/schema",
+			},
+			Fallback: FallbackConfig{
+				HealthEndpoint: "/-/healthy",
+			},
+			Tempo: TempoConfig{
+				Timeout:        30 * time.Second,
+				TraceEndpoint:  "/api/traces/{id}",
+				HealthEndpoint: "/ready",
+			},
+			CircuitBreaker: CircuitBreakerConfig{
+				Enabled:            false,
+				Window:             30 * time.Second,
+				MinRequests:        10,
+				ErrorRateThreshold: 0.5,
+				OpenDuration:       30 * time.Second,
+				HalfOpenRequests:   1,
+				ProbeInterval:      10 * time.Second,
+				ProbeTimeout:       2 * time.Second,
+			},
+			LabelEnforcement: LabelEnforcementConfig{
+				TenantLabel: "tenant",
 			},
 		},
 		QueryTemplates: map[string]QueryTemplateConfig{
 			"service_error_rate": {
-				Lang:  "promql",
-				Query: `sum(rate(http_requests_total{service="{{service}}",status=~"5.."}[{{window}}])) / clamp_min(sum(rate(http_requests_total{service="{{service}}"}[{{window}}])), 1)`,
-				Step:  "5m",
+				Lang:      "promql",
+				Query:     `sum(rate(http_requests_total{service="{{service}}",status=~"5.."}[{{window}}])) / clamp_min(sum(rate(http_requests_total{service="{{service}}"}[{{window}}])), 1)`,
+				Step:      "5m",
+				Variables: serviceWindowVariables,
 			},
 			"service_latency_p95": {
-				Lang:  "promql",
-				Query: `histogram_quantile(0.95, sum(rate(http_request_duration_seconds_bucket{service="{{service}}"}[{{window}}])) by (le))`,
-				Step:  "5m",
+				Lang:      "promql",
+				Query:     `histogram_quantile(0.95, sum(rate(http_request_duration_seconds_bucket{service="{{service}}"}[{{window}}])) by (le))`,
+				Step:      "5m",
+				Variables: serviceWindowVariables,
 			},
 			"service_error_logs": {
-				Lang:  "logql",
-				Query: `{service="{{service}}"} |= "error"`,
+				Lang:      "logql",
+				Query:     `{service="{{service}}"} |= "error"`,
+				Variables: serviceWindowVariables[:1],
 			},
 			"service_error_traces": {
-				Lang:  "traceql",
-				Query: `FROM {{service}} WHERE error=true`,
+				Lang:      "traceql",
+				Query:     `{ resource.service.name = "{{service}}" && status = error }`,
+				Variables: serviceWindowVariables[:1],
 			},
 			"service_topology_logs": {
-				Lang:  "logql",
-				Query: `{service="{{service}}"}`,
+				Lang:      "logql",
+				Query:     `{service="{{service}}"}`,
+				Variables: serviceWindowVariables[:1],
+			},
+		},
+		TemplateStore: TemplateStoreConfig{
+			Table:    "query_templates",
+			CacheTTL: 30 * time.Second,
+		},
+		Discovery: DiscoveryConfig{
+			TTL:          30 * time.Second,
+			Lookback:     6 * time.Hour,
+			ServiceLabel: "service",
+		},
+		Sharding: ShardingConfig{
+			Enabled:           false,
+			Interval:          24 * time.Hour,
+			MaxShards:         32,
+			TenantConcurrency: 4,
+		},
+		Batch: BatchConfig{
+			MaxQueries: 10,
+			RateLimit:  BatchRateLimitRequest,
+		},
+		Telemetry: TelemetryConfig{
+			Metrics: MetricsConfig{Enabled: true, Path: "/metrics"},
+			Tracing: TracingConfig{
+				Timeout:     10 * time.Second,
+				ServiceName: "observe-gateway",
+				SampleRatio: 1,
 			},
 		},
 	}
 }
 
-// ResolveQueryTemplate renders a named query template using the provided variables.
-func (c Config) ResolveQueryTemplate(name string, variables map[string]string) (QueryTemplateConfig, bool) {
-	template, ok := c.QueryTemplates[name]
-	if !ok {
-		return QueryTemplateConfig{}, false
-	}
-	if len(variables) == 0 {
-		return template, true
-	}
-	rendered := template
-	rendered.Query = applyTemplateVariables(rendered.Query, variables)
-	rendered.Step = applyTemplateVariables(rendered.Step, variables)
-	rendered.Lang = strings.TrimSpace(rendered.Lang)
-	return rendered, true
-}
-
-func applyTemplateVariables(input string, variables map[string]string) string {
-	out := input
-	for key, value := range variables {
-		out = strings.ReplaceAll(out, "{{"+strings.TrimSpace(key)+"}}", value)
-	}
-	return out
+// serviceWindowVariables are the variables of the built-in templates.
+var serviceWindowVariables = []TemplateVariableConfig{
+	{Name: "service", Type: "label_value"},
+	{Name: "window", Type: "duration", Default: "5m"},
 }
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces secrets in Redacted output.
+const redacted = "REDACTED"
+
+// Validate reports configuration errors that would otherwise only surface
+// when a request uses the affected setting.
+func (c Config) Validate() error {
+	var errs []error
+	for name, tmpl := range c.QueryTemplates {
+		switch strings.ToLower(strings.TrimSpace(tmpl.Lang)) {
+		case "promql", "logql", "traceql":
+		default:
+			errs = append(errs, fmt.Errorf("query_templates.%s: unsupported lang %q", name, tmpl.Lang))
+		}
+		if strings.TrimSpace(tmpl.Query) == "" {
+			errs = append(errs, fmt.Errorf("query_templates.%s: query is required", name))
+		}
+		if tmpl.Step != "" && !strings.Contains(tmpl.Step, "{{") {
+			if _, err := time.ParseDuration(tmpl.Step); err != nil {
+				errs = append(errs, fmt.Errorf("query_templates.%s: invalid step %q", name, tmpl.Step))
+			}
+		}
+	}
+
+	rules := map[string]RateLimitRule{
+		"rate_limiter":          {RequestsPerSecond: c.RateLimiter.RequestsPerSecond, Burst: c.RateLimiter.Burst},
+		"rate_limiter.per_user": c.RateLimiter.PerUser,
+	}
+	for prefix, set := range map[string]map[string]RateLimitRule{
+		"rate_limiter.tenants":   c.RateLimiter.Tenants,
+		"rate_limiter.users":     c.RateLimiter.Users,
+		"rate_limiter.templates": c.RateLimiter.Templates,
+		"rate_limiter.langs":     c.RateLimiter.Langs,
+	} {
+		for name, rule := range set {
+			rules[prefix+"."+name] = rule
+		}
+	}
+	for name, rule := range rules {
+		if rule.RequestsPerSecond < 0 || rule.Burst < 0 {
+			errs = append(errs, fmt.Errorf("%s: requests_per_second and burst must not be negative", name))
+		}
+	}
+
+	if c.Cache.TTL < 0 || c.Cache.SplitInterval < 0 || c.Cache.MaxFreshness < 0 || c.Cache.ExtentTTL < 0 {
+		errs = append(errs, errors.New("cache: durations must not be negative"))
+	}
+	if c.Sharding.Enabled && c.Sharding.Interval <= 0 {
+		errs = append(errs, errors.New("sharding.interval must be positive when sharding is enabled"))
+	}
+	if c.Sharding.MaxShards < 0 || c.Sharding.TenantConcurrency < 0 {
+		errs = append(errs, errors.New("sharding: max_shards and tenant_concurrency must not be negative"))
+	}
+	if c.Batch.MaxQueries < 0 {
+		errs = append(errs, errors.New("batch.max_queries must not be negative"))
+	}
+	switch c.Batch.RateLimit {
+	case "", BatchRateLimitRequest, BatchRateLimitQuery:
+	default:
+		errs = append(errs, fmt.Errorf("batch.rate_limit: unsupported mode %q", c.Batch.RateLimit))
+	}
+	if c.Server.MaxPageSize < 0 {
+		errs = append(errs, errors.New("server.max_page_size must not be negative"))
+	}
+
+	if err := validateURL(c.Backends.OpenObserve.BaseURL); err != nil {
+		errs = append(errs, fmt.Errorf("backends.openobserve.base_url: %w", err))
+	}
+	if c.Backends.Fallback.Enabled {
+		if c.Backends.Fallback.BaseURL == "" {
+			errs = append(errs, errors.New("backends.fallback.base_url is required when the fallback is enabled"))
+		} else if err := validateURL(c.Backends.Fallback.BaseURL); err != nil {
+			errs = append(errs, fmt.Errorf("backends.fallback.base_url: %w", err))
+		}
+	}
+	if c.Backends.Tempo.Enabled {
+		if c.Backends.Tempo.BaseURL == "" {
+			errs = append(errs, errors.New("backends.tempo.base_url is required when tempo is enabled"))
+		} else if err := validateURL(c.Backends.Tempo.BaseURL); err != nil {
+			errs = append(errs, fmt.Errorf("backends.tempo.base_url: %w", err))
+		}
+	}
+	if c.Telemetry.Tracing.Enabled {
+		if c.Telemetry.Tracing.Endpoint == "" {
+			errs = append(errs, errors.New("telemetry.tracing.endpoint is required when tracing is enabled"))
+		} else if err := validateURL(c.Telemetry.Tracing.Endpoint); err != nil {
+			errs = append(errs, fmt.Errorf("telemetry.tracing.endpoint: %w", err))
+		}
+	}
+	if r := c.Telemetry.Tracing.SampleRatio; r < 0 || r > 1 {
+		errs = append(errs, errors.New("telemetry.tracing.sample_ratio must be between 0 and 1"))
+	}
+	return errors.Join(errs...)
+}
+
+// validateURL accepts an empty URL, which the backend reports on use, or an
+// absolute http(s) URL.
+func validateURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an absolute http(s) URL", raw)
+	}
+	return nil
+}
+
+// Hash fingerprints the effective configuration, after environment
+// expansion and defaults.
+func (c Config) Hash() string {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Redacted returns a copy with credentials replaced, safe to expose on
+// admin endpoints.
+func (c Config) Redacted() Config {
+	mask := func(s *string) {
+		if *s != "" {
+			*s = redacted
+		}
+	}
+	mask(&c.Server.AdminToken)
+	mask(&c.RateLimiter.RedisPassword)
+	mask(&c.Backends.OpenObserve.APIKey)
+	mask(&c.Backends.Fallback.APIKey)
+	mask(&c.Backends.Tempo.APIKey)
+	c.Backends.Metadata.DSN = redactDSN(c.Backends.Metadata.DSN)
+	c.Audit.OTLP.Headers = redactHeaders(c.Audit.OTLP.Headers)
+	c.Telemetry.Tracing.Headers = redactHeaders(c.Telemetry.Tracing.Headers)
+	return c
+}
+
+// redactHeaders masks header values, which often carry credentials.
+func redactHeaders(headers map[string]string) map[string]string {
+	if len(headers) == 0 {
+		return headers
+	}
+	out := make(map[string]string, len(headers))
+	for k := range headers {
+		out[k] = redacted
+	}
+	return out
+}
+
+// redactDSN masks the password of a URL-style DSN, or the whole DSN when it
+// cannot be parsed as a URL.
+func redactDSN(dsn string) string {
+	if dsn == "" {
+		return ""
+	}
+	u, err := url.Parse(dsn)
+	if err != nil || u.Scheme == "" {
+		return redacted
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	return u.String()
+}
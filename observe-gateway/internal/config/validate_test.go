@@ -0,0 +1,63 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	if err := defaultConfig().Validate(); err != nil {
+		t.Fatalf("defaultConfig().Validate() error = %v", err)
+	}
+
+	cfg := defaultConfig()
+	cfg.QueryTemplates = map[string]QueryTemplateConfig{
+		"bad_lang":  {Lang: "sql", Query: "select 1"},
+		"bad_step":  {Lang: "promql", Query: "up", Step: "five minutes"},
+		"templated": {Lang: "promql", Query: "up", Step: "{{step}}"},
+	}
+	cfg.RateLimiter.PerUser.Burst = -1
+	cfg.Backends.Fallback.Enabled = true
+	cfg.Backends.OpenObserve.BaseURL = "openobserve:5080"
+	cfg.Sharding.Enabled, cfg.Sharding.Interval = true, 0
+	cfg.Batch.RateLimit = "weighted"
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate() error = nil, want errors")
+	}
+	for _, want := range []string{"bad_lang", "bad_step", "per_user", "fallback.base_url", "openobserve.base_url", "sharding.interval", "batch.rate_limit"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("Validate() error = %v, want mention of %s", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "templated") {
+		t.Fatalf("Validate() error = %v, templated step should be accepted", err)
+	}
+}
+
+func TestRedactedAndHash(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Server.AdminToken = "admin-secret"
+	cfg.Backends.OpenObserve.APIKey = "oo-secret"
+	cfg.Backends.Metadata.DSN = "postgres://gateway:pg-secret@db:5432/meta"
+	cfg.Audit.OTLP.Headers = map[string]string{"Authorization": "Bearer otlp-secret"}
+
+	redacted := cfg.Redacted()
+	if redacted.Server.AdminToken != "REDACTED" || redacted.Backends.OpenObserve.APIKey != "REDACTED" {
+		t.Fatalf("redacted secrets = %q, %q", redacted.Server.AdminToken, redacted.Backends.OpenObserve.APIKey)
+	}
+	if redacted.Backends.Fallback.APIKey != "" {
+		t.Fatalf("empty fallback api key = %q, want empty", redacted.Backends.Fallback.APIKey)
+	}
+	if dsn := redacted.Backends.Metadata.DSN; strings.Contains(dsn, "pg-secret") || !strings.Contains(dsn, "gateway") {
+		t.Fatalf("redacted dsn = %q", dsn)
+	}
+	if cfg.Audit.OTLP.Headers["Authorization"] != "Bearer otlp-secret" {
+		t.Fatal("Redacted() modified the original headers")
+	}
+
+	if cfg.Hash() != cfg.Hash() || cfg.Hash() == redacted.Hash() {
+		t.Fatal("Hash() should be stable and change with the configuration")
+	}
+}
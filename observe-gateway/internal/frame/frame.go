@@ -0,0 +1,299 @@
+// Package frame converts backend payloads into the gateway's normalized
+// result model so clients can read metrics, logs and traces without knowing
+// which upstream answered.
+package frame
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/promapi"
+)
+
+// Frame kinds.
+const (
+	KindMetrics = "metrics"
+	KindLogs    = "logs"
+	KindTraces  = "traces"
+)
+
+// Frame is the normalized result of a query. Exactly one of Series, Rows and
+// Spans is populated according to Kind.
+type Frame struct {
+	Kind     string   `json:"kind"`
+	Series   []Series `json:"series,omitempty"`
+	Rows     []Row    `json:"rows,omitempty"`
+	Spans    []Span   `json:"spans,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Series is a labelled metric time series.
+type Series struct {
+	Labels  map[string]string `json:"labels"`
+	Samples []Sample          `json:"samples"`
+}
+
+// Sample is a single metric point. Non-finite values are encoded as the
+// strings "NaN", "+Inf" and "-Inf".
+type Sample struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// MarshalJSON encodes the sample, keeping non-finite values representable.
+func (s Sample) MarshalJSON() ([]byte, error) {
+	var value any = s.Value
+	if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+		value = promapi.FormatValue(s.Value)
+	}
+	return json.Marshal(struct {
+		Time  time.Time `json:"time"`
+		Value any       `json:"value"`
+	}{s.Time, value})
+}
+
+// Row is a single log line.
+type Row struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Labels    map[string]string `json:"labels"`
+	Line      string            `json:"line"`
+	// Fields holds the remaining columns of the record, such as labels
+	// extracted by a LogQL pipeline.
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// Span is a single trace span.
+type Span struct {
+	TraceID      string            `json:"trace_id"`
+	SpanID       string            `json:"span_id"`
+	ParentSpanID string            `json:"parent_span_id,omitempty"`
+	Name         string            `json:"name"`
+	Service      string            `json:"service,omitempty"`
+	Kind         string            `json:"kind,omitempty"`
+	Status       string            `json:"status,omitempty"`
+	Start        time.Time         `json:"start"`
+	DurationUS   int64             `json:"duration_us"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+}
+
+// Normalize converts a backend payload for the given query language.
+func Normalize(lang string, payload json.RawMessage) (Frame, error) {
+	switch lang {
+	case "promql":
+		return FromPrometheus(payload)
+	case "logql":
+		if isPrometheus(payload) {
+			return FromPrometheus(payload)
+		}
+		return FromLogHits(payload)
+	case "traceql":
+		return FromSpanHits(payload)
+	default:
+		return Frame{}, fmt.Errorf("frame: unsupported language %q", lang)
+	}
+}
+
+// NormalizeHit converts a single search hit, as produced by a streamed log or
+// trace search, into a Row or Span.
+func NormalizeHit(lang string, hit json.RawMessage) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(hit))
+	dec.UseNumber()
+	var record map[string]any
+	if err := dec.Decode(&record); err != nil {
+		return nil, fmt.Errorf("frame: decode search hit: %w", err)
+	}
+	switch lang {
+	case "logql":
+		return RowFromHit(record)
+	case "traceql":
+		return SpanFromHit(record)
+	default:
+		return nil, fmt.Errorf("frame: unsupported language %q", lang)
+	}
+}
+
+func isPrometheus(payload json.RawMessage) bool {
+	var probe struct {
+		Status string          `json:"status"`
+		Data   json.RawMessage `json:"data"`
+	}
+	return json.Unmarshal(payload, &probe) == nil && probe.Status != "" && len(probe.Data) > 0
+}
+
+// FromPrometheus converts a Prometheus API response of any result type.
+func FromPrometheus(payload json.RawMessage) (Frame, error) {
+	resp, err := promapi.Parse(payload)
+	if err != nil {
+		return Frame{}, fmt.Errorf("frame: %w", err)
+	}
+	out := Frame{Kind: KindMetrics, Series: []Series{}, Warnings: resp.Warnings}
+
+	switch resp.Data.ResultType {
+	case promapi.ResultMatrix:
+		matrix, err := resp.Matrix()
+		if err != nil {
+			return Frame{}, fmt.Errorf("frame: %w", err)
+		}
+		for _, s := range matrix {
+			series := Series{Labels: nonNil(s.Metric), Samples: make([]Sample, 0, len(s.Values))}
+			for _, v := range s.Values {
+				series.Samples = append(series.Samples, fromPromSample(v))
+			}
+			out.Series = append(out.Series, series)
+		}
+	case promapi.ResultVector:
+		vector, err := resp.Vector()
+		if err != nil {
+			return Frame{}, fmt.Errorf("frame: %w", err)
+		}
+		for _, v := range vector {
+			out.Series = append(out.Series, Series{Labels: nonNil(v.Metric), Samples: []Sample{fromPromSample(v.Value)}})
+		}
+	case promapi.ResultScalar:
+		var sample promapi.Sample
+		if err := json.Unmarshal(resp.Data.Result, &sample); err != nil {
+			return Frame{}, fmt.Errorf("frame: decode scalar: %w", err)
+		}
+		out.Series = append(out.Series, Series{Labels: map[string]string{}, Samples: []Sample{fromPromSample(sample)}})
+	default:
+		return Frame{}, fmt.Errorf("frame: unsupported result type %q", resp.Data.ResultType)
+	}
+	return out, nil
+}
+
+func fromPromSample(s promapi.Sample) Sample {
+	sec, frac := math.Modf(s.T)
+	return Sample{Time: time.Unix(int64(sec), int64(frac*1e9)).UTC().Round(time.Millisecond), Value: s.V}
+}
+
+// Log record columns recognised in OpenObserve search hits.
+var (
+	logTimeColumns  = []string{"_timestamp", "timestamp"}
+	logLineColumns  = []string{"message", "log", "body"}
+	logLabelColumns = []string{"labels"}
+)
+
+// FromLogHits converts OpenObserve log search hits into rows.
+func FromLogHits(payload json.RawMessage) (Frame, error) {
+	hits, err := DecodeHits(payload)
+	if err != nil {
+		return Frame{}, fmt.Errorf("frame: decode search hits: %w", err)
+	}
+	out := Frame{Kind: KindLogs, Rows: make([]Row, 0, len(hits))}
+	for _, hit := range hits {
+		row, err := RowFromHit(hit)
+		if err != nil {
+			return Frame{}, err
+		}
+		out.Rows = append(out.Rows, row)
+	}
+	sort.SliceStable(out.Rows, func(i, j int) bool { return out.Rows[i].Timestamp.Before(out.Rows[j].Timestamp) })
+	return out, nil
+}
+
+// RowFromHit converts a single OpenObserve log record.
+func RowFromHit(hit map[string]any) (Row, error) {
+	tsCol := firstPresent(hit, logTimeColumns)
+	ts, err := ParseTime(hit[tsCol])
+	if err != nil {
+		return Row{}, fmt.Errorf("frame: log record: %w", err)
+	}
+	labelsCol := firstPresent(hit, logLabelColumns)
+	labels, err := ParseLabels(hit[labelsCol])
+	if err != nil {
+		return Row{}, fmt.Errorf("frame: log record: %w", err)
+	}
+	lineCol := firstPresent(hit, logLineColumns)
+	row := Row{Timestamp: ts, Labels: labels, Line: String(hit[lineCol])}
+	for k, v := range hit {
+		if k == tsCol || k == labelsCol || k == lineCol {
+			continue
+		}
+		if row.Fields == nil {
+			row.Fields = map[string]string{}
+		}
+		row.Fields[k] = String(v)
+	}
+	return row, nil
+}
+
+// FromSpanHits converts OpenObserve trace search hits into spans ordered by
+// trace and start time.
+func FromSpanHits(payload json.RawMessage) (Frame, error) {
+	hits, err := DecodeHits(payload)
+	if err != nil {
+		return Frame{}, fmt.Errorf("frame: decode search hits: %w", err)
+	}
+	out := Frame{Kind: KindTraces, Spans: make([]Span, 0, len(hits))}
+	for _, hit := range hits {
+		span, err := SpanFromHit(hit)
+		if err != nil {
+			return Frame{}, err
+		}
+		out.Spans = append(out.Spans, span)
+	}
+	sort.SliceStable(out.Spans, func(i, j int) bool {
+		a, b := out.Spans[i], out.Spans[j]
+		if a.TraceID != b.TraceID {
+			return a.TraceID < b.TraceID
+		}
+		return a.Start.Before(b.Start)
+	})
+	return out, nil
+}
+
+// SpanFromHit converts a single OpenObserve span record.
+func SpanFromHit(hit map[string]any) (Span, error) {
+	start, err := ParseTime(hit[firstPresent(hit, []string{"start_time", "_timestamp"})])
+	if err != nil {
+		return Span{}, fmt.Errorf("frame: span: %w", err)
+	}
+	span := Span{
+		TraceID:      String(hit["trace_id"]),
+		SpanID:       String(hit["span_id"]),
+		ParentSpanID: String(hit["reference_parent_span_id"]),
+		Name:         String(hit["operation_name"]),
+		Service:      String(hit["service_name"]),
+		Kind:         String(hit["span_kind"]),
+		Status:       String(hit["span_status"]),
+		Start:        start,
+		DurationUS:   int64(Float(hit["duration"])),
+	}
+	attrs := map[string]string{}
+	for _, col := range []string{"resource_attributes", "attributes"} {
+		set, err := ParseLabels(hit[col])
+		if err != nil {
+			return Span{}, fmt.Errorf("frame: span %s: %w", col, err)
+		}
+		for k, v := range set {
+			attrs[k] = v
+		}
+	}
+	if span.Service == "" {
+		span.Service = attrs["service.name"]
+	}
+	if len(attrs) > 0 {
+		span.Attributes = attrs
+	}
+	return span, nil
+}
+
+func firstPresent(hit map[string]any, cols []string) string {
+	for _, col := range cols {
+		if _, ok := hit[col]; ok {
+			return col
+		}
+	}
+	return cols[0]
+}
+
+func nonNil(m map[string]string) map[string]string {
+	if m == nil {
+		return map[string]string{}
+	}
+	return m
+}
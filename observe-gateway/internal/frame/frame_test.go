@@ -0,0 +1,82 @@
+package frame
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestFromPrometheusMatrixAndVector(t *testing.T) {
+	matrix := `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"job":"api"},"values":[[1700000000,"1"],[1700000060.5,"NaN"]]}]}}`
+	f, err := Normalize("promql", json.RawMessage(matrix))
+	if err != nil {
+		t.Fatalf("Normalize() error = %v", err)
+	}
+	if f.Kind != KindMetrics || len(f.Series) != 1 || len(f.Series[0].Samples) != 2 {
+		t.Fatalf("frame = %+v, want one series with two samples", f)
+	}
+	s := f.Series[0]
+	if s.Labels["job"] != "api" {
+		t.Fatalf("labels = %v, want job=api", s.Labels)
+	}
+	if want := time.UnixMilli(1700000060500).UTC(); !s.Samples[1].Time.Equal(want) {
+		t.Fatalf("time = %s, want %s", s.Samples[1].Time, want)
+	}
+	if !math.IsNaN(s.Samples[1].Value) {
+		t.Fatalf("value = %v, want NaN", s.Samples[1].Value)
+	}
+	if _, err := json.Marshal(f); err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+
+	vector := `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,"3"]}]}}`
+	f, err = Normalize("logql", json.RawMessage(vector))
+	if err != nil {
+		t.Fatalf("Normalize() error = %v", err)
+	}
+	if len(f.Series) != 1 || f.Series[0].Samples[0].Value != 3 {
+		t.Fatalf("frame = %+v, want single sample 3", f)
+	}
+}
+
+func TestFromLogHits(t *testing.T) {
+	payload := `{"hits":[
+		{"_timestamp":1700000001000000,"labels":"{\"service\":\"api\"}","message":"second","svc":"api"},
+		{"_timestamp":1700000000000000,"labels":{"service":"api"},"message":"first"}
+	]}`
+	f, err := Normalize("logql", json.RawMessage(payload))
+	if err != nil {
+		t.Fatalf("Normalize() error = %v", err)
+	}
+	if f.Kind != KindLogs || len(f.Rows) != 2 {
+		t.Fatalf("frame = %+v, want two rows", f)
+	}
+	if f.Rows[0].Line != "first" || f.Rows[1].Line != "second" {
+		t.Fatalf("rows not ordered by timestamp: %+v", f.Rows)
+	}
+	if f.Rows[1].Labels["service"] != "api" || f.Rows[1].Fields["svc"] != "api" {
+		t.Fatalf("row = %+v, want labels and extracted fields", f.Rows[1])
+	}
+}
+
+func TestFromSpanHits(t *testing.T) {
+	payload := `{"hits":[
+		{"trace_id":"t1","span_id":"b","reference_parent_span_id":"a","operation_name":"db","start_time":1700000000100000000,"duration":500,"attributes":{"db.system":"postgresql"}},
+		{"trace_id":"t1","span_id":"a","operation_name":"GET /","service_name":"api","span_status":"ERROR","start_time":1700000000000000000,"duration":2000}
+	]}`
+	f, err := Normalize("traceql", json.RawMessage(payload))
+	if err != nil {
+		t.Fatalf("Normalize() error = %v", err)
+	}
+	if f.Kind != KindTraces || len(f.Spans) != 2 {
+		t.Fatalf("frame = %+v, want two spans", f)
+	}
+	root, child := f.Spans[0], f.Spans[1]
+	if root.SpanID != "a" || root.Service != "api" || root.Status != "ERROR" || root.DurationUS != 2000 {
+		t.Fatalf("root = %+v", root)
+	}
+	if child.ParentSpanID != "a" || child.Attributes["db.system"] != "postgresql" {
+		t.Fatalf("child = %+v", child)
+	}
+}
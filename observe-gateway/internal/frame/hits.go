@@ -0,0 +1,120 @@
+package frame
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ParseTime accepts the timestamp encodings OpenObserve uses in search hits:
+// RFC 3339 strings or epoch numbers in s/ms/µs/ns.
+func ParseTime(v any) (time.Time, error) {
+	switch t := v.(type) {
+	case json.Number:
+		n, err := t.Float64()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid timestamp %q", t)
+		}
+		return epochTime(n), nil
+	case float64:
+		return epochTime(t), nil
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02 15:04:05.999999999"} {
+			if ts, err := time.Parse(layout, t); err == nil {
+				return ts.UTC(), nil
+			}
+		}
+		if n, err := strconv.ParseFloat(t, 64); err == nil {
+			return epochTime(n), nil
+		}
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", t)
+	default:
+		return time.Time{}, fmt.Errorf("missing timestamp")
+	}
+}
+
+func epochTime(n float64) time.Time {
+	switch {
+	case n > 1e17:
+		return time.Unix(0, int64(n)).UTC()
+	case n > 1e14:
+		return time.UnixMicro(int64(n)).UTC()
+	case n > 1e11:
+		return time.UnixMilli(int64(n)).UTC()
+	default:
+		return time.Unix(int64(n), 0).UTC()
+	}
+}
+
+// ParseLabels decodes a label set stored either as a JSON object or as a
+// JSON encoded string.
+func ParseLabels(v any) (map[string]string, error) {
+	labels := map[string]string{}
+	switch l := v.(type) {
+	case nil:
+	case map[string]any:
+		for k, val := range l {
+			labels[k] = String(val)
+		}
+	case string:
+		if l == "" {
+			break
+		}
+		var raw map[string]any
+		if err := json.Unmarshal([]byte(l), &raw); err != nil {
+			return nil, fmt.Errorf("invalid labels: %w", err)
+		}
+		for k, val := range raw {
+			labels[k] = String(val)
+		}
+	default:
+		return nil, fmt.Errorf("invalid labels of type %T", v)
+	}
+	return labels, nil
+}
+
+// String renders a scalar hit field as text.
+func String(v any) string {
+	switch s := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return s
+	case json.Number:
+		return s.String()
+	default:
+		return fmt.Sprint(s)
+	}
+}
+
+// Float reads a numeric hit field, returning 0 when it is absent or invalid.
+func Float(v any) float64 {
+	switch n := v.(type) {
+	case json.Number:
+		f, _ := n.Float64()
+		return f
+	case float64:
+		return n
+	case string:
+		f, _ := strconv.ParseFloat(n, 64)
+		return f
+	default:
+		return 0
+	}
+}
+
+// DecodeHits decodes an OpenObserve _search response body, keeping numbers
+// as json.Number.
+func DecodeHits(payload []byte) ([]map[string]any, error) {
+	var body struct {
+		Hits []map[string]any `json:"hits"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&body); err != nil {
+		return nil, err
+	}
+	return body.Hits, nil
+}
@@ -0,0 +1,203 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrBudgetExhausted indicates the tenant has used its query cost budget for
+// the current window.
+var ErrBudgetExhausted = errors.New("query cost budget exhausted")
+
+// budgetBuckets is the resolution of the rolling cost window.
+const budgetBuckets = 60
+
+// BudgetConfig configures the rolling per-tenant cost budget.
+type BudgetConfig struct {
+	Enabled bool
+	// Limit is the default cost allowed per tenant within Window.
+	Limit  int64
+	Window time.Duration
+	// Source optionally overrides Limit per tenant.
+	Source BudgetSource
+}
+
+// BudgetSource supplies per-tenant budget overrides, typically from the
+// metadata store. ok is false when the tenant has no override.
+type BudgetSource interface {
+	TenantBudget(ctx context.Context, tenant string) (limit int64, ok bool, err error)
+}
+
+// Budget is a tenant's cost usage within the current window. A zero Limit
+// means the tenant is not budgeted.
+type Budget struct {
+	Limit int64
+	Used  int64
+}
+
+// Enabled reports whether the budget applies.
+func (b Budget) Enabled() bool {
+	return b.Limit > 0
+}
+
+// Remaining returns the cost left in the window, never below zero.
+func (b Budget) Remaining() int64 {
+	if b.Used >= b.Limit {
+		return 0
+	}
+	return b.Limit - b.Used
+}
+
+type costBucket struct {
+	index int64
+	cost  int64
+}
+
+// costWindow is an in-process rolling sum of cost per tenant.
+type costWindow struct {
+	width time.Duration
+	now   func() time.Time
+
+	mu      sync.Mutex
+	tenants map[string]*[budgetBuckets]costBucket
+}
+
+func newCostWindow(window time.Duration) *costWindow {
+	width := window / budgetBuckets
+	if width <= 0 {
+		width = 1
+	}
+	return &costWindow{width: width, now: time.Now, tenants: make(map[string]*[budgetBuckets]costBucket)}
+}
+
+// resize changes the window, discarding totals recorded at another
+// resolution.
+func (w *costWindow) resize(window time.Duration) {
+	width := window / budgetBuckets
+	if width <= 0 {
+		width = 1
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if width != w.width {
+		w.width = width
+		w.tenants = make(map[string]*[budgetBuckets]costBucket)
+	}
+}
+
+// index returns the current bucket index.
+func (w *costWindow) index() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.now().UnixNano() / int64(w.width)
+}
+
+// add records cost for the tenant and returns the window total.
+func (w *costWindow) add(tenant string, cost int64) int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	buckets := w.tenants[tenant]
+	if buckets == nil {
+		buckets = new([budgetBuckets]costBucket)
+		w.tenants[tenant] = buckets
+	}
+	idx := w.now().UnixNano() / int64(w.width)
+	if cost > 0 {
+		bucket := &buckets[idx%budgetBuckets]
+		if bucket.index != idx {
+			*bucket = costBucket{index: idx}
+		}
+		bucket.cost += cost
+	}
+
+	var used int64
+	for _, bucket := range buckets {
+		if bucket.index > idx-budgetBuckets {
+			used += bucket.cost
+		}
+	}
+	return used
+}
+
+// budgetScript keeps one hash per tenant mapping bucket index to cost,
+// dropping buckets that have left the window.
+var budgetScript = redis.NewScript(`
+local key = KEYS[1]
+local current = tonumber(ARGV[1])
+local buckets = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[4])
+if cost > 0 then
+  redis.call('HINCRBY', key, ARGV[1], cost)
+  redis.call('PEXPIRE', key, ttl)
+end
+local used = 0
+local fields = redis.call('HGETALL', key)
+for i = 1, #fields, 2 do
+  local idx = tonumber(fields[i])
+  if idx <= current - buckets then
+    redis.call('HDEL', key, fields[i])
+  else
+    used = used + tonumber(fields[i + 1])
+  end
+end
+return used
+`)
+
+// CheckBudget returns the tenant's current budget usage, or
+// ErrBudgetExhausted when nothing is left.
+func (l *Limiter) CheckBudget(ctx context.Context, tenant string) (Budget, error) {
+	budget, err := l.budgetUsage(ctx, tenant, 0)
+	if err != nil {
+		return Budget{}, err
+	}
+	if budget.Enabled() && budget.Remaining() == 0 {
+		return budget, ErrBudgetExhausted
+	}
+	return budget, nil
+}
+
+// ChargeBudget records the cost of a completed query.
+func (l *Limiter) ChargeBudget(ctx context.Context, tenant string, cost int64) (Budget, error) {
+	return l.budgetUsage(ctx, tenant, cost)
+}
+
+func (l *Limiter) budgetUsage(ctx context.Context, tenant string, cost int64) (Budget, error) {
+	if l == nil || tenant == "" {
+		return Budget{}, nil
+	}
+	budget := l.settings.Load().budget
+	if !budget.Enabled {
+		return Budget{}, nil
+	}
+
+	limit := budget.Limit
+	if budget.Source != nil {
+		override, ok, err := budget.Source.TenantBudget(ctx, tenant)
+		if err != nil {
+			return Budget{}, err
+		}
+		if ok {
+			limit = override
+		}
+	}
+	if limit <= 0 {
+		return Budget{}, nil
+	}
+
+	if l.redis == nil {
+		return Budget{Limit: limit, Used: l.costs.add(tenant, cost)}, nil
+	}
+
+	idx := l.costs.index()
+	used, err := budgetScript.Run(ctx, l.redis, []string{"budget:" + tenant}, idx, budgetBuckets, cost, budget.Window.Milliseconds()).Int64()
+	if err != nil {
+		return Budget{}, err
+	}
+	return Budget{Limit: limit, Used: used}, nil
+}
@@ -0,0 +1,109 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+type budgetSource map[string]int64
+
+func (s budgetSource) TenantBudget(_ context.Context, tenant string) (int64, bool, error) {
+	limit, ok := s[tenant]
+	return limit, ok, nil
+}
+
+func TestBudgetRollingWindow(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := New(Config{Budget: BudgetConfig{Enabled: true, Limit: 100, Window: time.Hour}})
+	l.costs.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if _, err := l.ChargeBudget(ctx, "tenant-a", 70); err != nil {
+		t.Fatalf("ChargeBudget() error = %v", err)
+	}
+	budget, err := l.ChargeBudget(ctx, "tenant-a", 40)
+	if err != nil {
+		t.Fatalf("ChargeBudget() error = %v", err)
+	}
+	if budget.Used != 110 || budget.Remaining() != 0 {
+		t.Fatalf("budget = %+v, want used 110, remaining 0", budget)
+	}
+	if _, err := l.CheckBudget(ctx, "tenant-a"); !errors.Is(err, ErrBudgetExhausted) {
+		t.Fatalf("CheckBudget() error = %v, want ErrBudgetExhausted", err)
+	}
+	if _, err := l.CheckBudget(ctx, "tenant-b"); err != nil {
+		t.Fatalf("CheckBudget(tenant-b) error = %v, want budgets isolated per tenant", err)
+	}
+
+	now = now.Add(time.Hour)
+	budget, err = l.CheckBudget(ctx, "tenant-a")
+	if err != nil {
+		t.Fatalf("CheckBudget() after window error = %v", err)
+	}
+	if budget.Remaining() != 100 {
+		t.Fatalf("remaining = %d, want 100 after the window rolled over", budget.Remaining())
+	}
+}
+
+func TestBudgetPerTenantOverride(t *testing.T) {
+	l := New(Config{Budget: BudgetConfig{
+		Enabled: true,
+		Limit:   100,
+		Source:  budgetSource{"heavy": 10, "unlimited": 0},
+	}})
+	ctx := context.Background()
+
+	budget, err := l.ChargeBudget(ctx, "heavy", 10)
+	if err != nil {
+		t.Fatalf("ChargeBudget() error = %v", err)
+	}
+	if budget.Limit != 10 {
+		t.Fatalf("limit = %d, want tenant override 10", budget.Limit)
+	}
+	if _, err := l.CheckBudget(ctx, "heavy"); !errors.Is(err, ErrBudgetExhausted) {
+		t.Fatalf("CheckBudget() error = %v, want ErrBudgetExhausted", err)
+	}
+
+	budget, err = l.ChargeBudget(ctx, "unlimited", 1000)
+	if err != nil || budget.Enabled() {
+		t.Fatalf("budget = %+v, err = %v, want no budget for a zero override", budget, err)
+	}
+}
+
+func TestBudgetSharedThroughRedis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	now := time.Unix(1700000000, 0)
+	replicas := make([]*Limiter, 2)
+	for i := range replicas {
+		replicas[i] = New(Config{Redis: client, Budget: BudgetConfig{Enabled: true, Limit: 100, Window: time.Hour}})
+		replicas[i].costs.now = func() time.Time { return now }
+	}
+	ctx := context.Background()
+
+	if _, err := replicas[0].ChargeBudget(ctx, "tenant-a", 60); err != nil {
+		t.Fatalf("ChargeBudget() error = %v", err)
+	}
+	budget, err := replicas[1].ChargeBudget(ctx, "tenant-a", 50)
+	if err != nil {
+		t.Fatalf("ChargeBudget() error = %v", err)
+	}
+	if budget.Used != 110 {
+		t.Fatalf("used = %d, want 110 across replicas", budget.Used)
+	}
+	if _, err := replicas[0].CheckBudget(ctx, "tenant-a"); !errors.Is(err, ErrBudgetExhausted) {
+		t.Fatalf("CheckBudget() error = %v, want ErrBudgetExhausted", err)
+	}
+
+	now = now.Add(time.Hour)
+	if budget, err := replicas[1].CheckBudget(ctx, "tenant-a"); err != nil || budget.Used != 0 {
+		t.Fatalf("CheckBudget() = %+v, %v, want the window to roll over", budget, err)
+	}
+}
@@ -3,143 +3,413 @@ package limiter
 import (
 	"context"
 	"errors"
+	"fmt"
+	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
-	"golang.org/x/time/rate"
 )
 
 // ErrRateLimited indicates tenant exceeded rate limits.
 var ErrRateLimited = errors.New("rate limit exceeded")
 
-// Limiter enforces per-tenant limits using local token buckets and optional Redis sliding window.
-type Limiter struct {
-	enabled bool
+// RateLimitError reports which rule rejected a request. It matches
+// ErrRateLimited with errors.Is.
+type RateLimitError struct {
+	Rule       string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded: %s", e.Rule)
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// Limit is a token bucket: a sustained rate and the burst allowed on top.
+// A zero RequestsPerSecond disables the rule.
+type Limit struct {
+	RequestsPerSecond float64
+	Burst             int
+}
 
-	rps    float64
-	burst  int
-	window time.Duration
+func (l Limit) enabled() bool {
+	return l.RequestsPerSecond > 0
+}
+
+// LimitSource supplies per-tenant limit overrides, typically from the
+// metadata store. ok is false when the tenant has no override.
+type LimitSource interface {
+	TenantLimit(ctx context.Context, tenant string) (limit Limit, ok bool, err error)
+}
+
+// Subject identifies the caller and the query being limited.
+type Subject struct {
+	Tenant   string
+	User     string
+	Template string
+	Lang     string
+}
+
+// Decision describes the outcome for the most constrained rule.
+type Decision struct {
+	Rule       string
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// Limiter enforces layered per-tenant, per-user, per-template and
+// per-language limits with GCRA, locally or in Redis with O(1) state per
+// rule.
+type Limiter struct {
+	// settings holds the rules swapped by Reload; bucket state survives a
+	// reload.
+	settings atomic.Pointer[settings]
+	now      func() time.Time
 
 	localMu sync.Mutex
-	local   map[string]*rate.Limiter
+	local   map[string]time.Time
 
 	redis redis.UniversalClient
+	costs *costWindow
+}
+
+// settings are the reloadable limiter rules.
+type settings struct {
+	enabled   bool
+	tenant    Limit
+	tenants   map[string]Limit
+	perUser   Limit
+	users     map[string]Limit
+	templates map[string]Limit
+	langs     map[string]Limit
+	source    LimitSource
+	budget    BudgetConfig
 }
 
 // Config contains parameters for limiter construction.
 type Config struct {
-	Enabled           bool
+	Enabled bool
+	// RequestsPerSecond and Burst are the default per-tenant limit.
 	RequestsPerSecond float64
 	Burst             int
-	Window            time.Duration
-	Redis             redis.UniversalClient
+	// Tenants overrides the tenant limit; Source overrides it from the
+	// metadata store and takes precedence.
+	Tenants map[string]Limit
+	Source  LimitSource
+	// PerUser limits each user within a tenant; Users overrides it by user.
+	PerUser Limit
+	Users   map[string]Limit
+	// Templates and Langs limit each tenant's use of a query template or
+	// language.
+	Templates map[string]Limit
+	Langs     map[string]Limit
+	Redis     redis.UniversalClient
+	// Budget enables the rolling cost budget independently of request
+	// rate limiting.
+	Budget BudgetConfig
 }
 
 // New creates a Limiter from the supplied configuration.
 func New(cfg Config) *Limiter {
+	l := &Limiter{now: time.Now, local: make(map[string]time.Time), redis: cfg.Redis}
+	l.settings.Store(newSettings(&cfg))
+	l.costs = newCostWindow(cfg.Budget.Window)
+	return l
+}
+
+// Reload swaps the limits and budget for subsequent requests. Redis and the
+// current bucket state are kept; local cost totals reset only when the
+// budget window changes.
+func (l *Limiter) Reload(cfg Config) {
+	next := newSettings(&cfg)
+	l.costs.resize(next.budget.Window)
+	l.settings.Store(next)
+}
+
+func newSettings(cfg *Config) *settings {
+	if cfg.Budget.Window <= 0 {
+		cfg.Budget.Window = time.Hour
+	}
 	if !cfg.Enabled {
-		return &Limiter{enabled: false}
+		return &settings{budget: cfg.Budget}
 	}
-
-	if cfg.Burst <= 0 {
-		cfg.Burst = int(cfg.RequestsPerSecond * 2)
-		if cfg.Burst < 1 {
-			cfg.Burst = 1
-		}
+	return &settings{
+		enabled:   true,
+		tenant:    Limit{RequestsPerSecond: cfg.RequestsPerSecond, Burst: cfg.Burst},
+		tenants:   cfg.Tenants,
+		perUser:   cfg.PerUser,
+		users:     cfg.Users,
+		templates: cfg.Templates,
+		langs:     cfg.Langs,
+		source:    cfg.Source,
+		budget:    cfg.Budget,
 	}
+}
 
-	if cfg.Window <= 0 {
-		cfg.Window = time.Minute
+// rule is a limit applied to one bucket key.
+type rule struct {
+	name  string
+	key   string
+	limit Limit
+	// tokens is the number of requests the decision consumes.
+	tokens int
+}
+
+// Allow checks every rule that applies to the subject and consumes a token
+// from each only if all of them allow the request. A rejection returns a
+// *RateLimitError naming the rule that tripped.
+func (l *Limiter) Allow(ctx context.Context, subj Subject) (Decision, error) {
+	if l == nil || subj.Tenant == "" {
+		return Decision{}, nil
+	}
+	set := l.settings.Load()
+	if !set.enabled {
+		return Decision{}, nil
 	}
 
-	return &Limiter{
-		enabled: true,
-		rps:     cfg.RequestsPerSecond,
-		burst:   cfg.Burst,
-		window:  cfg.Window,
-		local:   make(map[string]*rate.Limiter),
-		redis:   cfg.Redis,
+	tenantLimit, err := set.tenantLimit(ctx, subj.Tenant)
+	if err != nil {
+		return Decision{}, err
 	}
+	return l.allow(ctx, set.rules(subj, tenantLimit))
 }
 
-// Allow verifies whether the tenant may perform the next action.
-func (l *Limiter) Allow(ctx context.Context, tenant string) error {
-	if !l.enabled || tenant == "" {
-		return nil
+// AllowBatch admits the queries of a batch, made by one tenant and user,
+// as a single decision. Each template and language rule is charged a token
+// per query using it. The tenant and user rules are charged one token, or
+// one per query when perQuery is set. Nothing is consumed unless every rule
+// allows the whole batch.
+func (l *Limiter) AllowBatch(ctx context.Context, subjects []Subject, perQuery bool) (Decision, error) {
+	if l == nil || len(subjects) == 0 || subjects[0].Tenant == "" {
+		return Decision{}, nil
+	}
+	set := l.settings.Load()
+	if !set.enabled {
+		return Decision{}, nil
 	}
 
-	if !l.allowLocal(tenant) {
-		return ErrRateLimited
+	tenantLimit, err := set.tenantLimit(ctx, subjects[0].Tenant)
+	if err != nil {
+		return Decision{}, err
 	}
+	var rules []rule
+	index := map[string]int{}
+	for _, subj := range subjects {
+		for _, r := range set.rules(subj, tenantLimit) {
+			i, ok := index[r.key]
+			if !ok {
+				index[r.key] = len(rules)
+				rules = append(rules, r)
+				continue
+			}
+			if perQuery || (r.name != "tenant" && r.name != "user") {
+				rules[i].tokens++
+			}
+		}
+	}
+	return l.allow(ctx, rules)
+}
 
+// allow consumes the tokens of every rule if all of them allow it.
+func (l *Limiter) allow(ctx context.Context, rules []rule) (Decision, error) {
+	if len(rules) == 0 {
+		return Decision{}, nil
+	}
+	var decision Decision
+	var err error
+	var allowed bool
 	if l.redis != nil {
-		allowed, err := l.allowRedis(ctx, tenant)
+		decision, allowed, err = l.allowRedis(ctx, rules)
 		if err != nil {
-			return err
+			return Decision{}, err
 		}
-		if !allowed {
-			return ErrRateLimited
+	} else {
+		decision, allowed = l.allowLocal(rules)
+	}
+	if !allowed {
+		return decision, &RateLimitError{Rule: decision.Rule, RetryAfter: decision.RetryAfter}
+	}
+	return decision, nil
+}
+
+// tenantLimit returns the limit of a tenant: its override from the
+// metadata store, its configured override or the default.
+func (s *settings) tenantLimit(ctx context.Context, tenant string) (Limit, error) {
+	limit := s.tenant
+	if override, ok := s.tenants[tenant]; ok {
+		limit = override
+	}
+	if s.source != nil {
+		override, ok, err := s.source.TenantLimit(ctx, tenant)
+		if err != nil {
+			return Limit{}, err
+		}
+		if ok {
+			limit = override
+		}
+	}
+	return limit, nil
+}
+
+// rules returns the rules that apply to a subject, each charged one token.
+func (s *settings) rules(subj Subject, tenantLimit Limit) []rule {
+	var rules []rule
+	add := func(name, key string, limit Limit) {
+		if limit.enabled() {
+			rules = append(rules, rule{name: name, key: "rate:" + key, limit: normalize(limit), tokens: 1})
+		}
+	}
+	add("tenant", subj.Tenant, tenantLimit)
+	if subj.User != "" {
+		userLimit := s.perUser
+		if override, ok := s.users[subj.User]; ok {
+			userLimit = override
+		}
+		add("user", subj.Tenant+":user:"+subj.User, userLimit)
+	}
+	if subj.Template != "" {
+		add("template:"+subj.Template, subj.Tenant+":template:"+subj.Template, s.templates[subj.Template])
+	}
+	if subj.Lang != "" {
+		add("lang:"+subj.Lang, subj.Tenant+":lang:"+subj.Lang, s.langs[subj.Lang])
+	}
+	return rules
+}
+
+func normalize(limit Limit) Limit {
+	if limit.Burst <= 0 {
+		limit.Burst = int(math.Ceil(limit.RequestsPerSecond * 2))
+		if limit.Burst < 1 {
+			limit.Burst = 1
 		}
 	}
+	return limit
+}
 
-	return nil
+// interval is the GCRA emission interval: the time one request "costs".
+func (r rule) interval() time.Duration {
+	return time.Duration(float64(time.Second) / r.limit.RequestsPerSecond)
 }
 
-func (l *Limiter) allowLocal(tenant string) bool {
+// allowLocal runs GCRA against in-process theoretical arrival times (TAT).
+// A request is allowed when its new TAT stays within burst intervals of now.
+func (l *Limiter) allowLocal(rules []rule) (Decision, bool) {
 	l.localMu.Lock()
-	limiter := l.local[tenant]
-	if limiter == nil {
-		limit := rate.Inf
-		if l.rps > 0 {
-			limit = rate.Limit(l.rps)
+	defer l.localMu.Unlock()
+
+	now := l.now()
+	tats := make([]time.Time, len(rules))
+	for i, r := range rules {
+		tat := l.local[r.key]
+		if tat.Before(now) {
+			tat = now
+		}
+		tats[i] = tat.Add(r.interval() * time.Duration(r.tokens))
+		allowAt := tats[i].Add(-r.interval() * time.Duration(r.limit.Burst))
+		if allowAt.After(now) {
+			return Decision{Rule: r.name, Limit: r.limit.Burst, RetryAfter: allowAt.Sub(now)}, false
 		}
-		limiter = rate.NewLimiter(limit, l.burst)
-		l.local[tenant] = limiter
 	}
-	l.localMu.Unlock()
 
-	if limiter == nil {
-		return true
+	l.sweepLocal(now)
+	decision := Decision{Remaining: math.MaxInt}
+	for i, r := range rules {
+		l.local[r.key] = tats[i]
+		remaining := remaining(r, tats[i].Sub(now))
+		if remaining < decision.Remaining {
+			decision = Decision{Rule: r.name, Limit: r.limit.Burst, Remaining: remaining}
+		}
 	}
-	return limiter.Allow()
+	return decision, true
 }
 
-var redisScript = redis.NewScript(`
-local key = KEYS[1]
-local now = tonumber(ARGV[1])
-local window = tonumber(ARGV[2])
-local limit = tonumber(ARGV[3])
-redis.call('ZREMRANGEBYSCORE', key, 0, now - window)
-local count = redis.call('ZCARD', key)
-if count >= limit then
-  return 0
-end
-redis.call('ZADD', key, now, now)
-redis.call('PEXPIRE', key, window)
-return 1
-`)
-
-func (l *Limiter) allowRedis(ctx context.Context, tenant string) (bool, error) {
-	if l.redis == nil {
-		return true, nil
+// sweepLocal drops buckets that have fully refilled once the map grows.
+func (l *Limiter) sweepLocal(now time.Time) {
+	if len(l.local) < 10000 {
+		return
 	}
+	for key, tat := range l.local {
+		if !tat.After(now) {
+			delete(l.local, key)
+		}
+	}
+}
 
-	limit := l.burst
-	if limit <= 0 {
-		limit = 1
+func remaining(r rule, ahead time.Duration) int {
+	n := int((r.interval()*time.Duration(r.limit.Burst) - ahead) / r.interval())
+	if n < 0 {
+		return 0
 	}
+	return n
+}
+
+// gcraScript applies GCRA to every key atomically, storing one TAT (in
+// microseconds) per key. The clock is read from the Redis server so every
+// gateway instance sharing the keys agrees on it. Each key takes an
+// interval, burst and token count argument. It returns {allowed, rule
+// index, retry after us} on rejection and {allowed, rule index, remaining}
+// for the most constrained rule otherwise.
+var gcraScript = redis.NewScript(`
+redis.replicate_commands()
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local tats = {}
+for i = 1, #KEYS do
+  local interval = tonumber(ARGV[3 * i - 2])
+  local burst = tonumber(ARGV[3 * i - 1])
+  local tokens = tonumber(ARGV[3 * i])
+  local tat = tonumber(redis.call('GET', KEYS[i]) or now)
+  if tat < now then
+    tat = now
+  end
+  local new_tat = tat + interval * tokens
+  local allow_at = new_tat - interval * burst
+  if allow_at > now then
+    return {0, i, allow_at - now}
+  end
+  tats[i] = new_tat
+end
+local min_idx, min_remaining = 1, -1
+for i = 1, #KEYS do
+  local interval = tonumber(ARGV[3 * i - 2])
+  local burst = tonumber(ARGV[3 * i - 1])
+  redis.call('SET', KEYS[i], tats[i], 'PX', math.ceil((tats[i] - now) / 1000) + 1)
+  local remaining = math.floor((interval * burst - (tats[i] - now)) / interval)
+  if min_remaining < 0 or remaining < min_remaining then
+    min_idx, min_remaining = i, remaining
+  end
+end
+return {1, min_idx, min_remaining}
+`)
 
-	now := time.Now().UnixMilli()
-	window := l.window.Milliseconds()
-	if window <= 0 {
-		window = time.Minute.Milliseconds()
+func (l *Limiter) allowRedis(ctx context.Context, rules []rule) (Decision, bool, error) {
+	keys := make([]string, len(rules))
+	args := make([]any, 0, 3*len(rules))
+	for i, r := range rules {
+		keys[i] = r.key
+		interval := r.interval().Microseconds()
+		if interval < 1 {
+			interval = 1
+		}
+		args = append(args, interval, r.limit.Burst, r.tokens)
 	}
 
-	res, err := redisScript.Run(ctx, l.redis, []string{"rate:" + tenant}, now, window, limit).Int()
+	res, err := gcraScript.Run(ctx, l.redis, keys, args...).Int64Slice()
 	if err != nil {
-		return false, err
+		return Decision{}, false, err
 	}
-
-	return res == 1, nil
+	if len(res) != 3 || res[1] < 1 || int(res[1]) > len(rules) {
+		return Decision{}, false, fmt.Errorf("unexpected rate limit script result %v", res)
+	}
+	r := rules[res[1]-1]
+	if res[0] == 0 {
+		return Decision{Rule: r.name, Limit: r.limit.Burst, RetryAfter: time.Duration(res[2]) * time.Microsecond}, false, nil
+	}
+	return Decision{Rule: r.name, Limit: r.limit.Burst, Remaining: int(max(res[2], 0))}, true, nil
 }
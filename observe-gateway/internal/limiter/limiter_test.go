@@ -0,0 +1,216 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func layeredConfig() Config {
+	return Config{
+		Enabled:           true,
+		RequestsPerSecond: 10,
+		Burst:             5,
+		PerUser:           Limit{RequestsPerSecond: 1, Burst: 2},
+		Templates:         map[string]Limit{"heavy": {RequestsPerSecond: 1, Burst: 1}},
+	}
+}
+
+func TestAllowLayersRules(t *testing.T) {
+	l := New(layeredConfig())
+	now := time.Unix(1700000000, 0)
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	alice := Subject{Tenant: "tenant-a", User: "alice", Lang: "promql"}
+	for i := 0; i < 2; i++ {
+		d, err := l.Allow(ctx, alice)
+		if err != nil {
+			t.Fatalf("Allow() #%d error = %v", i, err)
+		}
+		if d.Rule != "user" || d.Limit != 2 || d.Remaining != 1-i {
+			t.Fatalf("decision #%d = %+v, want user rule with %d remaining", i, d, 1-i)
+		}
+	}
+	d, err := l.Allow(ctx, alice)
+	var limited *RateLimitError
+	if !errors.As(err, &limited) || limited.Rule != "user" || !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Allow() error = %v, want user rule rejection", err)
+	}
+	if d.RetryAfter != time.Second {
+		t.Fatalf("retry after = %s, want 1s", d.RetryAfter)
+	}
+
+	// Another user of the same tenant still has tokens, but the rejected
+	// request did not consume any from the shared tenant bucket.
+	d, err = l.Allow(ctx, Subject{Tenant: "tenant-a", User: "bob"})
+	if err != nil {
+		t.Fatalf("Allow(bob) error = %v", err)
+	}
+	if tenant := l.local["rate:tenant-a"]; tenant != now.Add(300*time.Millisecond) {
+		t.Fatalf("tenant TAT = %s, want 3 requests charged", tenant.Sub(now))
+	}
+
+	_, err = l.Allow(ctx, Subject{Tenant: "tenant-b", Template: "heavy"})
+	if err != nil {
+		t.Fatalf("Allow(heavy) error = %v", err)
+	}
+	_, err = l.Allow(ctx, Subject{Tenant: "tenant-b", Template: "heavy"})
+	if !errors.As(err, &limited) || limited.Rule != "template:heavy" {
+		t.Fatalf("Allow(heavy) error = %v, want template rule rejection", err)
+	}
+
+	now = now.Add(time.Second)
+	if _, err := l.Allow(ctx, alice); err != nil {
+		t.Fatalf("Allow() after refill error = %v", err)
+	}
+}
+
+type limitSource map[string]Limit
+
+func (s limitSource) TenantLimit(_ context.Context, tenant string) (Limit, bool, error) {
+	limit, ok := s[tenant]
+	return limit, ok, nil
+}
+
+func TestAllowTenantOverrides(t *testing.T) {
+	cfg := Config{
+		Enabled:           true,
+		RequestsPerSecond: 100,
+		Tenants:           map[string]Limit{"small": {RequestsPerSecond: 1, Burst: 1}},
+		Source:            limitSource{"db": {RequestsPerSecond: 1, Burst: 3}},
+	}
+	l := New(cfg)
+	ctx := context.Background()
+
+	if d, _ := l.Allow(ctx, Subject{Tenant: "small"}); d.Limit != 1 {
+		t.Fatalf("config override limit = %d, want 1", d.Limit)
+	}
+	if d, _ := l.Allow(ctx, Subject{Tenant: "db"}); d.Limit != 3 {
+		t.Fatalf("metadata override limit = %d, want 3", d.Limit)
+	}
+	if d, _ := l.Allow(ctx, Subject{Tenant: "other"}); d.Limit != 200 {
+		t.Fatalf("default limit = %d, want burst derived from rate", d.Limit)
+	}
+}
+
+func TestAllowRedisGCRA(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	cfg := layeredConfig()
+	cfg.Redis = client
+	now := time.Unix(1700000000, 0)
+	mr.SetTime(now)
+	// The replicas' own clocks disagree; the buckets follow the Redis clock.
+	replicas := []*Limiter{New(cfg), New(cfg)}
+	for i, l := range replicas {
+		skew := time.Duration(i) * time.Hour
+		l.now = func() time.Time { return now.Add(skew) }
+	}
+	ctx := context.Background()
+	alice := Subject{Tenant: "tenant-a", User: "alice"}
+
+	if d, err := replicas[0].Allow(ctx, alice); err != nil || d.Rule != "user" || d.Remaining != 1 {
+		t.Fatalf("Allow() = %+v, %v, want user rule with 1 remaining", d, err)
+	}
+	if _, err := replicas[1].Allow(ctx, alice); err != nil {
+		t.Fatalf("Allow() on second replica error = %v", err)
+	}
+	d, err := replicas[0].Allow(ctx, alice)
+	var limited *RateLimitError
+	if !errors.As(err, &limited) || limited.Rule != "user" || d.RetryAfter != time.Second {
+		t.Fatalf("Allow() = %+v, %v, want user rejection with 1s retry", d, err)
+	}
+	if n := len(mr.Keys()); n != 2 {
+		t.Fatalf("redis keys = %d, want one per rule", n)
+	}
+
+	mr.SetTime(now.Add(time.Second))
+	if _, err := replicas[1].Allow(ctx, alice); err != nil {
+		t.Fatalf("Allow() after refill error = %v", err)
+	}
+}
+
+func TestAllowBatch(t *testing.T) {
+	for _, backing := range []string{"local", "redis"} {
+		t.Run(backing, func(t *testing.T) {
+			cfg := layeredConfig()
+			cfg.PerUser = Limit{RequestsPerSecond: 1, Burst: 3}
+			now := time.Unix(1700000000, 0)
+			if backing == "redis" {
+				mr := miniredis.RunT(t)
+				mr.SetTime(now)
+				client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+				defer client.Close()
+				cfg.Redis = client
+			}
+			l := New(cfg)
+			l.now = func() time.Time { return now }
+			ctx := context.Background()
+
+			batch := []Subject{
+				{Tenant: "tenant-a", User: "alice", Lang: "promql"},
+				{Tenant: "tenant-a", User: "alice", Lang: "logql"},
+			}
+			// Counted as one request, the user rule has two of three tokens
+			// left.
+			d, err := l.AllowBatch(ctx, batch, false)
+			if err != nil || d.Rule != "user" || d.Remaining != 2 {
+				t.Fatalf("AllowBatch() = %+v, %v, want user rule with 2 remaining", d, err)
+			}
+			// Weighted per query, the batch needs two of the two left.
+			d, err = l.AllowBatch(ctx, batch, true)
+			if err != nil || d.Remaining != 0 {
+				t.Fatalf("AllowBatch(per query) = %+v, %v, want user rule with 0 remaining", d, err)
+			}
+			var limited *RateLimitError
+			if _, err := l.AllowBatch(ctx, batch[:1], true); !errors.As(err, &limited) || limited.Rule != "user" {
+				t.Fatalf("AllowBatch() error = %v, want user rule rejection", err)
+			}
+
+			// A template rule is charged for every query using it, and a
+			// rejected batch consumes nothing.
+			heavy := []Subject{{Tenant: "tenant-b", Template: "heavy"}, {Tenant: "tenant-b", Template: "heavy"}}
+			if _, err := l.AllowBatch(ctx, heavy, false); !errors.As(err, &limited) || limited.Rule != "template:heavy" {
+				t.Fatalf("AllowBatch(heavy) error = %v, want template rule rejection", err)
+			}
+			if _, err := l.AllowBatch(ctx, heavy[:1], false); err != nil {
+				t.Fatalf("AllowBatch(heavy) after rejection error = %v", err)
+			}
+		})
+	}
+}
+
+func TestReloadAppliesNewRules(t *testing.T) {
+	l := New(Config{})
+	now := time.Unix(1700000000, 0)
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+	subject := Subject{Tenant: "tenant-a", User: "alice", Template: "heavy"}
+
+	for i := 0; i < 3; i++ {
+		if _, err := l.Allow(ctx, subject); err != nil {
+			t.Fatalf("Allow() #%d while disabled error = %v", i, err)
+		}
+	}
+
+	l.Reload(layeredConfig())
+	if _, err := l.Allow(ctx, subject); err != nil {
+		t.Fatalf("Allow() after reload error = %v", err)
+	}
+	var limited *RateLimitError
+	if _, err := l.Allow(ctx, subject); !errors.As(err, &limited) || limited.Rule != "template:heavy" {
+		t.Fatalf("Allow() after reload error = %v, want template rule rejection", err)
+	}
+
+	l.Reload(Config{})
+	if _, err := l.Allow(ctx, subject); err != nil {
+		t.Fatalf("Allow() after disabling error = %v", err)
+	}
+}
@@ -0,0 +1,420 @@
+package logql
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expr is any parsed LogQL expression.
+type Expr interface {
+	fmt.Stringer
+	expr()
+}
+
+// LogQuery is a stream selector followed by an optional pipeline.
+type LogQuery struct {
+	Matchers []*Matcher
+	Pipeline []Stage
+}
+
+func (*LogQuery) expr() {}
+
+func (q *LogQuery) String() string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, m := range q.Matchers {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(m.String())
+	}
+	b.WriteByte('}')
+	for _, stage := range q.Pipeline {
+		b.WriteByte(' ')
+		b.WriteString(stage.String())
+	}
+	return b.String()
+}
+
+// MatchType is the operator of a stream selector label matcher.
+type MatchType int
+
+const (
+	MatchEqual MatchType = iota
+	MatchNotEqual
+	MatchRegexp
+	MatchNotRegexp
+)
+
+func (t MatchType) String() string {
+	switch t {
+	case MatchNotEqual:
+		return "!="
+	case MatchRegexp:
+		return "=~"
+	case MatchNotRegexp:
+		return "!~"
+	default:
+		return "="
+	}
+}
+
+// Matcher is a single label matcher inside a stream selector.
+type Matcher struct {
+	Name  string
+	Type  MatchType
+	Value string
+}
+
+func (m *Matcher) String() string {
+	return m.Name + m.Type.String() + strconv.Quote(m.Value)
+}
+
+// Stage is a single step of a log pipeline.
+type Stage interface {
+	fmt.Stringer
+	stage()
+}
+
+// LineFilterOp is the operator of a line filter.
+type LineFilterOp int
+
+const (
+	LineContains LineFilterOp = iota
+	LineNotContains
+	LineMatch
+	LineNotMatch
+)
+
+func (op LineFilterOp) String() string {
+	switch op {
+	case LineNotContains:
+		return "!="
+	case LineMatch:
+		return "|~"
+	case LineNotMatch:
+		return "!~"
+	default:
+		return "|="
+	}
+}
+
+// LineFilter keeps or drops log lines by substring or regular expression.
+type LineFilter struct {
+	Op    LineFilterOp
+	Value string
+}
+
+func (*LineFilter) stage() {}
+
+func (f *LineFilter) String() string {
+	return f.Op.String() + " " + strconv.Quote(f.Value)
+}
+
+// ParserKind names a label extraction parser.
+type ParserKind string
+
+const (
+	ParserJSON    ParserKind = "json"
+	ParserLogfmt  ParserKind = "logfmt"
+	ParserRegexp  ParserKind = "regexp"
+	ParserPattern ParserKind = "pattern"
+	ParserUnpack  ParserKind = "unpack"
+)
+
+// LabelExtraction maps an extracted label to a parser specific expression,
+// e.g. `| json status="response.status"`.
+type LabelExtraction struct {
+	Name       string
+	Expression string
+}
+
+// ParserStage extracts labels from the log line.
+type ParserStage struct {
+	Parser ParserKind
+	// Expression holds the pattern of the regexp and pattern parsers.
+	Expression string
+	Params     []LabelExtraction
+}
+
+func (*ParserStage) stage() {}
+
+func (p *ParserStage) String() string {
+	var b strings.Builder
+	b.WriteString("| ")
+	b.WriteString(string(p.Parser))
+	if p.Expression != "" {
+		b.WriteByte(' ')
+		b.WriteString(strconv.Quote(p.Expression))
+	}
+	for i, param := range p.Params {
+		if i == 0 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteString(", ")
+		}
+		b.WriteString(param.Name + "=" + strconv.Quote(param.Expression))
+	}
+	return b.String()
+}
+
+// LabelFilterStage keeps lines whose labels satisfy Filter.
+type LabelFilterStage struct {
+	Filter LabelFilter
+}
+
+func (*LabelFilterStage) stage() {}
+
+func (s *LabelFilterStage) String() string {
+	return "| " + s.Filter.String()
+}
+
+// LabelFormatRule renames a label (Src is a label name) or renders a
+// template into it (Template is set).
+type LabelFormatRule struct {
+	Dst      string
+	Src      string
+	Template string
+}
+
+// LabelFormatStage implements `| label_format dst=src, dst2="{{.x}}"`.
+type LabelFormatStage struct {
+	Rules []LabelFormatRule
+}
+
+func (*LabelFormatStage) stage() {}
+
+func (s *LabelFormatStage) String() string {
+	parts := make([]string, 0, len(s.Rules))
+	for _, r := range s.Rules {
+		if r.Template != "" {
+			parts = append(parts, r.Dst+"="+strconv.Quote(r.Template))
+			continue
+		}
+		parts = append(parts, r.Dst+"="+r.Src)
+	}
+	return "| label_format " + strings.Join(parts, ", ")
+}
+
+// LineFormatStage rewrites the log line from a template.
+type LineFormatStage struct {
+	Template string
+}
+
+func (*LineFormatStage) stage() {}
+
+func (s *LineFormatStage) String() string {
+	return "| line_format " + strconv.Quote(s.Template)
+}
+
+// DropStage removes labels from the result.
+type DropStage struct {
+	Names []string
+}
+
+func (*DropStage) stage() {}
+
+func (s *DropStage) String() string {
+	return "| drop " + strings.Join(s.Names, ", ")
+}
+
+// KeepStage removes every label except Names from the result.
+type KeepStage struct {
+	Names []string
+}
+
+func (*KeepStage) stage() {}
+
+func (s *KeepStage) String() string {
+	return "| keep " + strings.Join(s.Names, ", ")
+}
+
+// LabelFilter is a boolean expression over labels.
+type LabelFilter interface {
+	fmt.Stringer
+	labelFilter()
+}
+
+// CompareOp is the operator of a label comparison.
+type CompareOp int
+
+const (
+	CompareEqual CompareOp = iota
+	CompareNotEqual
+	CompareRegexp
+	CompareNotRegexp
+	CompareGreater
+	CompareGreaterEqual
+	CompareLess
+	CompareLessEqual
+)
+
+func (op CompareOp) String() string {
+	switch op {
+	case CompareNotEqual:
+		return "!="
+	case CompareRegexp:
+		return "=~"
+	case CompareNotRegexp:
+		return "!~"
+	case CompareGreater:
+		return ">"
+	case CompareGreaterEqual:
+		return ">="
+	case CompareLess:
+		return "<"
+	case CompareLessEqual:
+		return "<="
+	default:
+		return "="
+	}
+}
+
+// ValueKind is the literal type on the right hand side of a comparison.
+type ValueKind int
+
+const (
+	ValueString ValueKind = iota
+	ValueNumber
+	ValueDuration
+	ValueBytes
+)
+
+// Value is a typed literal. Number holds the numeric value of numbers,
+// durations (in seconds) and byte sizes (in bytes).
+type Value struct {
+	Kind   ValueKind
+	Raw    string
+	Number float64
+}
+
+func (v Value) String() string {
+	if v.Kind == ValueString {
+		return strconv.Quote(v.Raw)
+	}
+	return v.Raw
+}
+
+// LabelComparison compares a label against a literal.
+type LabelComparison struct {
+	Name  string
+	Op    CompareOp
+	Value Value
+}
+
+func (*LabelComparison) labelFilter() {}
+
+func (c *LabelComparison) String() string {
+	return c.Name + c.Op.String() + c.Value.String()
+}
+
+// BinaryLabelFilter combines two label filters with "and" or "or".
+type BinaryLabelFilter struct {
+	Or    bool
+	Left  LabelFilter
+	Right LabelFilter
+}
+
+func (*BinaryLabelFilter) labelFilter() {}
+
+func (f *BinaryLabelFilter) String() string {
+	op := " and "
+	if f.Or {
+		op = " or "
+	}
+	return "(" + f.Left.String() + op + f.Right.String() + ")"
+}
+
+// UnwrapStage selects the label whose numeric value feeds unwrapped range
+// aggregations such as sum_over_time.
+type UnwrapStage struct {
+	Label string
+}
+
+func (*UnwrapStage) stage() {}
+
+func (s *UnwrapStage) String() string {
+	return "| unwrap " + s.Label
+}
+
+// RangeAggregation applies a range function over a log query, e.g.
+// count_over_time({app="x"}[5m]).
+type RangeAggregation struct {
+	Op    string
+	Query *LogQuery
+	Range time.Duration
+}
+
+func (*RangeAggregation) expr() {}
+
+func (r *RangeAggregation) String() string {
+	return fmt.Sprintf("%s(%s [%s])", r.Op, r.Query, formatDuration(r.Range))
+}
+
+// Unwrap returns the unwrapped label of the aggregated query, if any.
+func (r *RangeAggregation) Unwrap() string {
+	for _, stage := range r.Query.Pipeline {
+		if u, ok := stage.(*UnwrapStage); ok {
+			return u.Label
+		}
+	}
+	return ""
+}
+
+// Grouping is the by/without clause of a vector aggregation.
+type Grouping struct {
+	Without bool
+	Labels  []string
+}
+
+func (g *Grouping) String() string {
+	if g == nil {
+		return ""
+	}
+	kw := "by"
+	if g.Without {
+		kw = "without"
+	}
+	return fmt.Sprintf(" %s (%s)", kw, strings.Join(g.Labels, ", "))
+}
+
+// VectorAggregation aggregates the series of a metric expression, e.g.
+// sum by (level) (...).
+type VectorAggregation struct {
+	Op       string
+	Grouping *Grouping
+	Expr     Expr
+}
+
+func (*VectorAggregation) expr() {}
+
+func (v *VectorAggregation) String() string {
+	return fmt.Sprintf("%s%s (%s)", v.Op, v.Grouping, v.Expr)
+}
+
+// Range aggregation and vector aggregation operators.
+var (
+	RangeOps  = []string{"count_over_time", "rate", "bytes_over_time", "bytes_rate", "sum_over_time", "avg_over_time", "min_over_time", "max_over_time"}
+	VectorOps = []string{"sum", "avg", "min", "max", "count"}
+)
+
+func isRangeOp(name string) bool  { return slices.Contains(RangeOps, name) }
+func isVectorOp(name string) bool { return slices.Contains(VectorOps, name) }
+
+func formatDuration(d time.Duration) string {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	case d%time.Second == 0:
+		return fmt.Sprintf("%ds", d/time.Second)
+	default:
+		return d.String()
+	}
+}
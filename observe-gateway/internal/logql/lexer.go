@@ -0,0 +1,283 @@
+package logql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// tokenKind enumerates the lexical tokens understood by the parser.
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokDuration
+	tokBytes
+	tokLBrace
+	tokRBrace
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokComma
+	tokPipe
+	tokPipeExact // |=
+	tokPipeMatch // |~
+	tokEq        // =
+	tokEqEq      // ==
+	tokNeq       // !=
+	tokRe        // =~
+	tokNre       // !~
+	tokGt
+	tokGte
+	tokLt
+	tokLte
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokEOF:
+		return "end of query"
+	case tokIdent:
+		return "identifier"
+	case tokString:
+		return "string"
+	case tokNumber:
+		return "number"
+	case tokDuration:
+		return "duration"
+	case tokBytes:
+		return "bytes"
+	case tokLBrace:
+		return "{"
+	case tokRBrace:
+		return "}"
+	case tokLParen:
+		return "("
+	case tokRParen:
+		return ")"
+	case tokLBracket:
+		return "["
+	case tokRBracket:
+		return "]"
+	case tokComma:
+		return ","
+	case tokPipe:
+		return "|"
+	case tokPipeExact:
+		return "|="
+	case tokPipeMatch:
+		return "|~"
+	case tokEq:
+		return "="
+	case tokEqEq:
+		return "=="
+	case tokNeq:
+		return "!="
+	case tokRe:
+		return "=~"
+	case tokNre:
+		return "!~"
+	case tokGt:
+		return ">"
+	case tokGte:
+		return ">="
+	case tokLt:
+		return "<"
+	case tokLte:
+		return "<="
+	default:
+		return "unknown"
+	}
+}
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// ParseError reports a syntax error at a byte offset of the query.
+type ParseError struct {
+	Pos int
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("logql: parse error at position %d: %s", e.Pos, e.Msg)
+}
+
+var singleCharTokens = map[byte]tokenKind{
+	'{': tokLBrace, '}': tokRBrace,
+	'(': tokLParen, ')': tokRParen,
+	'[': tokLBracket, ']': tokRBracket,
+	',': tokComma, '|': tokPipe,
+	'=': tokEq, '>': tokGt, '<': tokLt,
+}
+
+type lexer struct {
+	input string
+	pos   int
+}
+
+func lex(input string) ([]token, error) {
+	l := &lexer{input: input}
+	var tokens []token
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, tok)
+		if tok.kind == tokEOF {
+			return tokens, nil
+		}
+	}
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipSpace()
+	if l.pos >= len(l.input) {
+		return token{kind: tokEOF, pos: l.pos}, nil
+	}
+
+	start := l.pos
+	ch := l.input[l.pos]
+	two := ""
+	if l.pos+1 < len(l.input) {
+		two = l.input[l.pos : l.pos+2]
+	}
+
+	switch two {
+	case "|=":
+		l.pos += 2
+		return token{kind: tokPipeExact, text: two, pos: start}, nil
+	case "|~":
+		l.pos += 2
+		return token{kind: tokPipeMatch, text: two, pos: start}, nil
+	case "!=":
+		l.pos += 2
+		return token{kind: tokNeq, text: two, pos: start}, nil
+	case "!~":
+		l.pos += 2
+		return token{kind: tokNre, text: two, pos: start}, nil
+	case "=~":
+		l.pos += 2
+		return token{kind: tokRe, text: two, pos: start}, nil
+	case "==":
+		l.pos += 2
+		return token{kind: tokEqEq, text: two, pos: start}, nil
+	case ">=":
+		l.pos += 2
+		return token{kind: tokGte, text: two, pos: start}, nil
+	case "<=":
+		l.pos += 2
+		return token{kind: tokLte, text: two, pos: start}, nil
+	}
+
+	if kind, ok := singleCharTokens[ch]; ok {
+		l.pos++
+		return token{kind: kind, text: string(ch), pos: start}, nil
+	}
+
+	switch {
+	case ch == '"' || ch == '`':
+		return l.lexString()
+	case isDigit(ch) || (ch == '.' && l.pos+1 < len(l.input) && isDigit(l.input[l.pos+1])):
+		return l.lexNumber()
+	case isIdentStart(ch):
+		for l.pos < len(l.input) && isIdentPart(l.input[l.pos]) {
+			l.pos++
+		}
+		return token{kind: tokIdent, text: l.input[start:l.pos], pos: start}, nil
+	}
+
+	r, _ := utf8.DecodeRuneInString(l.input[l.pos:])
+	return token{}, &ParseError{Pos: start, Msg: fmt.Sprintf("unexpected character %q", r)}
+}
+
+func (l *lexer) skipSpace() {
+	for l.pos < len(l.input) {
+		r, size := utf8.DecodeRuneInString(l.input[l.pos:])
+		if !unicode.IsSpace(r) {
+			return
+		}
+		l.pos += size
+	}
+}
+
+func (l *lexer) lexString() (token, error) {
+	start := l.pos
+	quote := l.input[l.pos]
+	l.pos++
+
+	if quote == '`' {
+		end := strings.IndexByte(l.input[l.pos:], '`')
+		if end == -1 {
+			return token{}, &ParseError{Pos: start, Msg: "unterminated raw string"}
+		}
+		text := l.input[l.pos : l.pos+end]
+		l.pos += end + 1
+		return token{kind: tokString, text: text, pos: start}, nil
+	}
+
+	for l.pos < len(l.input) {
+		switch l.input[l.pos] {
+		case '\\':
+			l.pos += 2
+		case '"':
+			l.pos++
+			text, err := strconv.Unquote(l.input[start:l.pos])
+			if err != nil {
+				return token{}, &ParseError{Pos: start, Msg: "invalid string literal"}
+			}
+			return token{kind: tokString, text: text, pos: start}, nil
+		default:
+			l.pos++
+		}
+	}
+	return token{}, &ParseError{Pos: start, Msg: "unterminated string"}
+}
+
+// lexNumber scans numbers and the duration (5m, 1h30m) and byte size (10KB)
+// literals that share a numeric prefix.
+func (l *lexer) lexNumber() (token, error) {
+	start := l.pos
+	for l.pos < len(l.input) && (isDigit(l.input[l.pos]) || l.input[l.pos] == '.') {
+		l.pos++
+	}
+	if l.pos >= len(l.input) || !isUnitChar(l.input[l.pos]) {
+		return token{kind: tokNumber, text: l.input[start:l.pos], pos: start}, nil
+	}
+
+	for l.pos < len(l.input) && (isUnitChar(l.input[l.pos]) || isDigit(l.input[l.pos]) || l.input[l.pos] == '.') {
+		l.pos++
+	}
+	text := l.input[start:l.pos]
+	if _, err := ParseDuration(text); err == nil {
+		return token{kind: tokDuration, text: text, pos: start}, nil
+	}
+	if _, err := ParseBytes(text); err == nil {
+		return token{kind: tokBytes, text: text, pos: start}, nil
+	}
+	return token{}, &ParseError{Pos: start, Msg: fmt.Sprintf("invalid number literal %q", text)}
+}
+
+func isDigit(ch byte) bool { return ch >= '0' && ch <= '9' }
+
+func isUnitChar(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func isIdentStart(ch byte) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func isIdentPart(ch byte) bool {
+	return isIdentStart(ch) || isDigit(ch)
+}
@@ -0,0 +1,613 @@
+// Package logql implements a lexer and parser for the LogQL query language
+// that produces an AST for the gateway translators.
+package logql
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseExpr parses a LogQL query into its AST.
+func ParseExpr(input string) (Expr, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, "unexpected %s after query", describe(tok))
+	}
+	return expr, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) peekAt(offset int) token {
+	if p.pos+offset >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos+offset]
+}
+
+func (p *parser) advance() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) expect(kind tokenKind) (token, error) {
+	tok := p.advance()
+	if tok.kind != kind {
+		return tok, p.errorf(tok, "expected %s, got %s", kind, describe(tok))
+	}
+	return tok, nil
+}
+
+func (p *parser) errorf(tok token, format string, args ...any) error {
+	return &ParseError{Pos: tok.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func describe(tok token) string {
+	switch tok.kind {
+	case tokEOF:
+		return tok.kind.String()
+	case tokString:
+		return "string " + strconv.Quote(tok.text)
+	default:
+		return fmt.Sprintf("%s %q", tok.kind, tok.text)
+	}
+}
+
+func (p *parser) parseExpr() (Expr, error) {
+	tok := p.peek()
+	switch {
+	case tok.kind == tokLBrace:
+		return p.parseLogQuery()
+	case tok.kind == tokLParen:
+		p.advance()
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	case tok.kind == tokIdent && isRangeOp(tok.text):
+		return p.parseRangeAggregation()
+	case tok.kind == tokIdent && isVectorOp(tok.text):
+		return p.parseVectorAggregation()
+	case tok.kind == tokIdent:
+		return nil, p.errorf(tok, "unknown function %q", tok.text)
+	}
+	return nil, p.errorf(tok, "expected stream selector, got %s", describe(tok))
+}
+
+func (p *parser) parseRangeAggregation() (Expr, error) {
+	op := p.advance()
+	if _, err := p.expect(tokLParen); err != nil {
+		return nil, err
+	}
+	q, err := p.parseLogQuery()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokLBracket); err != nil {
+		return nil, err
+	}
+	rangeTok, err := p.expect(tokDuration)
+	if err != nil {
+		return nil, err
+	}
+	rng, _ := ParseDuration(rangeTok.text)
+	if rng <= 0 {
+		return nil, p.errorf(rangeTok, "range must be positive")
+	}
+	if _, err := p.expect(tokRBracket); err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokRParen); err != nil {
+		return nil, err
+	}
+
+	agg := &RangeAggregation{Op: op.text, Query: q, Range: rng}
+	unwrapped := agg.Unwrap() != ""
+	switch op.text {
+	case "sum_over_time", "avg_over_time", "min_over_time", "max_over_time":
+		if !unwrapped {
+			return nil, p.errorf(op, "%s requires an unwrap stage", op.text)
+		}
+	case "rate":
+	default:
+		if unwrapped {
+			return nil, p.errorf(op, "%s does not accept an unwrap stage", op.text)
+		}
+	}
+	return agg, nil
+}
+
+func (p *parser) parseVectorAggregation() (Expr, error) {
+	op := p.advance()
+	grouping, err := p.parseGrouping()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokLParen); err != nil {
+		return nil, err
+	}
+	inner, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := inner.(*LogQuery); ok {
+		return nil, p.errorf(op, "%s requires a metric expression, got a log query", op.text)
+	}
+	if _, err := p.expect(tokRParen); err != nil {
+		return nil, err
+	}
+	if grouping == nil {
+		if grouping, err = p.parseGrouping(); err != nil {
+			return nil, err
+		}
+	}
+	return &VectorAggregation{Op: op.text, Grouping: grouping, Expr: inner}, nil
+}
+
+func (p *parser) parseGrouping() (*Grouping, error) {
+	tok := p.peek()
+	if tok.kind != tokIdent || (tok.text != "by" && tok.text != "without") {
+		return nil, nil
+	}
+	p.advance()
+	if _, err := p.expect(tokLParen); err != nil {
+		return nil, err
+	}
+	g := &Grouping{Without: tok.text == "without"}
+	if p.peek().kind == tokRParen {
+		p.advance()
+		return g, nil
+	}
+	names, err := p.parseNameList()
+	if err != nil {
+		return nil, err
+	}
+	g.Labels = names
+	if _, err := p.expect(tokRParen); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (p *parser) parseLogQuery() (*LogQuery, error) {
+	matchers, err := p.parseSelector()
+	if err != nil {
+		return nil, err
+	}
+	q := &LogQuery{Matchers: matchers}
+	for {
+		stage, ok, err := p.parseStage()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return q, nil
+		}
+		q.Pipeline = append(q.Pipeline, stage)
+	}
+}
+
+func (p *parser) parseSelector() ([]*Matcher, error) {
+	if _, err := p.expect(tokLBrace); err != nil {
+		return nil, err
+	}
+	var matchers []*Matcher
+	for {
+		if p.peek().kind == tokRBrace && len(matchers) > 0 {
+			p.advance()
+			return matchers, nil
+		}
+		name, err := p.expect(tokIdent)
+		if err != nil {
+			return nil, err
+		}
+		opTok := p.advance()
+		var typ MatchType
+		switch opTok.kind {
+		case tokEq:
+			typ = MatchEqual
+		case tokNeq:
+			typ = MatchNotEqual
+		case tokRe:
+			typ = MatchRegexp
+		case tokNre:
+			typ = MatchNotRegexp
+		default:
+			return nil, p.errorf(opTok, "expected label matcher operator, got %s", describe(opTok))
+		}
+		value, err := p.expect(tokString)
+		if err != nil {
+			return nil, err
+		}
+		if typ == MatchRegexp || typ == MatchNotRegexp {
+			if _, err := regexp.Compile(value.text); err != nil {
+				return nil, p.errorf(value, "invalid regexp %q: %v", value.text, err)
+			}
+		}
+		matchers = append(matchers, &Matcher{Name: name.text, Type: typ, Value: value.text})
+
+		switch tok := p.advance(); tok.kind {
+		case tokComma:
+		case tokRBrace:
+			return matchers, nil
+		default:
+			return nil, p.errorf(tok, "expected , or } in stream selector, got %s", describe(tok))
+		}
+	}
+}
+
+// parseStage parses the next pipeline stage. ok is false when the pipeline
+// has ended.
+func (p *parser) parseStage() (Stage, bool, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case tokPipeExact, tokNeq, tokPipeMatch, tokNre:
+		p.advance()
+		value, err := p.expect(tokString)
+		if err != nil {
+			return nil, false, err
+		}
+		filter := &LineFilter{Value: value.text}
+		switch tok.kind {
+		case tokNeq:
+			filter.Op = LineNotContains
+		case tokPipeMatch:
+			filter.Op = LineMatch
+		case tokNre:
+			filter.Op = LineNotMatch
+		}
+		if filter.Op == LineMatch || filter.Op == LineNotMatch {
+			if _, err := regexp.Compile(value.text); err != nil {
+				return nil, false, p.errorf(value, "invalid regexp %q: %v", value.text, err)
+			}
+		}
+		return filter, true, nil
+	case tokPipe:
+		p.advance()
+		stage, err := p.parsePipeStage()
+		return stage, err == nil, err
+	default:
+		return nil, false, nil
+	}
+}
+
+func (p *parser) parsePipeStage() (Stage, error) {
+	tok := p.peek()
+	if tok.kind != tokIdent {
+		if tok.kind == tokLParen {
+			filter, err := p.parseLabelFilter()
+			if err != nil {
+				return nil, err
+			}
+			return &LabelFilterStage{Filter: filter}, nil
+		}
+		return nil, p.errorf(tok, "expected pipeline stage, got %s", describe(tok))
+	}
+
+	// A stage keyword followed by a comparison operator is a label filter
+	// on a label that happens to share the keyword's name.
+	if isComparison(p.peekAt(1).kind) {
+		filter, err := p.parseLabelFilter()
+		if err != nil {
+			return nil, err
+		}
+		return &LabelFilterStage{Filter: filter}, nil
+	}
+
+	switch tok.text {
+	case "json", "logfmt", "unpack":
+		p.advance()
+		stage := &ParserStage{Parser: ParserKind(tok.text)}
+		if tok.text == "json" {
+			params, err := p.parseExtractions()
+			if err != nil {
+				return nil, err
+			}
+			stage.Params = params
+		}
+		return stage, nil
+	case "regexp", "pattern":
+		p.advance()
+		expr, err := p.expect(tokString)
+		if err != nil {
+			return nil, err
+		}
+		if tok.text == "regexp" {
+			if _, err := regexp.Compile(expr.text); err != nil {
+				return nil, p.errorf(expr, "invalid regexp %q: %v", expr.text, err)
+			}
+		}
+		return &ParserStage{Parser: ParserKind(tok.text), Expression: expr.text}, nil
+	case "label_format":
+		p.advance()
+		return p.parseLabelFormat()
+	case "line_format":
+		p.advance()
+		tmpl, err := p.expect(tokString)
+		if err != nil {
+			return nil, err
+		}
+		return &LineFormatStage{Template: tmpl.text}, nil
+	case "unwrap":
+		p.advance()
+		label, err := p.expect(tokIdent)
+		if err != nil {
+			return nil, err
+		}
+		return &UnwrapStage{Label: label.text}, nil
+	case "drop", "keep":
+		p.advance()
+		names, err := p.parseNameList()
+		if err != nil {
+			return nil, err
+		}
+		if tok.text == "drop" {
+			return &DropStage{Names: names}, nil
+		}
+		return &KeepStage{Names: names}, nil
+	}
+
+	filter, err := p.parseLabelFilter()
+	if err != nil {
+		return nil, err
+	}
+	return &LabelFilterStage{Filter: filter}, nil
+}
+
+func (p *parser) parseExtractions() ([]LabelExtraction, error) {
+	var params []LabelExtraction
+	for p.peek().kind == tokIdent && p.peekAt(1).kind == tokEq {
+		name := p.advance()
+		p.advance()
+		value, err := p.expect(tokString)
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, LabelExtraction{Name: name.text, Expression: value.text})
+		if p.peek().kind != tokComma {
+			break
+		}
+		p.advance()
+	}
+	return params, nil
+}
+
+func (p *parser) parseLabelFormat() (Stage, error) {
+	stage := &LabelFormatStage{}
+	for {
+		dst, err := p.expect(tokIdent)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokEq); err != nil {
+			return nil, err
+		}
+		src := p.advance()
+		switch src.kind {
+		case tokIdent:
+			stage.Rules = append(stage.Rules, LabelFormatRule{Dst: dst.text, Src: src.text})
+		case tokString:
+			stage.Rules = append(stage.Rules, LabelFormatRule{Dst: dst.text, Template: src.text})
+		default:
+			return nil, p.errorf(src, "expected label name or template, got %s", describe(src))
+		}
+		if p.peek().kind != tokComma {
+			return stage, nil
+		}
+		p.advance()
+	}
+}
+
+func (p *parser) parseNameList() ([]string, error) {
+	var names []string
+	for {
+		name, err := p.expect(tokIdent)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name.text)
+		if p.peek().kind != tokComma {
+			return names, nil
+		}
+		p.advance()
+	}
+}
+
+// parseLabelFilter parses `a="x" and (b>1 or c!~"y")`. A comma or plain
+// juxtaposition binds like "and".
+func (p *parser) parseLabelFilter() (LabelFilter, error) {
+	left, err := p.parseLabelFilterAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokIdent && p.peek().text == "or" {
+		p.advance()
+		right, err := p.parseLabelFilterAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryLabelFilter{Or: true, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseLabelFilterAnd() (LabelFilter, error) {
+	left, err := p.parseLabelFilterPrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		switch {
+		case tok.kind == tokComma, tok.kind == tokIdent && tok.text == "and":
+			p.advance()
+		case tok.kind == tokLParen, tok.kind == tokIdent && tok.text != "or" && isComparison(p.peekAt(1).kind):
+		default:
+			return left, nil
+		}
+		right, err := p.parseLabelFilterPrimary()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryLabelFilter{Left: left, Right: right}
+	}
+}
+
+func (p *parser) parseLabelFilterPrimary() (LabelFilter, error) {
+	if p.peek().kind == tokLParen {
+		p.advance()
+		filter, err := p.parseLabelFilter()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen); err != nil {
+			return nil, err
+		}
+		return filter, nil
+	}
+
+	name, err := p.expect(tokIdent)
+	if err != nil {
+		return nil, err
+	}
+	opTok := p.advance()
+	if !isComparison(opTok.kind) {
+		return nil, p.errorf(opTok, "expected comparison operator after %q, got %s", name.text, describe(opTok))
+	}
+	op := compareOps[opTok.kind]
+
+	valTok := p.advance()
+	var value Value
+	switch valTok.kind {
+	case tokString:
+		value = Value{Kind: ValueString, Raw: valTok.text}
+	case tokNumber:
+		n, err := strconv.ParseFloat(valTok.text, 64)
+		if err != nil {
+			return nil, p.errorf(valTok, "invalid number %q", valTok.text)
+		}
+		value = Value{Kind: ValueNumber, Raw: valTok.text, Number: n}
+	case tokDuration:
+		d, _ := ParseDuration(valTok.text)
+		value = Value{Kind: ValueDuration, Raw: valTok.text, Number: d.Seconds()}
+	case tokBytes:
+		b, _ := ParseBytes(valTok.text)
+		value = Value{Kind: ValueBytes, Raw: valTok.text, Number: float64(b)}
+	default:
+		return nil, p.errorf(valTok, "expected value after %s, got %s", opTok.text, describe(valTok))
+	}
+
+	switch op {
+	case CompareRegexp, CompareNotRegexp:
+		if value.Kind != ValueString {
+			return nil, p.errorf(valTok, "regexp comparison requires a string")
+		}
+		if _, err := regexp.Compile(value.Raw); err != nil {
+			return nil, p.errorf(valTok, "invalid regexp %q: %v", value.Raw, err)
+		}
+	case CompareGreater, CompareGreaterEqual, CompareLess, CompareLessEqual:
+		if value.Kind == ValueString {
+			return nil, p.errorf(valTok, "%s comparison requires a numeric value", opTok.text)
+		}
+	}
+
+	return &LabelComparison{Name: name.text, Op: op, Value: value}, nil
+}
+
+var compareOps = map[tokenKind]CompareOp{
+	tokEq:   CompareEqual,
+	tokEqEq: CompareEqual,
+	tokNeq:  CompareNotEqual,
+	tokRe:   CompareRegexp,
+	tokNre:  CompareNotRegexp,
+	tokGt:   CompareGreater,
+	tokGte:  CompareGreaterEqual,
+	tokLt:   CompareLess,
+	tokLte:  CompareLessEqual,
+}
+
+func isComparison(kind tokenKind) bool {
+	_, ok := compareOps[kind]
+	return ok
+}
+
+// ParseDuration parses Go style durations (1h30m, 250ms) and the
+// Prometheus d/w/y units.
+func ParseDuration(s string) (time.Duration, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, nil
+	}
+	m := promDurationRe.FindStringSubmatch(s)
+	if m == nil || s == "" {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	units := []time.Duration{365 * 24 * time.Hour, 7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second, time.Millisecond}
+	var total time.Duration
+	for i, unit := range units {
+		if m[i+1] == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(m[i+1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		total += time.Duration(n) * unit
+	}
+	return total, nil
+}
+
+var promDurationRe = regexp.MustCompile(`^(?:(\d+)y)?(?:(\d+)w)?(?:(\d+)d)?(?:(\d+)h)?(?:(\d+)m)?(?:(\d+)s)?(?:(\d+)ms)?$`)
+
+// ParseBytes parses human readable byte sizes such as 10KB or 1.5MiB.
+func ParseBytes(s string) (uint64, error) {
+	idx := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if idx <= 0 {
+		return 0, fmt.Errorf("invalid byte size %q", s)
+	}
+	n, err := strconv.ParseFloat(s[:idx], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid byte size %q", s)
+	}
+	mult, ok := byteUnits[strings.ToLower(s[idx:])]
+	if !ok {
+		return 0, fmt.Errorf("invalid byte size %q", s)
+	}
+	return uint64(n * float64(mult)), nil
+}
+
+var byteUnits = map[string]uint64{
+	"b":   1,
+	"kb":  1000,
+	"kib": 1 << 10,
+	"mb":  1000 * 1000,
+	"mib": 1 << 20,
+	"gb":  1000 * 1000 * 1000,
+	"gib": 1 << 30,
+	"tb":  1000 * 1000 * 1000 * 1000,
+	"tib": 1 << 40,
+}
@@ -0,0 +1,128 @@
+package logql
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseExprLogQuery(t *testing.T) {
+	expr, err := ParseExpr(`{service="api", env=~"prod|staging", msg="a,b"} |= "error" != "timeout" | json | level="error" and status >= 500 | label_format svc=service`)
+	if err != nil {
+		t.Fatalf("ParseExpr() error = %v", err)
+	}
+	q, ok := expr.(*LogQuery)
+	if !ok {
+		t.Fatalf("expr = %T, want *LogQuery", expr)
+	}
+	if len(q.Matchers) != 3 {
+		t.Fatalf("matchers = %d, want 3", len(q.Matchers))
+	}
+	if q.Matchers[1].Type != MatchRegexp || q.Matchers[1].Value != "prod|staging" {
+		t.Fatalf("matcher[1] = %s", q.Matchers[1])
+	}
+	if q.Matchers[2].Value != "a,b" {
+		t.Fatalf("matcher[2] value = %q, want a,b", q.Matchers[2].Value)
+	}
+	if len(q.Pipeline) != 5 {
+		t.Fatalf("pipeline = %d stages, want 5", len(q.Pipeline))
+	}
+	if f, ok := q.Pipeline[1].(*LineFilter); !ok || f.Op != LineNotContains {
+		t.Fatalf("stage[1] = %s, want != line filter", q.Pipeline[1])
+	}
+	if p, ok := q.Pipeline[2].(*ParserStage); !ok || p.Parser != ParserJSON {
+		t.Fatalf("stage[2] = %s, want json parser", q.Pipeline[2])
+	}
+	filter, ok := q.Pipeline[3].(*LabelFilterStage)
+	if !ok {
+		t.Fatalf("stage[3] = %T, want *LabelFilterStage", q.Pipeline[3])
+	}
+	bin, ok := filter.Filter.(*BinaryLabelFilter)
+	if !ok || bin.Or {
+		t.Fatalf("filter = %s, want and", filter.Filter)
+	}
+	if cmp := bin.Right.(*LabelComparison); cmp.Op != CompareGreaterEqual || cmp.Value.Kind != ValueNumber || cmp.Value.Number != 500 {
+		t.Fatalf("right = %s", cmp)
+	}
+	if lf, ok := q.Pipeline[4].(*LabelFormatStage); !ok || lf.Rules[0].Dst != "svc" || lf.Rules[0].Src != "service" {
+		t.Fatalf("stage[4] = %s", q.Pipeline[4])
+	}
+}
+
+func TestParseExprLabelFilterValues(t *testing.T) {
+	expr, err := ParseExpr("{app=\"x\"} | logfmt | (latency > 250ms or size >= 10KB) | msg=`raw \"quoted\"`")
+	if err != nil {
+		t.Fatalf("ParseExpr() error = %v", err)
+	}
+	q := expr.(*LogQuery)
+	bin := q.Pipeline[1].(*LabelFilterStage).Filter.(*BinaryLabelFilter)
+	if !bin.Or {
+		t.Fatalf("filter = %s, want or", bin)
+	}
+	if v := bin.Left.(*LabelComparison).Value; v.Kind != ValueDuration || v.Number != 0.25 {
+		t.Fatalf("latency value = %+v", v)
+	}
+	if v := bin.Right.(*LabelComparison).Value; v.Kind != ValueBytes || v.Number != 10000 {
+		t.Fatalf("size value = %+v", v)
+	}
+	if v := q.Pipeline[2].(*LabelFilterStage).Filter.(*LabelComparison).Value.Raw; v != `raw "quoted"` {
+		t.Fatalf("raw string = %q", v)
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	cases := []string{
+		``,
+		`{}`,
+		`{service="api"`,
+		`{service=api}`,
+		`{service=~"("}`,
+		`{service="api"} |= `,
+		`{service="api"} | status > "x"`,
+		`{service="api"} | level`,
+		`{service="api"} extra`,
+	}
+	for _, input := range cases {
+		_, err := ParseExpr(input)
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Errorf("ParseExpr(%q) error = %v, want *ParseError", input, err)
+		}
+	}
+}
+
+func TestParseExprMetricQuery(t *testing.T) {
+	expr, err := ParseExpr(`sum by (level)(count_over_time({service="x"} |= "error" [5m]))`)
+	if err != nil {
+		t.Fatalf("ParseExpr() error = %v", err)
+	}
+	vec, ok := expr.(*VectorAggregation)
+	if !ok || vec.Op != "sum" || vec.Grouping == nil || vec.Grouping.Labels[0] != "level" {
+		t.Fatalf("expr = %s, want sum by (level)", expr)
+	}
+	rng, ok := vec.Expr.(*RangeAggregation)
+	if !ok || rng.Op != "count_over_time" || rng.Range != 5*time.Minute {
+		t.Fatalf("inner = %s, want count_over_time over 5m", vec.Expr)
+	}
+
+	expr, err = ParseExpr(`max(avg_over_time({service="x"} | logfmt | unwrap latency [1m])) without (pod)`)
+	if err != nil {
+		t.Fatalf("ParseExpr() error = %v", err)
+	}
+	vec = expr.(*VectorAggregation)
+	if !vec.Grouping.Without || vec.Expr.(*RangeAggregation).Unwrap() != "latency" {
+		t.Fatalf("expr = %s", expr)
+	}
+
+	for _, input := range []string{
+		`sum({service="x"})`,
+		`sum_over_time({service="x"}[5m])`,
+		`count_over_time({service="x"} | unwrap n [5m])`,
+		`count_over_time({service="x"})`,
+		`topk(3, count_over_time({service="x"}[5m]))`,
+	} {
+		if _, err := ParseExpr(input); err == nil {
+			t.Errorf("ParseExpr(%q) error = nil, want error", input)
+		}
+	}
+}
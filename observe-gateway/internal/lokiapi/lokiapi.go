@@ -0,0 +1,116 @@
+// Package lokiapi models the Loki HTTP API responses served by the gateway's
+// Loki facade. Metric queries share the Prometheus matrix format of promapi.
+package lokiapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/frame"
+	"github.com/xscopehub/observe-gateway/internal/promapi"
+)
+
+// ResultStreams is the result type of log queries.
+const ResultStreams = "streams"
+
+// Stream is a set of log entries sharing a label set.
+type Stream struct {
+	Labels map[string]string `json:"stream"`
+	Values []Entry           `json:"values"`
+}
+
+// Entry is a single log line.
+type Entry struct {
+	Time time.Time
+	Line string
+}
+
+// MarshalJSON encodes the entry as ["<unix nanoseconds>", "line"].
+func (e Entry) MarshalJSON() ([]byte, error) {
+	return json.Marshal([2]string{strconv.FormatInt(e.Time.UnixNano(), 10), e.Line})
+}
+
+// UnmarshalJSON decodes a ["<unix nanoseconds>", "line"] pair.
+func (e *Entry) UnmarshalJSON(data []byte) error {
+	var raw [2]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("lokiapi: entry: %w", err)
+	}
+	ns, err := strconv.ParseInt(raw[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("lokiapi: entry timestamp: %w", err)
+	}
+	e.Time, e.Line = time.Unix(0, ns).UTC(), raw[1]
+	return nil
+}
+
+// TailResponse is a message of the tail websocket.
+type TailResponse struct {
+	Streams        []Stream `json:"streams"`
+	DroppedEntries []any    `json:"dropped_entries"`
+}
+
+// NewStreams builds a successful streams response.
+func NewStreams(streams []Stream) (promapi.Response, error) {
+	if streams == nil {
+		streams = []Stream{}
+	}
+	raw, err := json.Marshal(streams)
+	if err != nil {
+		return promapi.Response{}, err
+	}
+	return promapi.Response{Status: "success", Data: promapi.Data{ResultType: ResultStreams, Result: raw}}, nil
+}
+
+// GroupRows groups log rows into streams by label set. Entries are ordered
+// oldest first when forward is set and newest first otherwise; streams are
+// ordered by their labels.
+func GroupRows(rows []frame.Row, forward bool) []Stream {
+	byKey := make(map[string]*Stream)
+	var keys []string
+	for _, row := range rows {
+		key := labelKey(row.Labels)
+		s, ok := byKey[key]
+		if !ok {
+			s = &Stream{Labels: row.Labels}
+			if s.Labels == nil {
+				s.Labels = map[string]string{}
+			}
+			byKey[key] = s
+			keys = append(keys, key)
+		}
+		s.Values = append(s.Values, Entry{Time: row.Timestamp, Line: row.Line})
+	}
+	sort.Strings(keys)
+
+	out := make([]Stream, 0, len(keys))
+	for _, key := range keys {
+		s := byKey[key]
+		sort.SliceStable(s.Values, func(i, j int) bool {
+			if forward {
+				return s.Values[i].Time.Before(s.Values[j].Time)
+			}
+			return s.Values[i].Time.After(s.Values[j].Time)
+		})
+		out = append(out, *s)
+	}
+	return out
+}
+
+// labelKey renders a label set in the canonical {a="1", b="2"} form.
+func labelKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + strconv.Quote(labels[name])
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
@@ -0,0 +1,44 @@
+package lokiapi
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/frame"
+)
+
+func TestGroupRows(t *testing.T) {
+	t0 := time.Unix(1700000000, 0).UTC()
+	rows := []frame.Row{
+		{Timestamp: t0, Labels: map[string]string{"app": "web"}, Line: "a"},
+		{Timestamp: t0.Add(time.Second), Labels: map[string]string{"app": "api"}, Line: "b"},
+		{Timestamp: t0.Add(2 * time.Second), Labels: map[string]string{"app": "web"}, Line: "c"},
+		{Timestamp: t0.Add(3 * time.Second), Line: "d"},
+	}
+
+	streams := GroupRows(rows, false)
+	payload, err := json.Marshal(streams)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	want := `[{"stream":{"app":"api"},"values":[["1700000001000000000","b"]]},` +
+		`{"stream":{"app":"web"},"values":[["1700000002000000000","c"],["1700000000000000000","a"]]},` +
+		`{"stream":{},"values":[["1700000003000000000","d"]]}]`
+	if string(payload) != want {
+		t.Fatalf("streams = %s, want %s", payload, want)
+	}
+
+	forward := GroupRows(rows, true)
+	if got := forward[1].Values; got[0].Line != "a" || got[1].Line != "c" {
+		t.Fatalf("forward values = %+v, want oldest first", got)
+	}
+
+	var decoded []Stream
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if got := decoded[1].Values[1]; !got.Time.Equal(t0) || got.Line != "a" {
+		t.Fatalf("decoded entry = %+v, want %v a", got, t0)
+	}
+}
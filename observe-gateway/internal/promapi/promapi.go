@@ -0,0 +1,195 @@
+// Package promapi models the Prometheus HTTP API response envelope shared by
+// the gateway translators, caches and API facades.
+package promapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Result types of the Prometheus query API.
+const (
+	ResultMatrix = "matrix"
+	ResultVector = "vector"
+	ResultScalar = "scalar"
+	ResultString = "string"
+)
+
+// Response is the standard Prometheus API envelope.
+type Response struct {
+	Status    string   `json:"status"`
+	Data      Data     `json:"data"`
+	ErrorType string   `json:"errorType,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	Warnings  []string `json:"warnings,omitempty"`
+}
+
+// Data holds the typed query result.
+type Data struct {
+	ResultType string          `json:"resultType"`
+	Result     json.RawMessage `json:"result"`
+}
+
+// Series is a single matrix series.
+type Series struct {
+	Metric map[string]string `json:"metric"`
+	Values []Sample          `json:"values"`
+}
+
+// VectorSample is a single instant vector element.
+type VectorSample struct {
+	Metric map[string]string `json:"metric"`
+	Value  Sample            `json:"value"`
+}
+
+// Sample is a [unix seconds, "value"] pair.
+type Sample struct {
+	T float64
+	V float64
+}
+
+// MarshalJSON encodes the sample in the Prometheus wire format.
+func (s Sample) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]any{s.T, FormatValue(s.V)})
+}
+
+// UnmarshalJSON decodes a [timestamp, "value"] pair.
+func (s *Sample) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if len(raw) != 2 {
+		return fmt.Errorf("promapi: sample must have 2 elements, got %d", len(raw))
+	}
+	if err := json.Unmarshal(raw[0], &s.T); err != nil {
+		return fmt.Errorf("promapi: sample timestamp: %w", err)
+	}
+	var value string
+	if err := json.Unmarshal(raw[1], &value); err != nil {
+		return fmt.Errorf("promapi: sample value: %w", err)
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("promapi: sample value: %w", err)
+	}
+	s.V = v
+	return nil
+}
+
+// FormatValue renders a float the way Prometheus does.
+func FormatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+}
+
+// NewMatrix builds a successful matrix response.
+func NewMatrix(series []Series) (Response, error) {
+	if series == nil {
+		series = []Series{}
+	}
+	raw, err := json.Marshal(series)
+	if err != nil {
+		return Response{}, err
+	}
+	return Response{Status: "success", Data: Data{ResultType: ResultMatrix, Result: raw}}, nil
+}
+
+// Parse decodes a Prometheus API response body.
+func Parse(body []byte) (Response, error) {
+	var resp Response
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return Response{}, fmt.Errorf("promapi: decode response: %w", err)
+	}
+	if resp.Status != "" && resp.Status != "success" {
+		return resp, fmt.Errorf("promapi: %s: %s", resp.ErrorType, resp.Error)
+	}
+	return resp, nil
+}
+
+// Matrix returns the series of a matrix response.
+func (r Response) Matrix() ([]Series, error) {
+	if r.Data.ResultType != ResultMatrix {
+		return nil, fmt.Errorf("promapi: result type %q is not a matrix", r.Data.ResultType)
+	}
+	var series []Series
+	if err := json.Unmarshal(r.Data.Result, &series); err != nil {
+		return nil, fmt.Errorf("promapi: decode matrix: %w", err)
+	}
+	return series, nil
+}
+
+// Vector returns the samples of a vector response.
+func (r Response) Vector() ([]VectorSample, error) {
+	if r.Data.ResultType != ResultVector {
+		return nil, fmt.Errorf("promapi: result type %q is not a vector", r.Data.ResultType)
+	}
+	var samples []VectorSample
+	if err := json.Unmarshal(r.Data.Result, &samples); err != nil {
+		return nil, fmt.Errorf("promapi: decode vector: %w", err)
+	}
+	return samples, nil
+}
+
+// MergeSeries joins series fetched for separate time ranges by label set,
+// keeping samples in time order and one sample per timestamp.
+func MergeSeries(parts [][]Series) []Series {
+	index := map[string]int{}
+	var out []Series
+	for _, part := range parts {
+		for _, s := range part {
+			key := labelsKey(s.Metric)
+			i, ok := index[key]
+			if !ok {
+				i = len(out)
+				index[key] = i
+				out = append(out, Series{Metric: s.Metric})
+			}
+			out[i].Values = append(out[i].Values, s.Values...)
+		}
+	}
+	for i := range out {
+		values := out[i].Values
+		sort.SliceStable(values, func(a, b int) bool { return values[a].T < values[b].T })
+		deduped := values[:0]
+		for _, v := range values {
+			if n := len(deduped); n > 0 && deduped[n-1].Millis() == v.Millis() {
+				continue
+			}
+			deduped = append(deduped, v)
+		}
+		out[i].Values = deduped
+	}
+	sort.SliceStable(out, func(a, b int) bool { return labelsKey(out[a].Metric) < labelsKey(out[b].Metric) })
+	return out
+}
+
+// Millis returns the sample timestamp in Unix milliseconds.
+func (s Sample) Millis() int64 {
+	return int64(s.T*1000 + 0.5)
+}
+
+func labelsKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%q=%q,", k, labels[k])
+	}
+	return b.String()
+}
@@ -0,0 +1,119 @@
+// Package promlabel enforces label matchers on PromQL queries, in the manner
+// of prom-label-proxy: every vector selector of a query is rewritten to carry
+// the enforced matchers, so a tenant can only read its own series.
+package promlabel
+
+import (
+	"fmt"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// ConflictError reports a query matcher that contradicts an enforced one.
+type ConflictError struct {
+	Label string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("label %q is enforced by the gateway and cannot be overridden", e.Label)
+}
+
+// ParseMatchers parses a selector such as `{cluster="eu",env=~"prod|stage"}`
+// into matchers.
+func ParseMatchers(selector string) ([]*labels.Matcher, error) {
+	matchers, err := parser.ParseMetricSelector(selector)
+	if err != nil {
+		return nil, fmt.Errorf("parse label matchers %q: %w", selector, err)
+	}
+	return matchers, nil
+}
+
+// Enforce parses query and adds matchers to each of its vector selectors.
+// A selector that already carries an identical matcher is left as is; one
+// that matches an enforced label differently is rejected with a
+// ConflictError.
+func Enforce(query string, matchers []*labels.Matcher) (string, error) {
+	if len(matchers) == 0 {
+		return query, nil
+	}
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		return "", err
+	}
+
+	enforced := byName(matchers)
+	err = inspect(expr, func(vs *parser.VectorSelector) error {
+		return enforce(vs, matchers, enforced)
+	})
+	if err != nil {
+		return "", err
+	}
+	return expr.String(), nil
+}
+
+// EnforceSelector parses a series selector, such as a match[] parameter of
+// the Prometheus metadata API, and adds matchers to it like Enforce.
+func EnforceSelector(selector string, matchers []*labels.Matcher) (string, error) {
+	parsed, err := parser.ParseMetricSelector(selector)
+	if err != nil {
+		return "", err
+	}
+	vs := &parser.VectorSelector{LabelMatchers: parsed}
+	for _, m := range parsed {
+		if m.Name == labels.MetricName && m.Type == labels.MatchEqual {
+			vs.Name = m.Value
+		}
+	}
+	if err := enforce(vs, matchers, byName(matchers)); err != nil {
+		return "", err
+	}
+	return vs.String(), nil
+}
+
+// Selector formats matchers as a series selector.
+func Selector(matchers []*labels.Matcher) string {
+	return (&parser.VectorSelector{LabelMatchers: matchers}).String()
+}
+
+func byName(matchers []*labels.Matcher) map[string]*labels.Matcher {
+	enforced := make(map[string]*labels.Matcher, len(matchers))
+	for _, m := range matchers {
+		enforced[m.Name] = m
+	}
+	return enforced
+}
+
+// enforce adds the matchers vs lacks and rejects those it contradicts.
+func enforce(vs *parser.VectorSelector, matchers []*labels.Matcher, enforced map[string]*labels.Matcher) error {
+	present := make(map[string]bool, len(matchers))
+	for _, m := range vs.LabelMatchers {
+		want, ok := enforced[m.Name]
+		if !ok {
+			continue
+		}
+		if m.Type != want.Type || m.Value != want.Value {
+			return &ConflictError{Label: m.Name}
+		}
+		present[m.Name] = true
+	}
+	for _, m := range matchers {
+		if !present[m.Name] {
+			vs.LabelMatchers = append(vs.LabelMatchers, m)
+		}
+	}
+	return nil
+}
+
+// inspect calls fn for every vector selector, including those inside matrix
+// selectors and subqueries, stopping at the first error.
+func inspect(expr parser.Expr, fn func(*parser.VectorSelector) error) error {
+	var err error
+	parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+		if vs, ok := node.(*parser.VectorSelector); ok && err == nil {
+			err = fn(vs)
+		}
+		return err
+	})
+	return err
+}
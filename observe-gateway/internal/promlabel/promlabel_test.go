@@ -0,0 +1,89 @@
+package promlabel
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+func TestEnforce(t *testing.T) {
+	enforced := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "tenant", "acme")}
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`up`, `up{tenant="acme"}`},
+		{`sum(rate(http_requests_total{job="api"}[5m])) by (code)`, `sum by (code) (rate(http_requests_total{job="api",tenant="acme"}[5m]))`},
+		{`a / on(instance) b offset 5m`, `a{tenant="acme"} / on (instance) b{tenant="acme"} offset 5m`},
+		{`max_over_time(rate(x[1m])[10m:1m])`, `max_over_time(rate(x{tenant="acme"}[1m])[10m:1m])`},
+		{`up{tenant="acme"}`, `up{tenant="acme"}`},
+		{`{__name__=~"node_.*"}`, `{__name__=~"node_.*",tenant="acme"}`},
+		{`vector(1)`, `vector(1)`},
+	}
+	for _, tt := range tests {
+		got, err := Enforce(tt.query, enforced)
+		if err != nil {
+			t.Fatalf("Enforce(%q) error = %v", tt.query, err)
+		}
+		if got != tt.want {
+			t.Fatalf("Enforce(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestEnforceRejectsOverrides(t *testing.T) {
+	enforced, err := ParseMatchers(`{tenant="acme",env=~"prod|stage"}`)
+	if err != nil {
+		t.Fatalf("ParseMatchers() error = %v", err)
+	}
+	for _, query := range []string{
+		`up{tenant="other"}`,
+		`up{tenant=~"acme|other"}`,
+		`up{tenant!="acme"}`,
+		`sum(up) + on() count(x{env="dev"})`,
+	} {
+		_, err := Enforce(query, enforced)
+		var conflict *ConflictError
+		if !errors.As(err, &conflict) {
+			t.Fatalf("Enforce(%q) error = %v, want a ConflictError", query, err)
+		}
+	}
+
+	got, err := Enforce(`up{env=~"prod|stage"}`, enforced)
+	if err != nil || got != `up{env=~"prod|stage",tenant="acme"}` {
+		t.Fatalf("Enforce() = %q, %v", got, err)
+	}
+	if _, err := Enforce(`sum(`, enforced); err == nil {
+		t.Fatalf("Enforce() accepted an invalid query")
+	}
+}
+
+func TestEnforceSelector(t *testing.T) {
+	enforced := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "tenant", "acme")}
+	for selector, want := range map[string]string{
+		`up`:                    `up{tenant="acme"}`,
+		`{job=~"api|web"}`:      `{job=~"api|web",tenant="acme"}`,
+		`{__name__=~"node_.*"}`: `{__name__=~"node_.*",tenant="acme"}`,
+		`up{tenant="acme"}`:     `up{tenant="acme"}`,
+		`http_requests_total{}`: `http_requests_total{tenant="acme"}`,
+	} {
+		got, err := EnforceSelector(selector, enforced)
+		if err != nil {
+			t.Fatalf("EnforceSelector(%q) error = %v", selector, err)
+		}
+		if got != want {
+			t.Fatalf("EnforceSelector(%q) = %q, want %q", selector, got, want)
+		}
+	}
+	if _, err := EnforceSelector(`rate(up[5m])`, enforced); err == nil {
+		t.Fatal("EnforceSelector(rate(up[5m])) error = nil, want a selector error")
+	}
+	var conflict *ConflictError
+	if _, err := EnforceSelector(`up{tenant="other"}`, enforced); !errors.As(err, &conflict) {
+		t.Fatalf("EnforceSelector() error = %v, want ConflictError", err)
+	}
+	if got := Selector(enforced); got != `{tenant="acme"}` {
+		t.Fatalf("Selector() = %q", got)
+	}
+}
@@ -15,14 +15,53 @@ type Request struct {
 	End       time.Time         `json:"end"`
 	Step      string            `json:"step"`
 	Normalize bool              `json:"normalize"`
+	// Time evaluates an instant PromQL query at a point in time; zero
+	// evaluates it now.
+	Time time.Time `json:"time"`
+	// Limit enables pagination of log and trace searches; Cursor continues
+	// from a previous page's next_cursor.
+	Limit  int    `json:"limit"`
+	Cursor string `json:"cursor"`
+	// Stream selects a streaming response: "ndjson" or "sse".
+	Stream string `json:"stream"`
+	// Direction orders LogQL log hits: DirectionBackward, newest first, by
+	// default or DirectionForward, oldest first.
+	Direction string `json:"direction"`
+
+	// Offset is the decoded cursor position, filled in by the server.
+	Offset int `json:"-"`
+}
+
+// Metadata request kinds of the Prometheus API.
+const (
+	MetadataSeries      = "series"
+	MetadataLabels      = "labels"
+	MetadataLabelValues = "label_values"
+)
+
+// MetadataRequest asks the Prometheus API for the series, label names or
+// values of one label matching a set of series selectors.
+type MetadataRequest struct {
+	Kind string
+	// Label is the label whose values are listed by MetadataLabelValues.
+	Label    string
+	Matchers []string
+	// Start and End bound the series considered; zero leaves the bound to
+	// the backend.
+	Start time.Time
+	End   time.Time
+	Limit int
 }
 
 // Response wraps upstream responses with additional metadata.
 type Response struct {
-	Lang   string          `json:"lang"`
-	Tenant string          `json:"tenant"`
-	Result json.RawMessage `json:"result"`
-	Stats  Stats           `json:"stats"`
+	Lang       string          `json:"lang"`
+	Tenant     string          `json:"tenant"`
+	Result     json.RawMessage `json:"result"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+	// Warnings describe the time ranges missing from a partial result.
+	Warnings []string `json:"warnings,omitempty"`
+	Stats    Stats    `json:"stats"`
 }
 
 // Stats describes runtime statistics.
@@ -31,6 +70,25 @@ type Stats struct {
 	Cached     bool   `json:"cached"`
 	DurationMS int64  `json:"duration_ms"`
 	Cost       int64  `json:"cost"`
+	Breaker    string `json:"breaker,omitempty"`
+	// CacheTier is "l1" or "l2" when the response was served from cache.
+	CacheTier string `json:"cache_tier,omitempty"`
+	// Coalesced is true when the response was shared with an identical
+	// concurrent request.
+	Coalesced bool `json:"coalesced,omitempty"`
+	// Partial is true when part of the result could not be fetched.
+	Partial bool `json:"partial,omitempty"`
+}
+
+// Directions of LogQL log searches.
+const (
+	DirectionBackward = "backward"
+	DirectionForward  = "forward"
+)
+
+// Forward returns true when log hits are requested oldest first.
+func (r Request) Forward() bool {
+	return r.Direction == DirectionForward
 }
 
 // HasTimeRange returns true when the request is a range query.
@@ -45,3 +103,12 @@ func (r Request) StepDuration() (time.Duration, error) {
 	}
 	return time.ParseDuration(r.Step)
 }
+
+// TraceRequest looks up a single trace by ID.
+type TraceRequest struct {
+	// ID is the trace ID as 32 lowercase hex digits.
+	ID string
+	// Start and End bound the search; zero leaves the bound to the backend.
+	Start time.Time
+	End   time.Time
+}
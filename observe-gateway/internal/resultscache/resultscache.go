@@ -0,0 +1,243 @@
+// Package resultscache caches range query results in fixed, step-aligned
+// extents so that overlapping range queries, such as a refreshing
+// dashboard, only fetch the time ranges that have not been seen before.
+package resultscache
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/backend"
+	"github.com/xscopehub/observe-gateway/internal/cache"
+	"github.com/xscopehub/observe-gateway/internal/promapi"
+	"github.com/xscopehub/observe-gateway/internal/query"
+)
+
+// Config controls range splitting.
+type Config struct {
+	Enabled bool
+	// SplitInterval is the extent size, typically an hour or a day.
+	SplitInterval time.Duration
+	// MaxFreshness excludes extents ending within this window of now from
+	// caching, since their data may still change.
+	MaxFreshness time.Duration
+	// TTL is how long a completed extent is kept.
+	TTL time.Duration
+}
+
+// Fetcher runs a sub-range of the original request against the backend.
+type Fetcher func(context.Context, query.Request) (backend.Result, error)
+
+// Cache splits range queries into extents stored in a cache.Cache. A nil
+// Cache is disabled.
+type Cache struct {
+	cfg   Config
+	store *cache.Cache
+	now   func() time.Time
+}
+
+// New returns a results cache, or nil when splitting is disabled.
+func New(cfg Config, store *cache.Cache) *Cache {
+	if !cfg.Enabled || cfg.SplitInterval <= 0 || store == nil {
+		return nil
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = time.Hour
+	}
+	return &Cache{cfg: cfg, store: store, now: time.Now}
+}
+
+// extent is the part of a request that falls into one split interval.
+type extent struct {
+	index      int64
+	start, end time.Time
+	cacheable  bool
+	cached     bool
+	series     []promapi.Series
+}
+
+// entry is the stored form of an extent.
+type entry struct {
+	Backend string           `json:"backend"`
+	Series  []promapi.Series `json:"series"`
+}
+
+// Do answers a range query from cached extents, fetching contiguous runs of
+// missing extents from the backend. The start and end of the request are
+// aligned down to the step so that repeated queries share extents. The
+// returned tier is empty unless every extent came from the cache, in which
+// case it is the slowest tier that served one.
+func (c *Cache) Do(ctx context.Context, key cache.Key, req query.Request, fetch Fetcher) (backend.Result, cache.Tier, error) {
+	step, err := req.StepDuration()
+	if err != nil || step <= 0 {
+		res, err := fetch(ctx, req)
+		return res, "", err
+	}
+	start, end := alignDown(req.Start, step), alignDown(req.End, step)
+	if end.Before(start) {
+		res, err := fetch(ctx, req)
+		return res, "", err
+	}
+
+	extents := c.split(start, end, step)
+	var out backend.Result
+	tier := cache.TierL1
+	for i := range extents {
+		e := &extents[i]
+		if !e.cacheable {
+			continue
+		}
+		data, hitTier, ok := c.store.Get(ctx, c.extentKey(key, step, e.index))
+		if !ok {
+			continue
+		}
+		var stored entry
+		if json.Unmarshal(data, &stored) != nil {
+			continue
+		}
+		e.series, e.cached = stored.Series, true
+		out.Backend = stored.Backend
+		if hitTier == cache.TierL2 {
+			tier = cache.TierL2
+		}
+	}
+
+	var warnings []string
+	fetched := false
+	for i := 0; i < len(extents); {
+		if extents[i].cached {
+			i++
+			continue
+		}
+		j := i
+		for j+1 < len(extents) && !extents[j+1].cached {
+			j++
+		}
+
+		sub := req
+		sub.Start, sub.End = extents[i].start, extents[j].end
+		res, err := fetch(ctx, sub)
+		if err != nil {
+			return backend.Result{}, "", err
+		}
+		resp, err := promapi.Parse(res.Payload)
+		if err != nil {
+			return backend.Result{}, "", err
+		}
+		series, err := resp.Matrix()
+		if err != nil {
+			return backend.Result{}, "", err
+		}
+		warnings = append(warnings, resp.Warnings...)
+		out.Backend, out.Breaker = res.Backend, res.Breaker
+		out.Cost += res.Cost
+		out.Warnings = append(out.Warnings, res.Warnings...)
+		out.Partial = out.Partial || res.Partial
+		fetched = true
+
+		for k := i; k <= j; k++ {
+			e := &extents[k]
+			e.series = trim(series, e.start, e.end)
+			// A partial result lacks data the extent may hold.
+			if e.cacheable && !res.Partial {
+				data, err := json.Marshal(entry{Backend: res.Backend, Series: e.series})
+				if err == nil {
+					c.store.SetWithTTL(ctx, c.extentKey(key, step, e.index), data, int64(len(data)), c.cfg.TTL)
+				}
+			}
+		}
+		i = j + 1
+	}
+
+	merged := make([][]promapi.Series, 0, len(extents))
+	for _, e := range extents {
+		merged = append(merged, trim(e.series, start, end))
+	}
+	resp, err := promapi.NewMatrix(promapi.MergeSeries(merged))
+	if err != nil {
+		return backend.Result{}, "", err
+	}
+	resp.Warnings = warnings
+	out.Payload, err = json.Marshal(resp)
+	if err != nil {
+		return backend.Result{}, "", err
+	}
+	if fetched {
+		tier = ""
+	}
+	return out, tier, nil
+}
+
+// split divides [start, end] into extents. Cacheable extents cover their
+// whole split interval so later queries can reuse them; extents inside the
+// freshness window cover only the requested range.
+func (c *Cache) split(start, end time.Time, step time.Duration) []extent {
+	size := int64(c.cfg.SplitInterval)
+	fresh := c.now().Add(-c.cfg.MaxFreshness)
+
+	var extents []extent
+	for idx := floorDiv(start.UnixNano(), size); idx <= floorDiv(end.UnixNano(), size); idx++ {
+		lo := time.Unix(0, idx*size).UTC()
+		hi := lo.Add(c.cfg.SplitInterval)
+		e := extent{index: idx, start: alignUp(lo, step), end: alignDown(hi.Add(-1), step)}
+		e.cacheable = !hi.After(fresh)
+		if !e.cacheable {
+			if e.start.Before(start) {
+				e.start = start
+			}
+			if e.end.After(end) {
+				e.end = end
+			}
+		}
+		if !e.end.Before(e.start) {
+			extents = append(extents, e)
+		}
+	}
+	return extents
+}
+
+func (c *Cache) extentKey(key cache.Key, step time.Duration, index int64) cache.Key {
+	key.ID = strings.Join([]string{"extent", key.ID, step.String(), c.cfg.SplitInterval.String(), strconv.FormatInt(index, 10)}, "|")
+	return key
+}
+
+// trim keeps the samples with timestamps in [start, end].
+func trim(series []promapi.Series, start, end time.Time) []promapi.Series {
+	lo, hi := start.UnixMilli(), end.UnixMilli()
+	out := make([]promapi.Series, 0, len(series))
+	for _, s := range series {
+		var values []promapi.Sample
+		for _, v := range s.Values {
+			if t := v.Millis(); t >= lo && t <= hi {
+				values = append(values, v)
+			}
+		}
+		if len(values) > 0 {
+			out = append(out, promapi.Series{Metric: s.Metric, Values: values})
+		}
+	}
+	return out
+}
+
+func alignDown(t time.Time, step time.Duration) time.Time {
+	return time.Unix(0, floorDiv(t.UnixNano(), int64(step))*int64(step)).UTC()
+}
+
+func alignUp(t time.Time, step time.Duration) time.Time {
+	aligned := alignDown(t, step)
+	if aligned.Before(t) {
+		aligned = aligned.Add(step)
+	}
+	return aligned
+}
+
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
@@ -0,0 +1,145 @@
+package resultscache
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/backend"
+	"github.com/xscopehub/observe-gateway/internal/cache"
+	"github.com/xscopehub/observe-gateway/internal/promapi"
+	"github.com/xscopehub/observe-gateway/internal/query"
+)
+
+// fakeMatrix answers every sub-request with one series holding a sample per
+// step whose value is the timestamp.
+type fakeMatrix struct {
+	calls []query.Request
+}
+
+func (f *fakeMatrix) fetch(_ context.Context, req query.Request) (backend.Result, error) {
+	f.calls = append(f.calls, req)
+	step, _ := req.StepDuration()
+	var values []promapi.Sample
+	for t := req.Start; !t.After(req.End); t = t.Add(step) {
+		values = append(values, promapi.Sample{T: float64(t.Unix()), V: float64(t.Unix())})
+	}
+	resp, err := promapi.NewMatrix([]promapi.Series{{Metric: map[string]string{"job": "api"}, Values: values}})
+	if err != nil {
+		return backend.Result{}, err
+	}
+	payload, err := json.Marshal(resp)
+	return backend.Result{Payload: payload, Backend: "fake", Cost: 1}, err
+}
+
+func newTestCache(t *testing.T, now time.Time) (*Cache, *cache.Cache) {
+	t.Helper()
+	store, err := cache.New(cache.Config{Enabled: true, NumCounters: 1000, MaxCost: 1 << 20})
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	c := New(Config{Enabled: true, SplitInterval: time.Hour, MaxFreshness: 10 * time.Minute}, store)
+	c.now = func() time.Time { return now }
+	return c, store
+}
+
+var key = cache.Key{Tenant: "tenant-a", Lang: "promql", ID: "up"}
+
+func samples(t *testing.T, payload []byte) []promapi.Sample {
+	t.Helper()
+	resp, err := promapi.Parse(payload)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	series, err := resp.Matrix()
+	if err != nil {
+		t.Fatalf("Matrix() error = %v", err)
+	}
+	if len(series) != 1 {
+		t.Fatalf("series = %d, want 1", len(series))
+	}
+	return series[0].Values
+}
+
+func TestDoReusesExtents(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c, store := newTestCache(t, now)
+	f := &fakeMatrix{}
+
+	req := query.Request{Lang: "promql", Query: "up", Start: now.Add(-3 * time.Hour), End: now, Step: "15m"}
+	res, tier, err := c.Do(context.Background(), key, req, f.fetch)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if tier != "" {
+		t.Fatalf("cached = true on a cold cache")
+	}
+	if got := samples(t, res.Payload); len(got) != 13 || got[0].T != float64(req.Start.Unix()) || got[12].T != float64(now.Unix()) {
+		t.Fatalf("samples = %v, want 13 points from start to end", got)
+	}
+	if len(f.calls) != 1 {
+		t.Fatalf("calls = %d, want one request for contiguous missing extents", len(f.calls))
+	}
+	store.Wait()
+
+	// A refresh 30s later with a shifted window only fetches the fresh tail.
+	f.calls = nil
+	c.now = func() time.Time { return now.Add(30 * time.Second) }
+	req.Start, req.End = req.Start.Add(30*time.Second), req.End.Add(30*time.Second)
+	res, _, err = c.Do(context.Background(), key, req, f.fetch)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if len(f.calls) != 1 {
+		t.Fatalf("calls = %d, want 1", len(f.calls))
+	}
+	if call := f.calls[0]; !call.Start.Equal(now.Add(-time.Hour)) || !call.End.Equal(now) {
+		t.Fatalf("fetched %s..%s, want only the extent inside the freshness window", call.Start, call.End)
+	}
+	got := samples(t, res.Payload)
+	if len(got) != 13 || got[0].T != float64(now.Add(-3*time.Hour).Unix()) {
+		t.Fatalf("samples = %v, want 13 points aligned down to the step", got)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i].T <= got[i-1].T {
+			t.Fatalf("samples out of order at %d: %v", i, got)
+		}
+	}
+}
+
+func TestDoFetchesOnlyMissingExtents(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c, store := newTestCache(t, now)
+	f := &fakeMatrix{}
+	ctx := context.Background()
+
+	older := query.Request{Lang: "promql", Query: "up", Start: now.Add(-6 * time.Hour), End: now.Add(-5 * time.Hour), Step: "30m"}
+	if _, _, err := c.Do(ctx, key, older, f.fetch); err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	store.Wait()
+
+	f.calls = nil
+	wide := query.Request{Lang: "promql", Query: "up", Start: now.Add(-6 * time.Hour), End: now.Add(-2 * time.Hour), Step: "30m"}
+	res, tier, err := c.Do(ctx, key, wide, f.fetch)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if tier != "" {
+		t.Fatalf("cached = true, want a partial hit")
+	}
+	// The first query cached the whole 06:00 and 07:00 extents.
+	if len(f.calls) != 1 || !f.calls[0].Start.Equal(now.Add(-4*time.Hour)) {
+		t.Fatalf("calls = %+v, want one fetch starting after the cached extents", f.calls)
+	}
+	if got := samples(t, res.Payload); len(got) != 9 {
+		t.Fatalf("samples = %d, want 9", len(got))
+	}
+	store.Wait()
+
+	f.calls = nil
+	if _, tier, err := c.Do(ctx, key, wide, f.fetch); err != nil || tier != cache.TierL1 || len(f.calls) != 0 {
+		t.Fatalf("Do() tier = %q, calls = %d, err = %v, want a full L1 hit", tier, len(f.calls), err)
+	}
+}
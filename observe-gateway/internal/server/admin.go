@@ -0,0 +1,305 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gopkg.in/yaml.v3"
+
+	"github.com/xscopehub/observe-gateway/internal/audit"
+	"github.com/xscopehub/observe-gateway/internal/auth"
+)
+
+// requireAdmin guards /admin endpoints with the configured bearer token.
+// Admin endpoints act on every tenant, so API keys, which each belong to a
+// tenant, never pass.
+func (s *Server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := s.config().Server.AdminToken
+		if token == "" {
+			s.writeError(w, http.StatusNotFound, "admin endpoints are disabled")
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		attempt := audit.Entry{Query: r.Method + " " + r.URL.Path}
+		if s.keys.Enabled() {
+			id, err := s.keys.Authenticate(r)
+			if err == nil {
+				err = &auth.Error{Method: id.Method, Credential: id.Credential, Err: errors.New("admin endpoints require the admin token")}
+				s.writeError(w, http.StatusForbidden, err.Error())
+				attempt.Tenant, attempt.User = id.Tenant, id.User
+				s.auditAuthFailure(r, attempt, http.StatusForbidden, err)
+				return
+			}
+			if !errors.Is(err, auth.ErrNoCredentials) {
+				s.writeError(w, http.StatusUnauthorized, "invalid admin token")
+				s.auditAuthFailure(r, attempt, http.StatusUnauthorized, err)
+				return
+			}
+		}
+		s.writeError(w, http.StatusUnauthorized, "invalid admin token")
+		s.auditAuthFailure(r, attempt, http.StatusUnauthorized, errors.New("invalid admin token"))
+	})
+}
+
+// auditAuthFailure records a rejected authentication attempt with whatever
+// identifies the caller: remote address, auth method and credential ID.
+func (s *Server) auditAuthFailure(r *http.Request, entry audit.Entry, status int, err error) {
+	entry.Event = audit.EventAuthFailure
+	entry.Status = status
+	entry.RemoteAddr = r.RemoteAddr
+	entry.Error = err.Error()
+	var authErr *auth.Error
+	if errors.As(err, &authErr) {
+		entry.AuthMethod = authErr.Method
+		entry.Credential = authErr.Credential
+	}
+	s.auditLog.Log(entry)
+}
+
+// caller is the tenant and user a request acts for.
+type caller struct {
+	tenant string
+	user   string
+	// identity is set when an authenticator verified the request.
+	identity *auth.Identity
+}
+
+// identify determines the caller. Tenant and user headers are only trusted
+// when no authenticator is enabled; on failure the claimed headers are
+// returned to attribute the attempt.
+func (s *Server) identify(r *http.Request) (caller, error) {
+	cfg := s.config().Server
+	tenantHeader := cfg.TenantHeader
+	if tenantHeader == "" {
+		tenantHeader = "X-Tenant"
+	}
+	userHeader := cfg.UserHeader
+	if userHeader == "" {
+		userHeader = "X-User"
+	}
+	c := caller{tenant: r.Header.Get(tenantHeader), user: r.Header.Get(userHeader)}
+	if s.auth == nil || !s.auth.Enabled() {
+		return c, nil
+	}
+	_, span := tracer.Start(r.Context(), "auth")
+	id, err := s.auth.Authenticate(r)
+	span.End()
+	if err != nil {
+		return c, err
+	}
+	if id.Tenant != "" {
+		c.tenant = id.Tenant
+	}
+	if id.User != "" {
+		c.user = id.User
+	}
+	c.identity = &id
+	return c, nil
+}
+
+// require returns an error unless the caller holds one of scopes.
+func (c caller) require(scopes ...string) error {
+	if c.identity == nil {
+		return nil
+	}
+	for _, scope := range scopes {
+		if c.identity.Allows(scope) {
+			return nil
+		}
+	}
+	return &auth.Error{Method: c.identity.Method, Credential: c.identity.Credential, Err: fmt.Errorf("credentials lack the %s scope", scopes[0])}
+}
+
+// handleConfig reports the active configuration with secrets redacted:
+// GET /admin/config.
+func (s *Server) handleConfig(w http.ResponseWriter, _ *http.Request) {
+	active := s.config()
+	// Round-trip through YAML so the keys match the configuration file.
+	data, err := yaml.Marshal(active.Redacted())
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var cfg map[string]any
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]any{
+		"version":   active.version,
+		"hash":      active.hash,
+		"loaded_at": active.loadedAt,
+		"config":    cfg,
+	})
+}
+
+// handlePurgeCache removes cached results of a tenant and/or a template:
+// DELETE /admin/cache?tenant=<tenant>&template=<template>.
+func (s *Server) handlePurgeCache(w http.ResponseWriter, r *http.Request) {
+	tenant := r.URL.Query().Get("tenant")
+	template := r.URL.Query().Get("template")
+	if tenant == "" && template == "" {
+		s.writeError(w, http.StatusBadRequest, "tenant or template is required")
+		return
+	}
+
+	deleted, err := s.cache.Purge(r.Context(), tenant, template)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]any{"tenant": tenant, "template": template, "deleted": deleted})
+}
+
+// apiKeyScopes are the scopes an API key may be issued with. Keys act for
+// their own tenant only, so no scope grants admin access.
+var apiKeyScopes = map[string]bool{
+	"query":         true,
+	"query:promql":  true,
+	"query:logql":   true,
+	"query:traceql": true,
+	"templates":     true,
+}
+
+type issueAPIKeyRequest struct {
+	Tenant    string     `json:"tenant"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	// TTL is an alternative to ExpiresAt, as a Go duration such as "720h".
+	TTL string `json:"ttl"`
+}
+
+type issuedAPIKey struct {
+	// Key is the secret; it is only returned when the key is issued.
+	Key    string      `json:"key"`
+	APIKey auth.APIKey `json:"api_key"`
+}
+
+// handleIssueAPIKey issues a key: POST /admin/api-keys.
+func (s *Server) handleIssueAPIKey(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAPIKeys(w) {
+		return
+	}
+	var req issueAPIKeyRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	if req.Tenant == "" {
+		s.writeError(w, http.StatusBadRequest, "tenant is required")
+		return
+	}
+	if len(req.Scopes) == 0 {
+		req.Scopes = []string{"query"}
+	}
+	for _, scope := range req.Scopes {
+		if !apiKeyScopes[scope] {
+			s.writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown scope %q", scope))
+			return
+		}
+	}
+	if req.TTL != "" {
+		if req.ExpiresAt != nil {
+			s.writeError(w, http.StatusBadRequest, "expires_at and ttl are mutually exclusive")
+			return
+		}
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			s.writeError(w, http.StatusBadRequest, "invalid ttl")
+			return
+		}
+		expiresAt := time.Now().Add(ttl).UTC()
+		req.ExpiresAt = &expiresAt
+	}
+
+	key, secret, err := s.keys.Issue(r.Context(), req.Tenant, req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, issuedAPIKey{Key: secret, APIKey: key})
+}
+
+// handleListAPIKeys lists keys without their secrets:
+// GET /admin/api-keys?tenant=<tenant>.
+func (s *Server) handleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAPIKeys(w) {
+		return
+	}
+	keys, err := s.keys.List(r.Context(), r.URL.Query().Get("tenant"))
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]any{"api_keys": keys})
+}
+
+// handleRotateAPIKey replaces a key, letting the old one work for an
+// optional grace period: POST /admin/api-keys/{id}/rotate?grace=<duration>.
+func (s *Server) handleRotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAPIKeys(w) {
+		return
+	}
+	var grace time.Duration
+	if raw := r.URL.Query().Get("grace"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 {
+			s.writeError(w, http.StatusBadRequest, "invalid grace")
+			return
+		}
+		grace = d
+	}
+
+	key, secret, err := s.keys.Rotate(r.Context(), chi.URLParam(r, "id"), grace)
+	if err != nil {
+		s.writeError(w, apiKeyErrorStatus(err), err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, issuedAPIKey{Key: secret, APIKey: key})
+}
+
+// handleRevokeAPIKey revokes a key immediately: DELETE /admin/api-keys/{id}.
+func (s *Server) handleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAPIKeys(w) {
+		return
+	}
+	id := chi.URLParam(r, "id")
+	if err := s.keys.Revoke(r.Context(), id); err != nil {
+		s.writeError(w, apiKeyErrorStatus(err), err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]any{"id": id, "revoked": true})
+}
+
+func (s *Server) requireAPIKeys(w http.ResponseWriter) bool {
+	if !s.keys.Enabled() {
+		s.writeError(w, http.StatusNotFound, "api keys are disabled")
+		return false
+	}
+	return true
+}
+
+func apiKeyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, auth.ErrKeyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, auth.ErrKeyInactive):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
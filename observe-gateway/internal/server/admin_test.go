@@ -0,0 +1,238 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/audit"
+	"github.com/xscopehub/observe-gateway/internal/auth"
+	"github.com/xscopehub/observe-gateway/internal/backend"
+	"github.com/xscopehub/observe-gateway/internal/cache"
+	"github.com/xscopehub/observe-gateway/internal/config"
+	"github.com/xscopehub/observe-gateway/internal/query"
+)
+
+func TestCacheHitReportsTierAndPurge(t *testing.T) {
+	cacheStore, err := cache.New(cache.Config{Enabled: true, NumCounters: 1000, MaxCost: 1 << 20})
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	calls := 0
+	stub := stubBackend{
+		queryPromQL: func(context.Context, string, query.Request) (backend.Result, error) {
+			calls++
+			return backend.Result{Payload: json.RawMessage(`{"status":"success"}`), Backend: "stub"}, nil
+		},
+	}
+	cfg := config.Config{Server: config.ServerConfig{AdminToken: "secret"}}
+	handler := New(cfg, nil, nil, nil, stub, cacheStore, nil, audit.New(false, nil)).Handler()
+
+	run := func() query.Stats {
+		req := httptest.NewRequest(http.MethodPost, "/api/query", bytes.NewReader([]byte(`{"lang":"promql","query":"up"}`)))
+		req.Header.Set("X-Tenant", "tenant-a")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var resp query.Response
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("json.Unmarshal() error = %v: %s", err, rec.Body.String())
+		}
+		cacheStore.Wait()
+		return resp.Stats
+	}
+
+	if stats := run(); stats.Cached {
+		t.Fatalf("first response cached: %+v", stats)
+	}
+	if stats := run(); !stats.Cached || stats.CacheTier != "l1" || stats.Backend != "stub" {
+		t.Fatalf("stats = %+v, want an l1 hit", stats)
+	}
+
+	purge := func(token string) int {
+		req := httptest.NewRequest(http.MethodDelete, "/admin/cache?tenant=tenant-a", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := purge("wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("purge with bad token status = %d, want 401", code)
+	}
+	if code := purge("secret"); code != http.StatusOK {
+		t.Fatalf("purge status = %d, want 200", code)
+	}
+	if stats := run(); stats.Cached || calls != 2 {
+		t.Fatalf("stats = %+v, calls = %d, want a miss after purge", stats, calls)
+	}
+}
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	var tenants []string
+	stub := stubBackend{
+		queryPromQL: func(_ context.Context, tenant string, _ query.Request) (backend.Result, error) {
+			tenants = append(tenants, tenant)
+			return backend.Result{Payload: json.RawMessage(`{"status":"success"}`), Backend: "stub"}, nil
+		},
+	}
+	cacheStore, err := cache.New(cache.Config{Enabled: false})
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	keys := auth.NewAPIKeys(auth.NewMemoryKeyStore(), time.Minute)
+	cfg := config.Config{Server: config.ServerConfig{AdminToken: "secret"}}
+	handler := New(cfg, auth.Chain{keys}, keys, nil, stub, cacheStore, nil, audit.New(false, nil)).Handler()
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		req.Header.Set("X-Tenant", "spoofed")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	issue := func(body string) issuedAPIKey {
+		rec := do(http.MethodPost, "/admin/api-keys", "secret", body)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("issue status = %d, want 201: %s", rec.Code, rec.Body.String())
+		}
+		var issued issuedAPIKey
+		if err := json.Unmarshal(rec.Body.Bytes(), &issued); err != nil {
+			t.Fatalf("json.Unmarshal() error = %v", err)
+		}
+		return issued
+	}
+
+	promKey := issue(`{"tenant":"tenant-a","name":"batch","scopes":["query:promql"],"ttl":"24h"}`)
+	if promKey.APIKey.ExpiresAt == nil {
+		t.Fatalf("issued key has no expiry")
+	}
+	logKey := issue(`{"tenant":"tenant-a","name":"mcp","scopes":["query:logql"]}`)
+
+	for _, scope := range []string{"write", "admin", "*"} {
+		if rec := do(http.MethodPost, "/admin/api-keys", "secret", `{"tenant":"tenant-a","scopes":["`+scope+`"]}`); rec.Code != http.StatusBadRequest {
+			t.Fatalf("scope %q status = %d, want 400", scope, rec.Code)
+		}
+	}
+	if rec := do(http.MethodGet, "/admin/api-keys", promKey.Key, ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("admin call with query key status = %d, want 403", rec.Code)
+	}
+	// Keys issued with admin scopes before they were withdrawn stay tenant
+	// credentials.
+	_, legacy, err := keys.Issue(context.Background(), "tenant-a", "legacy", []string{"*"}, nil)
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	if rec := do(http.MethodGet, "/admin/api-keys", legacy, ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("admin call with wildcard key status = %d, want 403", rec.Code)
+	}
+
+	const promQuery = `{"lang":"promql","query":"up"}`
+	if rec := do(http.MethodPost, "/api/query", "", promQuery); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("query without credentials status = %d, want 401", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/query", promKey.Key, promQuery); rec.Code != http.StatusOK {
+		t.Fatalf("query status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	if len(tenants) != 1 || tenants[0] != "tenant-a" {
+		t.Fatalf("backend tenants = %v, want the key's tenant", tenants)
+	}
+	if rec := do(http.MethodPost, "/api/query", logKey.Key, promQuery); rec.Code != http.StatusForbidden {
+		t.Fatalf("query outside scope status = %d, want 403", rec.Code)
+	}
+
+	rec := do(http.MethodGet, "/admin/api-keys?tenant=tenant-a", "secret", "")
+	if rec.Code != http.StatusOK || bytes.Contains(rec.Body.Bytes(), []byte(promKey.Key)) {
+		t.Fatalf("list status = %d body = %s, want keys without secrets", rec.Code, rec.Body.String())
+	}
+
+	if rec := do(http.MethodDelete, "/admin/api-keys/"+promKey.APIKey.ID, "secret", ""); rec.Code != http.StatusOK {
+		t.Fatalf("revoke status = %d, want 200", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/query", promKey.Key, promQuery); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("query with revoked key status = %d, want 401", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/admin/api-keys/"+promKey.APIKey.ID+"/rotate", "secret", ""); rec.Code != http.StatusConflict {
+		t.Fatalf("rotate revoked key status = %d, want 409", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/admin/api-keys/missing", "secret", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("revoke missing key status = %d, want 404", rec.Code)
+	}
+}
+
+func TestConfigEndpointReportsReload(t *testing.T) {
+	cacheStore, err := cache.New(cache.Config{Enabled: false})
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	var queries []string
+	stub := stubBackend{
+		queryPromQL: func(_ context.Context, _ string, req query.Request) (backend.Result, error) {
+			queries = append(queries, req.Query)
+			return backend.Result{Payload: json.RawMessage(`{"status":"success"}`), Backend: "stub"}, nil
+		},
+	}
+	cfg := config.Config{
+		Server:         config.ServerConfig{AdminToken: "secret"},
+		QueryTemplates: map[string]config.QueryTemplateConfig{"up": {Lang: "promql", Query: "up"}},
+	}
+	srv := New(cfg, nil, nil, nil, stub, cacheStore, nil, audit.New(false, nil))
+	handler := srv.Handler()
+
+	type configResponse struct {
+		Version int64          `json:"version"`
+		Hash    string         `json:"hash"`
+		Config  map[string]any `json:"config"`
+	}
+	get := func() configResponse {
+		req := httptest.NewRequest(http.MethodGet, "/admin/config", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("config status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		var resp configResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode config: %v", err)
+		}
+		return resp
+	}
+	runTemplate := func() {
+		req := httptest.NewRequest(http.MethodPost, "/api/query", bytes.NewReader([]byte(`{"template":"up"}`)))
+		req.Header.Set("X-Tenant", "tenant-a")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("query status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	first := get()
+	if first.Version != 1 || first.Hash != cfg.Hash() {
+		t.Fatalf("config = version %d hash %q, want version 1 hash %q", first.Version, first.Hash, cfg.Hash())
+	}
+	if token := first.Config["server"].(map[string]any)["admin_token"]; token != "REDACTED" {
+		t.Fatalf("admin_token = %v, want REDACTED", token)
+	}
+	runTemplate()
+
+	cfg.QueryTemplates = map[string]config.QueryTemplateConfig{"up": {Lang: "promql", Query: `up{job="api"}`}}
+	srv.Reload(cfg)
+	second := get()
+	if second.Version != 2 || second.Hash == first.Hash {
+		t.Fatalf("reloaded config = version %d hash %q, want version 2 and a new hash", second.Version, second.Hash)
+	}
+	runTemplate()
+
+	if len(queries) != 2 || queries[0] != "up" || queries[1] != `up{job="api"}` {
+		t.Fatalf("queries = %q, want the template before and after reload", queries)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/audit"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// handleAudit returns audit entries, newest first:
+// GET /api/audit?tenant=&user=&event=&since=&until=&limit=. since and until
+// accept RFC 3339 times or durations relative to now; since defaults to 24h.
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if !s.auditLog.Queryable() {
+		s.writeError(w, http.StatusNotFound, "audit queries require the postgres audit sink")
+		return
+	}
+
+	params := r.URL.Query()
+	now := time.Now()
+	filter := audit.Filter{
+		Tenant: params.Get("tenant"),
+		User:   params.Get("user"),
+		Event:  params.Get("event"),
+		Since:  now.Add(-24 * time.Hour),
+		Limit:  defaultAuditLimit,
+	}
+	var err error
+	if raw := params.Get("since"); raw != "" {
+		if filter.Since, err = parseAuditTime(raw, now); err != nil {
+			s.writeError(w, http.StatusBadRequest, "invalid since")
+			return
+		}
+	}
+	if raw := params.Get("until"); raw != "" {
+		if filter.Until, err = parseAuditTime(raw, now); err != nil {
+			s.writeError(w, http.StatusBadRequest, "invalid until")
+			return
+		}
+	}
+	if raw := params.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			s.writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		filter.Limit = min(limit, maxAuditLimit)
+	}
+
+	entries, err := s.auditLog.Query(r.Context(), filter)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]any{"entries": entries})
+}
+
+// parseAuditTime accepts an RFC 3339 time or a duration before now.
+func parseAuditTime(raw string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(raw); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, raw)
+}
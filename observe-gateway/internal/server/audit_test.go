@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/audit"
+	"github.com/xscopehub/observe-gateway/internal/auth"
+	"github.com/xscopehub/observe-gateway/internal/cache"
+	"github.com/xscopehub/observe-gateway/internal/config"
+)
+
+// memoryAuditSink is a queryable in-memory audit sink.
+type memoryAuditSink struct {
+	mu      sync.Mutex
+	entries []audit.Entry
+}
+
+func (s *memoryAuditSink) Write(_ context.Context, entries []audit.Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, entries...)
+	return nil
+}
+
+func (s *memoryAuditSink) Query(_ context.Context, f audit.Filter) ([]audit.Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []audit.Entry{}
+	for i := len(s.entries) - 1; i >= 0 && len(out) < f.Limit; i-- {
+		e := s.entries[i]
+		if (f.Tenant == "" || e.Tenant == f.Tenant) && (f.Event == "" || e.Event == f.Event) && !e.Time.Before(f.Since) {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+func (s *memoryAuditSink) Close() error { return nil }
+
+func TestAuditRecordsFailedAuthAndIsQueryable(t *testing.T) {
+	cacheStore, err := cache.New(cache.Config{Enabled: false})
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	keys := auth.NewAPIKeys(auth.NewMemoryKeyStore(), time.Minute)
+	key, _, err := keys.Issue(context.Background(), "tenant-a", "batch", []string{"query"}, nil)
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	sink := &memoryAuditSink{}
+	cfg := config.Config{Server: config.ServerConfig{AdminToken: "secret"}}
+	handler := New(cfg, auth.Chain{keys}, keys, nil, stubBackend{}, cacheStore, nil, audit.NewLogger(audit.Config{Enabled: true}, sink)).Handler()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/query", bytes.NewReader([]byte(`{"lang":"promql","query":"up"}`)))
+	req.Header.Set("X-Tenant", "tenant-b")
+	req.Header.Set("X-API-Key", "ogk_"+key.ID+"_wrong")
+	req.RemoteAddr = "10.0.0.7:5000"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", rec.Code)
+	}
+
+	get := func(query string) []audit.Entry {
+		req := httptest.NewRequest(http.MethodGet, "/api/audit"+query, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /api/audit%s status = %d: %s", query, rec.Code, rec.Body.String())
+		}
+		var body struct {
+			Entries []audit.Entry `json:"entries"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("json.Unmarshal() error = %v", err)
+		}
+		return body.Entries
+	}
+
+	entries := get("?tenant=tenant-b&event=auth_failure&since=1h")
+	if len(entries) != 1 {
+		t.Fatalf("entries = %+v, want one auth failure", entries)
+	}
+	e := entries[0]
+	if e.AuthMethod != "apikey" || e.Credential != key.ID || e.RemoteAddr != "10.0.0.7:5000" || e.Status != http.StatusUnauthorized || e.Time.IsZero() {
+		t.Fatalf("entry = %+v, want an attributed auth failure", e)
+	}
+	if got := get("?tenant=tenant-a"); len(got) != 0 {
+		t.Fatalf("tenant-a entries = %+v, want none", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/audit?since=yesterday", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid since status = %d, want 400", rec.Code)
+	}
+}
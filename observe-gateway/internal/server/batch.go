@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/audit"
+	"github.com/xscopehub/observe-gateway/internal/config"
+	"github.com/xscopehub/observe-gateway/internal/limiter"
+	"github.com/xscopehub/observe-gateway/internal/query"
+	"github.com/xscopehub/observe-gateway/internal/telemetry"
+)
+
+var errBatchStream = errors.New("streaming is not supported in a batch")
+
+// metadataBackend is implemented by backends that can resolve a tenant's
+// metadata once for several queries.
+type metadataBackend interface {
+	WithTenantMetadata(context.Context, string) (context.Context, error)
+}
+
+// batchRequest is the body of POST /api/query/batch: /api/query bodies by
+// name.
+type batchRequest struct {
+	Queries map[string]query.Request `json:"queries"`
+}
+
+// batchResponse holds the /api/query response of each query of a batch, or
+// the error it failed with.
+type batchResponse struct {
+	Tenant  string                     `json:"tenant"`
+	Results map[string]json.RawMessage `json:"results"`
+}
+
+// batchError is the result of a query of a batch that failed.
+type batchError struct {
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+}
+
+// handleBatch runs several named queries of one tenant in parallel. The
+// caller is authenticated, rate limited and its tenant metadata resolved
+// once for the batch; a query that fails validation or execution reports
+// its error in its result without failing the others.
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	telemetry.ActiveRequests.Inc()
+	defer telemetry.ActiveRequests.Dec()
+
+	start := time.Now()
+	var body batchRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	_, span := tracer.Start(r.Context(), "parse")
+	err := decoder.Decode(&body)
+	span.End()
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		s.auditLog.Log(audit.Entry{Duration: time.Since(start), Error: err.Error()})
+		return
+	}
+	cfg := s.config().Batch
+	if len(body.Queries) == 0 {
+		s.writeError(w, http.StatusBadRequest, "queries are required")
+		return
+	}
+	if cfg.MaxQueries > 0 && len(body.Queries) > cfg.MaxQueries {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("a batch must not exceed %d queries", cfg.MaxQueries))
+		return
+	}
+
+	c, err := s.identify(r)
+	if err != nil {
+		s.writeError(w, http.StatusUnauthorized, err.Error())
+		s.auditAuthFailure(r, audit.Entry{Tenant: c.tenant, User: c.user, Duration: time.Since(start)}, http.StatusUnauthorized, err)
+		return
+	}
+	tenant, user := c.tenant, c.user
+	if tenant == "" {
+		s.writeError(w, http.StatusBadRequest, "tenant is required")
+		s.auditLog.Log(audit.Entry{Duration: time.Since(start), Error: "tenant missing"})
+		return
+	}
+	labelRequest(r.Context(), tenant, "", "")
+
+	names := make([]string, 0, len(body.Queries))
+	for name := range body.Queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	results := make(map[string]json.RawMessage, len(names))
+	fail := func(name string, status int, err error) {
+		results[name], _ = json.Marshal(batchError{Error: err.Error(), Status: status})
+	}
+	reqs := map[string]query.Request{}
+	var subjects []limiter.Subject
+	for _, name := range names {
+		req := body.Queries[name]
+		if req.Stream != "" {
+			fail(name, http.StatusBadRequest, errBatchStream)
+			s.auditLog.Log(audit.Entry{Tenant: tenant, User: user, Lang: req.Lang, Query: req.Query, Duration: time.Since(start), Error: errBatchStream.Error()})
+			continue
+		}
+		req, _, status, err := s.resolveQuery(r, c, req, start)
+		if err != nil {
+			fail(name, status, err)
+			continue
+		}
+		reqs[name] = req
+		subjects = append(subjects, limiter.Subject{Tenant: tenant, User: user, Template: req.Template, Lang: req.Lang})
+	}
+
+	if len(reqs) > 0 {
+		decision, err := s.limiter.AllowBatch(r.Context(), subjects, cfg.RateLimit == config.BatchRateLimitQuery)
+		if rej := s.checkAdmission(r.Context(), w, tenant, decision, err); rej != nil {
+			if rej.rule != "" {
+				s.writeJSON(w, rej.status, map[string]string{"error": rej.err.Error(), "rule": rej.rule})
+			} else {
+				s.writeError(w, rej.status, rej.err.Error())
+			}
+			for _, req := range reqs {
+				s.auditLog.Log(audit.Entry{Tenant: tenant, User: user, Lang: req.Lang, Query: req.Query, Duration: time.Since(start), RateLimitRule: rej.rule, Error: rej.err.Error()})
+			}
+			return
+		}
+
+		ctx := r.Context()
+		if resolver, ok := s.backend.(metadataBackend); ok {
+			if ctx, err = resolver.WithTenantMetadata(ctx, tenant); err != nil {
+				s.writeError(w, errorStatus(err), err.Error())
+				s.auditLog.Log(audit.Entry{Tenant: tenant, User: user, Duration: time.Since(start), Error: err.Error()})
+				return
+			}
+		}
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		var cost int64
+		for name, req := range reqs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				payload, spent, status, err := s.answerQuery(ctx, tenant, user, req, start)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					fail(name, status, err)
+					return
+				}
+				results[name] = payload
+				cost += spent
+			}()
+		}
+		wg.Wait()
+		s.chargeBudget(r.Context(), w, tenant, cost)
+	}
+
+	s.writeJSON(w, http.StatusOK, batchResponse{Tenant: tenant, Results: results})
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/audit"
+	"github.com/xscopehub/observe-gateway/internal/backend"
+	"github.com/xscopehub/observe-gateway/internal/cache"
+	"github.com/xscopehub/observe-gateway/internal/config"
+	"github.com/xscopehub/observe-gateway/internal/limiter"
+	"github.com/xscopehub/observe-gateway/internal/query"
+)
+
+type resolvedTenantKey struct{}
+
+// metadataStub is a backend that counts tenant metadata lookups and checks
+// that queries reuse them.
+type metadataStub struct {
+	stubBackend
+	lookups *atomic.Int32
+}
+
+func (m metadataStub) WithTenantMetadata(ctx context.Context, tenant string) (context.Context, error) {
+	m.lookups.Add(1)
+	return context.WithValue(ctx, resolvedTenantKey{}, tenant), nil
+}
+
+func TestHandleBatch(t *testing.T) {
+	cacheStore, err := cache.New(cache.Config{Enabled: false})
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	var lookups atomic.Int32
+	reused := func(ctx context.Context, tenant string) error {
+		if ctx.Value(resolvedTenantKey{}) != tenant {
+			return errors.New("tenant metadata not resolved")
+		}
+		return nil
+	}
+	stub := metadataStub{stubBackend: stubBackend{
+		queryPromQL: func(ctx context.Context, tenant string, req query.Request) (backend.Result, error) {
+			if err := reused(ctx, tenant); err != nil {
+				return backend.Result{}, err
+			}
+			if req.Query == "down" {
+				return backend.Result{}, errors.New("openobserve timeout")
+			}
+			return backend.Result{Payload: json.RawMessage(`{"status":"success","data":{"resultType":"vector","result":[]}}`), Backend: "openobserve-promql", Cost: 10}, nil
+		},
+		queryLogQL: func(ctx context.Context, tenant string, req query.Request) (backend.Result, error) {
+			if err := reused(ctx, tenant); err != nil {
+				return backend.Result{}, err
+			}
+			return backend.Result{Payload: json.RawMessage(`{"hits":[]}`), Backend: "openobserve-logsql", Cost: 5}, nil
+		},
+	}, lookups: &lookups}
+	cfg := config.Config{Batch: config.BatchConfig{MaxQueries: 4, RateLimit: config.BatchRateLimitQuery}}
+	srv := New(cfg, nil, nil, nil, stub, cacheStore, nil, audit.New(false, nil))
+	srv.limiter = limiter.New(limiter.Config{
+		Enabled:           true,
+		RequestsPerSecond: 1,
+		Burst:             4,
+		Budget:            limiter.BudgetConfig{Enabled: true, Limit: 100},
+	})
+	handler := srv.Handler()
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/query/batch", strings.NewReader(body))
+		req.Header.Set("X-Tenant", "tenant-a")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	start, end := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC).Format(time.RFC3339), time.Date(2024, 5, 1, 1, 0, 0, 0, time.UTC).Format(time.RFC3339)
+	rec := post(`{"queries":{
+		"rate":{"lang":"promql","query":"up"},
+		"logs":{"lang":"LogQL","query":"{app=\"api\"}","start":"` + start + `","end":"` + end + `"},
+		"failing":{"lang":"promql","query":"down"},
+		"invalid":{"lang":"logql","query":"{app=\"api\"}"}
+	}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Tenant  string `json:"tenant"`
+		Results map[string]struct {
+			query.Response
+			Error  string `json:"error"`
+			Status int    `json:"status"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.Tenant != "tenant-a" || len(resp.Results) != 4 {
+		t.Fatalf("response = %+v, want four results for tenant-a", resp)
+	}
+	if r := resp.Results["rate"]; r.Error != "" || r.Stats.Backend != "openobserve-promql" || r.Stats.Cost != 10 {
+		t.Fatalf("rate = %+v, want a backend result", r)
+	}
+	if r := resp.Results["logs"]; r.Error != "" || r.Lang != "logql" || r.Stats.Cost != 5 {
+		t.Fatalf("logs = %+v, want a logql result", r)
+	}
+	if r := resp.Results["failing"]; r.Status != http.StatusBadGateway || r.Error != "openobserve timeout" {
+		t.Fatalf("failing = %+v, want the backend error", r)
+	}
+	if r := resp.Results["invalid"]; r.Status != http.StatusBadRequest || !strings.Contains(r.Error, "requires start and end") {
+		t.Fatalf("invalid = %+v, want a validation error", r)
+	}
+	if n := lookups.Load(); n != 1 {
+		t.Fatalf("metadata lookups = %d, want 1", n)
+	}
+	if got := rec.Header().Get("X-Query-Budget-Remaining"); got != "85" {
+		t.Fatalf("budget remaining = %q, want 85", got)
+	}
+	// Three valid queries, each counted as a request, leave one token.
+	if got := rec.Header().Get("RateLimit-Remaining"); got != "1" {
+		t.Fatalf("rate limit remaining = %q, want 1", got)
+	}
+
+	rec = post(`{"queries":{"a":{"lang":"promql","query":"up"},"b":{"lang":"promql","query":"up"}}}`)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429 once the batch exceeds the remaining tokens", rec.Code)
+	}
+
+	rec = post(`{"queries":{"a":{},"b":{},"c":{},"d":{},"e":{}}}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "must not exceed 4") {
+		t.Fatalf("status = %d, body = %s, want the batch size rejected", rec.Code, rec.Body.String())
+	}
+}
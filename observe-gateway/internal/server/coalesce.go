@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"github.com/xscopehub/observe-gateway/internal/backend"
+	"github.com/xscopehub/observe-gateway/internal/cache"
+)
+
+// flightGroup coalesces concurrent identical queries into one backend call.
+// Unlike a plain singleflight, the shared call runs detached from any single
+// caller and is cancelled only once every waiter has gone away.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+type flight struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+
+	res  backend.Result
+	tier cache.Tier
+	err  error
+}
+
+type flightFunc func(context.Context) (backend.Result, cache.Tier, error)
+
+// do runs fn once per key among concurrent callers. shared is true for
+// callers that joined a call started by another request.
+func (g *flightGroup) do(ctx context.Context, key string, fn flightFunc) (res backend.Result, tier cache.Tier, shared bool, err error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flight)
+	}
+	f, shared := g.calls[key]
+	if shared {
+		f.waiters++
+	} else {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		f = &flight{done: make(chan struct{}), cancel: cancel, waiters: 1}
+		g.calls[key] = f
+		go g.run(callCtx, key, f, fn)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.res, f.tier, shared, f.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		f.waiters--
+		if f.waiters == 0 {
+			f.cancel()
+			g.forget(key, f)
+		}
+		g.mu.Unlock()
+		return backend.Result{}, "", shared, ctx.Err()
+	}
+}
+
+func (g *flightGroup) run(ctx context.Context, key string, f *flight, fn flightFunc) {
+	defer f.cancel()
+	f.res, f.tier, f.err = fn(ctx)
+	g.mu.Lock()
+	g.forget(key, f)
+	g.mu.Unlock()
+	close(f.done)
+}
+
+// forget removes f so later callers start a fresh call; g.mu must be held.
+func (g *flightGroup) forget(key string, f *flight) {
+	if g.calls[key] == f {
+		delete(g.calls, key)
+	}
+}
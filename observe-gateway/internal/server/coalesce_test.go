@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/backend"
+	"github.com/xscopehub/observe-gateway/internal/cache"
+)
+
+func TestFlightGroupSharesOneCall(t *testing.T) {
+	var g flightGroup
+	var calls int32
+	release := make(chan struct{})
+	fn := func(context.Context) (backend.Result, cache.Tier, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return backend.Result{Payload: json.RawMessage(`1`)}, "", nil
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	var shared int32
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, _, isShared, err := g.do(context.Background(), "tenant-a|up", fn)
+			if err != nil || string(res.Payload) != "1" {
+				t.Errorf("do() = %s, %v", res.Payload, err)
+			}
+			if isShared {
+				atomic.AddInt32(&shared, 1)
+			}
+		}()
+	}
+	waitFor(t, func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		f := g.calls["tenant-a|up"]
+		return f != nil && f.waiters == n
+	})
+	close(release)
+	wg.Wait()
+
+	if calls != 1 || shared != n-1 {
+		t.Fatalf("calls = %d, shared = %d, want 1 and %d", calls, shared, n-1)
+	}
+}
+
+func TestFlightGroupCancelsWhenAllWaitersLeave(t *testing.T) {
+	var g flightGroup
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	fn := func(ctx context.Context) (backend.Result, cache.Tier, error) {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return backend.Result{}, "", ctx.Err()
+	}
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	ctxB, cancelB := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() { _, _, _, err := g.do(ctxA, "k", fn); errs <- err }()
+	<-started
+	go func() { _, _, _, err := g.do(ctxB, "k", fn); errs <- err }()
+	waitFor(t, func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return g.calls["k"] != nil && g.calls["k"].waiters == 2
+	})
+
+	cancelA()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("first waiter error = %v, want context.Canceled", err)
+	}
+	select {
+	case <-cancelled:
+		t.Fatalf("backend call cancelled while a waiter remained")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	cancelB()
+	<-errs
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatalf("backend call not cancelled after every waiter left")
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/audit"
+	"github.com/xscopehub/observe-gateway/internal/backend"
+	"github.com/xscopehub/observe-gateway/internal/cache"
+	"github.com/xscopehub/observe-gateway/internal/limiter"
+	"github.com/xscopehub/observe-gateway/internal/telemetry"
+)
+
+// errAPIUnsupported is returned when the backend lacks the calls a
+// compatible API endpoint needs.
+var errAPIUnsupported = errors.New("the backend does not serve this api")
+
+// compatAPI describes a third-party query API served by the gateway, such
+// as the Prometheus or Loki HTTP API.
+type compatAPI struct {
+	// name namespaces the API's cache entries.
+	name       string
+	lang       string
+	writeError func(w http.ResponseWriter, status int, msg string)
+}
+
+// compatCall is a parsed request to a compatible API.
+type compatCall struct {
+	// query is recorded in the audit log.
+	query string
+	// key identifies the request among the tenant's cached and in-flight
+	// requests to the same API.
+	key string
+	// run returns the response body in the API's format.
+	run func(ctx context.Context, tenant string) (backend.Result, cache.Tier, error)
+	// lang, when set, replaces the API's language for the scope check,
+	// rate limit, cache and audit of a request that names its language.
+	lang string
+	// ttl, when positive, replaces the cache TTL of the response.
+	ttl time.Duration
+	// narrow, when set, replaces the scope check of lang: it returns the
+	// call restricted to what the caller may read, or an error when the
+	// caller may read none of it.
+	narrow func(caller) (compatCall, error)
+}
+
+// compatRequest is an admitted request to a compatible API.
+type compatRequest struct {
+	caller
+	lang  string
+	call  compatCall
+	entry audit.Entry
+	start time.Time
+}
+
+// acceptCompat identifies the caller, parses the request and applies the
+// scope, rate limit and budget checks of /api/query. A refused request is
+// answered in the API's error format and audited.
+func (s *Server) acceptCompat(w http.ResponseWriter, r *http.Request, api compatAPI, parse func(*http.Request) (compatCall, error)) (compatRequest, bool) {
+	start := time.Now()
+	c, err := s.identify(r)
+	if err != nil {
+		api.writeError(w, http.StatusUnauthorized, err.Error())
+		s.auditAuthFailure(r, audit.Entry{Tenant: c.tenant, User: c.user, Lang: api.lang, Query: r.URL.Path, Duration: time.Since(start)}, http.StatusUnauthorized, err)
+		return compatRequest{}, false
+	}
+	if c.tenant == "" {
+		api.writeError(w, http.StatusBadRequest, "tenant is required")
+		s.auditLog.Log(audit.Entry{Lang: api.lang, Query: r.URL.Path, Duration: time.Since(start), Error: "tenant missing"})
+		return compatRequest{}, false
+	}
+	labelRequest(r.Context(), c.tenant, api.lang, "")
+
+	if err := r.ParseForm(); err != nil {
+		api.writeError(w, http.StatusBadRequest, fmt.Sprintf("error parsing form values: %v", err))
+		s.auditLog.Log(audit.Entry{Tenant: c.tenant, User: c.user, Lang: api.lang, Query: r.URL.Path, Duration: time.Since(start), Error: err.Error()})
+		return compatRequest{}, false
+	}
+	_, span := tracer.Start(r.Context(), "parse")
+	call, err := parse(r)
+	span.End()
+	if err != nil {
+		api.writeError(w, http.StatusBadRequest, err.Error())
+		s.auditLog.Log(audit.Entry{Tenant: c.tenant, User: c.user, Lang: api.lang, Query: r.Form.Get("query"), Duration: time.Since(start), Error: err.Error()})
+		return compatRequest{}, false
+	}
+	lang := api.lang
+	if call.lang != "" {
+		lang = call.lang
+		labelRequest(r.Context(), "", lang, "")
+	}
+	entry := audit.Entry{Tenant: c.tenant, User: c.user, Lang: lang, Query: call.query}
+
+	if call.narrow != nil {
+		call, err = call.narrow(c)
+	} else {
+		err = c.require("query:" + lang)
+	}
+	if err != nil {
+		api.writeError(w, http.StatusForbidden, err.Error())
+		entry.Duration = time.Since(start)
+		s.auditAuthFailure(r, entry, http.StatusForbidden, err)
+		return compatRequest{}, false
+	}
+
+	if rej := s.admit(r.Context(), w, limiter.Subject{Tenant: c.tenant, User: c.user, Lang: lang}); rej != nil {
+		api.writeError(w, rej.status, rej.err.Error())
+		entry.Duration, entry.RateLimitRule, entry.Error = time.Since(start), rej.rule, rej.err.Error()
+		s.auditLog.Log(entry)
+		return compatRequest{}, false
+	}
+	return compatRequest{caller: c, lang: lang, call: call, entry: entry, start: start}, true
+}
+
+// serveCompat runs a request to a compatible API through the same
+// authentication, scope, rate limit, budget, cache, coalescing and audit
+// steps as /api/query and writes the response body unwrapped.
+func (s *Server) serveCompat(w http.ResponseWriter, r *http.Request, api compatAPI, parse func(*http.Request) (compatCall, error)) {
+	telemetry.ActiveRequests.Inc()
+	defer telemetry.ActiveRequests.Dec()
+
+	req, ok := s.acceptCompat(w, r, api, parse)
+	if !ok {
+		return
+	}
+	tenant, entry := req.tenant, req.entry
+
+	// Cached bodies are in the API's format, so each API has its own
+	// namespace apart from /api/query responses.
+	cacheKey := cache.Key{Tenant: tenant, Lang: req.lang, ID: api.name + "|" + tenant + "|" + req.call.key}
+	if data, tier, ok := s.cache.Get(r.Context(), cacheKey); ok {
+		writeRawJSON(w, data)
+		entry.Duration, entry.Cached, entry.CacheTier, entry.Backend, entry.Bytes = time.Since(req.start), true, string(tier), "cache", int64(len(data))
+		s.auditLog.Log(entry)
+		return
+	}
+
+	result, tier, coalesced, err := s.fetch(r.Context(), w, tenant, cacheKey.ID, func(ctx context.Context) (backend.Result, cache.Tier, error) {
+		return req.call.run(ctx, tenant)
+	})
+	entry.Coalesced = coalesced
+	if err != nil {
+		status := errorStatus(err)
+		if errors.Is(err, errAPIUnsupported) {
+			status = http.StatusNotImplemented
+		}
+		api.writeError(w, status, err.Error())
+		entry.Duration, entry.Error = time.Since(req.start), err.Error()
+		s.auditLog.Log(entry)
+		return
+	}
+
+	switch {
+	case coalesced, result.Partial:
+	case req.call.ttl > 0:
+		s.cache.SetWithTTL(r.Context(), cacheKey, result.Payload, int64(len(result.Payload)), req.call.ttl)
+	default:
+		s.cache.Set(r.Context(), cacheKey, result.Payload, int64(len(result.Payload)))
+	}
+	writeRawJSON(w, result.Payload)
+
+	entry.Duration, entry.Cached, entry.CacheTier = time.Since(req.start), tier != "", string(tier)
+	entry.Cost, entry.Backend, entry.Breaker = result.Cost, result.Backend, result.Breaker
+	entry.Bytes, entry.Rows = int64(len(result.Payload)), int64(result.Rows)
+	s.auditLog.Log(entry)
+}
+
+func writeRawJSON(w http.ResponseWriter, payload []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(payload)
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/query"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// cursor is the decoded form of an opaque pagination cursor. The
+// fingerprint ties it to the query it was issued for so a cursor cannot be
+// replayed against a different search.
+type cursor struct {
+	Offset      int    `json:"o"`
+	Fingerprint string `json:"f"`
+}
+
+func encodeCursor(req query.Request, tenant string, offset int) string {
+	data, _ := json.Marshal(cursor{Offset: offset, Fingerprint: cursorFingerprint(req, tenant)})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(req query.Request, tenant string) (int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(req.Cursor)
+	if err != nil {
+		return 0, errInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Offset < 0 {
+		return 0, errInvalidCursor
+	}
+	if c.Fingerprint != cursorFingerprint(req, tenant) {
+		return 0, errors.New("cursor does not match query")
+	}
+	return c.Offset, nil
+}
+
+func cursorFingerprint(req query.Request, tenant string) string {
+	h := sha256.New()
+	parts := []string{req.Lang, req.Query, req.Start.UTC().Format(time.RFC3339Nano), req.End.UTC().Format(time.RFC3339Nano), tenant}
+	if req.Forward() {
+		parts = append(parts, query.DirectionForward)
+	}
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/audit"
+	"github.com/xscopehub/observe-gateway/internal/backend"
+	"github.com/xscopehub/observe-gateway/internal/cache"
+	"github.com/xscopehub/observe-gateway/internal/query"
+)
+
+// costHistoryTTL is how long the last observed backend cost of a query is
+// kept to estimate the cost of explained queries.
+const costHistoryTTL = 24 * time.Hour
+
+// Cost estimate bases of an explanation.
+const (
+	costBasisCache   = "cache"
+	costBasisHistory = "history"
+	costBasisUnknown = "unknown"
+)
+
+// explainBackend is implemented by backends that plan a query without
+// running it.
+type explainBackend interface {
+	Explain(context.Context, string, query.Request) (backend.Plan, error)
+}
+
+// explanation is the response of POST /api/query/explain.
+type explanation struct {
+	Lang     string            `json:"lang"`
+	Tenant   string            `json:"tenant"`
+	Template *resolvedTemplate `json:"template,omitempty"`
+	// Query is the query after template resolution; BackendQuery is the
+	// query sent to the backend after tenant label enforcement.
+	Query          string   `json:"query"`
+	BackendQuery   string   `json:"backend_query"`
+	SQL            string   `json:"sql,omitempty"`
+	Backend        string   `json:"backend"`
+	Breaker        string   `json:"breaker,omitempty"`
+	Org            string   `json:"org,omitempty"`
+	TenantMatchers []string `json:"tenant_matchers"`
+	CacheKey       string   `json:"cache_key"`
+	Cached         bool     `json:"cached"`
+	CacheTier      string   `json:"cache_tier,omitempty"`
+	// EstimatedCost is null when the query has no cost history.
+	EstimatedCost *int64 `json:"estimated_cost"`
+	CostBasis     string `json:"cost_basis"`
+}
+
+// observedCost is the backend cost of a query over a time range.
+type observedCost struct {
+	Cost         int64   `json:"cost"`
+	RangeSeconds float64 `json:"range_seconds"`
+}
+
+// handleExplain runs the /api/query pipeline up to translation and reports
+// the plan instead of executing it: POST /api/query/explain takes the same
+// body as /api/query. Explaining consumes no rate limit tokens or budget.
+func (s *Server) handleExplain(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	p, ok := s.prepareQuery(w, r, start)
+	if !ok {
+		return
+	}
+	req, tenant, user := p.req, p.tenant, p.user
+
+	explainer, ok := s.backend.(explainBackend)
+	if !ok {
+		s.writeError(w, http.StatusNotImplemented, errAPIUnsupported.Error())
+		return
+	}
+	plan, err := explainer.Explain(r.Context(), tenant, req)
+	if err != nil {
+		s.writeError(w, errorStatus(err), err.Error())
+		s.auditLog.Log(audit.Entry{Tenant: tenant, User: user, Lang: req.Lang, Query: req.Query, Duration: time.Since(start), Error: err.Error()})
+		return
+	}
+
+	cacheKey := cache.Key{Tenant: tenant, Lang: req.Lang, Template: req.Template, ID: buildCacheKey(req, tenant)}
+	resp := explanation{
+		Lang:           req.Lang,
+		Tenant:         tenant,
+		Template:       p.template,
+		Query:          req.Query,
+		BackendQuery:   plan.Query,
+		SQL:            plan.SQL,
+		Backend:        plan.Backend,
+		Breaker:        plan.Breaker,
+		Org:            plan.Org,
+		TenantMatchers: plan.Matchers,
+		CacheKey:       cacheKey.ID,
+		CostBasis:      costBasisUnknown,
+	}
+	// Streamed responses are never cached.
+	if req.Stream == "" {
+		_, tier, cached := s.cache.Peek(r.Context(), cacheKey)
+		resp.Cached, resp.CacheTier = cached, string(tier)
+	}
+	if resp.Cached {
+		var none int64
+		resp.EstimatedCost, resp.CostBasis = &none, costBasisCache
+	} else if cost, ok := s.estimateCost(r.Context(), tenant, req); ok {
+		resp.EstimatedCost, resp.CostBasis = &cost, costBasisHistory
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+// recordCost keeps the backend cost of a query run to estimate the cost of
+// explaining it later.
+func (s *Server) recordCost(ctx context.Context, tenant string, req query.Request, cost int64) {
+	if cost <= 0 {
+		return
+	}
+	data, err := json.Marshal(observedCost{Cost: cost, RangeSeconds: rangeSeconds(req)})
+	if err != nil {
+		return
+	}
+	s.cache.SetWithTTL(ctx, costKey(tenant, req), data, int64(len(data)), costHistoryTTL)
+}
+
+// estimateCost scales the last observed cost of a query to the requested
+// time range, since backend costs such as scanned bytes grow with it.
+func (s *Server) estimateCost(ctx context.Context, tenant string, req query.Request) (int64, bool) {
+	data, _, ok := s.cache.Peek(ctx, costKey(tenant, req))
+	if !ok {
+		return 0, false
+	}
+	var observed observedCost
+	if json.Unmarshal(data, &observed) != nil {
+		return 0, false
+	}
+	requested := rangeSeconds(req)
+	if observed.RangeSeconds <= 0 || requested <= 0 {
+		return observed.Cost, true
+	}
+	return int64(math.Round(float64(observed.Cost) * requested / observed.RangeSeconds)), true
+}
+
+// costKey identifies the cost history of a query regardless of its time
+// range and of the template it was rendered from.
+func costKey(tenant string, req query.Request) cache.Key {
+	return cache.Key{Tenant: tenant, Lang: req.Lang, ID: "cost|" + tenant + "|" + req.Lang + "|" + req.Query}
+}
+
+func rangeSeconds(req query.Request) float64 {
+	if !req.HasTimeRange() {
+		return 0
+	}
+	return req.End.Sub(req.Start).Seconds()
+}
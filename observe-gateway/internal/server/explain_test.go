@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/audit"
+	"github.com/xscopehub/observe-gateway/internal/backend"
+	"github.com/xscopehub/observe-gateway/internal/cache"
+	"github.com/xscopehub/observe-gateway/internal/config"
+	"github.com/xscopehub/observe-gateway/internal/query"
+)
+
+// explainStub is a backend that also plans queries.
+type explainStub struct {
+	stubBackend
+}
+
+func (explainStub) Explain(_ context.Context, tenant string, req query.Request) (backend.Plan, error) {
+	return backend.Plan{
+		Query:    strings.Replace(req.Query, "{", `{tenant="`+tenant+`",`, 1),
+		Backend:  "openobserve-promql",
+		Breaker:  "closed",
+		Matchers: []string{`tenant="` + tenant + `"`},
+	}, nil
+}
+
+func TestExplain(t *testing.T) {
+	cacheStore, err := cache.New(cache.Config{Enabled: true, NumCounters: 1000, MaxCost: 1 << 20, TTL: time.Minute})
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	calls := 0
+	stub := explainStub{stubBackend{
+		queryPromQL: func(context.Context, string, query.Request) (backend.Result, error) {
+			calls++
+			return backend.Result{Payload: json.RawMessage(`{"status":"success","data":{"resultType":"matrix","result":[]}}`), Backend: "openobserve-promql", Cost: 100}, nil
+		},
+	}}
+	cfg := config.Config{QueryTemplates: map[string]config.QueryTemplateConfig{
+		"error_rate": {Lang: "promql", Query: `sum(rate(http_requests_total{service="{{service}}",status=~"5.."}[5m]))`, Step: "1m"},
+	}}
+	handler := New(cfg, nil, nil, nil, stub, cacheStore, nil, audit.New(false, nil)).Handler()
+
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	body := func(hours int) string {
+		return fmt.Sprintf(`{"template":"error_rate","variables":{"service":"api"},"start":%q,"end":%q}`, start.Format(time.RFC3339), start.Add(time.Duration(hours)*time.Hour).Format(time.RFC3339))
+	}
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("X-Tenant", "tenant-a")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		cacheStore.Wait()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s status = %d, want %d: %s", path, rec.Code, http.StatusOK, rec.Body.String())
+		}
+		return rec
+	}
+	explain := func(body string) explanation {
+		var got explanation
+		if err := json.Unmarshal(post("/api/query/explain", body).Body.Bytes(), &got); err != nil {
+			t.Fatalf("json.Unmarshal() error = %v", err)
+		}
+		return got
+	}
+
+	got := explain(body(1))
+	wantQuery := `sum(rate(http_requests_total{service="api",status=~"5.."}[5m]))`
+	if got.Template == nil || got.Template.Name != "error_rate" || got.Template.Scope != "global" || got.Template.Rendered.Query != wantQuery {
+		t.Fatalf("template = %+v, want the rendered global template", got.Template)
+	}
+	if got.Query != wantQuery || !strings.HasPrefix(got.BackendQuery, `sum(rate(http_requests_total{tenant="tenant-a",`) {
+		t.Fatalf("query = %q, backend query = %q", got.Query, got.BackendQuery)
+	}
+	if got.Backend != "openobserve-promql" || len(got.TenantMatchers) != 1 || !strings.Contains(got.CacheKey, wantQuery) {
+		t.Fatalf("explanation = %+v", got)
+	}
+	if got.Cached || got.EstimatedCost != nil || got.CostBasis != costBasisUnknown {
+		t.Fatalf("cached = %v, estimated cost = %v (%s), want an uncached query without history", got.Cached, got.EstimatedCost, got.CostBasis)
+	}
+	if calls != 0 {
+		t.Fatalf("backend calls = %d after explain, want 0", calls)
+	}
+
+	post("/api/query", body(1))
+	got = explain(body(1))
+	if !got.Cached || got.CacheTier != "l1" || got.EstimatedCost == nil || *got.EstimatedCost != 0 || got.CostBasis != costBasisCache {
+		t.Fatalf("cached = %v (%s), estimated cost = %v (%s), want a cached query costing nothing", got.Cached, got.CacheTier, got.EstimatedCost, got.CostBasis)
+	}
+	got = explain(body(3))
+	if got.Cached || got.EstimatedCost == nil || *got.EstimatedCost != 300 || got.CostBasis != costBasisHistory {
+		t.Fatalf("cached = %v, estimated cost = %v (%s), want 300 scaled from history", got.Cached, got.EstimatedCost, got.CostBasis)
+	}
+	if calls != 1 {
+		t.Fatalf("backend calls = %d, want 1", calls)
+	}
+}
@@ -0,0 +1,420 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/net/websocket"
+
+	"github.com/xscopehub/observe-gateway/internal/backend"
+	"github.com/xscopehub/observe-gateway/internal/cache"
+	"github.com/xscopehub/observe-gateway/internal/frame"
+	"github.com/xscopehub/observe-gateway/internal/logql"
+	"github.com/xscopehub/observe-gateway/internal/lokiapi"
+	"github.com/xscopehub/observe-gateway/internal/query"
+	"github.com/xscopehub/observe-gateway/internal/telemetry"
+)
+
+const (
+	lokiDefaultLimit = 100
+	// lokiMaxDelay bounds the delay_for parameter of the tail endpoint.
+	lokiMaxDelay = 5 * time.Second
+	// lokiTailInterval is how often a tail polls the backend for new logs.
+	lokiTailInterval = time.Second
+)
+
+var lokiAPI = compatAPI{name: "loki", lang: "logql", writeError: writeTextError}
+
+// logLabelsBackend is implemented by backends that list the label names
+// and values of log streams.
+type logLabelsBackend interface {
+	QueryLogLabels(context.Context, string, query.MetadataRequest) (backend.Result, error)
+}
+
+// routeLoki serves the Loki HTTP API under /loki, so Grafana's Loki data
+// source and logcli can query logs through the gateway.
+func (s *Server) routeLoki(r chi.Router) {
+	r.Get("/query_range", s.handleLokiQueryRange)
+	r.Post("/query_range", s.handleLokiQueryRange)
+	r.Get("/labels", s.handleLokiLabels)
+	r.Get("/label", s.handleLokiLabels)
+	r.Get("/label/{name}/values", s.handleLokiLabelValues)
+	r.Get("/tail", s.handleLokiTail)
+}
+
+// handleLokiQueryRange runs a log or metric query over a time range:
+// /loki/api/v1/query_range. Log queries answer with a streams result and
+// metric queries with a matrix.
+func (s *Server) handleLokiQueryRange(w http.ResponseWriter, r *http.Request) {
+	s.serveCompat(w, r, lokiAPI, func(r *http.Request) (compatCall, error) {
+		req := query.Request{Lang: "logql", Query: r.Form.Get("query")}
+		expr, err := logql.ParseExpr(req.Query)
+		if err != nil {
+			return compatCall{}, fmt.Errorf("parse error: %w", err)
+		}
+		if req.End, err = parseLokiTime(r.Form.Get("end"), time.Now()); err != nil {
+			return compatCall{}, fmt.Errorf(`invalid parameter "end": %w`, err)
+		}
+		if req.Start, err = parseLokiTime(r.Form.Get("start"), req.End.Add(-time.Hour)); err != nil {
+			return compatCall{}, fmt.Errorf(`invalid parameter "start": %w`, err)
+		}
+		if !req.End.After(req.Start) {
+			return compatCall{}, errors.New("end timestamp must not be before or equal to start time")
+		}
+
+		if _, ok := expr.(*logql.LogQuery); !ok {
+			step := lokiDefaultStep(req.Start, req.End)
+			if v := r.Form.Get("step"); v != "" {
+				if step, err = parsePromDuration(v); err != nil {
+					return compatCall{}, fmt.Errorf(`invalid parameter "step": %w`, err)
+				}
+			}
+			if step <= 0 {
+				return compatCall{}, errors.New("zero or negative query resolution step widths are not accepted. Try a positive integer")
+			}
+			if req.End.Sub(req.Start)/step > maxPromPoints {
+				return compatCall{}, errors.New("exceeded maximum resolution of 11,000 points per timeseries. Try decreasing the query resolution (?step=XX)")
+			}
+			req.Step = step.String()
+			return compatCall{
+				query: req.Query,
+				key:   buildCacheKey(req, ""),
+				run: func(ctx context.Context, tenant string) (backend.Result, cache.Tier, error) {
+					return s.query(ctx, tenant, req)
+				},
+			}, nil
+		}
+
+		if req.Limit, err = s.parseLokiLimit(r.Form.Get("limit")); err != nil {
+			return compatCall{}, err
+		}
+		switch direction := strings.ToLower(r.Form.Get("direction")); direction {
+		case "", query.DirectionBackward:
+		case query.DirectionForward:
+			req.Direction = direction
+		default:
+			return compatCall{}, fmt.Errorf("invalid direction %q", r.Form.Get("direction"))
+		}
+		return compatCall{
+			query: req.Query,
+			key:   buildCacheKey(req, ""),
+			run: func(ctx context.Context, tenant string) (backend.Result, cache.Tier, error) {
+				res, tier, err := s.query(ctx, tenant, req)
+				if err != nil {
+					return res, tier, err
+				}
+				f, err := frame.FromLogHits(res.Payload)
+				if err != nil {
+					return backend.Result{}, "", fmt.Errorf("convert log hits: %w", err)
+				}
+				resp, err := lokiapi.NewStreams(lokiapi.GroupRows(f.Rows, req.Forward()))
+				if err != nil {
+					return backend.Result{}, "", err
+				}
+				resp.Warnings = res.Warnings
+				// Convert before the body is shared with coalesced requests
+				// and cached.
+				if res.Payload, err = json.Marshal(resp); err != nil {
+					return backend.Result{}, "", err
+				}
+				return res, tier, nil
+			},
+		}, nil
+	})
+}
+
+// handleLokiLabels lists label names: /loki/api/v1/labels.
+func (s *Server) handleLokiLabels(w http.ResponseWriter, r *http.Request) {
+	s.serveCompat(w, r, lokiAPI, func(r *http.Request) (compatCall, error) {
+		return s.lokiLabelsCall(r, query.MetadataRequest{Kind: query.MetadataLabels})
+	})
+}
+
+// handleLokiLabelValues lists the values of a label:
+// /loki/api/v1/label/{name}/values.
+func (s *Server) handleLokiLabelValues(w http.ResponseWriter, r *http.Request) {
+	s.serveCompat(w, r, lokiAPI, func(r *http.Request) (compatCall, error) {
+		name := chi.URLParam(r, "name")
+		if !labelNamePattern.MatchString(name) {
+			return compatCall{}, fmt.Errorf("invalid label name: %q", name)
+		}
+		return s.lokiLabelsCall(r, query.MetadataRequest{Kind: query.MetadataLabelValues, Label: name})
+	})
+}
+
+// lokiLabelsCall lists label names or values with the start, end and query
+// parameters of r. The optional query is a stream selector narrowing the
+// streams considered.
+func (s *Server) lokiLabelsCall(r *http.Request, req query.MetadataRequest) (compatCall, error) {
+	var err error
+	if req.End, err = parseLokiTime(r.Form.Get("end"), time.Now()); err != nil {
+		return compatCall{}, fmt.Errorf(`invalid parameter "end": %w`, err)
+	}
+	if req.Start, err = parseLokiTime(r.Form.Get("start"), req.End.Add(-6*time.Hour)); err != nil {
+		return compatCall{}, fmt.Errorf(`invalid parameter "start": %w`, err)
+	}
+	if req.End.Before(req.Start) {
+		return compatCall{}, errors.New("end timestamp must not be before start time")
+	}
+	if selector := r.Form.Get("query"); selector != "" {
+		expr, err := logql.ParseExpr(selector)
+		if err != nil {
+			return compatCall{}, fmt.Errorf("parse error: %w", err)
+		}
+		if _, ok := expr.(*logql.LogQuery); !ok {
+			return compatCall{}, errors.New("query must be a stream selector")
+		}
+		req.Matchers = []string{selector}
+	}
+
+	desc := req.Kind
+	if req.Label != "" {
+		desc += "(" + req.Label + ")"
+	}
+	if len(req.Matchers) > 0 {
+		desc += " " + req.Matchers[0]
+	}
+	key := []string{desc, formatPromTime(req.Start), formatPromTime(req.End)}
+	return compatCall{
+		query: desc,
+		key:   strings.Join(key, "|"),
+		run: func(ctx context.Context, tenant string) (backend.Result, cache.Tier, error) {
+			labels, ok := s.backend.(logLabelsBackend)
+			if !ok {
+				return backend.Result{}, "", errAPIUnsupported
+			}
+			res, err := labels.QueryLogLabels(ctx, tenant, req)
+			return res, "", err
+		},
+	}, nil
+}
+
+// lokiTail is a parsed tail request.
+type lokiTail struct {
+	req   query.Request
+	delay time.Duration
+}
+
+// handleLokiTail streams new log lines over a websocket:
+// /loki/api/v1/tail. The gateway polls the backend every second for lines
+// between the last poll and now minus delay_for, and sends them as tail
+// responses. The tail ends when the client disconnects, the query fails or
+// the tenant's budget is exhausted.
+func (s *Server) handleLokiTail(w http.ResponseWriter, r *http.Request) {
+	telemetry.ActiveRequests.Inc()
+	defer telemetry.ActiveRequests.Dec()
+
+	var tail lokiTail
+	req, ok := s.acceptCompat(w, r, lokiAPI, func(r *http.Request) (compatCall, error) {
+		var err error
+		if tail, err = s.parseLokiTail(r); err != nil {
+			return compatCall{}, err
+		}
+		return compatCall{query: tail.req.Query}, nil
+	})
+	if !ok {
+		return
+	}
+
+	ws := websocket.Server{
+		// Callers are authenticated by the gateway, so cross-origin
+		// clients such as Grafana are accepted.
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(conn *websocket.Conn) {
+			stats, err := s.tailLoki(r.Context(), conn, req.tenant, tail)
+			entry := req.entry
+			entry.Duration, entry.Backend, entry.Cost = time.Since(req.start), stats.backend, stats.cost
+			entry.Rows, entry.Bytes = stats.rows, stats.bytes
+			if err != nil {
+				entry.Error = err.Error()
+			}
+			s.auditLog.Log(entry)
+		},
+	}
+	ws.ServeHTTP(w, r)
+}
+
+// tailStats sums up the polls of a tail for its audit entry.
+type tailStats struct {
+	rows, bytes, cost int64
+	backend           string
+}
+
+// tailLoki polls the backend for the tail's query until the connection
+// closes.
+func (s *Server) tailLoki(ctx context.Context, conn *websocket.Conn, tenant string, tail lokiTail) (tailStats, error) {
+	var stats tailStats
+	// The tail outlives the server's write timeout.
+	conn.SetDeadline(time.Time{})
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		// Clients send nothing; a read returns when the connection closes.
+		io.Copy(io.Discard, conn)
+		cancel()
+	}()
+
+	ticker := time.NewTicker(lokiTailInterval)
+	defer ticker.Stop()
+	from := tail.req.Start
+	for {
+		// OpenObserve stores microseconds and includes both ends of a
+		// search, so the next window starts a microsecond after this one.
+		to := time.Now().Add(-tail.delay).Truncate(time.Microsecond)
+		if !to.Before(from) {
+			req := tail.req
+			req.Start, req.End, req.Direction = from, to, query.DirectionForward
+			if done, err := s.tailWindow(ctx, conn, tenant, req, &stats); done || err != nil {
+				return stats, err
+			}
+			from = to.Add(time.Microsecond)
+		}
+
+		select {
+		case <-ctx.Done():
+			return stats, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// tailWindow sends the log lines of one poll window, oldest first, a page
+// of req.Limit lines per message. It reports done when the connection has
+// closed.
+func (s *Server) tailWindow(ctx context.Context, conn *websocket.Conn, tenant string, req query.Request, stats *tailStats) (bool, error) {
+	for {
+		if _, err := s.limiter.CheckBudget(ctx, tenant); err != nil {
+			return false, err
+		}
+		res, err := s.backend.QueryLogQL(ctx, tenant, req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return true, nil
+			}
+			return false, err
+		}
+		stats.cost, stats.backend = stats.cost+res.Cost, res.Backend
+		if res.Cost > 0 {
+			if _, err := s.limiter.ChargeBudget(ctx, tenant, res.Cost); err != nil {
+				log.Printf("charge query budget for %s: %v", tenant, err)
+			}
+		}
+		f, err := frame.FromLogHits(res.Payload)
+		if err != nil {
+			return false, fmt.Errorf("convert log hits: %w", err)
+		}
+		if len(f.Rows) == 0 {
+			return false, nil
+		}
+		msg, err := json.Marshal(lokiapi.TailResponse{Streams: lokiapi.GroupRows(f.Rows, true)})
+		if err != nil {
+			return false, err
+		}
+		if err := websocket.Message.Send(conn, string(msg)); err != nil {
+			return true, nil
+		}
+		stats.rows, stats.bytes = stats.rows+int64(len(f.Rows)), stats.bytes+int64(len(msg))
+		if !res.HasMore {
+			return false, nil
+		}
+		req.Offset += len(f.Rows)
+	}
+}
+
+// parseLokiTail parses the query, start, limit and delay_for parameters of
+// a tail request.
+func (s *Server) parseLokiTail(r *http.Request) (lokiTail, error) {
+	req := query.Request{Lang: "logql", Query: r.Form.Get("query")}
+	expr, err := logql.ParseExpr(req.Query)
+	if err != nil {
+		return lokiTail{}, fmt.Errorf("parse error: %w", err)
+	}
+	if _, ok := expr.(*logql.LogQuery); !ok {
+		return lokiTail{}, errors.New("tail is only supported for log queries")
+	}
+	if req.Start, err = parseLokiTime(r.Form.Get("start"), time.Now().Add(-time.Hour)); err != nil {
+		return lokiTail{}, fmt.Errorf(`invalid parameter "start": %w`, err)
+	}
+	if req.Limit, err = s.parseLokiLimit(r.Form.Get("limit")); err != nil {
+		return lokiTail{}, err
+	}
+	var delay time.Duration
+	if v := r.Form.Get("delay_for"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 0 {
+			return lokiTail{}, errors.New(`invalid parameter "delay_for": must be a non-negative integer`)
+		}
+		if delay = time.Duration(seconds) * time.Second; delay > lokiMaxDelay {
+			return lokiTail{}, fmt.Errorf("delay_for can't be greater than %d", int(lokiMaxDelay.Seconds()))
+		}
+	}
+	return lokiTail{req: req, delay: delay}, nil
+}
+
+// parseLokiLimit parses the limit parameter, which defaults to 100 and is
+// bounded by the server's maximum page size.
+func (s *Server) parseLokiLimit(v string) (int, error) {
+	max := s.config().Server.MaxPageSize
+	if v == "" {
+		if max > 0 {
+			return min(lokiDefaultLimit, max), nil
+		}
+		return lokiDefaultLimit, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit <= 0 {
+		return 0, errors.New(`invalid parameter "limit": limit must be a positive value`)
+	}
+	if max > 0 && limit > max {
+		return 0, fmt.Errorf(`invalid parameter "limit": limit must not exceed %d`, max)
+	}
+	return limit, nil
+}
+
+// parseLokiTime parses a Loki timestamp: Unix seconds with a fractional
+// part, Unix seconds or nanoseconds, or an RFC 3339 time. An empty value
+// yields def.
+func parseLokiTime(v string, def time.Time) (time.Time, error) {
+	if v == "" {
+		return def, nil
+	}
+	if strings.Contains(v, ".") {
+		if t, err := strconv.ParseFloat(v, 64); err == nil {
+			sec, frac := math.Modf(t)
+			return time.Unix(int64(sec), int64(math.Round(frac*1e9))).UTC(), nil
+		}
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if len(v) <= 10 {
+			return time.Unix(n, 0).UTC(), nil
+		}
+		return time.Unix(0, n).UTC(), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q to a valid timestamp", v)
+}
+
+// lokiDefaultStep is Loki's default metric query step: the range split
+// into about 250 points, at least one second apart.
+func lokiDefaultStep(start, end time.Time) time.Duration {
+	seconds := math.Ceil(end.Sub(start).Seconds() / 250)
+	return time.Duration(max(seconds, 1)) * time.Second
+}
+
+// writeTextError writes an error in plain text, as Loki and Tempo do.
+func writeTextError(w http.ResponseWriter, status int, msg string) {
+	http.Error(w, msg, status)
+}
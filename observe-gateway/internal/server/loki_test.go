@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/xscopehub/observe-gateway/internal/audit"
+	"github.com/xscopehub/observe-gateway/internal/backend"
+	"github.com/xscopehub/observe-gateway/internal/cache"
+	"github.com/xscopehub/observe-gateway/internal/config"
+	"github.com/xscopehub/observe-gateway/internal/lokiapi"
+	"github.com/xscopehub/observe-gateway/internal/query"
+)
+
+func TestLokiAPI(t *testing.T) {
+	cacheStore, err := cache.New(cache.Config{Enabled: false})
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	const matrix = `{"status":"success","data":{"resultType":"matrix","result":[]}}`
+	var queries []query.Request
+	var labels []query.MetadataRequest
+	stub := stubBackend{
+		queryLogQL: func(_ context.Context, tenant string, req query.Request) (backend.Result, error) {
+			if tenant != "tenant-a" {
+				t.Fatalf("tenant = %q, want tenant-a", tenant)
+			}
+			queries = append(queries, req)
+			if strings.HasPrefix(req.Query, "count_over_time") {
+				return backend.Result{Payload: json.RawMessage(matrix)}, nil
+			}
+			return backend.Result{Payload: json.RawMessage(`{"hits":[` +
+				`{"_timestamp":1700000001000000,"labels":{"app":"web"},"message":"b"},` +
+				`{"_timestamp":1700000000000000,"labels":{"app":"web"},"message":"a"}]}`)}, nil
+		},
+		logLabels: func(_ context.Context, _ string, req query.MetadataRequest) (backend.Result, error) {
+			labels = append(labels, req)
+			return backend.Result{Payload: json.RawMessage(`{"status":"success","data":["app"]}`)}, nil
+		},
+	}
+	handler := New(config.Config{Server: config.ServerConfig{MaxPageSize: 500}}, nil, nil, nil, stub, cacheStore, nil, audit.New(false, nil)).Handler()
+
+	do := func(path string, form url.Values, want int) string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path+"?"+form.Encode(), nil)
+		req.Header.Set("X-Tenant", "tenant-a")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Fatalf("GET %s status = %d, want %d: %s", path, rec.Code, want, rec.Body.String())
+		}
+		return rec.Body.String()
+	}
+
+	body := do("/loki/api/v1/query_range", url.Values{
+		"query": {`{app="web"} |= "error"`},
+		"start": {"1700000000"},
+		"end":   {"1700003600000000000"},
+	}, http.StatusOK)
+	want := `{"status":"success","data":{"resultType":"streams","result":[{"stream":{"app":"web"},"values":[["1700000001000000000","b"],["1700000000000000000","a"]]}]}}`
+	if body != want {
+		t.Fatalf("streams body = %s, want %s", body, want)
+	}
+	if got := queries[0]; got.Limit != 100 || got.Step != "" || got.End.Sub(got.Start) != time.Hour {
+		t.Fatalf("log query = %+v, want limit 100 over one hour", got)
+	}
+
+	// Metric queries keep the backend's matrix and get Loki's default step.
+	body = do("/loki/api/v1/query_range", url.Values{
+		"query": {`count_over_time({app="web"}[1m])`},
+		"start": {"1700000000"},
+		"end":   {"1700003600"},
+	}, http.StatusOK)
+	if body != matrix {
+		t.Fatalf("matrix body = %s, want %s", body, matrix)
+	}
+	if got := queries[1].Step; got != "15s" {
+		t.Fatalf("metric step = %q, want 15s", got)
+	}
+
+	// A forward search is passed to the backend, which orders the hits.
+	do("/loki/api/v1/query_range", url.Values{
+		"query":     {`{app="web"}`},
+		"direction": {"FORWARD"},
+	}, http.StatusOK)
+	if got := queries[2].Direction; got != query.DirectionForward {
+		t.Fatalf("direction = %q, want forward", got)
+	}
+
+	do("/loki/api/v1/label/app/values", url.Values{"query": {`{env="prod"}`}}, http.StatusOK)
+	if got := labels[0]; got.Label != "app" || len(got.Matchers) != 1 || got.End.Sub(got.Start) != 6*time.Hour {
+		t.Fatalf("label values request = %+v", got)
+	}
+
+	for _, tc := range []struct {
+		path string
+		form url.Values
+	}{
+		{"/loki/api/v1/query_range", url.Values{"query": {`{app=`}}},
+		{"/loki/api/v1/query_range", url.Values{"query": {`{app="web"}`}, "limit": {"1000"}}},
+		{"/loki/api/v1/query_range", url.Values{"query": {`{app="web"}`}, "direction": {"sideways"}}},
+		{"/loki/api/v1/labels", url.Values{"query": {`rate({app="web"}[1m])`}}},
+	} {
+		if body := do(tc.path, tc.form, http.StatusBadRequest); strings.HasPrefix(body, "{") {
+			t.Fatalf("error body = %s, want plain text", body)
+		}
+	}
+}
+
+func TestLokiTail(t *testing.T) {
+	cacheStore, err := cache.New(cache.Config{Enabled: false})
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	var mu sync.Mutex
+	var queries []query.Request
+	stub := stubBackend{
+		queryLogQL: func(_ context.Context, _ string, req query.Request) (backend.Result, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			queries = append(queries, req)
+			// Each window holds two lines, served a page at a time.
+			line := []string{"a", "b"}[req.Offset]
+			return backend.Result{Payload: json.RawMessage(`{"hits":[{"_timestamp":1700000000000000,"labels":{"app":"web"},"message":"` + line + `"}]}`), HasMore: req.Offset == 0}, nil
+		},
+	}
+	srv := httptest.NewServer(New(config.Config{}, nil, nil, nil, stub, cacheStore, nil, audit.New(false, nil)).Handler())
+	defer srv.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/loki/api/v1/tail?" + url.Values{
+		"query": {`{app="web"}`},
+		"limit": {"10"},
+	}.Encode()
+	cfg, err := websocket.NewConfig(wsURL, srv.URL)
+	if err != nil {
+		t.Fatalf("NewConfig() error = %v", err)
+	}
+	cfg.Header.Set("X-Tenant", "tenant-a")
+	conn, err := websocket.DialConfig(cfg)
+	if err != nil {
+		t.Fatalf("DialConfig() error = %v", err)
+	}
+	defer conn.Close()
+
+	// Both pages of the first window, then the first page of the next.
+	for i, want := range []string{"a", "b", "a"} {
+		var resp lokiapi.TailResponse
+		if err := websocket.JSON.Receive(conn, &resp); err != nil {
+			t.Fatalf("Receive() #%d error = %v", i, err)
+		}
+		if len(resp.Streams) != 1 || resp.Streams[0].Values[0].Line != want {
+			t.Fatalf("tail response #%d = %+v, want line %q", i, resp, want)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got := queries[0]; got.Limit != 10 || got.Query != `{app="web"}` || !got.HasTimeRange() || !got.Forward() {
+		t.Fatalf("tail query = %+v", got)
+	}
+	if got := queries[1]; got.Offset != 1 || !got.Start.Equal(queries[0].Start) || !got.End.Equal(queries[0].End) {
+		t.Fatalf("second page = %+v, want offset 1 of the first window", got)
+	}
+	// Both ends of a window are inclusive, so the next one starts after it.
+	if got := queries[2]; got.Offset != 0 || !got.Start.Equal(queries[0].End.Add(time.Microsecond)) {
+		t.Fatalf("next window = %+v, want it to start 1µs after %s", got, queries[0].End)
+	}
+
+	// A metric query cannot be tailed and is refused before the upgrade.
+	req := httptest.NewRequest(http.MethodGet, "/loki/api/v1/tail?query="+url.QueryEscape(`rate({app="web"}[1m])`), nil)
+	req.Header.Set("X-Tenant", "tenant-a")
+	rec := httptest.NewRecorder()
+	New(config.Config{}, nil, nil, nil, stub, cacheStore, nil, audit.New(false, nil)).Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("metric tail status = %d, want 400", rec.Code)
+	}
+}
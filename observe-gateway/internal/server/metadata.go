@@ -0,0 +1,317 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/xscopehub/observe-gateway/internal/backend"
+	"github.com/xscopehub/observe-gateway/internal/cache"
+	"github.com/xscopehub/observe-gateway/internal/query"
+)
+
+const (
+	// defaultDiscoveryLookback is searched when neither the request nor
+	// the configuration gives a time range.
+	defaultDiscoveryLookback = 6 * time.Hour
+	defaultServiceLabel      = "service"
+	// traceServiceField is the trace stream field naming a span's service.
+	traceServiceField = "service_name"
+)
+
+// traceLabelsBackend is implemented by backends that list the fields of
+// trace streams and their values.
+type traceLabelsBackend interface {
+	QueryTraceLabels(context.Context, string, query.MetadataRequest) (backend.Result, error)
+}
+
+// labelLookup lists label names, or the values of req.Label, as a
+// Prometheus label response.
+type labelLookup func(ctx context.Context, tenant string, req query.MetadataRequest) (backend.Result, error)
+
+// serviceSignal is a signal searched for services.
+type serviceSignal struct {
+	name string
+	lang string
+}
+
+var serviceSignals = []serviceSignal{{"metrics", "promql"}, {"logs", "logql"}, {"traces", "traceql"}}
+
+// metadataService is a service and the signals it was seen in.
+type metadataService struct {
+	Name    string   `json:"name"`
+	Signals []string `json:"signals"`
+}
+
+// routeMetadata serves the discovery endpoints under /api/metadata, which
+// list the services, labels and label values of the caller's tenant.
+func (s *Server) routeMetadata(r chi.Router) {
+	r.Get("/services", s.handleMetadataServices)
+	r.Get("/labels", s.handleMetadataLabels)
+	r.Get("/label/{name}/values", s.handleMetadataLabelValues)
+}
+
+func (s *Server) metadataAPI() compatAPI {
+	return compatAPI{name: "metadata", lang: "metadata", writeError: s.writeError}
+}
+
+// handleMetadataLabels lists the label names of one language's data:
+// /api/metadata/labels?lang=.
+func (s *Server) handleMetadataLabels(w http.ResponseWriter, r *http.Request) {
+	s.serveCompat(w, r, s.metadataAPI(), func(r *http.Request) (compatCall, error) {
+		return s.metadataLabelsCall(r, query.MetadataRequest{Kind: query.MetadataLabels})
+	})
+}
+
+// handleMetadataLabelValues lists the values of a label:
+// /api/metadata/label/{name}/values?lang=.
+func (s *Server) handleMetadataLabelValues(w http.ResponseWriter, r *http.Request) {
+	s.serveCompat(w, r, s.metadataAPI(), func(r *http.Request) (compatCall, error) {
+		return s.metadataLabelsCall(r, query.MetadataRequest{Kind: query.MetadataLabelValues, Label: chi.URLParam(r, "name")})
+	})
+}
+
+func (s *Server) metadataLabelsCall(r *http.Request, req query.MetadataRequest) (compatCall, error) {
+	lang := r.Form.Get("lang")
+	switch lang {
+	case "promql", "logql", "traceql":
+	case "":
+		return compatCall{}, errors.New("lang is required")
+	default:
+		return compatCall{}, fmt.Errorf("unsupported lang: %s", lang)
+	}
+	if err := s.parseMetadataRequest(r, &req); err != nil {
+		return compatCall{}, err
+	}
+
+	desc := req.Kind
+	if req.Label != "" {
+		desc += "(" + req.Label + ")"
+	}
+	return compatCall{
+		query: desc,
+		key:   metadataKey(desc, req),
+		lang:  lang,
+		ttl:   s.config().Discovery.TTL,
+		run: func(ctx context.Context, tenant string) (backend.Result, cache.Tier, error) {
+			lookup, ok := s.lookupFor(lang)
+			if !ok {
+				return backend.Result{}, "", errAPIUnsupported
+			}
+			res, values, err := lookupLabels(ctx, lookup, tenant, s.withLookback(req))
+			if err != nil {
+				return backend.Result{}, "", err
+			}
+			body := map[string]any{"lang": lang, "labels": values}
+			if req.Label != "" {
+				body = map[string]any{"lang": lang, "label": req.Label, "values": values}
+			}
+			if res.Payload, err = json.Marshal(body); err != nil {
+				return backend.Result{}, "", err
+			}
+			res.Rows = len(values)
+			return res, "", nil
+		},
+	}, nil
+}
+
+// handleMetadataServices lists the services seen in metrics, logs and
+// traces: /api/metadata/services. Services are the values of the
+// configured service label in metrics and logs and of service_name in
+// traces. Only the signals whose query:<lang> scope the caller holds are
+// searched; a signal skipped for lack of a scope or whose lookup fails is
+// reported as a warning.
+func (s *Server) handleMetadataServices(w http.ResponseWriter, r *http.Request) {
+	s.serveCompat(w, r, s.metadataAPI(), func(r *http.Request) (compatCall, error) {
+		req := query.MetadataRequest{Kind: query.MetadataLabelValues}
+		if err := s.parseMetadataRequest(r, &req); err != nil {
+			return compatCall{}, err
+		}
+		return compatCall{
+			query: "services",
+			narrow: func(c caller) (compatCall, error) {
+				var signals []serviceSignal
+				var warnings, names []string
+				var denied error
+				for _, signal := range serviceSignals {
+					if err := c.require("query:" + signal.lang); err != nil {
+						warnings = append(warnings, fmt.Sprintf("%s: %v", signal.name, err))
+						if denied == nil {
+							denied = err
+						}
+						continue
+					}
+					signals = append(signals, signal)
+					names = append(names, signal.name)
+				}
+				if len(signals) == 0 {
+					return compatCall{}, denied
+				}
+				return compatCall{
+					query: "services",
+					// Callers with different scopes see different services.
+					key: metadataKey("services("+strings.Join(names, ",")+")", req),
+					ttl: s.config().Discovery.TTL,
+					run: func(ctx context.Context, tenant string) (backend.Result, cache.Tier, error) {
+						res, err := s.discoverServices(ctx, tenant, s.withLookback(req), signals, warnings)
+						return res, "", err
+					},
+				}, nil
+			},
+		}, nil
+	})
+}
+
+// discoverServices searches signals for services, adding the warnings of
+// failed lookups to warnings.
+func (s *Server) discoverServices(ctx context.Context, tenant string, req query.MetadataRequest, signals []serviceSignal, warnings []string) (backend.Result, error) {
+	serviceLabel := s.config().Discovery.ServiceLabel
+	if serviceLabel == "" {
+		serviceLabel = defaultServiceLabel
+	}
+	type found struct {
+		res    backend.Result
+		values []string
+		err    error
+	}
+	results := make([]found, len(signals))
+	var wg sync.WaitGroup
+	for i, signal := range signals {
+		lookup, ok := s.lookupFor(signal.lang)
+		if !ok {
+			results[i].err = errAPIUnsupported
+			continue
+		}
+		signalReq := req
+		signalReq.Label = serviceLabel
+		if signal.lang == "traceql" {
+			signalReq.Label = traceServiceField
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i].res, results[i].values, results[i].err = lookupLabels(ctx, lookup, tenant, signalReq)
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[string][]string)
+	warnings = append([]string{}, warnings...)
+	var res backend.Result
+	var errs []error
+	for i, signal := range signals {
+		if err := results[i].err; err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: %v", signal.name, err))
+			errs = append(errs, err)
+			continue
+		}
+		res.Cost += results[i].res.Cost
+		for _, name := range results[i].values {
+			seen[name] = append(seen[name], signal.name)
+		}
+	}
+	if len(errs) == len(signals) {
+		return backend.Result{}, errors.Join(errs...)
+	}
+
+	services := make([]metadataService, 0, len(seen))
+	for name, seenIn := range seen {
+		sort.Strings(seenIn)
+		services = append(services, metadataService{Name: name, Signals: seenIn})
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	if req.Limit > 0 && len(services) > req.Limit {
+		services = services[:req.Limit]
+	}
+
+	payload, err := json.Marshal(map[string]any{"services": services, "warnings": warnings})
+	if err != nil {
+		return backend.Result{}, err
+	}
+	res.Payload, res.Backend, res.Rows = payload, "metadata", len(services)
+	return res, nil
+}
+
+// lookupFor returns the label lookup of a language, if the backend
+// serves it.
+func (s *Server) lookupFor(lang string) (labelLookup, bool) {
+	switch lang {
+	case "promql":
+		if b, ok := s.backend.(promMetadataBackend); ok {
+			return b.QueryPromMetadata, true
+		}
+	case "logql":
+		if b, ok := s.backend.(logLabelsBackend); ok {
+			return b.QueryLogLabels, true
+		}
+	case "traceql":
+		if b, ok := s.backend.(traceLabelsBackend); ok {
+			return b.QueryTraceLabels, true
+		}
+	}
+	return nil, false
+}
+
+// lookupLabels runs a label lookup and decodes the names or values it
+// returns.
+func lookupLabels(ctx context.Context, lookup labelLookup, tenant string, req query.MetadataRequest) (backend.Result, []string, error) {
+	res, err := lookup(ctx, tenant, req)
+	if err != nil {
+		return backend.Result{}, nil, err
+	}
+	var body struct {
+		Data []string `json:"data"`
+	}
+	if err := json.Unmarshal(res.Payload, &body); err != nil {
+		return backend.Result{}, nil, fmt.Errorf("decode label response: %w", err)
+	}
+	if body.Data == nil {
+		body.Data = []string{}
+	}
+	return res, body.Data, nil
+}
+
+// parseMetadataRequest parses the optional start, end and limit
+// parameters of a discovery request.
+func (s *Server) parseMetadataRequest(r *http.Request, req *query.MetadataRequest) error {
+	var err error
+	if req.Start, req.End, err = parseOptionalRange(r); err != nil {
+		return err
+	}
+	if v := r.Form.Get("limit"); v != "" {
+		if req.Limit, err = strconv.Atoi(v); err != nil || req.Limit < 0 {
+			return errors.New(`invalid parameter "limit": limit must be a non-negative integer`)
+		}
+	}
+	return nil
+}
+
+// withLookback fills in the time range of a request that has none: it ends
+// now and starts the configured lookback earlier. Defaults are applied when
+// the request runs, so cached results of the default range stay shared.
+func (s *Server) withLookback(req query.MetadataRequest) query.MetadataRequest {
+	lookback := s.config().Discovery.Lookback
+	if lookback <= 0 {
+		lookback = defaultDiscoveryLookback
+	}
+	if req.End.IsZero() {
+		req.End = time.Now()
+	}
+	if req.Start.IsZero() {
+		req.Start = req.End.Add(-lookback)
+	}
+	return req
+}
+
+func metadataKey(desc string, req query.MetadataRequest) string {
+	return strings.Join([]string{desc, formatPromTime(req.Start), formatPromTime(req.End), strconv.Itoa(req.Limit)}, "|")
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/audit"
+	"github.com/xscopehub/observe-gateway/internal/auth"
+	"github.com/xscopehub/observe-gateway/internal/backend"
+	"github.com/xscopehub/observe-gateway/internal/cache"
+	"github.com/xscopehub/observe-gateway/internal/config"
+	"github.com/xscopehub/observe-gateway/internal/query"
+)
+
+func TestMetadataDiscovery(t *testing.T) {
+	cacheStore, err := cache.New(cache.Config{Enabled: true, MaxCost: 1 << 20, NumCounters: 1e4, BufferItems: 64})
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	defer cacheStore.Close()
+	labels := func(values ...string) backend.Result {
+		payload, _ := json.Marshal(map[string]any{"status": "success", "data": values})
+		return backend.Result{Payload: payload, Cost: 1}
+	}
+	var promCalls, logCalls, traceCalls []query.MetadataRequest
+	stub := stubBackend{
+		promMetadata: func(_ context.Context, tenant string, req query.MetadataRequest) (backend.Result, error) {
+			promCalls = append(promCalls, req)
+			if req.Kind == query.MetadataLabels {
+				return labels("job", "__name__", "service"), nil
+			}
+			return labels("api", "db"), nil
+		},
+		logLabels: func(_ context.Context, _ string, req query.MetadataRequest) (backend.Result, error) {
+			logCalls = append(logCalls, req)
+			return backend.Result{}, errors.New("openobserve unavailable")
+		},
+		traceLabels: func(_ context.Context, _ string, req query.MetadataRequest) (backend.Result, error) {
+			traceCalls = append(traceCalls, req)
+			return labels("api", "web"), nil
+		},
+	}
+	cfg := config.Config{Discovery: config.DiscoveryConfig{TTL: time.Minute, Lookback: time.Hour, ServiceLabel: "app"}}
+	handler := New(cfg, nil, nil, nil, stub, cacheStore, nil, audit.New(false, nil)).Handler()
+
+	do := func(path string, form url.Values, want int) map[string]any {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path+"?"+form.Encode(), nil)
+		req.Header.Set("X-Tenant", "tenant-a")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Fatalf("GET %s status = %d, want %d: %s", path, rec.Code, want, rec.Body.String())
+		}
+		var body map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Unmarshal(%s) error = %v", rec.Body.String(), err)
+		}
+		return body
+	}
+
+	body := do("/api/metadata/labels", url.Values{"lang": {"promql"}}, http.StatusOK)
+	if got, _ := json.Marshal(body); string(got) != `{"labels":["job","__name__","service"],"lang":"promql"}` {
+		t.Fatalf("labels = %s", got)
+	}
+	if req := promCalls[0]; req.End.Sub(req.Start) != time.Hour || time.Since(req.End) > time.Minute {
+		t.Fatalf("default range = %v to %v, want the last hour", req.Start, req.End)
+	}
+	cacheStore.Wait()
+	do("/api/metadata/labels", url.Values{"lang": {"promql"}}, http.StatusOK)
+	if len(promCalls) != 1 {
+		t.Fatalf("prom calls = %d, want the repeated lookup served from cache", len(promCalls))
+	}
+
+	body = do("/api/metadata/label/service_name/values", url.Values{"lang": {"traceql"}, "start": {"1700000000"}, "end": {"1700003600"}}, http.StatusOK)
+	if body["label"] != "service_name" || len(body["values"].([]any)) != 2 {
+		t.Fatalf("values = %v", body)
+	}
+	if req := traceCalls[0]; req.Label != "service_name" || req.Start.Unix() != 1700000000 || req.End.Unix() != 1700003600 {
+		t.Fatalf("trace request = %+v", req)
+	}
+
+	body = do("/api/metadata/services", nil, http.StatusOK)
+	if got, _ := json.Marshal(body["services"]); string(got) != `[{"name":"api","signals":["metrics","traces"]},{"name":"db","signals":["metrics"]},{"name":"web","signals":["traces"]}]` {
+		t.Fatalf("services = %s", got)
+	}
+	if logCalls[0].Label != "app" {
+		t.Fatalf("log label = %q, want the configured service label app", logCalls[0].Label)
+	}
+	if warnings := body["warnings"].([]any); len(warnings) != 1 || warnings[0] != "logs: openobserve unavailable" {
+		t.Fatalf("warnings = %v", warnings)
+	}
+
+	do("/api/metadata/labels", nil, http.StatusBadRequest)
+	do("/api/metadata/labels", url.Values{"lang": {"sql"}}, http.StatusBadRequest)
+	do("/api/metadata/labels", url.Values{"lang": {"logql"}}, http.StatusBadGateway)
+}
+
+func TestMetadataServicesScopes(t *testing.T) {
+	cacheStore, err := cache.New(cache.Config{Enabled: true, MaxCost: 1 << 20, NumCounters: 1e4, BufferItems: 64})
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	defer cacheStore.Close()
+	labels := func(values ...string) backend.Result {
+		payload, _ := json.Marshal(map[string]any{"status": "success", "data": values})
+		return backend.Result{Payload: payload}
+	}
+	stub := stubBackend{
+		promMetadata: func(context.Context, string, query.MetadataRequest) (backend.Result, error) {
+			return labels("api"), nil
+		},
+		logLabels: func(context.Context, string, query.MetadataRequest) (backend.Result, error) {
+			return labels("web"), nil
+		},
+		traceLabels: func(context.Context, string, query.MetadataRequest) (backend.Result, error) {
+			return labels("db"), nil
+		},
+	}
+	keys := auth.NewAPIKeys(auth.NewMemoryKeyStore(), time.Minute)
+	handler := New(config.Config{}, auth.Chain{keys}, keys, nil, stub, cacheStore, nil, audit.New(false, nil)).Handler()
+
+	do := func(scopes []string, want int) map[string]any {
+		t.Helper()
+		_, secret, err := keys.Issue(context.Background(), "tenant-a", "discovery", scopes, nil)
+		if err != nil {
+			t.Fatalf("Issue() error = %v", err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/api/metadata/services", nil)
+		req.Header.Set("X-API-Key", secret)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Fatalf("scopes %v status = %d, want %d: %s", scopes, rec.Code, want, rec.Body.String())
+		}
+		var body map[string]any
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return body
+	}
+
+	// A key scoped to one language sees that signal's services only, even
+	// after a broader key cached its result.
+	do([]string{"query"}, http.StatusOK)
+	body := do([]string{"query:logql"}, http.StatusOK)
+	if got, _ := json.Marshal(body["services"]); string(got) != `[{"name":"web","signals":["logs"]}]` {
+		t.Fatalf("services = %s, want the log services", got)
+	}
+	if warnings := body["warnings"].([]any); len(warnings) != 2 || warnings[0] != "metrics: credentials lack the query:promql scope" {
+		t.Fatalf("warnings = %v, want the skipped metrics and traces", warnings)
+	}
+	do([]string{"templates"}, http.StatusForbidden)
+}
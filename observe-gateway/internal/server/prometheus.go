@@ -0,0 +1,262 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/xscopehub/observe-gateway/internal/backend"
+	"github.com/xscopehub/observe-gateway/internal/cache"
+	"github.com/xscopehub/observe-gateway/internal/query"
+)
+
+// maxPromPoints is the resolution limit Prometheus and Loki apply to range
+// queries.
+const maxPromPoints = 11000
+
+var prometheusAPI = compatAPI{name: "prometheus", lang: "promql", writeError: writePromError}
+
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// promMetadataBackend is implemented by backends that serve the Prometheus
+// series, labels and label values API.
+type promMetadataBackend interface {
+	QueryPromMetadata(context.Context, string, query.MetadataRequest) (backend.Result, error)
+}
+
+// routePrometheus serves the Prometheus HTTP API under /prometheus, so
+// PromQL clients such as Grafana and promtool can use the gateway as a
+// Prometheus data source.
+func (s *Server) routePrometheus(r chi.Router) {
+	for path, handler := range map[string]http.HandlerFunc{
+		"/query":       s.handlePromQuery,
+		"/query_range": s.handlePromQueryRange,
+		"/series":      s.handlePromSeries,
+		"/labels":      s.handlePromLabels,
+	} {
+		r.Get(path, handler)
+		r.Post(path, handler)
+	}
+	r.Get("/label/{name}/values", s.handlePromLabelValues)
+}
+
+// handlePromQuery evaluates an instant query: /prometheus/api/v1/query.
+func (s *Server) handlePromQuery(w http.ResponseWriter, r *http.Request) {
+	s.serveCompat(w, r, prometheusAPI, func(r *http.Request) (compatCall, error) {
+		req := query.Request{Lang: "promql", Query: r.Form.Get("query")}
+		if err := parsePromQuery(req.Query); err != nil {
+			return compatCall{}, err
+		}
+		if v := r.Form.Get("time"); v != "" {
+			t, err := parsePromTime(v)
+			if err != nil {
+				return compatCall{}, fmt.Errorf(`invalid parameter "time": %w`, err)
+			}
+			req.Time = t
+		}
+		return s.promQueryCall(req), nil
+	})
+}
+
+// handlePromQueryRange evaluates a range query:
+// /prometheus/api/v1/query_range.
+func (s *Server) handlePromQueryRange(w http.ResponseWriter, r *http.Request) {
+	s.serveCompat(w, r, prometheusAPI, func(r *http.Request) (compatCall, error) {
+		req := query.Request{Lang: "promql", Query: r.Form.Get("query")}
+		var err error
+		if req.Start, err = parsePromTime(r.Form.Get("start")); err != nil {
+			return compatCall{}, fmt.Errorf(`invalid parameter "start": %w`, err)
+		}
+		if req.End, err = parsePromTime(r.Form.Get("end")); err != nil {
+			return compatCall{}, fmt.Errorf(`invalid parameter "end": %w`, err)
+		}
+		if req.End.Before(req.Start) {
+			return compatCall{}, errors.New(`invalid parameter "end": end timestamp must not be before start time`)
+		}
+		step, err := parsePromDuration(r.Form.Get("step"))
+		if err != nil {
+			return compatCall{}, fmt.Errorf(`invalid parameter "step": %w`, err)
+		}
+		if step <= 0 {
+			return compatCall{}, errors.New("zero or negative query resolution step widths are not accepted. Try a positive integer")
+		}
+		if req.End.Sub(req.Start)/step > maxPromPoints {
+			return compatCall{}, errors.New("exceeded maximum resolution of 11,000 points per timeseries. Try decreasing the query resolution (?step=XX)")
+		}
+		req.Step = step.String()
+		if err := parsePromQuery(req.Query); err != nil {
+			return compatCall{}, err
+		}
+		return s.promQueryCall(req), nil
+	})
+}
+
+// handlePromSeries lists the series matching selectors:
+// /prometheus/api/v1/series.
+func (s *Server) handlePromSeries(w http.ResponseWriter, r *http.Request) {
+	s.serveCompat(w, r, prometheusAPI, func(r *http.Request) (compatCall, error) {
+		if len(r.Form["match[]"]) == 0 {
+			return compatCall{}, errors.New("no match[] parameter provided")
+		}
+		return s.promMetadataCall(r, query.MetadataRequest{Kind: query.MetadataSeries})
+	})
+}
+
+// handlePromLabels lists label names: /prometheus/api/v1/labels.
+func (s *Server) handlePromLabels(w http.ResponseWriter, r *http.Request) {
+	s.serveCompat(w, r, prometheusAPI, func(r *http.Request) (compatCall, error) {
+		return s.promMetadataCall(r, query.MetadataRequest{Kind: query.MetadataLabels})
+	})
+}
+
+// handlePromLabelValues lists the values of a label:
+// /prometheus/api/v1/label/{name}/values.
+func (s *Server) handlePromLabelValues(w http.ResponseWriter, r *http.Request) {
+	s.serveCompat(w, r, prometheusAPI, func(r *http.Request) (compatCall, error) {
+		name := chi.URLParam(r, "name")
+		if !labelNamePattern.MatchString(name) {
+			return compatCall{}, fmt.Errorf("invalid label name: %q", name)
+		}
+		return s.promMetadataCall(r, query.MetadataRequest{Kind: query.MetadataLabelValues, Label: name})
+	})
+}
+
+// promQueryCall runs a PromQL query like /api/query, assembling range
+// queries from cached extents.
+func (s *Server) promQueryCall(req query.Request) compatCall {
+	return compatCall{
+		query: req.Query,
+		key:   buildCacheKey(req, ""),
+		run: func(ctx context.Context, tenant string) (backend.Result, cache.Tier, error) {
+			return s.query(ctx, tenant, req)
+		},
+	}
+}
+
+// promMetadataCall lists series, label names or label values with the
+// match[], start, end and limit parameters of r.
+func (s *Server) promMetadataCall(r *http.Request, req query.MetadataRequest) (compatCall, error) {
+	req.Matchers = r.Form["match[]"]
+	for _, selector := range req.Matchers {
+		if _, err := parser.ParseMetricSelector(selector); err != nil {
+			return compatCall{}, fmt.Errorf(`invalid parameter "match[]": %w`, err)
+		}
+	}
+	var err error
+	if v := r.Form.Get("start"); v != "" {
+		if req.Start, err = parsePromTime(v); err != nil {
+			return compatCall{}, fmt.Errorf(`invalid parameter "start": %w`, err)
+		}
+	}
+	if v := r.Form.Get("end"); v != "" {
+		if req.End, err = parsePromTime(v); err != nil {
+			return compatCall{}, fmt.Errorf(`invalid parameter "end": %w`, err)
+		}
+	}
+	if v := r.Form.Get("limit"); v != "" {
+		if req.Limit, err = strconv.Atoi(v); err != nil || req.Limit < 0 {
+			return compatCall{}, errors.New(`invalid parameter "limit": limit must be a non-negative integer`)
+		}
+	}
+
+	desc := req.Kind
+	if req.Label != "" {
+		desc += "(" + req.Label + ")"
+	}
+	if len(req.Matchers) > 0 {
+		desc += " " + strings.Join(req.Matchers, " ")
+	}
+	key := []string{desc, formatPromTime(req.Start), formatPromTime(req.End), strconv.Itoa(req.Limit)}
+	return compatCall{
+		query: desc,
+		key:   strings.Join(key, "|"),
+		run: func(ctx context.Context, tenant string) (backend.Result, cache.Tier, error) {
+			metadata, ok := s.backend.(promMetadataBackend)
+			if !ok {
+				return backend.Result{}, "", errAPIUnsupported
+			}
+			res, err := metadata.QueryPromMetadata(ctx, tenant, req)
+			return res, "", err
+		},
+	}, nil
+}
+
+// parsePromQuery checks the query parameter parses as PromQL.
+func parsePromQuery(q string) error {
+	if _, err := parser.ParseExpr(q); err != nil {
+		return fmt.Errorf(`invalid parameter "query": %w`, err)
+	}
+	return nil
+}
+
+// parsePromTime parses a Unix timestamp in seconds or an RFC 3339 time.
+func parsePromTime(v string) (time.Time, error) {
+	if t, err := strconv.ParseFloat(v, 64); err == nil {
+		sec, frac := math.Modf(t)
+		return time.Unix(int64(sec), int64(math.Round(frac*1e9))).UTC(), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q to a valid timestamp", v)
+}
+
+// parsePromDuration parses a duration in seconds or in the Prometheus
+// duration syntax, e.g. 15s or 1d.
+func parsePromDuration(v string) (time.Duration, error) {
+	if d, err := strconv.ParseFloat(v, 64); err == nil {
+		ts := d * float64(time.Second)
+		if ts > float64(math.MaxInt64) || ts < float64(math.MinInt64) {
+			return 0, fmt.Errorf("cannot parse %q to a valid duration. It overflows int64", v)
+		}
+		return time.Duration(ts), nil
+	}
+	if d, err := model.ParseDuration(v); err == nil {
+		return time.Duration(d), nil
+	}
+	return 0, fmt.Errorf("cannot parse %q to a valid duration", v)
+}
+
+func formatPromTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// writePromError writes an error in the Prometheus API format, with the
+// error type a Prometheus client expects for the status.
+func writePromError(w http.ResponseWriter, status int, msg string) {
+	errorType := "server_error"
+	switch {
+	case status == http.StatusBadRequest:
+		errorType = "bad_data"
+	case status == http.StatusNotFound:
+		errorType = "not_found"
+	case status == http.StatusUnprocessableEntity:
+		errorType = "execution"
+	case status == http.StatusServiceUnavailable:
+		errorType = "unavailable"
+	case status == http.StatusGatewayTimeout:
+		errorType = "timeout"
+	case status == http.StatusInternalServerError:
+		errorType = "internal"
+	case status < http.StatusInternalServerError:
+		errorType = "client_error"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	payload, _ := json.Marshal(map[string]string{"status": "error", "errorType": errorType, "error": msg})
+	w.Write(payload)
+}
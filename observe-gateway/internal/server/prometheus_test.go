@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/audit"
+	"github.com/xscopehub/observe-gateway/internal/backend"
+	"github.com/xscopehub/observe-gateway/internal/cache"
+	"github.com/xscopehub/observe-gateway/internal/config"
+	"github.com/xscopehub/observe-gateway/internal/query"
+)
+
+func TestPrometheusAPI(t *testing.T) {
+	cacheStore, err := cache.New(cache.Config{Enabled: false})
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	const vector = `{"status":"success","data":{"resultType":"vector","result":[]}}`
+	var queries []query.Request
+	var metadata []query.MetadataRequest
+	stub := stubBackend{
+		queryPromQL: func(_ context.Context, tenant string, req query.Request) (backend.Result, error) {
+			if tenant != "tenant-a" {
+				t.Fatalf("tenant = %q, want tenant-a", tenant)
+			}
+			queries = append(queries, req)
+			if req.Query == "down" {
+				return backend.Result{}, &backend.CircuitOpenError{Backend: "openobserve"}
+			}
+			return backend.Result{Payload: json.RawMessage(vector), Backend: "stub"}, nil
+		},
+		promMetadata: func(_ context.Context, _ string, req query.MetadataRequest) (backend.Result, error) {
+			metadata = append(metadata, req)
+			return backend.Result{Payload: json.RawMessage(`{"status":"success","data":["job"]}`), Backend: "stub"}, nil
+		},
+	}
+	handler := New(config.Config{}, nil, nil, nil, stub, cacheStore, nil, audit.New(false, nil)).Handler()
+
+	do := func(method, path string, form url.Values, want int) string {
+		t.Helper()
+		var req *http.Request
+		if method == http.MethodPost {
+			req = httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req = httptest.NewRequest(method, path+"?"+form.Encode(), nil)
+		}
+		req.Header.Set("X-Tenant", "tenant-a")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Fatalf("%s %s status = %d, want %d: %s", method, path, rec.Code, want, rec.Body.String())
+		}
+		return rec.Body.String()
+	}
+
+	// Responses are the backend's Prometheus body, not the /api/query envelope.
+	if body := do(http.MethodGet, "/prometheus/api/v1/query", url.Values{"query": {"up"}, "time": {"1700000000.5"}}, http.StatusOK); body != vector {
+		t.Fatalf("body = %s, want %s", body, vector)
+	}
+	if got := queries[0]; got.Query != "up" || !got.Time.Equal(time.Unix(1700000000, 5e8)) || got.HasTimeRange() {
+		t.Fatalf("instant query = %+v", got)
+	}
+
+	do(http.MethodPost, "/prometheus/api/v1/query_range", url.Values{
+		"query": {"sum(rate(http_requests_total[5m]))"},
+		"start": {"2024-01-01T00:00:00Z"},
+		"end":   {"1704070800"},
+		"step":  {"1m"},
+	}, http.StatusOK)
+	if got := queries[1]; got.Step != "1m0s" || got.End.Sub(got.Start) != time.Hour {
+		t.Fatalf("range query = %+v, want a one hour range at 1m steps", got)
+	}
+
+	for name, form := range map[string]url.Values{
+		"invalid query": {"query": {"sum(up"}, "start": {"0"}, "end": {"60"}, "step": {"15"}},
+		"zero step":     {"query": {"up"}, "start": {"0"}, "end": {"60"}, "step": {"0"}},
+		"too many":      {"query": {"up"}, "start": {"0"}, "end": {"86400"}, "step": {"1"}},
+		"end first":     {"query": {"up"}, "start": {"60"}, "end": {"0"}, "step": {"15"}},
+	} {
+		var resp struct {
+			Status    string `json:"status"`
+			ErrorType string `json:"errorType"`
+		}
+		json.Unmarshal([]byte(do(http.MethodGet, "/prometheus/api/v1/query_range", form, http.StatusBadRequest)), &resp)
+		if resp.Status != "error" || resp.ErrorType != "bad_data" {
+			t.Fatalf("%s: response = %+v, want a bad_data error", name, resp)
+		}
+	}
+	if body := do(http.MethodGet, "/prometheus/api/v1/query", url.Values{"query": {"down"}}, http.StatusServiceUnavailable); !strings.Contains(body, `"errorType":"unavailable"`) {
+		t.Fatalf("body = %s, want an unavailable error", body)
+	}
+
+	do(http.MethodGet, "/prometheus/api/v1/series", url.Values{}, http.StatusBadRequest)
+	do(http.MethodPost, "/prometheus/api/v1/series", url.Values{"match[]": {`up`, `{job="api"}`}, "start": {"0"}, "end": {"60"}}, http.StatusOK)
+	do(http.MethodGet, "/prometheus/api/v1/labels", url.Values{"limit": {"10"}}, http.StatusOK)
+	if body := do(http.MethodGet, "/prometheus/api/v1/label/job/values", url.Values{"match[]": {`up`}}, http.StatusOK); body != `{"status":"success","data":["job"]}` {
+		t.Fatalf("body = %s", body)
+	}
+	do(http.MethodGet, "/prometheus/api/v1/label/bad-name/values", url.Values{}, http.StatusBadRequest)
+	if len(metadata) != 3 {
+		t.Fatalf("metadata requests = %d, want 3", len(metadata))
+	}
+	if got := metadata[0]; got.Kind != query.MetadataSeries || len(got.Matchers) != 2 || got.End.Sub(got.Start) != time.Minute {
+		t.Fatalf("series request = %+v", got)
+	}
+	if got := metadata[1]; got.Kind != query.MetadataLabels || got.Limit != 10 {
+		t.Fatalf("labels request = %+v", got)
+	}
+	if got := metadata[2]; got.Kind != query.MetadataLabelValues || got.Label != "job" || got.Matchers[0] != "up" {
+		t.Fatalf("label values request = %+v", got)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/prometheus/api/v1/labels", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"status":"error"`) {
+		t.Fatalf("without tenant: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+}
@@ -5,34 +5,63 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/xscopehub/observe-gateway/internal/audit"
 	"github.com/xscopehub/observe-gateway/internal/auth"
 	"github.com/xscopehub/observe-gateway/internal/backend"
 	"github.com/xscopehub/observe-gateway/internal/cache"
 	"github.com/xscopehub/observe-gateway/internal/config"
+	"github.com/xscopehub/observe-gateway/internal/frame"
 	"github.com/xscopehub/observe-gateway/internal/limiter"
+	"github.com/xscopehub/observe-gateway/internal/logql"
 	"github.com/xscopehub/observe-gateway/internal/query"
+	"github.com/xscopehub/observe-gateway/internal/resultscache"
+	"github.com/xscopehub/observe-gateway/internal/shard"
+	"github.com/xscopehub/observe-gateway/internal/telemetry"
+	"github.com/xscopehub/observe-gateway/internal/templates"
 )
 
 // Server represents the HTTP API server.
 type Server struct {
-	cfg      config.Config
-	router   chi.Router
-	auth     *auth.Authenticator
-	backend  queryBackend
-	cache    *cache.Cache
-	limiter  *limiter.Limiter
-	auditLog *audit.Logger
+	active    atomic.Pointer[activeConfig]
+	version   atomic.Int64
+	router    chi.Router
+	auth      auth.Provider
+	keys      *auth.APIKeys
+	templates *templates.Registry
+	backend   queryBackend
+	cache     *cache.Cache
+	limiter   *limiter.Limiter
+	auditLog  *audit.Logger
+
+	flights flightGroup
+	// shardSlots caps the concurrent query shards of each tenant.
+	shardSlots *shard.Slots
+}
 
-	activeRequests int64
+// activeConfig is the configuration serving requests together with the
+// state derived from it. Reload swaps it atomically; a request keeps the
+// snapshot it started with.
+type activeConfig struct {
+	config.Config
+	results  *resultscache.Cache
+	shards   *shard.Splitter
+	globals  map[string]templates.Template
+	version  int64
+	hash     string
+	loadedAt time.Time
 }
 
 type queryBackend interface {
@@ -42,28 +71,167 @@ type queryBackend interface {
 }
 
 // New constructs a server with all dependencies wired.
-func New(cfg config.Config, auth *auth.Authenticator, backend queryBackend, cache *cache.Cache, limiter *limiter.Limiter, auditLog *audit.Logger) *Server {
+func New(cfg config.Config, authn auth.Provider, keys *auth.APIKeys, tmpls *templates.Registry, backend queryBackend, cache *cache.Cache, limiter *limiter.Limiter, auditLog *audit.Logger) *Server {
 	s := &Server{
-		cfg:      cfg,
-		auth:     auth,
-		backend:  backend,
-		cache:    cache,
-		limiter:  limiter,
-		auditLog: auditLog,
+		auth:      authn,
+		keys:      keys,
+		templates: tmpls,
+		backend:   backend,
+		cache:     cache,
+		limiter:   limiter,
+		auditLog:  auditLog,
+
+		shardSlots: shard.NewSlots(),
 	}
+	s.Reload(cfg)
 
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
+	r.Use(s.instrument)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(2 * time.Minute))
+	r.Use(timeout(2 * time.Minute))
 
+	if metrics := cfg.Telemetry.Metrics; metrics.Enabled {
+		path := metrics.Path
+		if path == "" {
+			path = "/metrics"
+		}
+		r.Method(http.MethodGet, path, telemetry.Handler())
+	}
 	r.Post("/api/query", s.handleQuery)
+	r.Post("/api/query/explain", s.handleExplain)
+	r.Post("/api/query/batch", s.handleBatch)
+	r.Route("/prometheus/api/v1", s.routePrometheus)
+	r.Route("/loki/api/v1", s.routeLoki)
+	r.Route("/tempo", s.routeTempo)
+	r.Route("/api/metadata", s.routeMetadata)
+	r.With(s.requireAdmin).Get("/api/audit", s.handleAudit)
+	r.Route("/api/templates", func(r chi.Router) {
+		r.Get("/", s.handleListTemplates)
+		r.Get("/{name}", s.handleGetTemplate)
+		r.Put("/{name}", s.handleSaveTemplate)
+		r.Delete("/{name}", s.handleDeleteTemplate)
+		r.Get("/{name}/versions", s.handleTemplateVersions)
+		r.Post("/{name}/rollback", s.handleRollbackTemplate)
+		r.Post("/{name}/dry-run", s.handleDryRunTemplate)
+	})
+
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(s.requireAdmin)
+		r.Get("/config", s.handleConfig)
+		r.Delete("/cache", s.handlePurgeCache)
+		r.Post("/api-keys", s.handleIssueAPIKey)
+		r.Get("/api-keys", s.handleListAPIKeys)
+		r.Post("/api-keys/{id}/rotate", s.handleRotateAPIKey)
+		r.Delete("/api-keys/{id}", s.handleRevokeAPIKey)
+	})
 
 	s.router = r
 	return s
 }
 
+// timeout works like middleware.Timeout, answering 504 when a handler
+// overruns d, except that websocket upgrades are exempt and streamed
+// responses lift the limit with liftTimeout once they start.
+func timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithCancel(r.Context())
+			rt := &requestTimeout{Context: ctx}
+			rt.timer = time.AfterFunc(d, func() {
+				rt.expired.Store(true)
+				cancel()
+			})
+			defer func() {
+				rt.timer.Stop()
+				cancel()
+				if rt.expired.Load() {
+					w.WriteHeader(http.StatusGatewayTimeout)
+				}
+			}()
+			next.ServeHTTP(w, r.WithContext(rt))
+		})
+	}
+}
+
+// requestTimeout is a request context that ends with
+// context.DeadlineExceeded when its timer fires, like one made by
+// context.WithTimeout, but whose timer can be stopped.
+type requestTimeout struct {
+	context.Context
+	timer   *time.Timer
+	expired atomic.Bool
+}
+
+func (c *requestTimeout) Err() error {
+	if c.expired.Load() {
+		return context.DeadlineExceeded
+	}
+	return c.Context.Err()
+}
+
+func (c *requestTimeout) Value(key any) any {
+	if key == (requestTimeoutKey{}) {
+		return c
+	}
+	return c.Context.Value(key)
+}
+
+type requestTimeoutKey struct{}
+
+// liftTimeout stops the request timeout for a response streamed for as
+// long as records arrive; the stream's per-record write deadline bounds it
+// instead. It reports false when the timeout already expired.
+func liftTimeout(ctx context.Context) bool {
+	rt, ok := ctx.Value(requestTimeoutKey{}).(*requestTimeout)
+	if !ok {
+		return true
+	}
+	return rt.timer.Stop() || !rt.expired.Load()
+}
+
+// Reload makes cfg the configuration for new requests. Requests in flight
+// finish with the configuration they started with. Listener settings only
+// take effect on restart.
+func (s *Server) Reload(cfg config.Config) {
+	globals, err := templates.Globals(cfg.QueryTemplates)
+	if err != nil {
+		log.Printf("skipping invalid query templates: %v", err)
+	}
+	s.active.Store(&activeConfig{
+		Config: cfg,
+		results: resultscache.New(resultscache.Config{
+			Enabled:       cfg.Cache.Enabled,
+			SplitInterval: cfg.Cache.SplitInterval,
+			MaxFreshness:  cfg.Cache.MaxFreshness,
+			TTL:           cfg.Cache.ExtentTTL,
+		}, s.cache),
+		shards: shard.New(shard.Config{
+			Enabled:           cfg.Sharding.Enabled,
+			Interval:          cfg.Sharding.Interval,
+			MaxShards:         cfg.Sharding.MaxShards,
+			TenantConcurrency: cfg.Sharding.TenantConcurrency,
+		}, s.shardSlots),
+		globals:  globals,
+		version:  s.version.Add(1),
+		hash:     cfg.Hash(),
+		loadedAt: time.Now().UTC(),
+	})
+}
+
+// config returns the active configuration.
+func (s *Server) config() *activeConfig {
+	if active := s.active.Load(); active != nil {
+		return active
+	}
+	return &activeConfig{}
+}
+
 // Handler exposes the HTTP handler for embedding.
 func (s *Server) Handler() http.Handler {
 	return s.router
@@ -71,12 +239,13 @@ func (s *Server) Handler() http.Handler {
 
 // Run starts the HTTP server until context cancellation.
 func (s *Server) Run(ctx context.Context) error {
+	cfg := s.config().Server
 	srv := &http.Server{
-		Addr:         s.cfg.Server.Address,
+		Addr:         cfg.Address,
 		Handler:      s.Handler(),
-		ReadTimeout:  s.cfg.Server.ReadTimeout,
-		WriteTimeout: s.cfg.Server.WriteTimeout,
-		IdleTimeout:  s.cfg.Server.IdleTimeout,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
 	}
 
 	errCh := make(chan error, 1)
@@ -102,136 +271,397 @@ func (s *Server) Run(ctx context.Context) error {
 	}
 }
 
-func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
-	atomic.AddInt64(&s.activeRequests, 1)
-	defer atomic.AddInt64(&s.activeRequests, -1)
-
-	start := time.Now()
+// preparedQuery is a query request body that passed authentication,
+// template resolution and validation.
+type preparedQuery struct {
+	req    query.Request
+	tenant string
+	user   string
+	// template is the template the query was rendered from, if any.
+	template *resolvedTemplate
+}
 
+// prepareQuery decodes, authenticates, renders and validates a query
+// request body. On failure it writes the error response, audits the
+// request and returns false.
+func (s *Server) prepareQuery(w http.ResponseWriter, r *http.Request, start time.Time) (preparedQuery, bool) {
 	var req query.Request
 	decoder := json.NewDecoder(r.Body)
 	decoder.DisallowUnknownFields()
-	if err := decoder.Decode(&req); err != nil {
+	_, span := tracer.Start(r.Context(), "parse")
+	err := decoder.Decode(&req)
+	span.End()
+	if err != nil {
 		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
 		s.auditLog.Log(audit.Entry{Tenant: "", User: "", Lang: req.Lang, Query: req.Query, Duration: time.Since(start), Error: err.Error()})
-		return
+		return preparedQuery{}, false
 	}
 
-	req = s.resolveTemplate(req)
+	c, err := s.identify(r)
+	if err != nil {
+		s.writeError(w, http.StatusUnauthorized, err.Error())
+		// The claimed tenant and user headers attribute the attempt.
+		s.auditAuthFailure(r, audit.Entry{Tenant: c.tenant, User: c.user, Lang: req.Lang, Query: req.Query, Duration: time.Since(start)}, http.StatusUnauthorized, err)
+		return preparedQuery{}, false
+	}
+	tenant, user := c.tenant, c.user
+	if tenant == "" {
+		s.writeError(w, http.StatusBadRequest, "tenant is required")
+		s.auditLog.Log(audit.Entry{Lang: req.Lang, Query: req.Query, Duration: time.Since(start), Error: "tenant missing"})
+		return preparedQuery{}, false
+	}
+	labelRequest(r.Context(), tenant, "", "")
+
+	req.Stream = streamFormat(req, r)
+	req, tmpl, status, err := s.resolveQuery(r, c, req, start)
+	if err != nil {
+		s.writeError(w, status, err.Error())
+		return preparedQuery{}, false
+	}
+	// Only validated languages and resolved template names become metric
+	// labels, so callers cannot mint new series.
+	var template string
+	if tmpl != nil {
+		template = tmpl.Name
+	}
+	labelRequest(r.Context(), "", req.Lang, template)
+	return preparedQuery{req: req, tenant: tenant, user: user, template: tmpl}, true
+}
+
+// resolveQuery renders, normalizes and validates one query of an
+// identified caller. On failure it audits the query and returns the status
+// and error to answer with.
+func (s *Server) resolveQuery(r *http.Request, c caller, req query.Request, start time.Time) (query.Request, *resolvedTemplate, int, error) {
+	tenant, user := c.tenant, c.user
+	req, tmpl, err := s.renderTemplate(r.Context(), tenant, req)
+	if err != nil {
+		status := http.StatusBadRequest
+		if !isTemplateError(err) {
+			status = http.StatusInternalServerError
+		}
+		s.auditLog.Log(audit.Entry{Tenant: tenant, User: user, Lang: req.Lang, Query: req.Query, Duration: time.Since(start), Error: err.Error()})
+		return req, nil, status, err
+	}
 	req.Lang = strings.ToLower(req.Lang)
 	if req.Query == "" {
-		s.writeError(w, http.StatusBadRequest, "query is required")
-		s.auditLog.Log(audit.Entry{Lang: req.Lang, Query: req.Query, Duration: time.Since(start), Error: "query is required"})
-		return
+		s.auditLog.Log(audit.Entry{Tenant: tenant, User: user, Lang: req.Lang, Query: req.Query, Duration: time.Since(start), Error: "query is required"})
+		return req, nil, http.StatusBadRequest, errors.New("query is required")
 	}
 
 	if req.Step != "" {
 		if _, err := req.StepDuration(); err != nil {
-			s.writeError(w, http.StatusBadRequest, "invalid step duration")
-			s.auditLog.Log(audit.Entry{Lang: req.Lang, Query: req.Query, Duration: time.Since(start), Error: "invalid step"})
-			return
+			s.auditLog.Log(audit.Entry{Tenant: tenant, User: user, Lang: req.Lang, Query: req.Query, Duration: time.Since(start), Error: "invalid step"})
+			return req, nil, http.StatusBadRequest, errors.New("invalid step duration")
 		}
 	}
 
-	tenantHeader := s.cfg.Server.TenantHeader
-	if tenantHeader == "" {
-		tenantHeader = "X-Tenant"
+	if err := c.require("query:" + req.Lang); err != nil {
+		s.auditAuthFailure(r, audit.Entry{Tenant: tenant, User: user, Lang: req.Lang, Query: req.Query, Duration: time.Since(start)}, http.StatusForbidden, err)
+		return req, nil, http.StatusForbidden, err
+	}
+
+	if err := s.validate(&req, tenant); err != nil {
+		s.auditLog.Log(audit.Entry{Tenant: tenant, User: user, Lang: req.Lang, Query: req.Query, Duration: time.Since(start), Error: err.Error()})
+		return req, nil, http.StatusBadRequest, err
 	}
-	userHeader := s.cfg.Server.UserHeader
-	if userHeader == "" {
-		userHeader = "X-User"
+	return req, tmpl, http.StatusOK, nil
+}
+
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	telemetry.ActiveRequests.Inc()
+	defer telemetry.ActiveRequests.Dec()
+
+	start := time.Now()
+	p, ok := s.prepareQuery(w, r, start)
+	if !ok {
+		return
 	}
-	tenant := r.Header.Get(tenantHeader)
-	user := r.Header.Get(userHeader)
-	if s.auth != nil {
-		if t, u, err := s.auth.Verify(r); err == nil {
-			tenant, user = t, u
+	req, tenant, user := p.req, p.tenant, p.user
+
+	if rej := s.admit(r.Context(), w, limiter.Subject{Tenant: tenant, User: user, Template: req.Template, Lang: req.Lang}); rej != nil {
+		if rej.rule != "" {
+			s.writeJSON(w, rej.status, map[string]string{"error": rej.err.Error(), "rule": rej.rule})
 		} else {
-			s.writeError(w, http.StatusUnauthorized, err.Error())
-			s.auditLog.Log(audit.Entry{Lang: req.Lang, Query: req.Query, Duration: time.Since(start), Error: err.Error()})
-			return
+			s.writeError(w, rej.status, rej.err.Error())
 		}
-	}
-	if tenant == "" {
-		s.writeError(w, http.StatusBadRequest, "tenant is required")
-		s.auditLog.Log(audit.Entry{Lang: req.Lang, Query: req.Query, Duration: time.Since(start), Error: "tenant missing"})
+		s.auditLog.Log(audit.Entry{Tenant: tenant, User: user, Lang: req.Lang, Query: req.Query, Duration: time.Since(start), RateLimitRule: rej.rule, Error: rej.err.Error()})
 		return
 	}
 
-	if err := s.validate(&req); err != nil {
-		s.writeError(w, http.StatusBadRequest, err.Error())
-		s.auditLog.Log(audit.Entry{Tenant: tenant, User: user, Lang: req.Lang, Query: req.Query, Duration: time.Since(start), Error: err.Error()})
+	// Streamed responses are never cached.
+	if req.Stream != "" {
+		s.handleStream(w, r, tenant, user, req, start)
 		return
 	}
 
-	if s.limiter != nil {
-		if err := s.limiter.Allow(r.Context(), tenant); err != nil {
-			status := http.StatusTooManyRequests
-			if !errors.Is(err, limiter.ErrRateLimited) {
-				status = http.StatusInternalServerError
-			}
-			s.writeError(w, status, err.Error())
-			s.auditLog.Log(audit.Entry{Tenant: tenant, User: user, Lang: req.Lang, Query: req.Query, Duration: time.Since(start), Error: err.Error()})
-			return
-		}
+	payload, cost, status, err := s.answerQuery(r.Context(), tenant, user, req, start)
+	if err != nil {
+		s.writeError(w, status, err.Error())
+		return
 	}
+	s.chargeBudget(r.Context(), w, tenant, cost)
 
-	cacheKey := buildCacheKey(req, tenant)
-	if data, ok := s.cache.Get(r.Context(), cacheKey); ok {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		w.Write(data)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(payload)
+}
 
+// answerQuery answers an admitted query that is not streamed from the
+// response cache or the backend, and audits it. cost is the backend cost
+// the query incurred itself, for the caller to charge to the tenant budget;
+// on failure status is the HTTP status to answer with.
+func (s *Server) answerQuery(ctx context.Context, tenant, user string, req query.Request, start time.Time) ([]byte, int64, int, error) {
+	cacheKey := cache.Key{Tenant: tenant, Lang: req.Lang, Template: req.Template, ID: buildCacheKey(req, tenant)}
+	if data, tier, ok := s.cache.Get(ctx, cacheKey); ok {
 		var cachedResp query.Response
 		if err := json.Unmarshal(data, &cachedResp); err == nil {
-			s.auditLog.Log(audit.Entry{Tenant: tenant, User: user, Lang: req.Lang, Query: req.Query, Duration: time.Since(start), Cached: true, Backend: cachedResp.Stats.Backend, Cost: cachedResp.Stats.Cost})
+			cachedResp.Stats.Cached = true
+			cachedResp.Stats.CacheTier = string(tier)
+			cachedResp.Stats.DurationMS = time.Since(start).Milliseconds()
+			if payload, err := json.Marshal(cachedResp); err == nil {
+				data = payload
+			}
+			s.auditLog.Log(audit.Entry{Tenant: tenant, User: user, Lang: req.Lang, Query: req.Query, Duration: time.Since(start), Cached: true, CacheTier: string(tier), Backend: cachedResp.Stats.Backend, Cost: cachedResp.Stats.Cost, Breaker: cachedResp.Stats.Breaker, Bytes: int64(len(data))})
 		} else {
-			s.auditLog.Log(audit.Entry{Tenant: tenant, User: user, Lang: req.Lang, Query: req.Query, Duration: time.Since(start), Cached: true, Backend: "cache"})
+			s.auditLog.Log(audit.Entry{Tenant: tenant, User: user, Lang: req.Lang, Query: req.Query, Duration: time.Since(start), Cached: true, CacheTier: string(tier), Backend: "cache", Bytes: int64(len(data))})
 		}
-		return
+		return data, 0, http.StatusOK, nil
 	}
 
-	result, err := s.dispatch(r.Context(), tenant, req)
+	result, tier, coalesced, err := s.coalesce(ctx, cacheKey.ID, func(ctx context.Context) (backend.Result, cache.Tier, error) {
+		return s.query(ctx, tenant, req)
+	})
 	if err != nil {
-		status := http.StatusBadGateway
-		var unsupported *backend.UnsupportedError
-		if errors.As(err, &unsupported) {
-			status = http.StatusBadRequest
+		s.auditLog.Log(audit.Entry{Tenant: tenant, User: user, Lang: req.Lang, Query: req.Query, Duration: time.Since(start), Coalesced: coalesced, Error: err.Error()})
+		return nil, 0, errorStatus(err), err
+	}
+
+	if req.Normalize {
+		normalized, err := normalizeResult(req.Lang, result.Payload)
+		if err != nil {
+			s.auditLog.Log(audit.Entry{Tenant: tenant, User: user, Lang: req.Lang, Query: req.Query, Duration: time.Since(start), Error: err.Error(), Backend: result.Backend, Breaker: result.Breaker})
+			return nil, 0, http.StatusBadGateway, err
 		}
-		s.writeError(w, status, err.Error())
-		s.auditLog.Log(audit.Entry{Tenant: tenant, User: user, Lang: req.Lang, Query: req.Query, Duration: time.Since(start), Error: err.Error()})
-		return
+		result.Payload = normalized
 	}
 
 	resp := query.Response{
-		Lang:   req.Lang,
-		Tenant: tenant,
-		Result: result.Payload,
+		Lang:     req.Lang,
+		Tenant:   tenant,
+		Result:   result.Payload,
+		Warnings: result.Warnings,
 		Stats: query.Stats{
 			Backend:    result.Backend,
-			Cached:     false,
+			Cached:     tier != "",
+			CacheTier:  string(tier),
+			Coalesced:  coalesced,
 			DurationMS: time.Since(start).Milliseconds(),
 			Cost:       result.Cost,
+			Breaker:    result.Breaker,
+			Partial:    result.Partial,
 		},
 	}
+	if req.Limit > 0 && result.HasMore {
+		resp.NextCursor = encodeCursor(req, tenant, req.Offset+result.Rows)
+	}
 
 	payload, err := json.Marshal(resp)
 	if err != nil {
-		s.writeError(w, http.StatusInternalServerError, "marshal response failed")
-		s.auditLog.Log(audit.Entry{Tenant: tenant, User: user, Lang: req.Lang, Query: req.Query, Duration: time.Since(start), Error: err.Error(), Backend: result.Backend})
+		s.auditLog.Log(audit.Entry{Tenant: tenant, User: user, Lang: req.Lang, Query: req.Query, Duration: time.Since(start), Error: err.Error(), Backend: result.Backend, Breaker: result.Breaker})
+		return nil, 0, http.StatusInternalServerError, errors.New("marshal response failed")
+	}
+
+	if !coalesced && !result.Partial {
+		s.cache.Set(ctx, cacheKey, payload, int64(len(payload)))
+	}
+
+	s.auditLog.Log(audit.Entry{Tenant: tenant, User: user, Lang: req.Lang, Query: req.Query, Duration: time.Since(start), Cached: tier != "", CacheTier: string(tier), Coalesced: coalesced, Cost: result.Cost, Backend: result.Backend, Breaker: result.Breaker, Bytes: int64(len(payload)), Rows: int64(result.Rows)})
+	var cost int64
+	if !coalesced {
+		cost = result.Cost
+	}
+	return payload, cost, http.StatusOK, nil
+}
+
+// rejection is a request refused by a rate limit or the cost budget.
+type rejection struct {
+	status int
+	err    error
+	// rule names the rate limit rule that refused the request.
+	rule string
+}
+
+// admit consumes the request's rate limit tokens and checks the tenant's
+// cost budget, reporting both in response headers.
+func (s *Server) admit(ctx context.Context, w http.ResponseWriter, subj limiter.Subject) *rejection {
+	decision, err := s.limiter.Allow(ctx, subj)
+	return s.checkAdmission(ctx, w, subj.Tenant, decision, err)
+}
+
+// checkAdmission reports a rate limit decision for the tenant and, if it
+// allowed the request, checks the tenant's cost budget.
+func (s *Server) checkAdmission(ctx context.Context, w http.ResponseWriter, tenant string, decision limiter.Decision, err error) *rejection {
+	setRateLimitHeaders(w, decision)
+	if err != nil {
+		var limited *limiter.RateLimitError
+		if errors.As(err, &limited) {
+			telemetry.LimiterRejections.WithLabelValues(tenant, limited.Rule).Inc()
+			return &rejection{status: http.StatusTooManyRequests, err: err, rule: limited.Rule}
+		}
+		return &rejection{status: http.StatusInternalServerError, err: err}
+	}
+
+	budget, err := s.limiter.CheckBudget(ctx, tenant)
+	setBudgetHeader(w, budget)
+	if err != nil {
+		status := http.StatusTooManyRequests
+		if errors.Is(err, limiter.ErrBudgetExhausted) {
+			telemetry.LimiterRejections.WithLabelValues(tenant, "budget").Inc()
+		} else {
+			status = http.StatusInternalServerError
+		}
+		return &rejection{status: status, err: err}
+	}
+	return nil
+}
+
+// fetch is coalesce also charging the tenant's budget for a backend call
+// this request made.
+func (s *Server) fetch(ctx context.Context, w http.ResponseWriter, tenant, key string, fn flightFunc) (backend.Result, cache.Tier, bool, error) {
+	result, tier, coalesced, err := s.coalesce(ctx, key, fn)
+	if err == nil && !coalesced {
+		s.chargeBudget(ctx, w, tenant, result.Cost)
+	}
+	return result, tier, coalesced, err
+}
+
+// coalesce runs fn once among identical concurrent requests. The key must
+// include the tenant, so only a tenant's own requests are coalesced.
+func (s *Server) coalesce(ctx context.Context, key string, fn flightFunc) (backend.Result, cache.Tier, bool, error) {
+	result, tier, coalesced, err := s.flights.do(ctx, key, fn)
+	if coalesced {
+		telemetry.CoalescedRequests.Inc()
+	}
+	return result, tier, coalesced, err
+}
+
+// chargeBudget records a query's backend cost against the tenant budget and
+// refreshes the remaining-budget header.
+func (s *Server) chargeBudget(ctx context.Context, w http.ResponseWriter, tenant string, cost int64) {
+	if cost <= 0 {
+		return
+	}
+	budget, err := s.limiter.ChargeBudget(ctx, tenant, cost)
+	if err != nil {
+		log.Printf("charge query budget for %s: %v", tenant, err)
 		return
 	}
+	setBudgetHeader(w, budget)
+}
 
-	s.cache.Set(r.Context(), cacheKey, payload, int64(len(payload)))
+func setBudgetHeader(w http.ResponseWriter, budget limiter.Budget) {
+	if budget.Enabled() {
+		w.Header().Set("X-Query-Budget-Remaining", strconv.FormatInt(budget.Remaining(), 10))
+	}
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(payload)
+// errorStatus maps a backend error to an HTTP status.
+func errorStatus(err error) int {
+	var unsupported *backend.UnsupportedError
+	var invalid *backend.QueryError
+	var open *backend.CircuitOpenError
+	switch {
+	case errors.As(err, &unsupported) || errors.As(err, &invalid):
+		return http.StatusBadRequest
+	case errors.As(err, &open):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, backend.ErrTraceNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusBadGateway
+	}
+}
 
-	s.auditLog.Log(audit.Entry{Tenant: tenant, User: user, Lang: req.Lang, Query: req.Query, Duration: time.Since(start), Cost: result.Cost, Backend: result.Backend})
+// query runs the request, assembling splittable range queries from cached
+// extents. tier is set when the backend was not consulted.
+func (s *Server) query(ctx context.Context, tenant string, req query.Request) (backend.Result, cache.Tier, error) {
+	results := s.config().results
+	if results == nil || !splittable(req) {
+		res, err := s.execute(ctx, tenant, req)
+		return res, "", err
+	}
+	key := cache.Key{Tenant: tenant, Lang: req.Lang, Template: req.Template, ID: req.Query}
+	return results.Do(ctx, key, req, func(ctx context.Context, sub query.Request) (backend.Result, error) {
+		return s.execute(ctx, tenant, sub)
+	})
+}
+
+// execute runs a request against the backend, in parallel time shards when
+// sharding is enabled and the request is a long range query, and records
+// its cost for explain estimates.
+func (s *Server) execute(ctx context.Context, tenant string, req query.Request) (backend.Result, error) {
+	var res backend.Result
+	var err error
+	shards := s.config().shards
+	if kind, ok := shardKind(req); shards != nil && ok {
+		res, err = shards.Do(ctx, tenant, kind, req, func(ctx context.Context, sub query.Request) (backend.Result, error) {
+			return s.dispatch(ctx, tenant, sub)
+		})
+	} else {
+		res, err = s.dispatch(ctx, tenant, req)
+	}
+	if err == nil && !res.Partial {
+		s.recordCost(ctx, tenant, req, res.Cost)
+	}
+	return res, err
+}
+
+// shardKind reports how a request is split into time shards: by step for
+// matrix queries, by time for LogQL log searches. Streamed searches are
+// never sharded.
+func shardKind(req query.Request) (shard.Kind, bool) {
+	if splittable(req) {
+		return shard.Matrix, true
+	}
+	if req.Lang != "logql" || req.Stream != "" || !req.HasTimeRange() {
+		return 0, false
+	}
+	expr, err := logql.ParseExpr(req.Query)
+	if err != nil {
+		return 0, false
+	}
+	_, isLogQuery := expr.(*logql.LogQuery)
+	return shard.Logs, isLogQuery
+}
+
+// splittable reports whether a request returns a Prometheus matrix that can
+// be assembled from independently cached extents: PromQL range queries and
+// LogQL metric queries with an explicit step.
+func splittable(req query.Request) bool {
+	if !req.HasTimeRange() {
+		return false
+	}
+	if step, err := req.StepDuration(); err != nil || step <= 0 {
+		return false
+	}
+	switch req.Lang {
+	case "promql":
+		return true
+	case "logql":
+		expr, err := logql.ParseExpr(req.Query)
+		if err != nil {
+			return false
+		}
+		_, isLogQuery := expr.(*logql.LogQuery)
+		return !isLogQuery
+	default:
+		return false
+	}
 }
 
 func (s *Server) dispatch(ctx context.Context, tenant string, req query.Request) (backend.Result, error) {
+	ctx, span := tracer.Start(ctx, "dispatch", trace.WithAttributes(attribute.String("lang", req.Lang)))
+	defer span.End()
 	switch req.Lang {
 	case "promql":
 		return s.backend.QueryPromQL(ctx, tenant, req)
@@ -244,9 +674,21 @@ func (s *Server) dispatch(ctx context.Context, tenant string, req query.Request)
 	}
 }
 
-func (s *Server) validate(req *query.Request) error {
+// normalizeResult converts a backend payload into the unified frame model.
+func normalizeResult(lang string, payload json.RawMessage) (json.RawMessage, error) {
+	f, err := frame.Normalize(lang, payload)
+	if err != nil {
+		return nil, fmt.Errorf("normalize result: %w", err)
+	}
+	return json.Marshal(f)
+}
+
+func (s *Server) validate(req *query.Request, tenant string) error {
 	switch req.Lang {
 	case "promql":
+		if req.Limit != 0 || req.Cursor != "" || req.Stream != "" || req.Direction != "" {
+			return fmt.Errorf("pagination, streaming and direction are not supported for promql")
+		}
 		return nil
 	case "logql", "traceql":
 		if !req.HasTimeRange() {
@@ -255,19 +697,73 @@ func (s *Server) validate(req *query.Request) error {
 		if req.Start.After(req.End) {
 			return fmt.Errorf("start must be before end")
 		}
-		return nil
+		return s.validatePage(req, tenant)
 	default:
 		return fmt.Errorf("unsupported language: %s", req.Lang)
 	}
 }
 
+// validatePage checks the limit, cursor and stream options and resolves the
+// cursor into an offset.
+func (s *Server) validatePage(req *query.Request, tenant string) error {
+	switch req.Stream {
+	case "", streamNDJSON, streamSSE:
+	default:
+		return fmt.Errorf("unsupported stream format: %s", req.Stream)
+	}
+	switch req.Direction {
+	case "", query.DirectionBackward:
+	case query.DirectionForward:
+		if req.Lang != "logql" {
+			return fmt.Errorf("direction is only supported for logql")
+		}
+	default:
+		return fmt.Errorf("unsupported direction: %s", req.Direction)
+	}
+	if req.Limit < 0 {
+		return fmt.Errorf("limit must not be negative")
+	}
+	if max := s.config().Server.MaxPageSize; max > 0 && req.Limit > max {
+		return fmt.Errorf("limit must not exceed %d", max)
+	}
+	if req.Cursor == "" {
+		return nil
+	}
+	if req.Limit == 0 {
+		return fmt.Errorf("cursor requires limit")
+	}
+	offset, err := decodeCursor(*req, tenant)
+	if err != nil {
+		return err
+	}
+	req.Offset = offset
+	return nil
+}
+
 func (s *Server) writeError(w http.ResponseWriter, status int, msg string) {
+	s.writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, body any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
-	payload, _ := json.Marshal(map[string]string{"error": msg})
+	payload, _ := json.Marshal(body)
 	w.Write(payload)
 }
 
+// setRateLimitHeaders reports the most constrained rate limit rule using the
+// IETF RateLimit header fields.
+func setRateLimitHeaders(w http.ResponseWriter, d limiter.Decision) {
+	if d.Limit == 0 {
+		return
+	}
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	if d.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(d.RetryAfter.Seconds())), 10))
+	}
+}
+
 func buildCacheKey(req query.Request, tenant string) string {
 	var parts []string
 	parts = append(parts, strings.ToLower(req.Lang), req.Query, req.Template, tenant)
@@ -280,21 +776,58 @@ func buildCacheKey(req query.Request, tenant string) string {
 	if req.Step != "" {
 		parts = append(parts, req.Step)
 	}
+	if !req.Time.IsZero() {
+		parts = append(parts, "time="+req.Time.UTC().Format(time.RFC3339Nano))
+	}
 	if req.Normalize {
 		parts = append(parts, "normalize=true")
 	}
+	if req.Limit > 0 {
+		parts = append(parts, fmt.Sprintf("limit=%d", req.Limit), "cursor="+req.Cursor)
+	}
+	if req.Forward() {
+		parts = append(parts, "direction="+query.DirectionForward)
+	}
 	return strings.Join(parts, "|")
 }
 
-func (s *Server) resolveTemplate(req query.Request) query.Request {
-	templateName := strings.TrimSpace(req.Template)
-	if templateName == "" {
-		return req
-	}
+// resolveTemplate fills the language, query and step the request leaves
+// empty from the named template. A tenant's stored template takes
+// precedence over a global one of the same name.
+func (s *Server) resolveTemplate(ctx context.Context, tenant string, req query.Request) (query.Request, error) {
+	req, _, err := s.renderTemplate(ctx, tenant, req)
+	return req, err
+}
 
-	rendered, ok := s.cfg.ResolveQueryTemplate(templateName, req.Variables)
-	if !ok {
-		return req
+// resolvedTemplate is the template a request was rendered from.
+type resolvedTemplate struct {
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+	// Scope is tenant for a tenant's stored template, global otherwise.
+	Scope    string             `json:"scope"`
+	Rendered templates.Rendered `json:"rendered"`
+}
+
+// renderTemplate is resolveTemplate also returning the template used, or
+// nil when the request did not need one.
+func (s *Server) renderTemplate(ctx context.Context, tenant string, req query.Request) (query.Request, *resolvedTemplate, error) {
+	name := strings.TrimSpace(req.Template)
+	if name == "" || (strings.TrimSpace(req.Lang) != "" && strings.TrimSpace(req.Query) != "" && strings.TrimSpace(req.Step) != "") {
+		return req, nil, nil
+	}
+	tmpl, found, err := s.lookupTemplate(ctx, tenant, name)
+	if err != nil {
+		return req, nil, err
+	}
+	if !found {
+		if strings.TrimSpace(req.Query) != "" {
+			return req, nil, nil
+		}
+		return req, nil, &templates.Error{Template: name, Err: templates.ErrNotFound}
+	}
+	rendered, err := tmpl.Render(req.Variables)
+	if err != nil {
+		return req, nil, err
 	}
 	if strings.TrimSpace(req.Lang) == "" {
 		req.Lang = rendered.Lang
@@ -305,5 +838,9 @@ func (s *Server) resolveTemplate(req query.Request) query.Request {
 	if strings.TrimSpace(req.Step) == "" {
 		req.Step = rendered.Step
 	}
-	return req
+	scope := "tenant"
+	if tmpl.Tenant == "" {
+		scope = "global"
+	}
+	return req, &resolvedTemplate{Name: tmpl.Name, Version: tmpl.Version, Scope: scope, Rendered: rendered}, nil
 }
@@ -4,8 +4,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -13,6 +17,8 @@ import (
 	"github.com/xscopehub/observe-gateway/internal/backend"
 	"github.com/xscopehub/observe-gateway/internal/cache"
 	"github.com/xscopehub/observe-gateway/internal/config"
+	"github.com/xscopehub/observe-gateway/internal/frame"
+	"github.com/xscopehub/observe-gateway/internal/limiter"
 	"github.com/xscopehub/observe-gateway/internal/query"
 )
 
@@ -20,6 +26,11 @@ type stubBackend struct {
 	queryPromQL  func(context.Context, string, query.Request) (backend.Result, error)
 	queryLogQL   func(context.Context, string, query.Request) (backend.Result, error)
 	queryTraceQL func(context.Context, string, query.Request) (backend.Result, error)
+	streamLogQL  func(context.Context, string, query.Request) (*backend.Stream, error)
+	promMetadata func(context.Context, string, query.MetadataRequest) (backend.Result, error)
+	logLabels    func(context.Context, string, query.MetadataRequest) (backend.Result, error)
+	traceByID    func(context.Context, string, query.TraceRequest) (backend.Result, error)
+	traceLabels  func(context.Context, string, query.MetadataRequest) (backend.Result, error)
 }
 
 func (s stubBackend) QueryPromQL(ctx context.Context, tenant string, req query.Request) (backend.Result, error) {
@@ -43,6 +54,45 @@ func (s stubBackend) QueryTraceQL(ctx context.Context, tenant string, req query.
 	return s.queryTraceQL(ctx, tenant, req)
 }
 
+func (s stubBackend) StreamLogQL(ctx context.Context, tenant string, req query.Request) (*backend.Stream, error) {
+	if s.streamLogQL == nil {
+		return nil, &backend.UnsupportedError{Message: "stream not stubbed"}
+	}
+	return s.streamLogQL(ctx, tenant, req)
+}
+
+func (s stubBackend) QueryPromMetadata(ctx context.Context, tenant string, req query.MetadataRequest) (backend.Result, error) {
+	if s.promMetadata == nil {
+		return backend.Result{}, &backend.UnsupportedError{Message: "metadata not stubbed"}
+	}
+	return s.promMetadata(ctx, tenant, req)
+}
+
+func (s stubBackend) QueryLogLabels(ctx context.Context, tenant string, req query.MetadataRequest) (backend.Result, error) {
+	if s.logLabels == nil {
+		return backend.Result{}, &backend.UnsupportedError{Message: "labels not stubbed"}
+	}
+	return s.logLabels(ctx, tenant, req)
+}
+
+func (s stubBackend) QueryTraceByID(ctx context.Context, tenant string, req query.TraceRequest) (backend.Result, error) {
+	if s.traceByID == nil {
+		return backend.Result{}, &backend.UnsupportedError{Message: "trace by id not stubbed"}
+	}
+	return s.traceByID(ctx, tenant, req)
+}
+
+func (s stubBackend) QueryTraceLabels(ctx context.Context, tenant string, req query.MetadataRequest) (backend.Result, error) {
+	if s.traceLabels == nil {
+		return backend.Result{}, &backend.UnsupportedError{Message: "trace labels not stubbed"}
+	}
+	return s.traceLabels(ctx, tenant, req)
+}
+
+func (s stubBackend) StreamTraceQL(context.Context, string, query.Request) (*backend.Stream, error) {
+	return nil, &backend.UnsupportedError{Message: "stream not stubbed"}
+}
+
 func TestHandleQueryResolvesTemplateWithCustomHeaders(t *testing.T) {
 	cacheStore, err := cache.New(cache.Config{Enabled: false})
 	if err != nil {
@@ -64,7 +114,6 @@ func TestHandleQueryResolvesTemplateWithCustomHeaders(t *testing.T) {
 
 	called := false
 	srv := &Server{
-		cfg:   cfg,
 		cache: cacheStore,
 		backend: stubBackend{
 			queryLogQL: func(_ context.Context, tenant string, req query.Request) (backend.Result, error) {
@@ -90,6 +139,7 @@ func TestHandleQueryResolvesTemplateWithCustomHeaders(t *testing.T) {
 		},
 		auditLog: audit.New(false, nil),
 	}
+	srv.Reload(cfg)
 
 	reqBody, err := json.Marshal(query.Request{
 		Template:  "service_error_logs",
@@ -128,25 +178,27 @@ func TestHandleQueryResolvesTemplateWithCustomHeaders(t *testing.T) {
 }
 
 func TestResolveTemplateLeavesExplicitQueryIntact(t *testing.T) {
-	srv := &Server{
-		cfg: config.Config{
-			QueryTemplates: map[string]config.QueryTemplateConfig{
-				"service_error_rate": {
-					Lang:  "promql",
-					Query: `sum(rate(errors_total{service="{{service}}"}[{{window}}]))`,
-					Step:  "1m",
-				},
+	srv := &Server{}
+	srv.Reload(config.Config{
+		QueryTemplates: map[string]config.QueryTemplateConfig{
+			"service_error_rate": {
+				Lang:  "promql",
+				Query: `sum(rate(errors_total{service="{{service}}"}[{{window}}]))`,
+				Step:  "1m",
 			},
 		},
-	}
+	})
 
-	req := srv.resolveTemplate(query.Request{
+	req, err := srv.resolveTemplate(context.Background(), "tenant-a", query.Request{
 		Lang:      "promql",
 		Query:     `up{service="payments"}`,
 		Template:  "service_error_rate",
 		Variables: map[string]string{"service": "payments", "window": "5m"},
 		Step:      "30s",
 	})
+	if err != nil {
+		t.Fatalf("resolveTemplate() error = %v", err)
+	}
 
 	if req.Query != `up{service="payments"}` {
 		t.Fatalf("query = %q, want explicit query preserved", req.Query)
@@ -155,3 +207,199 @@ func TestResolveTemplateLeavesExplicitQueryIntact(t *testing.T) {
 		t.Fatalf("step = %q, want explicit step preserved", req.Step)
 	}
 }
+
+func TestHandleQueryNormalizesLogHits(t *testing.T) {
+	cacheStore, err := cache.New(cache.Config{Enabled: false})
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+
+	srv := &Server{
+		cache: cacheStore,
+		backend: stubBackend{
+			queryLogQL: func(context.Context, string, query.Request) (backend.Result, error) {
+				return backend.Result{
+					Payload: json.RawMessage(`{"hits":[{"_timestamp":1700000000000000,"labels":{"service":"api"},"message":"boom"}]}`),
+					Backend: "stub-logql",
+				}, nil
+			},
+		},
+		auditLog: audit.New(false, nil),
+	}
+
+	reqBody, err := json.Marshal(query.Request{
+		Lang:      "logql",
+		Query:     `{service="api"}`,
+		Start:     time.Unix(1699999000, 0).UTC(),
+		End:       time.Unix(1700001000, 0).UTC(),
+		Normalize: true,
+	})
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/query", bytes.NewReader(reqBody))
+	req.Header.Set("X-Tenant", "tenant-a")
+	rec := httptest.NewRecorder()
+
+	srv.handleQuery(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var resp struct {
+		Result frame.Frame `json:"result"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if resp.Result.Kind != frame.KindLogs || len(resp.Result.Rows) != 1 {
+		t.Fatalf("result = %+v, want one log row", resp.Result)
+	}
+	if row := resp.Result.Rows[0]; row.Line != "boom" || row.Labels["service"] != "api" {
+		t.Fatalf("row = %+v", row)
+	}
+}
+
+func TestHandleQueryEnforcesCostBudget(t *testing.T) {
+	cacheStore, err := cache.New(cache.Config{Enabled: false})
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+
+	calls := 0
+	srv := &Server{
+		cache: cacheStore,
+		backend: stubBackend{
+			queryPromQL: func(context.Context, string, query.Request) (backend.Result, error) {
+				calls++
+				return backend.Result{Payload: json.RawMessage(`{}`), Cost: 60}, nil
+			},
+		},
+		limiter:  limiter.New(limiter.Config{Budget: limiter.BudgetConfig{Enabled: true, Limit: 100}}),
+		auditLog: audit.New(false, nil),
+	}
+
+	wantRemaining := []string{"40", "0", "0"}
+	wantStatus := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+	for i := range wantStatus {
+		req := httptest.NewRequest(http.MethodPost, "/api/query", bytes.NewReader([]byte(`{"lang":"promql","query":"up"}`)))
+		req.Header.Set("X-Tenant", "tenant-a")
+		rec := httptest.NewRecorder()
+
+		srv.handleQuery(rec, req)
+
+		if rec.Code != wantStatus[i] {
+			t.Fatalf("request %d: status = %d, want %d", i, rec.Code, wantStatus[i])
+		}
+		if got := rec.Header().Get("X-Query-Budget-Remaining"); got != wantRemaining[i] {
+			t.Fatalf("request %d: X-Query-Budget-Remaining = %q, want %q", i, got, wantRemaining[i])
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("backend calls = %d, want 2", calls)
+	}
+}
+
+func TestHandleQueryRateLimitHeaders(t *testing.T) {
+	cacheStore, err := cache.New(cache.Config{Enabled: false})
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	var auditOut bytes.Buffer
+	srv := &Server{
+		cache:   cacheStore,
+		backend: stubBackend{},
+		limiter: limiter.New(limiter.Config{
+			Enabled:           true,
+			RequestsPerSecond: 100,
+			Burst:             100,
+			Langs:             map[string]limiter.Limit{"promql": {RequestsPerSecond: 0.5, Burst: 1}},
+		}),
+		auditLog: audit.New(true, &auditOut),
+	}
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/query", bytes.NewReader([]byte(`{"lang":"promql","query":"up"}`)))
+		req.Header.Set("X-Tenant", "tenant-a")
+		rec := httptest.NewRecorder()
+		srv.handleQuery(rec, req)
+		return rec
+	}
+
+	rec := send()
+	if rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "1" || rec.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("status = %d, headers = %v", rec.Code, rec.Header())
+	}
+	auditOut.Reset()
+
+	rec = send()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Fatalf("Retry-After = %q, want 2", got)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body["rule"] != "lang:promql" {
+		t.Fatalf("body = %s, want rule lang:promql", rec.Body.String())
+	}
+	var entry audit.Entry
+	if err := json.Unmarshal(auditOut.Bytes(), &entry); err != nil || entry.RateLimitRule != "lang:promql" {
+		t.Fatalf("audit = %s, want rate_limit_rule lang:promql", auditOut.String())
+	}
+}
+
+func TestHandleQueryShardsLogSearch(t *testing.T) {
+	cacheStore, err := cache.New(cache.Config{Enabled: true, NumCounters: 1000, MaxCost: 1 << 20, TTL: time.Minute})
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	var mu sync.Mutex
+	calls := 0
+	stub := stubBackend{
+		queryLogQL: func(_ context.Context, _ string, req query.Request) (backend.Result, error) {
+			mu.Lock()
+			calls++
+			mu.Unlock()
+			if req.Start.Equal(start.Add(24 * time.Hour)) {
+				return backend.Result{}, errors.New("openobserve timeout")
+			}
+			hit := fmt.Sprintf(`{"hits":[{"_timestamp":%d,"message":"m"}]}`, req.Start.UnixMicro())
+			return backend.Result{Payload: json.RawMessage(hit), Backend: "stub-logql", Cost: 1}, nil
+		},
+	}
+	cfg := config.Config{Sharding: config.ShardingConfig{Enabled: true, Interval: 24 * time.Hour}}
+	handler := New(cfg, nil, nil, nil, stub, cacheStore, nil, audit.New(false, nil)).Handler()
+
+	body := fmt.Sprintf(`{"lang":"logql","query":"{service=\"api\"}","start":%q,"end":%q}`, start.Format(time.RFC3339), start.Add(72*time.Hour).Format(time.RFC3339))
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/api/query", strings.NewReader(body))
+		req.Header.Set("X-Tenant", "tenant-a")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		cacheStore.Wait()
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		var resp struct {
+			Result struct {
+				Hits []json.RawMessage `json:"hits"`
+			} `json:"result"`
+			Warnings []string    `json:"warnings"`
+			Stats    query.Stats `json:"stats"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("json.Unmarshal() error = %v", err)
+		}
+		if len(resp.Result.Hits) != 2 || len(resp.Warnings) != 1 || !resp.Stats.Partial || resp.Stats.Cost != 2 {
+			t.Fatalf("response = %s, want two shards' hits and one warning", rec.Body.String())
+		}
+	}
+	if calls != 6 {
+		t.Fatalf("backend calls = %d, want 6: partial results must not be cached", calls)
+	}
+}
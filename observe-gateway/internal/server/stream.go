@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/audit"
+	"github.com/xscopehub/observe-gateway/internal/backend"
+	"github.com/xscopehub/observe-gateway/internal/frame"
+	"github.com/xscopehub/observe-gateway/internal/query"
+)
+
+// Streaming response formats.
+const (
+	streamNDJSON = "ndjson"
+	streamSSE    = "sse"
+)
+
+// streamingBackend is implemented by backends that can return search hits
+// incrementally.
+type streamingBackend interface {
+	StreamLogQL(context.Context, string, query.Request) (*backend.Stream, error)
+	StreamTraceQL(context.Context, string, query.Request) (*backend.Stream, error)
+}
+
+// streamFormat picks the streaming format from the request body or, when
+// unset, from the Accept header.
+func streamFormat(req query.Request, r *http.Request) string {
+	if req.Stream != "" {
+		return strings.ToLower(req.Stream)
+	}
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, "application/x-ndjson"):
+		return streamNDJSON
+	case strings.Contains(accept, "text/event-stream"):
+		return streamSSE
+	default:
+		return ""
+	}
+}
+
+// streamTrailer is the final record of a stream.
+type streamTrailer struct {
+	Done       bool   `json:"done"`
+	Rows       int64  `json:"rows"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Backend    string `json:"backend,omitempty"`
+}
+
+// streamWriter writes records in NDJSON or SSE framing, flushing each one
+// and counting the bytes sent.
+type streamWriter struct {
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	format  string
+	timeout time.Duration
+	bytes   int64
+}
+
+func (sw *streamWriter) write(event string, record any) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	var n int
+	if sw.format == streamSSE {
+		n, err = fmt.Fprintf(sw.w, "event: %s\ndata: %s\n\n", event, data)
+	} else {
+		n, err = fmt.Fprintf(sw.w, "%s\n", data)
+	}
+	sw.bytes += int64(n)
+	if err != nil {
+		return err
+	}
+	// Long streams outlive the server write timeout; extend it per record.
+	if sw.timeout > 0 {
+		_ = sw.rc.SetWriteDeadline(time.Now().Add(sw.timeout))
+	}
+	_ = sw.rc.Flush()
+	return nil
+}
+
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request, tenant, user string, req query.Request, start time.Time) {
+	entry := audit.Entry{Tenant: tenant, User: user, Lang: req.Lang, Query: req.Query}
+	// A stream lasts as long as the backend sends records, well past the
+	// request timeout.
+	if !liftTimeout(r.Context()) {
+		return
+	}
+
+	stream, err := s.openStream(r.Context(), tenant, req)
+	if err != nil {
+		s.writeError(w, errorStatus(err), err.Error())
+		entry.Duration, entry.Error = time.Since(start), err.Error()
+		s.auditLog.Log(entry)
+		return
+	}
+	defer stream.Close()
+	entry.Backend, entry.Cost, entry.Breaker = stream.Backend, stream.Cost, stream.Breaker
+	s.chargeBudget(r.Context(), w, tenant, stream.Cost)
+
+	contentType := "application/x-ndjson"
+	if req.Stream == streamSSE {
+		contentType = "text/event-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	sw := &streamWriter{w: w, rc: http.NewResponseController(w), format: req.Stream, timeout: s.config().Server.WriteTimeout}
+	for {
+		hit, err := stream.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var record any = hit
+		if err == nil && req.Normalize {
+			record, err = frame.NormalizeHit(req.Lang, hit)
+		}
+		if err == nil {
+			err = sw.write("hit", record)
+		}
+		if err != nil {
+			_ = sw.write("error", map[string]string{"error": err.Error()})
+			entry.Error = err.Error()
+			break
+		}
+		entry.Rows++
+	}
+
+	if entry.Error == "" {
+		trailer := streamTrailer{Done: true, Rows: entry.Rows, Backend: stream.Backend}
+		if req.Limit > 0 && entry.Rows == int64(req.Limit) {
+			trailer.NextCursor = encodeCursor(req, tenant, req.Offset+req.Limit)
+		}
+		_ = sw.write("done", trailer)
+	}
+
+	entry.Duration, entry.Bytes = time.Since(start), sw.bytes
+	s.auditLog.Log(entry)
+}
+
+func (s *Server) openStream(ctx context.Context, tenant string, req query.Request) (*backend.Stream, error) {
+	sb, ok := s.backend.(streamingBackend)
+	if !ok {
+		return nil, &backend.UnsupportedError{Status: http.StatusBadRequest, Message: "streaming is not supported by the backend"}
+	}
+	switch req.Lang {
+	case "logql":
+		return sb.StreamLogQL(ctx, tenant, req)
+	case "traceql":
+		return sb.StreamTraceQL(ctx, tenant, req)
+	default:
+		return nil, &backend.UnsupportedError{Status: http.StatusBadRequest, Message: fmt.Sprintf("streaming is not supported for %s", req.Lang)}
+	}
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/audit"
+	"github.com/xscopehub/observe-gateway/internal/backend"
+	"github.com/xscopehub/observe-gateway/internal/cache"
+	"github.com/xscopehub/observe-gateway/internal/config"
+	"github.com/xscopehub/observe-gateway/internal/query"
+)
+
+func newPagingServer(t *testing.T, stub stubBackend, auditOut io.Writer) *Server {
+	t.Helper()
+	cacheStore, err := cache.New(cache.Config{Enabled: false})
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	srv := &Server{
+		cache:    cacheStore,
+		backend:  stub,
+		auditLog: audit.New(auditOut != nil, auditOut),
+	}
+	srv.Reload(config.Config{Server: config.ServerConfig{MaxPageSize: 100}})
+	return srv
+}
+
+func postQuery(t *testing.T, srv *Server, body query.Request, accept string) *httptest.ResponseRecorder {
+	t.Helper()
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/query", bytes.NewReader(reqBody))
+	req.Header.Set("X-Tenant", "tenant-a")
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	srv.handleQuery(rec, req)
+	return rec
+}
+
+func logRequest() query.Request {
+	return query.Request{
+		Lang:  "logql",
+		Query: `{service="api"}`,
+		Start: time.Unix(1699999000, 0).UTC(),
+		End:   time.Unix(1700001000, 0).UTC(),
+	}
+}
+
+func TestHandleQueryStreamsNDJSON(t *testing.T) {
+	var auditOut bytes.Buffer
+	srv := newPagingServer(t, stubBackend{
+		streamLogQL: func(_ context.Context, _ string, req query.Request) (*backend.Stream, error) {
+			if req.Limit != 2 {
+				t.Fatalf("limit = %d, want 2", req.Limit)
+			}
+			body := `{"hits":[{"_timestamp":1700000000000000,"message":"a"},{"_timestamp":1700000001000000,"message":"b"}]}`
+			stream := backend.NewStream(io.NopCloser(strings.NewReader(body)), 3)
+			stream.Backend = "stub-logql"
+			return stream, nil
+		},
+	}, &auditOut)
+
+	req := logRequest()
+	req.Limit = 2
+	rec := postQuery(t, srv, req, "application/x-ndjson")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Fatalf("content type = %q, want application/x-ndjson", ct)
+	}
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("lines = %q, want two hits and a trailer", lines)
+	}
+	if lines[0] != `{"_timestamp":1700000000000000,"message":"a"}` {
+		t.Fatalf("first line = %q", lines[0])
+	}
+	var trailer streamTrailer
+	if err := json.Unmarshal([]byte(lines[2]), &trailer); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if !trailer.Done || trailer.Rows != 2 || trailer.NextCursor == "" {
+		t.Fatalf("trailer = %+v, want done with 2 rows and a cursor", trailer)
+	}
+
+	var entry audit.Entry
+	if err := json.Unmarshal(auditOut.Bytes(), &entry); err != nil {
+		t.Fatalf("json.Unmarshal(audit) error = %v", err)
+	}
+	if entry.Rows != 2 || entry.Bytes != int64(rec.Body.Len()) || entry.Cost != 3 {
+		t.Fatalf("audit = %+v, want rows 2, bytes %d, cost 3", entry, rec.Body.Len())
+	}
+}
+
+func TestHandleQueryStreamsSSE(t *testing.T) {
+	srv := newPagingServer(t, stubBackend{
+		streamLogQL: func(context.Context, string, query.Request) (*backend.Stream, error) {
+			body := `{"hits":[{"_timestamp":1700000000000000,"labels":{"service":"api"},"message":"boom"}]}`
+			return backend.NewStream(io.NopCloser(strings.NewReader(body)), 0), nil
+		},
+	}, nil)
+
+	req := logRequest()
+	req.Stream = "sse"
+	req.Normalize = true
+	rec := postQuery(t, srv, req, "")
+
+	want := "event: hit\ndata: {\"timestamp\":\"2023-11-14T22:13:20Z\",\"labels\":{\"service\":\"api\"},\"line\":\"boom\"}\n\n" +
+		"event: done\ndata: {\"done\":true,\"rows\":1}\n\n"
+	if rec.Body.String() != want {
+		t.Fatalf("body = %q, want %q", rec.Body.String(), want)
+	}
+}
+
+func TestHandleQueryReturnsNextCursor(t *testing.T) {
+	var offsets []int
+	srv := newPagingServer(t, stubBackend{
+		queryLogQL: func(_ context.Context, _ string, req query.Request) (backend.Result, error) {
+			offsets = append(offsets, req.Offset)
+			return backend.Result{Payload: json.RawMessage(`{"hits":[]}`), Rows: req.Limit, HasMore: req.Offset == 0}, nil
+		},
+	}, nil)
+
+	req := logRequest()
+	req.Limit = 10
+	rec := postQuery(t, srv, req, "")
+	var resp query.Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if resp.NextCursor == "" {
+		t.Fatalf("next_cursor is empty: %s", rec.Body.String())
+	}
+
+	req.Cursor = resp.NextCursor
+	rec = postQuery(t, srv, req, "")
+	resp = query.Response{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if resp.NextCursor != "" {
+		t.Fatalf("next_cursor = %q on the last page", resp.NextCursor)
+	}
+	if len(offsets) != 2 || offsets[1] != 10 {
+		t.Fatalf("offsets = %v, want [0 10]", offsets)
+	}
+}
+
+func TestHandleQueryRejectsInvalidPages(t *testing.T) {
+	srv := newPagingServer(t, stubBackend{}, nil)
+
+	other := logRequest()
+	other.Query = `{service="web"}`
+	foreign := encodeCursor(other, "tenant-a", 10)
+
+	tests := map[string]func(*query.Request){
+		"limit too large":  func(r *query.Request) { r.Limit = 1000 },
+		"negative limit":   func(r *query.Request) { r.Limit = -1 },
+		"cursor no limit":  func(r *query.Request) { r.Cursor = foreign },
+		"foreign cursor":   func(r *query.Request) { r.Limit = 10; r.Cursor = foreign },
+		"garbage cursor":   func(r *query.Request) { r.Limit = 10; r.Cursor = "!!" },
+		"unknown format":   func(r *query.Request) { r.Stream = "csv" },
+		"promql streaming": func(r *query.Request) { r.Lang = "promql"; r.Stream = "ndjson" },
+	}
+	for name, mutate := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := logRequest()
+			mutate(&req)
+			if rec := postQuery(t, srv, req, ""); rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestTimeoutSparesStreams(t *testing.T) {
+	var streamErr error
+	handler := timeout(20 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stream" {
+			liftTimeout(r.Context())
+		}
+		select {
+		case <-r.Context().Done():
+			streamErr = r.Context().Err()
+		case <-time.After(60 * time.Millisecond):
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query", nil))
+	if rec.Code != http.StatusGatewayTimeout || streamErr != context.DeadlineExceeded {
+		t.Fatalf("status = %d, err = %v, want 504 and deadline exceeded", rec.Code, streamErr)
+	}
+
+	streamErr = nil
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/stream", nil))
+	if rec.Code != http.StatusOK || streamErr != nil {
+		t.Fatalf("stream status = %d, err = %v, want 200 past the timeout", rec.Code, streamErr)
+	}
+}
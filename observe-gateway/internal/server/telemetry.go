@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/xscopehub/observe-gateway/internal/telemetry"
+)
+
+var tracer = otel.Tracer("github.com/xscopehub/observe-gateway/internal/server")
+
+// requestLabels are the metric labels a handler learns while serving a
+// request.
+type requestLabels struct {
+	tenant   string
+	lang     string
+	template string
+}
+
+type requestLabelsKey struct{}
+
+// labelRequest sets the non-empty labels of the request's metrics.
+func labelRequest(ctx context.Context, tenant, lang, template string) {
+	labels, ok := ctx.Value(requestLabelsKey{}).(*requestLabels)
+	if !ok {
+		return
+	}
+	if tenant != "" {
+		labels.tenant = tenant
+	}
+	if lang != "" {
+		labels.lang = lang
+	}
+	if template != "" {
+		labels.template = template
+	}
+}
+
+// instrument records the count and latency of each request and serves it
+// within a server span, continuing the W3C trace context sent by the
+// client.
+func (s *Server) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+		labels := &requestLabels{}
+		ctx = context.WithValue(ctx, requestLabelsKey{}, labels)
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		var route string
+		if rctx := chi.RouteContext(ctx); rctx != nil {
+			route = rctx.RoutePattern()
+		}
+		span.SetName(r.Method + " " + route)
+		span.SetAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("http.route", route),
+			attribute.Int("http.response.status_code", status),
+			attribute.String("tenant", labels.tenant),
+			attribute.String("lang", labels.lang),
+		)
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+
+		values := []string{route, labels.lang, labels.template, labels.tenant, strconv.Itoa(status)}
+		telemetry.Requests.WithLabelValues(values...).Inc()
+		telemetry.RequestDuration.WithLabelValues(values...).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/xscopehub/observe-gateway/internal/audit"
+	"github.com/xscopehub/observe-gateway/internal/backend"
+	"github.com/xscopehub/observe-gateway/internal/cache"
+	"github.com/xscopehub/observe-gateway/internal/config"
+	"github.com/xscopehub/observe-gateway/internal/query"
+)
+
+func TestMetricsAndTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	}()
+
+	cacheStore, err := cache.New(cache.Config{Enabled: false})
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	stub := stubBackend{
+		queryPromQL: func(context.Context, string, query.Request) (backend.Result, error) {
+			return backend.Result{Payload: json.RawMessage(`{"status":"success","data":{"resultType":"vector","result":[]}}`)}, nil
+		},
+	}
+	cfg := config.Config{Telemetry: config.TelemetryConfig{Metrics: config.MetricsConfig{Enabled: true, Path: "/metrics"}}}
+	handler := New(cfg, nil, nil, nil, stub, cacheStore, nil, audit.New(false, nil)).Handler()
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodPost, "/api/query", strings.NewReader(`{"lang":"promql","query":"up"}`))
+	req.Header.Set("X-Tenant", "tenant-metrics")
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+
+	names := map[string]bool{}
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID().String() != traceID {
+			t.Fatalf("span %s trace = %s, want the client's trace %s", span.Name(), span.SpanContext().TraceID(), traceID)
+		}
+		names[span.Name()] = true
+	}
+	for _, name := range []string{"POST /api/query", "parse", "dispatch"} {
+		if !names[name] {
+			t.Fatalf("spans = %v, want %s", names, name)
+		}
+	}
+
+	// Caller-chosen languages and template names that do not resolve are
+	// not labels.
+	for _, body := range []string{`{"lang":"made-up-lang","query":"up"}`, `{"lang":"promql","template":"made-up-template","query":"up"}`} {
+		req = httptest.NewRequest(http.MethodPost, "/api/query", strings.NewReader(body))
+		req.Header.Set("X-Tenant", "tenant-metrics")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	for _, want := range []string{
+		`observe_gateway_request_duration_seconds_count{lang="promql",route="/api/query",status="200",template="",tenant="tenant-metrics"} 2`,
+		`observe_gateway_requests_total{lang="",route="/api/query",status="400",template="",tenant="tenant-metrics"} 1`,
+		`observe_gateway_requests_total{lang="promql",route="/api/query",status="200",template="",tenant="tenant-metrics"} 2`,
+		`observe_gateway_active_requests 0`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Fatalf("metrics missing %s:\n%s", want, body)
+		}
+	}
+	if strings.Contains(string(body), "made-up") {
+		t.Fatalf("metrics carry caller-chosen labels:\n%s", body)
+	}
+}
@@ -0,0 +1,308 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/xscopehub/observe-gateway/internal/audit"
+	"github.com/xscopehub/observe-gateway/internal/logql"
+	"github.com/xscopehub/observe-gateway/internal/templates"
+	"github.com/xscopehub/observe-gateway/internal/traceql"
+)
+
+// templateRequest is the body of PUT /api/templates/{name}.
+type templateRequest struct {
+	Lang        string               `json:"lang"`
+	Query       string               `json:"query"`
+	Step        string               `json:"step"`
+	Description string               `json:"description"`
+	Variables   []templates.Variable `json:"variables"`
+}
+
+func (req templateRequest) template(tenant, name, user string) templates.Template {
+	return templates.Template{
+		Tenant:      tenant,
+		Name:        name,
+		Lang:        strings.ToLower(strings.TrimSpace(req.Lang)),
+		Query:       req.Query,
+		Step:        req.Step,
+		Description: req.Description,
+		Variables:   req.Variables,
+		CreatedBy:   user,
+	}
+}
+
+// dryRunRequest is the body of POST /api/templates/{name}/dry-run.
+type dryRunRequest struct {
+	Variables map[string]string `json:"variables"`
+	// Version renders a stored version instead of the effective template.
+	Version int `json:"version"`
+	// Template renders an unsaved definition, to try an edit before saving.
+	Template *templateRequest `json:"template"`
+}
+
+// lookupTemplate returns the template a tenant's queries resolve name to.
+func (s *Server) lookupTemplate(ctx context.Context, tenant, name string) (templates.Template, bool, error) {
+	tmpl, found, err := s.templates.Lookup(ctx, tenant, name)
+	if err != nil || found {
+		return tmpl, found, err
+	}
+	tmpl, found = s.config().globals[name]
+	return tmpl, found, nil
+}
+
+// templateCaller identifies the caller of a template endpoint and checks it
+// holds one of scopes.
+func (s *Server) templateCaller(w http.ResponseWriter, r *http.Request, scopes ...string) (caller, bool) {
+	attempt := audit.Entry{Query: r.Method + " " + r.URL.Path}
+	c, err := s.identify(r)
+	if err != nil {
+		s.writeError(w, http.StatusUnauthorized, err.Error())
+		attempt.Tenant, attempt.User = c.tenant, c.user
+		s.auditAuthFailure(r, attempt, http.StatusUnauthorized, err)
+		return caller{}, false
+	}
+	if err := c.require(scopes...); err != nil {
+		s.writeError(w, http.StatusForbidden, err.Error())
+		attempt.Tenant, attempt.User = c.tenant, c.user
+		s.auditAuthFailure(r, attempt, http.StatusForbidden, err)
+		return caller{}, false
+	}
+	if c.tenant == "" {
+		s.writeError(w, http.StatusBadRequest, "tenant is required")
+		return caller{}, false
+	}
+	return c, true
+}
+
+func (s *Server) requireTemplateStore(w http.ResponseWriter) bool {
+	if !s.templates.Enabled() {
+		s.writeError(w, http.StatusNotFound, "template store is disabled")
+		return false
+	}
+	return true
+}
+
+// handleListTemplates lists the templates available to the tenant, its own
+// replacing global ones of the same name: GET /api/templates.
+func (s *Server) handleListTemplates(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.templateCaller(w, r, "templates", "query")
+	if !ok {
+		return
+	}
+	byName := make(map[string]templates.Template)
+	for name, tmpl := range s.config().globals {
+		byName[name] = tmpl
+	}
+	if s.templates.Enabled() {
+		own, err := s.templates.List(r.Context(), c.tenant)
+		if err != nil {
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for _, tmpl := range own {
+			byName[tmpl.Name] = tmpl
+		}
+	}
+	out := make([]templates.Template, 0, len(byName))
+	for _, tmpl := range byName {
+		out = append(out, tmpl)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	s.writeJSON(w, http.StatusOK, map[string]any{"templates": out})
+}
+
+// handleGetTemplate returns the template the tenant's queries resolve a name
+// to: GET /api/templates/{name}.
+func (s *Server) handleGetTemplate(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.templateCaller(w, r, "templates", "query")
+	if !ok {
+		return
+	}
+	tmpl, found, err := s.lookupTemplate(r.Context(), c.tenant, chi.URLParam(r, "name"))
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !found {
+		s.writeError(w, http.StatusNotFound, templates.ErrNotFound.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, tmpl)
+}
+
+// handleSaveTemplate stores a new version of a tenant template:
+// PUT /api/templates/{name}.
+func (s *Server) handleSaveTemplate(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.templateCaller(w, r, "templates")
+	if !ok || !s.requireTemplateStore(w) {
+		return
+	}
+	var req templateRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	saved, err := s.templates.Save(r.Context(), req.template(c.tenant, chi.URLParam(r, "name"), c.user))
+	if err != nil {
+		s.writeError(w, templateErrorStatus(err), err.Error())
+		return
+	}
+	status := http.StatusOK
+	if saved.Version == 1 {
+		status = http.StatusCreated
+	}
+	s.writeJSON(w, status, saved)
+}
+
+// handleDeleteTemplate removes all versions of a tenant template; a global
+// template of the same name applies again: DELETE /api/templates/{name}.
+func (s *Server) handleDeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.templateCaller(w, r, "templates")
+	if !ok || !s.requireTemplateStore(w) {
+		return
+	}
+	name := chi.URLParam(r, "name")
+	if err := s.templates.Delete(r.Context(), c.tenant, name); err != nil {
+		s.writeError(w, templateErrorStatus(err), err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]any{"name": name, "deleted": true})
+}
+
+// handleTemplateVersions lists the versions of a tenant template, oldest
+// first: GET /api/templates/{name}/versions.
+func (s *Server) handleTemplateVersions(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.templateCaller(w, r, "templates", "query")
+	if !ok || !s.requireTemplateStore(w) {
+		return
+	}
+	versions, err := s.templates.Versions(r.Context(), c.tenant, chi.URLParam(r, "name"))
+	if err != nil {
+		s.writeError(w, templateErrorStatus(err), err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]any{"versions": versions})
+}
+
+// handleRollbackTemplate makes an earlier version current again by saving a
+// copy of it as the newest version:
+// POST /api/templates/{name}/rollback?version=<n>.
+func (s *Server) handleRollbackTemplate(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.templateCaller(w, r, "templates")
+	if !ok || !s.requireTemplateStore(w) {
+		return
+	}
+	version, err := strconv.Atoi(r.URL.Query().Get("version"))
+	if err != nil || version <= 0 {
+		s.writeError(w, http.StatusBadRequest, "invalid version")
+		return
+	}
+	saved, err := s.templates.Rollback(r.Context(), c.tenant, chi.URLParam(r, "name"), version, c.user)
+	if err != nil {
+		s.writeError(w, templateErrorStatus(err), err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, saved)
+}
+
+// handleDryRunTemplate renders a template and checks the resulting query
+// parses, without running it: POST /api/templates/{name}/dry-run.
+func (s *Server) handleDryRunTemplate(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.templateCaller(w, r, "templates", "query")
+	if !ok {
+		return
+	}
+	var req dryRunRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	name := chi.URLParam(r, "name")
+	var tmpl templates.Template
+	switch {
+	case req.Template != nil:
+		tmpl = req.Template.template(c.tenant, name, c.user)
+		if err := tmpl.Validate(); err != nil {
+			s.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	case req.Version > 0:
+		if !s.requireTemplateStore(w) {
+			return
+		}
+		var err error
+		if tmpl, err = s.templates.Version(r.Context(), c.tenant, name, req.Version); err != nil {
+			s.writeError(w, templateErrorStatus(err), err.Error())
+			return
+		}
+	default:
+		var found bool
+		var err error
+		if tmpl, found, err = s.lookupTemplate(r.Context(), c.tenant, name); err != nil {
+			s.writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !found {
+			s.writeError(w, http.StatusNotFound, templates.ErrNotFound.Error())
+			return
+		}
+	}
+
+	rendered, err := tmpl.Render(req.Variables)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := checkSyntax(rendered.Lang, rendered.Query); err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("rendered query is invalid: %v", err))
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]any{"template": tmpl.Name, "version": tmpl.Version, "rendered": rendered})
+}
+
+// checkSyntax parses a query in its language.
+func checkSyntax(lang, query string) error {
+	var err error
+	switch lang {
+	case "promql":
+		_, err = parser.ParseExpr(query)
+	case "logql":
+		_, err = logql.ParseExpr(query)
+	case "traceql":
+		_, err = traceql.Parse(query)
+	default:
+		err = fmt.Errorf("unsupported language: %s", lang)
+	}
+	return err
+}
+
+func isTemplateError(err error) bool {
+	var tmplErr *templates.Error
+	return errors.As(err, &tmplErr)
+}
+
+func templateErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, templates.ErrNotFound):
+		return http.StatusNotFound
+	case isTemplateError(err):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
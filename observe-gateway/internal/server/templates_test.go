@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/audit"
+	"github.com/xscopehub/observe-gateway/internal/backend"
+	"github.com/xscopehub/observe-gateway/internal/cache"
+	"github.com/xscopehub/observe-gateway/internal/config"
+	"github.com/xscopehub/observe-gateway/internal/query"
+	"github.com/xscopehub/observe-gateway/internal/templates"
+)
+
+func TestTemplateLifecycle(t *testing.T) {
+	cacheStore, err := cache.New(cache.Config{Enabled: false})
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	var queries []string
+	stub := stubBackend{
+		queryPromQL: func(_ context.Context, _ string, req query.Request) (backend.Result, error) {
+			queries = append(queries, req.Query)
+			return backend.Result{Payload: json.RawMessage(`{"status":"success"}`), Backend: "stub"}, nil
+		},
+	}
+	cfg := config.Config{QueryTemplates: map[string]config.QueryTemplateConfig{
+		"errors": {Lang: "promql", Query: `sum(rate(errors_total{service="{{service}}"}[{{window}}]))`},
+	}}
+	registry := templates.NewRegistry(templates.NewMemoryStore(), time.Minute)
+	handler := New(cfg, nil, nil, registry, stub, cacheStore, nil, audit.New(false, nil)).Handler()
+
+	do := func(method, path, tenant, body string, want int) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		req.Header.Set("X-Tenant", tenant)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Fatalf("%s %s status = %d, want %d: %s", method, path, rec.Code, want, rec.Body.String())
+		}
+		return rec
+	}
+	runQuery := func(tenant string, variables string) {
+		t.Helper()
+		do(http.MethodPost, "/api/query", tenant, `{"template":"errors","variables":`+variables+`}`, http.StatusOK)
+	}
+
+	// The global template escapes label values instead of letting them
+	// rewrite the query.
+	runQuery("tenant-a", `{"service":"x\"} or vector(1) or {a=\"","window":"5m"}`)
+	if want := `sum(rate(errors_total{service="x\"} or vector(1) or {a=\""}[5m]))`; queries[0] != want {
+		t.Fatalf("query = %s, want %s", queries[0], want)
+	}
+	do(http.MethodPost, "/api/query", "tenant-a", `{"template":"errors","variables":{"service":"api","window":"5m] or x"}}`, http.StatusBadRequest)
+
+	body := `{"lang":"promql","query":"sum(rate(errors_total{service=\"{{service}}\",env=\"{{env}}\"}[5m]))",
+		"variables":[{"name":"service","type":"label_value"},{"name":"env","type":"enum","values":["prod","staging"],"default":"prod"}]}`
+	do(http.MethodPut, "/api/templates/errors", "tenant-a", body, http.StatusCreated)
+	do(http.MethodPut, "/api/templates/errors", "tenant-a", `{"lang":"promql","query":"up{job={{job}}}","variables":[{"name":"job","type":"label_value"}]}`, http.StatusBadRequest)
+
+	runQuery("tenant-a", `{"service":"api"}`)
+	runQuery("tenant-b", `{"service":"api","window":"1m"}`)
+	if queries[1] != `sum(rate(errors_total{service="api",env="prod"}[5m]))` || queries[2] != `sum(rate(errors_total{service="api"}[1m]))` {
+		t.Fatalf("queries = %q, want tenant-a's own template and tenant-b the global one", queries[1:])
+	}
+
+	var list struct {
+		Templates []templates.Template `json:"templates"`
+	}
+	json.Unmarshal(do(http.MethodGet, "/api/templates", "tenant-a", "", http.StatusOK).Body.Bytes(), &list)
+	if len(list.Templates) != 1 || list.Templates[0].Tenant != "tenant-a" || list.Templates[0].Version != 1 {
+		t.Fatalf("templates = %+v, want tenant-a's version 1", list.Templates)
+	}
+
+	do(http.MethodPut, "/api/templates/errors", "tenant-a", `{"lang":"promql","query":"up{service=\"{{service}}\"}"}`, http.StatusOK)
+	var dryRun struct {
+		Version  int                `json:"version"`
+		Rendered templates.Rendered `json:"rendered"`
+	}
+	json.Unmarshal(do(http.MethodPost, "/api/templates/errors/dry-run", "tenant-a", `{"variables":{"service":"api"},"version":1}`, http.StatusOK).Body.Bytes(), &dryRun)
+	if dryRun.Version != 1 || dryRun.Rendered.Query != `sum(rate(errors_total{service="api",env="prod"}[5m]))` {
+		t.Fatalf("dry run = %+v, want version 1 rendered", dryRun)
+	}
+	do(http.MethodPost, "/api/templates/errors/dry-run", "tenant-a", `{"template":{"lang":"promql","query":"sum(up{service=\"{{service}}\"}"},"variables":{"service":"api"}}`, http.StatusBadRequest)
+
+	var rolledBack templates.Template
+	json.Unmarshal(do(http.MethodPost, "/api/templates/errors/rollback?version=1", "tenant-a", "", http.StatusOK).Body.Bytes(), &rolledBack)
+	if rolledBack.Version != 3 || len(rolledBack.Variables) != 2 {
+		t.Fatalf("rollback = %+v, want version 3 with version 1's variables", rolledBack)
+	}
+	var versions struct {
+		Versions []templates.Template `json:"versions"`
+	}
+	json.Unmarshal(do(http.MethodGet, "/api/templates/errors/versions", "tenant-a", "", http.StatusOK).Body.Bytes(), &versions)
+	if len(versions.Versions) != 3 {
+		t.Fatalf("versions = %d, want 3", len(versions.Versions))
+	}
+
+	do(http.MethodDelete, "/api/templates/errors", "tenant-a", "", http.StatusOK)
+	do(http.MethodDelete, "/api/templates/errors", "tenant-a", "", http.StatusNotFound)
+	var global templates.Template
+	json.Unmarshal(do(http.MethodGet, "/api/templates/errors", "tenant-a", "", http.StatusOK).Body.Bytes(), &global)
+	if global.Tenant != "" {
+		t.Fatalf("template after delete = %+v, want the global template", global)
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/xscopehub/observe-gateway/internal/backend"
+	"github.com/xscopehub/observe-gateway/internal/cache"
+	"github.com/xscopehub/observe-gateway/internal/frame"
+	"github.com/xscopehub/observe-gateway/internal/query"
+	"github.com/xscopehub/observe-gateway/internal/tempoapi"
+	"github.com/xscopehub/observe-gateway/internal/traceql"
+)
+
+const (
+	tempoDefaultLimit = 20
+	// tempoDefaultSpansPerSet is the number of matching spans listed per
+	// trace unless the spss parameter says otherwise.
+	tempoDefaultSpansPerSet = 3
+)
+
+var tempoAPI = compatAPI{name: "tempo", lang: "traceql", writeError: writeTextError}
+
+var traceIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{1,32}$`)
+
+// traceByIDBackend is implemented by backends that look up traces by ID.
+type traceByIDBackend interface {
+	QueryTraceByID(context.Context, string, query.TraceRequest) (backend.Result, error)
+}
+
+// routeTempo serves the Tempo HTTP API under /tempo, so Grafana's Tempo data
+// source can look up and search traces through the gateway.
+func (s *Server) routeTempo(r chi.Router) {
+	r.Get("/api/echo", handleTempoEcho)
+	r.Get("/api/traces/{id}", s.handleTempoTrace)
+	r.Get("/api/search", s.handleTempoSearch)
+}
+
+// handleTempoEcho answers the connection test of Tempo clients.
+func handleTempoEcho(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("echo"))
+}
+
+// handleTempoTrace returns a trace in OTLP/JSON: /tempo/api/traces/{id}.
+func (s *Server) handleTempoTrace(w http.ResponseWriter, r *http.Request) {
+	s.serveCompat(w, r, tempoAPI, func(r *http.Request) (compatCall, error) {
+		id := chi.URLParam(r, "id")
+		if !traceIDPattern.MatchString(id) {
+			return compatCall{}, fmt.Errorf("invalid trace id: %q", id)
+		}
+		// Tempo accepts IDs without their leading zeros.
+		req := query.TraceRequest{ID: fmt.Sprintf("%032s", strings.ToLower(id))}
+		var err error
+		if req.Start, req.End, err = parseOptionalRange(r); err != nil {
+			return compatCall{}, err
+		}
+		return compatCall{
+			query: "trace " + req.ID,
+			key:   strings.Join([]string{"trace", req.ID, formatPromTime(req.Start), formatPromTime(req.End)}, "|"),
+			run: func(ctx context.Context, tenant string) (backend.Result, cache.Tier, error) {
+				traces, ok := s.backend.(traceByIDBackend)
+				if !ok {
+					return backend.Result{}, "", errAPIUnsupported
+				}
+				res, err := traces.QueryTraceByID(ctx, tenant, req)
+				return res, "", err
+			},
+		}, nil
+	})
+}
+
+// handleTempoSearch runs a TraceQL search: /tempo/api/search. Matching
+// spans are summarized per trace, newest trace first.
+func (s *Server) handleTempoSearch(w http.ResponseWriter, r *http.Request) {
+	s.serveCompat(w, r, tempoAPI, func(r *http.Request) (compatCall, error) {
+		// The tag search predates TraceQL and would need its own
+		// translation; durations are filtered with TraceQL instead.
+		for _, name := range []string{"tags", "minDuration", "maxDuration"} {
+			if r.Form.Get(name) != "" {
+				return compatCall{}, fmt.Errorf("%s is not supported, search with a TraceQL query in q", name)
+			}
+		}
+		req := query.Request{Lang: "traceql", Query: strings.TrimSpace(r.Form.Get("q"))}
+		if req.Query == "" {
+			req.Query = "{}"
+		}
+		if _, err := traceql.Parse(req.Query); err != nil {
+			return compatCall{}, fmt.Errorf("invalid TraceQL query: %w", err)
+		}
+		var err error
+		if req.Start, req.End, err = parseOptionalRange(r); err != nil {
+			return compatCall{}, err
+		}
+		if req.End.IsZero() {
+			req.End = time.Now()
+		}
+		if req.Start.IsZero() {
+			req.Start = req.End.Add(-time.Hour)
+		}
+		if !req.End.After(req.Start) {
+			return compatCall{}, errors.New("end must be after start")
+		}
+		limit, err := parseTempoCount(r.Form.Get("limit"), "limit", tempoDefaultLimit)
+		if err != nil {
+			return compatCall{}, err
+		}
+		spss, err := parseTempoCount(r.Form.Get("spss"), "spss", tempoDefaultSpansPerSet)
+		if err != nil {
+			return compatCall{}, err
+		}
+		// Spans are fetched rather than traces, so a few traces with many
+		// matches may fill the page.
+		req.Limit = limit * spss
+		if max := s.config().Server.MaxPageSize; max > 0 && req.Limit > max {
+			req.Limit = max
+		}
+
+		return compatCall{
+			query: req.Query,
+			key:   buildCacheKey(req, "") + "|spss=" + strconv.Itoa(spss),
+			run: func(ctx context.Context, tenant string) (backend.Result, cache.Tier, error) {
+				res, tier, err := s.query(ctx, tenant, req)
+				if err != nil {
+					return res, tier, err
+				}
+				f, err := frame.FromSpanHits(res.Payload)
+				if err != nil {
+					return backend.Result{}, "", fmt.Errorf("convert span hits: %w", err)
+				}
+				// Convert before the body is shared with coalesced requests
+				// and cached.
+				if res.Payload, err = json.Marshal(tempoapi.NewSearch(f.Spans, limit, spss)); err != nil {
+					return backend.Result{}, "", err
+				}
+				return res, tier, nil
+			},
+		}, nil
+	})
+}
+
+// parseOptionalRange parses the optional start and end parameters, in Unix
+// seconds.
+func parseOptionalRange(r *http.Request) (start, end time.Time, err error) {
+	if v := r.Form.Get("start"); v != "" {
+		if start, err = parsePromTime(v); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf(`invalid parameter "start": %w`, err)
+		}
+	}
+	if v := r.Form.Get("end"); v != "" {
+		if end, err = parsePromTime(v); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf(`invalid parameter "end": %w`, err)
+		}
+	}
+	if !start.IsZero() && !end.IsZero() && end.Before(start) {
+		return time.Time{}, time.Time{}, errors.New("end must not be before start")
+	}
+	return start, end, nil
+}
+
+// parseTempoCount parses a positive count parameter.
+func parseTempoCount(v, name string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf(`invalid parameter %q: must be a positive integer`, name)
+	}
+	return n, nil
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/audit"
+	"github.com/xscopehub/observe-gateway/internal/backend"
+	"github.com/xscopehub/observe-gateway/internal/cache"
+	"github.com/xscopehub/observe-gateway/internal/config"
+	"github.com/xscopehub/observe-gateway/internal/query"
+	"github.com/xscopehub/observe-gateway/internal/tempoapi"
+)
+
+func TestTempoAPI(t *testing.T) {
+	cacheStore, err := cache.New(cache.Config{Enabled: false})
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	var traces []query.TraceRequest
+	var searches []query.Request
+	stub := stubBackend{
+		traceByID: func(_ context.Context, tenant string, req query.TraceRequest) (backend.Result, error) {
+			if tenant != "tenant-a" {
+				t.Fatalf("tenant = %q, want tenant-a", tenant)
+			}
+			traces = append(traces, req)
+			if strings.HasSuffix(req.ID, "ff") {
+				return backend.Result{}, backend.ErrTraceNotFound
+			}
+			return backend.Result{Payload: json.RawMessage(`{"batches":[]}`)}, nil
+		},
+		queryTraceQL: func(_ context.Context, _ string, req query.Request) (backend.Result, error) {
+			searches = append(searches, req)
+			return backend.Result{Payload: json.RawMessage(`{"hits":[` +
+				`{"trace_id":"t1","span_id":"s1","operation_name":"GET /","service_name":"api","start_time":1700000000000000,"duration":2000},` +
+				`{"trace_id":"t1","span_id":"s2","reference_parent_span_id":"s1","operation_name":"query","start_time":1700000000500000,"duration":3000},` +
+				`{"trace_id":"t2","span_id":"s3","reference_parent_span_id":"s9","operation_name":"work","start_time":1700000010000000,"duration":1000}]}`)}, nil
+		},
+	}
+	handler := New(config.Config{}, nil, nil, nil, stub, cacheStore, nil, audit.New(false, nil)).Handler()
+
+	do := func(path string, form url.Values, want int) string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path+"?"+form.Encode(), nil)
+		req.Header.Set("X-Tenant", "tenant-a")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Fatalf("GET %s status = %d, want %d: %s", path, rec.Code, want, rec.Body.String())
+		}
+		return rec.Body.String()
+	}
+
+	if body := do("/tempo/api/traces/ABC123", nil, http.StatusOK); body != `{"batches":[]}` {
+		t.Fatalf("trace body = %s, want the backend trace", body)
+	}
+	if got := traces[0]; got.ID != "00000000000000000000000000abc123" || !got.Start.IsZero() {
+		t.Fatalf("trace request = %+v, want a padded lowercase id without range", got)
+	}
+	do("/tempo/api/traces/"+strings.Repeat("f", 32), nil, http.StatusNotFound)
+	do("/tempo/api/traces/not-a-trace", nil, http.StatusBadRequest)
+
+	body := do("/tempo/api/search", url.Values{"q": {`{ name = "GET /" }`}, "start": {"1700000000"}, "end": {"1700003600"}, "limit": {"5"}, "spss": {"1"}}, http.StatusOK)
+	if got := searches[0]; got.Limit != 5 || got.End.Sub(got.Start) != time.Hour {
+		t.Fatalf("search request = %+v, want a 5 span page over one hour", got)
+	}
+	var resp tempoapi.SearchResponse
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("Unmarshal() error = %v: %s", err, body)
+	}
+	if len(resp.Traces) != 2 || resp.Traces[0].TraceID != "t2" || resp.Traces[0].RootServiceName != "<root span not yet received>" {
+		t.Fatalf("traces = %+v, want t2 first without a root", resp.Traces)
+	}
+	if got := resp.Traces[1]; got.RootServiceName != "api" || got.RootTraceName != "GET /" || got.DurationMs != 503 || got.SpanSet.Matched != 2 || len(got.SpanSet.Spans) != 1 {
+		t.Fatalf("t1 = %+v", got)
+	}
+
+	do("/tempo/api/search", url.Values{"tags": {"service.name=api"}}, http.StatusBadRequest)
+	do("/tempo/api/search", url.Values{"q": {"{ name = "}}, http.StatusBadRequest)
+	if body := do("/tempo/api/echo", nil, http.StatusOK); body != "echo" {
+		t.Fatalf("echo = %q", body)
+	}
+}
@@ -0,0 +1,292 @@
+// Package shard splits long range queries into time shards that run in
+// parallel, at most a configured number per tenant at once, and merges
+// their results in order. A shard that fails leaves a gap in the result
+// reported as a warning; the query only fails when every shard does.
+package shard
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/backend"
+	"github.com/xscopehub/observe-gateway/internal/promapi"
+	"github.com/xscopehub/observe-gateway/internal/query"
+	"github.com/xscopehub/observe-gateway/internal/telemetry"
+)
+
+const (
+	defaultMaxShards         = 32
+	defaultTenantConcurrency = 4
+)
+
+// Kind selects how a query is split and its shards merged.
+type Kind int
+
+const (
+	// Matrix queries are split on step boundaries and return a Prometheus
+	// matrix; PromQL range queries and LogQL metric queries.
+	Matrix Kind = iota
+	// Logs queries are log searches returning OpenObserve search hits,
+	// newest first.
+	Logs
+)
+
+// Config controls query sharding.
+type Config struct {
+	Enabled bool
+	// Interval is the time range of one shard.
+	Interval time.Duration
+	// MaxShards widens the shards of queries that would need more.
+	MaxShards int
+	// TenantConcurrency caps the shards of one tenant running at once.
+	TenantConcurrency int
+}
+
+// Fetcher runs one shard of the original request against the backend.
+type Fetcher func(context.Context, query.Request) (backend.Result, error)
+
+// Splitter runs range queries in time shards. A nil Splitter is disabled.
+type Splitter struct {
+	cfg   Config
+	slots *Slots
+}
+
+// New returns a splitter drawing tenant concurrency from slots, or nil when
+// sharding is disabled. Slots outlive configuration reloads so that the
+// cap holds for queries started under either configuration.
+func New(cfg Config, slots *Slots) *Splitter {
+	if !cfg.Enabled || cfg.Interval <= 0 || slots == nil {
+		return nil
+	}
+	if cfg.MaxShards <= 0 {
+		cfg.MaxShards = defaultMaxShards
+	}
+	if cfg.TenantConcurrency <= 0 {
+		cfg.TenantConcurrency = defaultTenantConcurrency
+	}
+	return &Splitter{cfg: cfg, slots: slots}
+}
+
+// span is the time range of one shard; both ends are included.
+type span struct {
+	start, end time.Time
+}
+
+// part is the outcome of one shard.
+type part struct {
+	res      backend.Result
+	series   []promapi.Series
+	hits     []json.RawMessage
+	total    *int
+	warnings []string
+	err      error
+}
+
+// Do runs req in time shards through fetch and merges the results. A
+// request spanning a single shard is passed to fetch unchanged.
+func (s *Splitter) Do(ctx context.Context, tenant string, kind Kind, req query.Request, fetch Fetcher) (backend.Result, error) {
+	spans := s.split(kind, req)
+	if len(spans) < 2 {
+		return fetch(ctx, req)
+	}
+
+	parts := make([]part, len(spans))
+	var wg sync.WaitGroup
+	for i, sp := range spans {
+		sub := req
+		sub.Start, sub.End = sp.start, sp.end
+		if kind == Logs && req.Limit > 0 {
+			// Any shard may hold the whole requested page.
+			sub.Offset, sub.Limit = 0, req.Offset+req.Limit
+		}
+		wg.Add(1)
+		go func(p *part) {
+			defer wg.Done()
+			release, err := s.slots.acquire(ctx, tenant, s.cfg.TenantConcurrency)
+			if err != nil {
+				p.err = err
+				return
+			}
+			p.res, p.err = fetch(ctx, sub)
+			release()
+			if p.err == nil {
+				p.err = p.decode(kind)
+			}
+		}(&parts[i])
+	}
+	wg.Wait()
+
+	var out backend.Result
+	failed := 0
+	for i, p := range parts {
+		if p.err != nil {
+			failed++
+			out.Warnings = append(out.Warnings, fmt.Sprintf("shard %s to %s failed: %v", spans[i].start.UTC().Format(time.RFC3339), spans[i].end.UTC().Format(time.RFC3339), p.err))
+			telemetry.QueryShards.WithLabelValues("failed").Inc()
+			continue
+		}
+		telemetry.QueryShards.WithLabelValues("ok").Inc()
+		out.Backend, out.Breaker = p.res.Backend, p.res.Breaker
+		out.Cost += p.res.Cost
+	}
+	if failed == len(parts) {
+		return backend.Result{}, parts[0].err
+	}
+	out.Partial = failed > 0
+
+	var err error
+	if kind == Logs {
+		err = mergeLogs(&out, parts, req)
+	} else {
+		err = mergeMatrix(&out, parts)
+	}
+	if err != nil {
+		return backend.Result{}, err
+	}
+	return out, nil
+}
+
+// split divides the request range into shards of the configured interval,
+// widened so that there are at most MaxShards of them.
+func (s *Splitter) split(kind Kind, req query.Request) []span {
+	if !req.HasTimeRange() || req.End.Before(req.Start) {
+		return nil
+	}
+	if kind == Matrix {
+		step, err := req.StepDuration()
+		if err != nil || step <= 0 {
+			return nil
+		}
+		// Shards start on the request's own steps, so every evaluation
+		// timestamp falls into exactly one shard.
+		points := int64(req.End.Sub(req.Start)/step) + 1
+		perShard := max(int64((s.cfg.Interval+step-1)/step), 1)
+		if ceilDiv(points, perShard) > int64(s.cfg.MaxShards) {
+			perShard = ceilDiv(points, int64(s.cfg.MaxShards))
+		}
+		width := time.Duration(perShard) * step
+		var spans []span
+		for lo := req.Start; !lo.After(req.End); lo = lo.Add(width) {
+			spans = append(spans, span{start: lo, end: minTime(lo.Add(width-step), req.End)})
+		}
+		return spans
+	}
+
+	width := s.cfg.Interval
+	total := req.End.Sub(req.Start)
+	if ceilDiv(int64(total), int64(width)) > int64(s.cfg.MaxShards) {
+		width = time.Duration(ceilDiv(int64(total), int64(s.cfg.MaxShards)))
+	}
+	// OpenObserve stores microsecond timestamps; shards end a microsecond
+	// before the next one starts so that no record is returned twice.
+	width = (width + time.Microsecond - 1).Truncate(time.Microsecond)
+	var spans []span
+	for lo := req.Start; ; lo = lo.Add(width) {
+		hi := lo.Add(width)
+		if !hi.Before(req.End) {
+			return append(spans, span{start: lo, end: req.End})
+		}
+		spans = append(spans, span{start: lo, end: hi.Add(-time.Microsecond)})
+	}
+}
+
+// decode parses the shard payload for merging.
+func (p *part) decode(kind Kind) error {
+	if kind == Matrix {
+		resp, err := promapi.Parse(p.res.Payload)
+		if err != nil {
+			return err
+		}
+		if p.series, err = resp.Matrix(); err != nil {
+			return err
+		}
+		p.warnings = resp.Warnings
+		return nil
+	}
+	var page struct {
+		Hits  []json.RawMessage `json:"hits"`
+		Total *int              `json:"total"`
+	}
+	if err := json.Unmarshal(p.res.Payload, &page); err != nil {
+		return fmt.Errorf("decode search hits: %w", err)
+	}
+	p.hits, p.total = page.Hits, page.Total
+	return nil
+}
+
+// mergeMatrix joins the series of the successful shards. Warnings of the
+// shards and of failed shards are part of the matrix response.
+func mergeMatrix(out *backend.Result, parts []part) error {
+	merged := make([][]promapi.Series, 0, len(parts))
+	var warnings []string
+	for _, p := range parts {
+		if p.err == nil {
+			merged = append(merged, p.series)
+			warnings = append(warnings, p.warnings...)
+		}
+	}
+	resp, err := promapi.NewMatrix(promapi.MergeSeries(merged))
+	if err != nil {
+		return err
+	}
+	resp.Warnings = append(warnings, out.Warnings...)
+	out.Payload, err = json.Marshal(resp)
+	return err
+}
+
+// mergeLogs concatenates the hits of the successful shards, newest shard
+// first or, for a forward search, oldest shard first, and cuts the
+// requested page from them.
+func mergeLogs(out *backend.Result, parts []part, req query.Request) error {
+	var hits []json.RawMessage
+	total, counted := 0, true
+	more := false
+	for i := range parts {
+		p := parts[len(parts)-1-i]
+		if req.Forward() {
+			p = parts[i]
+		}
+		if p.err != nil {
+			continue
+		}
+		hits = append(hits, p.hits...)
+		if p.total != nil {
+			total += *p.total
+		} else {
+			counted = false
+		}
+		more = more || p.res.HasMore
+	}
+	if req.Limit > 0 {
+		end := req.Offset + req.Limit
+		more = more || len(hits) > end
+		hits = hits[min(req.Offset, len(hits)):min(end, len(hits))]
+		out.HasMore = more
+	}
+	if hits == nil {
+		hits = []json.RawMessage{}
+	}
+	out.Rows = len(hits)
+
+	page := map[string]any{"hits": hits}
+	if counted {
+		page["total"] = total
+	}
+	var err error
+	out.Payload, err = json.Marshal(page)
+	return err
+}
+
+func ceilDiv(a, b int64) int64 {
+	return (a + b - 1) / b
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,285 @@
+package shard
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/backend"
+	"github.com/xscopehub/observe-gateway/internal/promapi"
+	"github.com/xscopehub/observe-gateway/internal/query"
+)
+
+var day0 = time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+// recorder records the shard requests it receives.
+type recorder struct {
+	mu    sync.Mutex
+	calls []query.Request
+}
+
+func (r *recorder) record(req query.Request) {
+	r.mu.Lock()
+	r.calls = append(r.calls, req)
+	r.mu.Unlock()
+}
+
+// matrix answers with one series holding a sample per step whose value is
+// the timestamp.
+func (r *recorder) matrix(_ context.Context, req query.Request) (backend.Result, error) {
+	r.record(req)
+	step, _ := req.StepDuration()
+	var values []promapi.Sample
+	for t := req.Start; !t.After(req.End); t = t.Add(step) {
+		values = append(values, promapi.Sample{T: float64(t.Unix()), V: float64(t.Unix())})
+	}
+	resp, err := promapi.NewMatrix([]promapi.Series{{Metric: map[string]string{"job": "api"}, Values: values}})
+	if err != nil {
+		return backend.Result{}, err
+	}
+	payload, err := json.Marshal(resp)
+	return backend.Result{Payload: payload, Backend: "fake", Cost: 1}, err
+}
+
+// logs answers with one hit per hour of the range, newest first unless the
+// request is forward, paged by the request's offset and limit.
+func (r *recorder) logs(_ context.Context, req query.Request) (backend.Result, error) {
+	r.record(req)
+	var hits []string
+	for t := req.End.Truncate(time.Hour); !t.Before(req.Start); t = t.Add(-time.Hour) {
+		hits = append(hits, t.Format(time.RFC3339))
+	}
+	if req.Forward() {
+		slices.Reverse(hits)
+	}
+	total := len(hits)
+	more := false
+	if req.Limit > 0 {
+		more = req.Offset+req.Limit < total
+		hits = hits[min(req.Offset, total):min(req.Offset+req.Limit, total)]
+	}
+	payload, err := json.Marshal(map[string]any{"hits": hits, "total": total})
+	return backend.Result{Payload: payload, Backend: "fake", Cost: 1, Rows: len(hits), HasMore: more}, err
+}
+
+func newSplitter(cfg Config) *Splitter {
+	cfg.Enabled = true
+	if cfg.Interval == 0 {
+		cfg.Interval = 24 * time.Hour
+	}
+	return New(cfg, NewSlots())
+}
+
+func TestMatrixShards(t *testing.T) {
+	s := newSplitter(Config{})
+	var rec recorder
+	req := query.Request{Lang: "promql", Query: "up", Start: day0, End: day0.Add(72 * time.Hour), Step: "1h"}
+
+	res, err := s.Do(context.Background(), "tenant-a", Matrix, req, rec.matrix)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if len(rec.calls) != 4 {
+		t.Fatalf("shards = %d, want 4", len(rec.calls))
+	}
+	for _, call := range rec.calls {
+		if call.Start.Hour() != 0 || call.End.Sub(call.Start) > 23*time.Hour {
+			t.Fatalf("shard = %s..%s, want a day starting at midnight", call.Start, call.End)
+		}
+	}
+	if res.Cost != 4 || res.Partial {
+		t.Fatalf("cost = %d, partial = %v, want 4, false", res.Cost, res.Partial)
+	}
+
+	resp, err := promapi.Parse(res.Payload)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	series, err := resp.Matrix()
+	if err != nil {
+		t.Fatalf("Matrix() error = %v", err)
+	}
+	if len(series) != 1 || len(series[0].Values) != 73 {
+		t.Fatalf("series = %+v, want one series of 73 samples", series)
+	}
+	for i, v := range series[0].Values {
+		if want := float64(day0.Add(time.Duration(i) * time.Hour).Unix()); v.T != want {
+			t.Fatalf("sample %d at %v, want %v", i, v.T, want)
+		}
+	}
+}
+
+func TestShortQueryIsNotSharded(t *testing.T) {
+	s := newSplitter(Config{})
+	var rec recorder
+	req := query.Request{Lang: "promql", Query: "up", Start: day0, End: day0.Add(6 * time.Hour), Step: "1m"}
+
+	if _, err := s.Do(context.Background(), "tenant-a", Matrix, req, rec.matrix); err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if len(rec.calls) != 1 || !rec.calls[0].Start.Equal(req.Start) || !rec.calls[0].End.Equal(req.End) {
+		t.Fatalf("calls = %+v, want the unchanged request", rec.calls)
+	}
+}
+
+func TestMaxShardsWidensShards(t *testing.T) {
+	s := newSplitter(Config{Interval: time.Hour, MaxShards: 5})
+	var rec recorder
+	req := query.Request{Lang: "promql", Query: "up", Start: day0, End: day0.Add(30 * 24 * time.Hour), Step: "5m"}
+
+	if _, err := s.Do(context.Background(), "tenant-a", Matrix, req, rec.matrix); err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if len(rec.calls) != 5 {
+		t.Fatalf("shards = %d, want 5", len(rec.calls))
+	}
+}
+
+func TestFailedShardGivesPartialResult(t *testing.T) {
+	s := newSplitter(Config{})
+	var rec recorder
+	fetch := func(ctx context.Context, req query.Request) (backend.Result, error) {
+		if req.Start.Equal(day0.Add(24 * time.Hour)) {
+			return backend.Result{}, errors.New("openobserve timeout")
+		}
+		return rec.matrix(ctx, req)
+	}
+	req := query.Request{Lang: "promql", Query: "up", Start: day0, End: day0.Add(71 * time.Hour), Step: "1h"}
+
+	res, err := s.Do(context.Background(), "tenant-a", Matrix, req, fetch)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if !res.Partial || len(res.Warnings) != 1 || !strings.Contains(res.Warnings[0], "2024-05-02T00:00:00Z to 2024-05-02T23:00:00Z failed: openobserve timeout") {
+		t.Fatalf("partial = %v, warnings = %q", res.Partial, res.Warnings)
+	}
+	resp, err := promapi.Parse(res.Payload)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	series, _ := resp.Matrix()
+	if len(series) != 1 || len(series[0].Values) != 48 {
+		t.Fatalf("series = %+v, want 48 samples from two shards", series)
+	}
+	if len(resp.Warnings) != 1 {
+		t.Fatalf("matrix warnings = %q, want the failed shard", resp.Warnings)
+	}
+
+	failing := func(context.Context, query.Request) (backend.Result, error) {
+		return backend.Result{}, errors.New("openobserve down")
+	}
+	if _, err := s.Do(context.Background(), "tenant-a", Matrix, req, failing); err == nil || err.Error() != "openobserve down" {
+		t.Fatalf("Do() error = %v, want openobserve down", err)
+	}
+}
+
+func TestLogShardsPage(t *testing.T) {
+	s := newSplitter(Config{})
+	var rec recorder
+	req := query.Request{Lang: "logql", Query: `{app="api"}`, Start: day0, End: day0.Add(72*time.Hour - time.Second), Limit: 10, Offset: 20}
+
+	res, err := s.Do(context.Background(), "tenant-a", Logs, req, rec.logs)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if len(rec.calls) != 3 {
+		t.Fatalf("shards = %d, want 3", len(rec.calls))
+	}
+	for _, call := range rec.calls {
+		if call.Offset != 0 || call.Limit != 30 {
+			t.Fatalf("shard page = offset %d limit %d, want 0, 30", call.Offset, call.Limit)
+		}
+		if call.End.Sub(call.Start) != 24*time.Hour-time.Microsecond && !call.End.Equal(req.End) {
+			t.Fatalf("shard = %s..%s, want a day", call.Start, call.End)
+		}
+	}
+
+	var page struct {
+		Hits  []string `json:"hits"`
+		Total int      `json:"total"`
+	}
+	if err := json.Unmarshal(res.Payload, &page); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	// Hits 20-29 of the 72 hourly hits, newest first: the last day's hits
+	// 20-23 followed by the second day's first six.
+	want := day0.Add(71*time.Hour - 20*time.Hour)
+	if len(page.Hits) != 10 || page.Hits[0] != want.Format(time.RFC3339) || page.Hits[9] != want.Add(-9*time.Hour).Format(time.RFC3339) {
+		t.Fatalf("hits = %v, want 10 hourly hits from %s", page.Hits, want)
+	}
+	if page.Total != 72 || res.Rows != 10 || !res.HasMore {
+		t.Fatalf("total = %d, rows = %d, has more = %v, want 72, 10, true", page.Total, res.Rows, res.HasMore)
+	}
+}
+
+func TestForwardLogShardsPage(t *testing.T) {
+	s := newSplitter(Config{})
+	var rec recorder
+	req := query.Request{Lang: "logql", Query: `{app="api"}`, Start: day0, End: day0.Add(72*time.Hour - time.Second), Limit: 10, Offset: 20, Direction: query.DirectionForward}
+
+	res, err := s.Do(context.Background(), "tenant-a", Logs, req, rec.logs)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	var page struct {
+		Hits []string `json:"hits"`
+	}
+	if err := json.Unmarshal(res.Payload, &page); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	// Hits 20-29 of the 72 hourly hits, oldest first: the first day's hits
+	// 20-23 followed by the second day's first six.
+	want := day0.Add(20 * time.Hour)
+	if len(page.Hits) != 10 || page.Hits[0] != want.Format(time.RFC3339) || page.Hits[9] != want.Add(9*time.Hour).Format(time.RFC3339) {
+		t.Fatalf("hits = %v, want 10 hourly hits from %s", page.Hits, want)
+	}
+}
+
+func TestTenantConcurrency(t *testing.T) {
+	s := newSplitter(Config{Interval: time.Hour, TenantConcurrency: 2})
+	var running, peak atomic.Int32
+	fetch := func(ctx context.Context, req query.Request) (backend.Result, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		return (&recorder{}).matrix(ctx, req)
+	}
+	req := query.Request{Lang: "promql", Query: "up", Start: day0, End: day0.Add(12 * time.Hour), Step: "1m"}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sub := req
+			sub.Query = fmt.Sprintf("up%d", i)
+			_, errs[i] = s.Do(context.Background(), "tenant-a", Matrix, sub, fetch)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("Do() error = %v", err)
+		}
+	}
+	if p := peak.Load(); p != 2 {
+		t.Fatalf("peak concurrent shards = %d, want 2", p)
+	}
+	if n := len(s.slots.tenants); n != 0 {
+		t.Fatalf("tenant semaphores = %d after all shards finished, want 0", n)
+	}
+}
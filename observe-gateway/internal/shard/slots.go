@@ -0,0 +1,56 @@
+package shard
+
+import (
+	"context"
+	"sync"
+)
+
+// Slots caps the shards each tenant runs at once. A tenant's semaphore is
+// dropped when its last shard finishes, so a changed cap applies once the
+// tenant's running shards are done.
+type Slots struct {
+	mu      sync.Mutex
+	tenants map[string]*semaphore
+}
+
+type semaphore struct {
+	ch   chan struct{}
+	refs int
+}
+
+// NewSlots returns an empty set of tenant slots.
+func NewSlots() *Slots {
+	return &Slots{tenants: map[string]*semaphore{}}
+}
+
+// acquire waits for one of the tenant's limit slots. The returned function
+// releases it.
+func (s *Slots) acquire(ctx context.Context, tenant string, limit int) (func(), error) {
+	s.mu.Lock()
+	sem, ok := s.tenants[tenant]
+	if !ok {
+		sem = &semaphore{ch: make(chan struct{}, limit)}
+		s.tenants[tenant] = sem
+	}
+	sem.refs++
+	s.mu.Unlock()
+
+	select {
+	case sem.ch <- struct{}{}:
+		return func() {
+			<-sem.ch
+			s.unref(tenant, sem)
+		}, nil
+	case <-ctx.Done():
+		s.unref(tenant, sem)
+		return nil, ctx.Err()
+	}
+}
+
+func (s *Slots) unref(tenant string, sem *semaphore) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sem.refs--; sem.refs == 0 {
+		delete(s.tenants, tenant)
+	}
+}
@@ -0,0 +1,148 @@
+package sqlbuilder
+
+import "strings"
+
+// Query is a complete statement that can also be nested as a subquery.
+type Query interface {
+	Expr
+	SQL() string
+}
+
+// Source is something a SELECT reads from.
+type Source interface {
+	renderSource(b *strings.Builder)
+}
+
+type tableSource struct {
+	table Identifier
+	alias Identifier
+}
+
+func (t tableSource) renderSource(b *strings.Builder) {
+	t.table.render(b)
+	if !t.alias.IsZero() {
+		b.WriteString(" AS ")
+		t.alias.render(b)
+	}
+}
+
+// Table reads from a table.
+func Table(table Identifier) Source { return tableSource{table: table} }
+
+// TableAs reads from a table under an alias.
+func TableAs(table, alias Identifier) Source { return tableSource{table: table, alias: alias} }
+
+type subquerySource struct {
+	query Query
+	alias Identifier
+}
+
+func (s subquerySource) renderSource(b *strings.Builder) {
+	b.WriteByte('(')
+	s.query.render(b)
+	b.WriteString(") AS ")
+	s.alias.render(b)
+}
+
+// Subquery reads from a nested query under an alias.
+func Subquery(q Query, alias Identifier) Source { return subquerySource{query: q, alias: alias} }
+
+// Star selects every column, optionally of one aliased source.
+func Star(qualifier string) Expr {
+	if qualifier == "" {
+		return literal("*")
+	}
+	return literal(MustIdent(qualifier).name + ".*")
+}
+
+type aliased struct {
+	expr  Expr
+	alias Identifier
+}
+
+func (a aliased) render(b *strings.Builder) {
+	a.expr.render(b)
+	b.WriteString(" AS ")
+	a.alias.render(b)
+}
+
+// As names a select list expression.
+func As(e Expr, alias Identifier) Expr { return aliased{expr: e, alias: alias} }
+
+// Join is an inner join of a SELECT.
+type Join struct {
+	Source Source
+	On     Expr
+}
+
+// Select is a SELECT statement. An empty column list selects *.
+type Select struct {
+	Distinct bool
+	Columns  []Expr
+	From     Source
+	Join     *Join
+	Where    Expr
+	GroupBy  []Expr
+	OrderBy  []Expr
+}
+
+// SQL renders the statement.
+func (s *Select) SQL() string { return Render(s) }
+
+func (s *Select) render(b *strings.Builder) {
+	b.WriteString("SELECT ")
+	if s.Distinct {
+		b.WriteString("DISTINCT ")
+	}
+	if len(s.Columns) == 0 {
+		b.WriteByte('*')
+	}
+	writeList(b, s.Columns)
+	b.WriteString(" FROM ")
+	s.From.renderSource(b)
+	if s.Join != nil {
+		b.WriteString(" JOIN ")
+		s.Join.Source.renderSource(b)
+		b.WriteString(" ON ")
+		s.Join.On.render(b)
+	}
+	b.WriteString(" WHERE ")
+	if s.Where == nil {
+		True.render(b)
+	} else {
+		s.Where.render(b)
+	}
+	if len(s.GroupBy) > 0 {
+		b.WriteString(" GROUP BY ")
+		writeList(b, s.GroupBy)
+	}
+	if len(s.OrderBy) > 0 {
+		b.WriteString(" ORDER BY ")
+		writeList(b, s.OrderBy)
+	}
+}
+
+func writeList(b *strings.Builder, exprs []Expr) {
+	for i, e := range exprs {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		e.render(b)
+	}
+}
+
+type union []Query
+
+func (u union) SQL() string { return Render(u) }
+
+func (u union) render(b *strings.Builder) {
+	for i, q := range u {
+		if i > 0 {
+			b.WriteString(" UNION ")
+		}
+		q.render(b)
+	}
+}
+
+// Union combines queries with UNION.
+func Union(queries ...Query) Query { return union(queries) }
@@ -0,0 +1,374 @@
+// Package sqlbuilder builds OpenObserve SQL from typed fragments so that
+// translated queries cannot be altered by tenant supplied values.
+//
+// Identifiers are whitelisted, numbers and durations are rendered from typed
+// Go values and every other value is emitted as a quoted string literal.
+// There is deliberately no way to splice raw text into a statement.
+package sqlbuilder
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidIdentifier is returned for identifiers outside the whitelist.
+var ErrInvalidIdentifier = errors.New("sqlbuilder: invalid identifier")
+
+// identRe admits plain SQL identifiers plus hyphens, which OpenObserve
+// allows in stream names; such identifiers are rendered double quoted.
+var identRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]{0,127}$`)
+
+// Expr is a renderable SQL expression.
+type Expr interface {
+	render(b *strings.Builder)
+}
+
+// Render returns the SQL text of an expression.
+func Render(e Expr) string {
+	var b strings.Builder
+	e.render(&b)
+	return b.String()
+}
+
+// Identifier is a validated, optionally qualified, column or table name.
+type Identifier struct {
+	qualifier string
+	name      string
+}
+
+// Ident validates a bare identifier.
+func Ident(name string) (Identifier, error) {
+	if !identRe.MatchString(name) {
+		return Identifier{}, fmt.Errorf("%w: %q", ErrInvalidIdentifier, name)
+	}
+	return Identifier{name: name}, nil
+}
+
+// MustIdent is Ident for identifiers known at compile time.
+func MustIdent(name string) Identifier {
+	id, err := Ident(name)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// Col returns a column optionally qualified by a table alias. An empty
+// qualifier yields a bare column.
+func Col(qualifier, name string) Identifier {
+	id := MustIdent(name)
+	if qualifier != "" {
+		id.qualifier = MustIdent(qualifier).name
+	}
+	return id
+}
+
+// Qualify returns the identifier qualified by a table alias.
+func (i Identifier) Qualify(alias Identifier) Identifier {
+	return Identifier{qualifier: alias.name, name: i.name}
+}
+
+// Name returns the unqualified identifier.
+func (i Identifier) Name() string { return i.name }
+
+// IsZero reports whether the identifier is unset.
+func (i Identifier) IsZero() bool { return i.name == "" }
+
+func (i Identifier) render(b *strings.Builder) {
+	if i.qualifier != "" {
+		writeIdent(b, i.qualifier)
+		b.WriteByte('.')
+	}
+	writeIdent(b, i.name)
+}
+
+func writeIdent(b *strings.Builder, name string) {
+	if strings.Contains(name, "-") {
+		b.WriteString(`"` + name + `"`)
+		return
+	}
+	b.WriteString(name)
+}
+
+type literal string
+
+func (l literal) render(b *strings.Builder) { b.WriteString(string(l)) }
+
+// String returns a quoted string literal.
+func String(s string) Expr {
+	return literal("'" + strings.ReplaceAll(s, "'", "''") + "'")
+}
+
+// Int returns an integer literal.
+func Int(n int64) Expr {
+	return literal(strconv.FormatInt(n, 10))
+}
+
+// Float returns a numeric literal. Non-finite values are rejected.
+func Float(f float64) (Expr, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("sqlbuilder: non-finite number %v", f)
+	}
+	return literal(strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
+
+// Micros returns a duration as an integer number of microseconds.
+func Micros(d time.Duration) Expr {
+	return Int(int64(d / time.Microsecond))
+}
+
+// Interval returns an OpenObserve interval string such as '60 seconds'.
+func Interval(d time.Duration) Expr {
+	return String(fmt.Sprintf("%d seconds", int64(d/time.Second)))
+}
+
+// True is an always true predicate.
+var True Expr = literal("1=1")
+
+// False is an always false predicate.
+var False Expr = literal("1=0")
+
+// Op is a comparison operator.
+type Op int
+
+const (
+	Eq Op = iota
+	Ne
+	Gt
+	Ge
+	Lt
+	Le
+	Match
+	NotMatch
+)
+
+var opText = map[Op]string{Eq: "=", Ne: "<>", Gt: ">", Ge: ">=", Lt: "<", Le: "<=", Match: "~", NotMatch: "!~"}
+
+type comparison struct {
+	left  Expr
+	op    Op
+	right Expr
+}
+
+func (c comparison) render(b *strings.Builder) {
+	c.left.render(b)
+	b.WriteByte(' ')
+	b.WriteString(opText[c.op])
+	b.WriteByte(' ')
+	c.right.render(b)
+}
+
+// Compare builds "left op right".
+func Compare(left Expr, op Op, right Expr) Expr {
+	if _, ok := opText[op]; !ok {
+		panic(fmt.Sprintf("sqlbuilder: unknown operator %d", op))
+	}
+	return comparison{left: left, op: op, right: right}
+}
+
+type logical struct {
+	op    string
+	terms []Expr
+}
+
+func (l logical) render(b *strings.Builder) {
+	b.WriteByte('(')
+	for i, t := range l.terms {
+		if i > 0 {
+			b.WriteString(" " + l.op + " ")
+		}
+		t.render(b)
+	}
+	b.WriteByte(')')
+}
+
+// And joins predicates with AND inside parentheses.
+func And(terms ...Expr) Expr {
+	if len(terms) == 1 {
+		return terms[0]
+	}
+	return logical{op: "AND", terms: terms}
+}
+
+// Or joins predicates with OR inside parentheses.
+func Or(terms ...Expr) Expr {
+	if len(terms) == 1 {
+		return terms[0]
+	}
+	return logical{op: "OR", terms: terms}
+}
+
+// Conjunction renders predicates joined by AND without surrounding
+// parentheses, as used for a top level WHERE clause. It is True when empty.
+func Conjunction(terms ...Expr) Expr {
+	if len(terms) == 0 {
+		return True
+	}
+	return conjunction(terms)
+}
+
+type conjunction []Expr
+
+func (c conjunction) render(b *strings.Builder) {
+	for i, t := range c {
+		if i > 0 {
+			b.WriteString(" AND ")
+		}
+		t.render(b)
+	}
+}
+
+type wrapped struct {
+	prefix string
+	inner  Expr
+	suffix string
+}
+
+func (w wrapped) render(b *strings.Builder) {
+	b.WriteString(w.prefix)
+	w.inner.render(b)
+	b.WriteString(w.suffix)
+}
+
+// Not negates a predicate.
+func Not(e Expr) Expr { return wrapped{prefix: "NOT (", inner: e, suffix: ")"} }
+
+// Paren wraps an expression in parentheses.
+func Paren(e Expr) Expr { return wrapped{prefix: "(", inner: e, suffix: ")"} }
+
+// IsNull builds "e IS NULL".
+func IsNull(e Expr) Expr { return wrapped{inner: e, suffix: " IS NULL"} }
+
+// IsNotNull builds "e IS NOT NULL".
+func IsNotNull(e Expr) Expr { return wrapped{inner: e, suffix: " IS NOT NULL"} }
+
+// Asc orders by e ascending.
+func Asc(e Expr) Expr { return wrapped{inner: e, suffix: " ASC"} }
+
+// Desc orders by e descending.
+func Desc(e Expr) Expr { return wrapped{inner: e, suffix: " DESC"} }
+
+// Type is a SQL type usable in casts.
+type Type int
+
+const (
+	Double Type = iota
+	JSONB
+)
+
+var typeText = map[Type]string{Double: "DOUBLE PRECISION", JSONB: "jsonb"}
+
+// Cast builds CAST(e AS type).
+func Cast(e Expr, t Type) Expr {
+	name, ok := typeText[t]
+	if !ok {
+		panic(fmt.Sprintf("sqlbuilder: unknown type %d", t))
+	}
+	return wrapped{prefix: "CAST(", inner: e, suffix: " AS " + name + ")"}
+}
+
+type jsonText struct {
+	doc  Expr
+	op   string
+	path Expr
+}
+
+func (j jsonText) render(b *strings.Builder) {
+	j.doc.render(b)
+	b.WriteString(j.op)
+	j.path.render(b)
+}
+
+// JSONText extracts a top level key as text: doc->>'key'.
+func JSONText(doc Expr, key string) Expr {
+	return jsonText{doc: doc, op: "->>", path: String(key)}
+}
+
+// JSONPathText extracts a nested path as text: doc#>>'{a,b}'.
+func JSONPathText(doc Expr, path []string) Expr {
+	elems := make([]string, len(path))
+	for i, p := range path {
+		elems[i] = p
+		if p == "" || strings.ContainsAny(p, `{},"\ `) {
+			elems[i] = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(p) + `"`
+		}
+	}
+	return jsonText{doc: doc, op: "#>>", path: String("{" + strings.Join(elems, ",") + "}")}
+}
+
+// Function is a whitelisted SQL function.
+type Function int
+
+const (
+	FuncCount Function = iota
+	FuncSum
+	FuncMin
+	FuncMax
+	FuncLength
+	FuncStrpos
+	FuncCoalesce
+	FuncHistogram
+	FuncJSONBObjectKeys
+)
+
+var funcText = map[Function]string{
+	FuncCount: "count", FuncSum: "sum", FuncMin: "min", FuncMax: "max",
+	FuncLength: "length", FuncStrpos: "strpos", FuncCoalesce: "COALESCE",
+	FuncHistogram: "histogram", FuncJSONBObjectKeys: "jsonb_object_keys",
+}
+
+type call struct {
+	fn   Function
+	args []Expr
+}
+
+func (c call) render(b *strings.Builder) {
+	b.WriteString(funcText[c.fn])
+	b.WriteByte('(')
+	for i, a := range c.args {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		a.render(b)
+	}
+	b.WriteByte(')')
+}
+
+// Call invokes a whitelisted function.
+func Call(fn Function, args ...Expr) Expr {
+	if _, ok := funcText[fn]; !ok {
+		panic(fmt.Sprintf("sqlbuilder: unknown function %d", fn))
+	}
+	return call{fn: fn, args: args}
+}
+
+// CountAll is count(*).
+var CountAll Expr = literal("count(*)")
+
+// Substring extracts the first capture group of a regular expression:
+// substring(e from 'pattern').
+func Substring(e Expr, pattern string) Expr {
+	return wrapped{prefix: "substring(", inner: e, suffix: " from " + Render(String(pattern)) + ")"}
+}
+
+type inQuery struct {
+	left  Expr
+	query Query
+}
+
+func (i inQuery) render(b *strings.Builder) {
+	i.left.render(b)
+	b.WriteString(" IN (")
+	i.query.render(b)
+	b.WriteByte(')')
+}
+
+// In builds "e IN (subquery)".
+func In(e Expr, q Query) Expr {
+	return inQuery{left: e, query: q}
+}
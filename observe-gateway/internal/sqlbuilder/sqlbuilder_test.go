@@ -0,0 +1,118 @@
+package sqlbuilder
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scanLiteral returns the decoded contents of the single quoted literal at
+// the start of sql and the number of bytes it spans.
+func scanLiteral(sql string) (string, int, bool) {
+	if !strings.HasPrefix(sql, "'") {
+		return "", 0, false
+	}
+	var b strings.Builder
+	for i := 1; i < len(sql); i++ {
+		if sql[i] != '\'' {
+			b.WriteByte(sql[i])
+			continue
+		}
+		if i+1 < len(sql) && sql[i+1] == '\'' {
+			b.WriteByte('\'')
+			i++
+			continue
+		}
+		return b.String(), i + 1, true
+	}
+	return "", 0, false
+}
+
+func TestSelect(t *testing.T) {
+	n, err := Float(500)
+	if err != nil {
+		t.Fatalf("Float() error = %v", err)
+	}
+	stmt := &Select{
+		Columns: []Expr{Star(""), As(JSONText(MustIdent("labels"), "service"), MustIdent("svc"))},
+		From:    Table(MustIdent("k8s-logs")),
+		Where: Conjunction(
+			Compare(JSONText(MustIdent("labels"), "env"), Eq, String("it's")),
+			Or(Compare(Cast(MustIdent("status"), Double), Ge, n), IsNull(MustIdent("status"))),
+			Compare(MustIdent("duration"), Gt, Micros(2*time.Second)),
+		),
+	}
+	want := `SELECT *, labels->>'service' AS svc FROM "k8s-logs" WHERE labels->>'env' = 'it''s' AND (CAST(status AS DOUBLE PRECISION) >= 500 OR status IS NULL) AND duration > 2000000`
+	if got := stmt.SQL(); got != want {
+		t.Fatalf("SQL()\n got = %s\nwant = %s", got, want)
+	}
+}
+
+func TestIdentRejectsUnsafeNames(t *testing.T) {
+	for _, name := range []string{"", "1logs", "logs;drop", `lo"gs`, "a b", "a.b", "logs'"} {
+		if _, err := Ident(name); !errors.Is(err, ErrInvalidIdentifier) {
+			t.Errorf("Ident(%q) error = %v, want ErrInvalidIdentifier", name, err)
+		}
+	}
+}
+
+func TestFloatRejectsNonFinite(t *testing.T) {
+	for _, f := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if _, err := Float(f); err == nil {
+			t.Errorf("Float(%v) error = nil, want error", f)
+		}
+	}
+}
+
+func TestJSONPathTextQuotesElements(t *testing.T) {
+	got := Render(JSONPathText(MustIdent("doc"), []string{"a", "b,c", `d"}`}))
+	want := `doc#>>'{a,"b,c","d\"}"}'`
+	if got != want {
+		t.Fatalf("JSONPathText() = %s, want %s", got, want)
+	}
+}
+
+// FuzzString checks that any value rendered as a string literal stays a
+// single literal at the end of the WHERE clause and round-trips.
+func FuzzString(f *testing.F) {
+	for _, seed := range []string{"x", "'", "''", `\'`, "'; DROP TABLE logs; --", "a' OR '1'='1", "/*", "\x00"} {
+		f.Add(seed)
+	}
+	prefix := "SELECT * FROM logs WHERE message = "
+	f.Fuzz(func(t *testing.T, value string) {
+		sql := (&Select{From: Table(MustIdent("logs")), Where: Compare(MustIdent("message"), Eq, String(value))}).SQL()
+		if !strings.HasPrefix(sql, prefix) {
+			t.Fatalf("SQL() = %q, want prefix %q", sql, prefix)
+		}
+		got, n, ok := scanLiteral(sql[len(prefix):])
+		if !ok || len(prefix)+n != len(sql) {
+			t.Fatalf("value %q escaped its literal: %s", value, sql)
+		}
+		if got != value {
+			t.Fatalf("literal = %q, want %q", got, value)
+		}
+	})
+}
+
+// FuzzIdent checks that accepted identifiers render as a single bare or
+// double quoted word.
+func FuzzIdent(f *testing.F) {
+	for _, seed := range []string{"logs", "k8s-logs", `logs"`, "logs; --", "a.b", "_"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, name string) {
+		id, err := Ident(name)
+		if err != nil {
+			return
+		}
+		got := Render(id)
+		if got != name && got != `"`+name+`"` {
+			t.Fatalf("Render(Ident(%q)) = %q", name, got)
+		}
+		if strings.ContainsAny(name, "\"'; \t\n()*/\\.") {
+			t.Fatalf("Ident(%q) accepted unsafe characters", name)
+		}
+	})
+}
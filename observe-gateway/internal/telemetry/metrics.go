@@ -0,0 +1,124 @@
+// Package telemetry holds the gateway's own Prometheus metrics and sets up
+// OpenTelemetry tracing of requests through the gateway and its backends.
+package telemetry
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "observe_gateway"
+
+// Registry holds the gateway metrics together with the Go runtime and
+// process collectors.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	// Requests counts HTTP requests by route, query language, template,
+	// tenant and status. Labels unknown when a request ends, such as the
+	// tenant of an unauthenticated request, are empty.
+	Requests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "HTTP requests served, by route, query language, template, tenant and status.",
+	}, []string{"route", "lang", "template", "tenant", "status"})
+
+	// RequestDuration is the latency of HTTP requests with the labels of
+	// Requests.
+	RequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency, by route, query language, template, tenant and status.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"route", "lang", "template", "tenant", "status"})
+
+	// ActiveRequests is the number of query requests in progress.
+	ActiveRequests = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_requests",
+		Help:      "Query requests in progress.",
+	})
+
+	// CoalescedRequests counts requests answered by another request's
+	// backend call.
+	CoalescedRequests = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "coalesced_requests_total",
+		Help:      "Requests that shared the backend call of an identical concurrent request.",
+	})
+
+	// CacheLookups counts cache lookups by result: the tier that served a
+	// hit, or miss.
+	CacheLookups = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_lookups_total",
+		Help:      "Response cache lookups, by result (l1, l2 or miss).",
+	}, []string{"result"})
+
+	// LimiterRejections counts requests refused by a rate limit rule or by
+	// the cost budget, whose rule is budget.
+	LimiterRejections = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "limiter_rejections_total",
+		Help:      "Requests rejected by the rate limiter or the cost budget, by tenant and rule.",
+	}, []string{"tenant", "rule"})
+
+	// BackendDuration is the latency of backend HTTP calls until the
+	// response headers arrive.
+	BackendDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "backend_request_duration_seconds",
+		Help:      "Backend HTTP call latency, by backend.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"backend"})
+
+	// BackendErrors counts backend calls that failed or returned a 5xx
+	// status.
+	BackendErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backend_errors_total",
+		Help:      "Backend HTTP calls that failed or returned a 5xx status, by backend.",
+	}, []string{"backend"})
+
+	// BreakerState is the circuit breaker state of each backend: 0 closed,
+	// 1 open, 2 half-open.
+	BreakerState = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "breaker_state",
+		Help:      "Circuit breaker state by backend: 0 closed, 1 open, 2 half-open.",
+	}, []string{"backend"})
+
+	// MetadataLookupDuration is the latency of metadata database lookups.
+	MetadataLookupDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "metadata_lookup_duration_seconds",
+		Help:      "Metadata database lookup latency, by lookup (tenant, budget or rate_limit).",
+		Buckets:   prometheus.ExponentialBuckets(.0005, 2, 14),
+	}, []string{"lookup"})
+
+	// QueryShards counts the time shards of sharded range queries by
+	// result: ok or failed.
+	QueryShards = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "query_shards_total",
+		Help:      "Time shards of sharded range queries, by result (ok or failed).",
+	}, []string{"result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
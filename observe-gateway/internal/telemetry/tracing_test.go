@@ -0,0 +1,69 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTransportPropagatesTraceContext(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	}()
+
+	var traceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		if r.URL.Path == "/fail" {
+			http.Error(w, "down", http.StatusBadGateway)
+		}
+	}))
+	defer upstream.Close()
+	client := &http.Client{Transport: Transport("test-upstream", nil)}
+	errorsBefore := testutil.ToFloat64(BackendErrors.WithLabelValues("test-upstream"))
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, upstream.URL+"/ok", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	resp.Body.Close()
+	parent.End()
+
+	if req.Header.Get("traceparent") != "" {
+		t.Fatal("Transport modified the caller's request headers")
+	}
+	spans := recorder.Ended()
+	if len(spans) != 2 || spans[0].Name() != "backend test-upstream" {
+		t.Fatalf("spans = %d, want the backend span and its parent", len(spans))
+	}
+	backendSpan := spans[0].SpanContext()
+	if want := "00-" + backendSpan.TraceID().String() + "-" + backendSpan.SpanID().String() + "-01"; traceparent != want {
+		t.Fatalf("traceparent = %q, want %q", traceparent, want)
+	}
+	if spans[0].Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Fatal("backend span is not a child of the request span")
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, upstream.URL+"/fail", nil)
+	if resp, err := client.Do(req); err == nil {
+		resp.Body.Close()
+	}
+	if got := testutil.ToFloat64(BackendErrors.WithLabelValues("test-upstream")) - errorsBefore; got != 1 {
+		t.Fatalf("backend errors = %v, want the 502 counted once", got)
+	}
+}
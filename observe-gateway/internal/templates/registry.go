@@ -0,0 +1,119 @@
+package templates
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Registry manages tenant templates on top of a Store, caching lookups made
+// on the query path.
+type Registry struct {
+	store    Store
+	cacheTTL time.Duration
+	now      func() time.Time
+
+	mu    sync.Mutex
+	cache map[[2]string]cachedTemplate
+}
+
+type cachedTemplate struct {
+	template  Template
+	found     bool
+	fetchedAt time.Time
+}
+
+// NewRegistry returns a registry. Lookups, including misses, are cached for
+// cacheTTL, which bounds how long a change takes to reach other replicas.
+// A nil store disables tenant templates.
+func NewRegistry(store Store, cacheTTL time.Duration) *Registry {
+	return &Registry{store: store, cacheTTL: cacheTTL, now: time.Now, cache: make(map[[2]string]cachedTemplate)}
+}
+
+// Enabled returns whether tenant templates are stored.
+func (r *Registry) Enabled() bool {
+	return r != nil && r.store != nil
+}
+
+// Lookup returns the latest version of a tenant template. found is false
+// when the tenant has no template of that name.
+func (r *Registry) Lookup(ctx context.Context, tenant, name string) (Template, bool, error) {
+	if !r.Enabled() {
+		return Template{}, false, nil
+	}
+	key := [2]string{tenant, name}
+	now := r.now()
+	r.mu.Lock()
+	cached, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok && now.Sub(cached.fetchedAt) < r.cacheTTL {
+		return cached.template, cached.found, nil
+	}
+
+	t, err := r.store.Latest(ctx, tenant, name)
+	found := err == nil
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return Template{}, false, err
+	}
+	r.mu.Lock()
+	r.cache[key] = cachedTemplate{template: t, found: found, fetchedAt: now}
+	r.mu.Unlock()
+	return t, found, nil
+}
+
+// Save validates t and stores it as the next version of the template.
+func (r *Registry) Save(ctx context.Context, t Template) (Template, error) {
+	if err := t.Validate(); err != nil {
+		return Template{}, err
+	}
+	t.Version = 0
+	t.CreatedAt = r.now().UTC()
+	saved, err := r.store.Save(ctx, t)
+	if err != nil {
+		return Template{}, err
+	}
+	r.forget(t.Tenant, t.Name)
+	return saved, nil
+}
+
+// Rollback stores a copy of an earlier version as the newest version, so
+// the history stays intact.
+func (r *Registry) Rollback(ctx context.Context, tenant, name string, version int, by string) (Template, error) {
+	old, err := r.store.Version(ctx, tenant, name, version)
+	if err != nil {
+		return Template{}, err
+	}
+	old.CreatedBy = by
+	return r.Save(ctx, old)
+}
+
+// Delete removes all versions of a template.
+func (r *Registry) Delete(ctx context.Context, tenant, name string) error {
+	if err := r.store.Delete(ctx, tenant, name); err != nil {
+		return err
+	}
+	r.forget(tenant, name)
+	return nil
+}
+
+// List returns the newest version of each template of a tenant.
+func (r *Registry) List(ctx context.Context, tenant string) ([]Template, error) {
+	return r.store.List(ctx, tenant)
+}
+
+// Version returns a specific version of a template.
+func (r *Registry) Version(ctx context.Context, tenant, name string, version int) (Template, error) {
+	return r.store.Version(ctx, tenant, name, version)
+}
+
+// Versions returns the history of a template, oldest first.
+func (r *Registry) Versions(ctx context.Context, tenant, name string) ([]Template, error) {
+	return r.store.Versions(ctx, tenant, name)
+}
+
+func (r *Registry) forget(tenant, name string) {
+	r.mu.Lock()
+	delete(r.cache, [2]string{tenant, name})
+	r.mu.Unlock()
+}
@@ -0,0 +1,244 @@
+package templates
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Store persists versioned tenant templates. Every save adds a version;
+// versions are never modified.
+type Store interface {
+	// Latest returns the newest version of a template.
+	Latest(ctx context.Context, tenant, name string) (Template, error)
+	Version(ctx context.Context, tenant, name string, version int) (Template, error)
+	// Versions returns all versions of a template, oldest first.
+	Versions(ctx context.Context, tenant, name string) ([]Template, error)
+	// List returns the newest version of each template of a tenant.
+	List(ctx context.Context, tenant string) ([]Template, error)
+	// Save stores t as the next version and returns it with its version set.
+	Save(ctx context.Context, t Template) (Template, error)
+	// Delete removes all versions of a template.
+	Delete(ctx context.Context, tenant, name string) error
+}
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// PGStore stores templates in a Postgres table.
+type PGStore struct {
+	pool  *pgxpool.Pool
+	table string
+}
+
+// NewPGStore returns a template store using table, creating it if missing.
+func NewPGStore(ctx context.Context, pool *pgxpool.Pool, table string) (*PGStore, error) {
+	if table == "" {
+		table = "query_templates"
+	}
+	if !tableName.MatchString(table) {
+		return nil, fmt.Errorf("invalid template table %q", table)
+	}
+	s := &PGStore{pool: pool, table: table}
+	_, err := pool.Exec(ctx, `CREATE TABLE IF NOT EXISTS `+table+` (
+	tenant      text NOT NULL,
+	name        text NOT NULL,
+	version     integer NOT NULL,
+	lang        text NOT NULL,
+	query       text NOT NULL,
+	step        text NOT NULL DEFAULT '',
+	description text NOT NULL DEFAULT '',
+	variables   jsonb NOT NULL DEFAULT '[]',
+	created_by  text NOT NULL DEFAULT '',
+	created_at  timestamptz NOT NULL,
+	PRIMARY KEY (tenant, name, version)
+)`)
+	if err != nil {
+		return nil, fmt.Errorf("create template table: %w", err)
+	}
+	return s, nil
+}
+
+const templateColumns = "tenant, name, version, lang, query, step, description, variables, created_by, created_at"
+
+// Latest returns the newest version of a template.
+func (s *PGStore) Latest(ctx context.Context, tenant, name string) (Template, error) {
+	return s.one(ctx, `SELECT `+templateColumns+` FROM `+s.table+` WHERE tenant = $1 AND name = $2 ORDER BY version DESC LIMIT 1`, tenant, name)
+}
+
+// Version returns a specific version of a template.
+func (s *PGStore) Version(ctx context.Context, tenant, name string, version int) (Template, error) {
+	return s.one(ctx, `SELECT `+templateColumns+` FROM `+s.table+` WHERE tenant = $1 AND name = $2 AND version = $3`, tenant, name, version)
+}
+
+// Versions returns all versions of a template, oldest first.
+func (s *PGStore) Versions(ctx context.Context, tenant, name string) ([]Template, error) {
+	out, err := s.many(ctx, `SELECT `+templateColumns+` FROM `+s.table+` WHERE tenant = $1 AND name = $2 ORDER BY version`, tenant, name)
+	if err == nil && len(out) == 0 {
+		return nil, ErrNotFound
+	}
+	return out, err
+}
+
+// List returns the newest version of each template of a tenant.
+func (s *PGStore) List(ctx context.Context, tenant string) ([]Template, error) {
+	return s.many(ctx, `SELECT DISTINCT ON (name) `+templateColumns+` FROM `+s.table+` WHERE tenant = $1 ORDER BY name, version DESC`, tenant)
+}
+
+// Save stores t as the next version. Concurrent saves of the same template
+// conflict on the primary key and one of them fails.
+func (s *PGStore) Save(ctx context.Context, t Template) (Template, error) {
+	variables, err := json.Marshal(nonNil(t.Variables))
+	if err != nil {
+		return Template{}, err
+	}
+	err = s.pool.QueryRow(ctx, `INSERT INTO `+s.table+` (`+templateColumns+`)
+SELECT $1, $2, COALESCE(MAX(version), 0) + 1, $3, $4, $5, $6, $7, $8, $9 FROM `+s.table+` WHERE tenant = $1 AND name = $2
+RETURNING version`,
+		t.Tenant, t.Name, t.Lang, t.Query, t.Step, t.Description, variables, t.CreatedBy, t.CreatedAt).Scan(&t.Version)
+	return t, err
+}
+
+// Delete removes all versions of a template.
+func (s *PGStore) Delete(ctx context.Context, tenant, name string) error {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM `+s.table+` WHERE tenant = $1 AND name = $2`, tenant, name)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PGStore) one(ctx context.Context, sql string, args ...any) (Template, error) {
+	t, err := scanTemplate(s.pool.QueryRow(ctx, sql, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Template{}, ErrNotFound
+	}
+	return t, err
+}
+
+func (s *PGStore) many(ctx context.Context, sql string, args ...any) ([]Template, error) {
+	rows, err := s.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Template{}
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+func scanTemplate(row pgx.Row) (Template, error) {
+	var t Template
+	var variables []byte
+	if err := row.Scan(&t.Tenant, &t.Name, &t.Version, &t.Lang, &t.Query, &t.Step, &t.Description, &variables, &t.CreatedBy, &t.CreatedAt); err != nil {
+		return Template{}, err
+	}
+	if err := json.Unmarshal(variables, &t.Variables); err != nil {
+		return Template{}, fmt.Errorf("decode variables of template %s: %w", t.Name, err)
+	}
+	return t, nil
+}
+
+func nonNil(vars []Variable) []Variable {
+	if vars == nil {
+		return []Variable{}
+	}
+	return vars
+}
+
+// MemoryStore keeps templates in memory, for tests and single-replica
+// development setups.
+type MemoryStore struct {
+	mu        sync.Mutex
+	templates map[[2]string][]Template
+}
+
+// NewMemoryStore returns an empty in-memory template store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{templates: make(map[[2]string][]Template)}
+}
+
+// Latest returns the newest version of a template.
+func (s *MemoryStore) Latest(_ context.Context, tenant, name string) (Template, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	versions := s.templates[[2]string{tenant, name}]
+	if len(versions) == 0 {
+		return Template{}, ErrNotFound
+	}
+	return versions[len(versions)-1], nil
+}
+
+// Version returns a specific version of a template.
+func (s *MemoryStore) Version(_ context.Context, tenant, name string, version int) (Template, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	versions := s.templates[[2]string{tenant, name}]
+	if version < 1 || version > len(versions) {
+		return Template{}, ErrNotFound
+	}
+	return versions[version-1], nil
+}
+
+// Versions returns all versions of a template, oldest first.
+func (s *MemoryStore) Versions(_ context.Context, tenant, name string) ([]Template, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	versions := s.templates[[2]string{tenant, name}]
+	if len(versions) == 0 {
+		return nil, ErrNotFound
+	}
+	return append([]Template(nil), versions...), nil
+}
+
+// List returns the newest version of each template of a tenant.
+func (s *MemoryStore) List(_ context.Context, tenant string) ([]Template, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []Template{}
+	for key, versions := range s.templates {
+		if key[0] == tenant {
+			out = append(out, versions[len(versions)-1])
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// Save stores t as the next version.
+func (s *MemoryStore) Save(_ context.Context, t Template) (Template, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := [2]string{t.Tenant, t.Name}
+	t.Version = len(s.templates[key]) + 1
+	s.templates[key] = append(s.templates[key], t)
+	return t, nil
+}
+
+// Delete removes all versions of a template.
+func (s *MemoryStore) Delete(_ context.Context, tenant, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := [2]string{tenant, name}
+	if _, ok := s.templates[key]; !ok {
+		return ErrNotFound
+	}
+	delete(s.templates, key)
+	return nil
+}
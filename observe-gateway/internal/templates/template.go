@@ -0,0 +1,360 @@
+// Package templates renders named query templates with typed variables.
+// Variable values are validated against their type and escaped for the
+// position they fill in the target query language, so a value can never
+// change the structure of the query it is substituted into.
+package templates
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/xscopehub/observe-gateway/internal/config"
+)
+
+// Variable types.
+const (
+	// TypeLabelValue is an arbitrary string, allowed only inside a
+	// double-quoted string literal.
+	TypeLabelValue = "label_value"
+	// TypeRegex is an RE2 regular expression, allowed only inside a
+	// double-quoted string literal.
+	TypeRegex = "regex"
+	// TypeDuration is a Prometheus-style duration such as 5m or 1h30m.
+	TypeDuration = "duration"
+	// TypeEnum is one of the declared values.
+	TypeEnum = "enum"
+)
+
+// ErrNotFound is returned for unknown templates and versions.
+var ErrNotFound = errors.New("template not found")
+
+var (
+	namePattern      = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+	variablePattern  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	durationPattern  = regexp.MustCompile(`^([0-9]+(ms|[smhdwy]))+$`)
+	rawEnumPattern   = regexp.MustCompile(`^[A-Za-z0-9_:.]+$`)
+	placeholderRegex = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+)
+
+// Template is a named query with typed variables. Global templates, from
+// the configuration file, have an empty Tenant.
+type Template struct {
+	Tenant      string     `json:"tenant,omitempty"`
+	Name        string     `json:"name"`
+	Version     int        `json:"version"`
+	Lang        string     `json:"lang"`
+	Query       string     `json:"query"`
+	Step        string     `json:"step,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Variables   []Variable `json:"variables,omitempty"`
+	CreatedBy   string     `json:"created_by,omitempty"`
+	CreatedAt   time.Time  `json:"created_at,omitzero"`
+}
+
+// Variable declares a template variable. Placeholders without a declaration
+// are typed by position: label_value inside a string literal, duration
+// elsewhere.
+type Variable struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Values      []string `json:"values,omitempty"`
+	Default     string   `json:"default,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+// Rendered is a template with its variables substituted.
+type Rendered struct {
+	Lang  string `json:"lang"`
+	Query string `json:"query"`
+	Step  string `json:"step,omitempty"`
+}
+
+// Error reports an invalid template definition or variable value.
+type Error struct {
+	Template string
+	Variable string
+	Err      error
+}
+
+func (e *Error) Error() string {
+	if e.Variable == "" {
+		return fmt.Sprintf("template %s: %v", e.Template, e.Err)
+	}
+	return fmt.Sprintf("template %s: variable %s: %v", e.Template, e.Variable, e.Err)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// position is where a placeholder appears.
+type position int
+
+const (
+	bare position = iota
+	doubleQuoted
+	otherQuoted
+)
+
+type placeholder struct {
+	name       string
+	start, end int
+	pos        position
+}
+
+// Validate checks the template definition, including that each variable is
+// only used where its values can be substituted safely.
+func (t Template) Validate() error {
+	fail := func(variable string, format string, args ...any) error {
+		return &Error{Template: t.Name, Variable: variable, Err: fmt.Errorf(format, args...)}
+	}
+	if !namePattern.MatchString(t.Name) {
+		return fail("", "invalid name %q", t.Name)
+	}
+	switch t.Lang {
+	case "promql", "logql", "traceql":
+	default:
+		return fail("", "unsupported lang %q", t.Lang)
+	}
+	if strings.TrimSpace(t.Query) == "" {
+		return fail("", "query is required")
+	}
+
+	declared := make(map[string]Variable, len(t.Variables))
+	for _, v := range t.Variables {
+		if !variablePattern.MatchString(v.Name) {
+			return fail(v.Name, "invalid name")
+		}
+		if _, ok := declared[v.Name]; ok {
+			return fail(v.Name, "declared twice")
+		}
+		switch v.Type {
+		case TypeLabelValue, TypeRegex, TypeDuration:
+			if len(v.Values) > 0 {
+				return fail(v.Name, "values are only allowed for enum variables")
+			}
+		case TypeEnum:
+			if len(v.Values) == 0 {
+				return fail(v.Name, "enum requires values")
+			}
+		default:
+			return fail(v.Name, "unsupported type %q", v.Type)
+		}
+		if v.Default != "" {
+			if err := v.check(v.Default); err != nil {
+				return fail(v.Name, "default: %v", err)
+			}
+		}
+		declared[v.Name] = v
+	}
+
+	uses := append(scan(t.Query), stepPlaceholders(t.Step)...)
+	for _, p := range uses {
+		v := t.variable(declared, p)
+		switch {
+		case p.pos == otherQuoted:
+			return fail(p.name, "placeholders are only supported in double-quoted strings")
+		case (v.Type == TypeLabelValue || v.Type == TypeRegex) && p.pos != doubleQuoted:
+			return fail(p.name, "%s variables must be inside a double-quoted string", v.Type)
+		case v.Type == TypeEnum && p.pos == bare:
+			for _, value := range v.Values {
+				if !rawEnumPattern.MatchString(value) {
+					return fail(p.name, "enum value %q must be quoted", value)
+				}
+			}
+		}
+	}
+	if t.Step != "" && len(stepPlaceholders(t.Step)) == 0 && !durationPattern.MatchString(t.Step) {
+		return fail("", "invalid step %q", t.Step)
+	}
+	return nil
+}
+
+// Render substitutes values into the template. Variables without a value
+// use their default; a missing value without a default is an error.
+func (t Template) Render(values map[string]string) (Rendered, error) {
+	declared := make(map[string]Variable, len(t.Variables))
+	for _, v := range t.Variables {
+		declared[v.Name] = v
+	}
+	query, err := t.render(t.Query, scan(t.Query), declared, values)
+	if err != nil {
+		return Rendered{}, err
+	}
+	step, err := t.render(t.Step, stepPlaceholders(t.Step), declared, values)
+	if err != nil {
+		return Rendered{}, err
+	}
+	return Rendered{Lang: t.Lang, Query: query, Step: step}, nil
+}
+
+func (t Template) render(input string, uses []placeholder, declared map[string]Variable, values map[string]string) (string, error) {
+	if len(uses) == 0 {
+		return input, nil
+	}
+	var b strings.Builder
+	last := 0
+	for _, p := range uses {
+		v := t.variable(declared, p)
+		value, ok := values[p.name]
+		if !ok {
+			value, ok = v.Default, v.Default != ""
+		}
+		if !ok {
+			return "", &Error{Template: t.Name, Variable: p.name, Err: errors.New("value is required")}
+		}
+		if err := v.check(value); err != nil {
+			return "", &Error{Template: t.Name, Variable: p.name, Err: err}
+		}
+		b.WriteString(input[last:p.start])
+		if p.pos == doubleQuoted {
+			b.WriteString(escapeString(t.Lang, value))
+		} else {
+			b.WriteString(value)
+		}
+		last = p.end
+	}
+	b.WriteString(input[last:])
+	return b.String(), nil
+}
+
+// variable returns the declaration for a placeholder, inferring the type of
+// undeclared ones from their position.
+func (t Template) variable(declared map[string]Variable, p placeholder) Variable {
+	if v, ok := declared[p.name]; ok {
+		return v
+	}
+	if p.pos == bare {
+		return Variable{Name: p.name, Type: TypeDuration}
+	}
+	return Variable{Name: p.name, Type: TypeLabelValue}
+}
+
+// check validates a value against the variable type.
+func (v Variable) check(value string) error {
+	switch v.Type {
+	case TypeLabelValue:
+		if !utf8.ValidString(value) {
+			return errors.New("value is not valid UTF-8")
+		}
+	case TypeRegex:
+		if _, err := regexp.Compile(value); err != nil {
+			return fmt.Errorf("invalid regex: %w", err)
+		}
+	case TypeDuration:
+		if !durationPattern.MatchString(value) {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+	case TypeEnum:
+		for _, allowed := range v.Values {
+			if value == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not one of %s", value, strings.Join(v.Values, ", "))
+	}
+	return nil
+}
+
+// escapeString escapes a value for a double-quoted string literal. PromQL,
+// LogQL and TraceQL all decode such literals with Go's escape rules.
+func escapeString(lang, value string) string {
+	switch lang {
+	case "promql", "logql", "traceql":
+		quoted := strconv.Quote(value)
+		return quoted[1 : len(quoted)-1]
+	default:
+		return value
+	}
+}
+
+// scan finds the placeholders of a query and whether each sits inside a
+// string literal.
+func scan(query string) []placeholder {
+	var out []placeholder
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		if ch == '{' && strings.HasPrefix(query[i:], "{{") {
+			if loc := placeholderRegex.FindStringSubmatchIndex(query[i:]); loc != nil && loc[0] == 0 {
+				p := placeholder{name: query[i+loc[2] : i+loc[3]], start: i, end: i + loc[1]}
+				switch quote {
+				case 0:
+					p.pos = bare
+				case '"':
+					p.pos = doubleQuoted
+				default:
+					p.pos = otherQuoted
+				}
+				out = append(out, p)
+				i = p.end - 1
+				continue
+			}
+		}
+		switch {
+		case quote == 0 && (ch == '"' || ch == '\'' || ch == '`'):
+			quote = ch
+		case quote != 0 && quote != '`' && ch == '\\':
+			i++
+		case quote != 0 && ch == quote:
+			quote = 0
+		}
+	}
+	return out
+}
+
+// stepPlaceholders finds the placeholders of a step, which is never quoted.
+func stepPlaceholders(step string) []placeholder {
+	var out []placeholder
+	for _, loc := range placeholderRegex.FindAllStringSubmatchIndex(step, -1) {
+		out = append(out, placeholder{name: step[loc[2]:loc[3]], start: loc[0], end: loc[1], pos: bare})
+	}
+	return out
+}
+
+// FromConfig converts a template from the configuration file into a global
+// template.
+func FromConfig(name string, cfg config.QueryTemplateConfig) Template {
+	t := Template{
+		Name:        name,
+		Lang:        strings.ToLower(strings.TrimSpace(cfg.Lang)),
+		Query:       cfg.Query,
+		Step:        cfg.Step,
+		Description: cfg.Description,
+	}
+	for _, v := range cfg.Variables {
+		t.Variables = append(t.Variables, Variable{
+			Name:        v.Name,
+			Type:        v.Type,
+			Values:      v.Values,
+			Default:     v.Default,
+			Description: v.Description,
+		})
+	}
+	return t
+}
+
+// Globals converts and validates the configured templates. Invalid
+// templates are left out and reported in the error.
+func Globals(cfgs map[string]config.QueryTemplateConfig) (map[string]Template, error) {
+	out := make(map[string]Template, len(cfgs))
+	var errs []error
+	names := make([]string, 0, len(cfgs))
+	for name := range cfgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t := FromConfig(name, cfgs[name])
+		if err := t.Validate(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		out[name] = t
+	}
+	return out, errors.Join(errs...)
+}
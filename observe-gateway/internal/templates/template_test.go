@@ -0,0 +1,178 @@
+package templates
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/config"
+)
+
+func TestRenderEscapesLabelValues(t *testing.T) {
+	tmpl := Template{
+		Name:  "errors",
+		Lang:  "promql",
+		Query: `sum(rate(http_requests_total{service="{{service}}"}[{{window}}]))`,
+	}
+	if err := tmpl.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	got, err := tmpl.Render(map[string]string{"service": `x"} or vector(1) or {a="`, "window": "5m"})
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	want := `sum(rate(http_requests_total{service="x\"} or vector(1) or {a=\""}[5m]))`
+	if got.Query != want {
+		t.Fatalf("query = %s, want %s", got.Query, want)
+	}
+
+	_, err = tmpl.Render(map[string]string{"service": "api", "window": "5m]) or vector(1"})
+	var tmplErr *Error
+	if !errors.As(err, &tmplErr) || tmplErr.Variable != "window" {
+		t.Fatalf("Render() error = %v, want invalid window", err)
+	}
+	if _, err := tmpl.Render(map[string]string{"window": "5m"}); err == nil {
+		t.Fatal("Render() without service error = nil, want missing value")
+	}
+}
+
+func TestRenderTypedVariables(t *testing.T) {
+	tmpl := Template{
+		Name:  "logs",
+		Lang:  "logql",
+		Query: `sum by ({{group}}) (count_over_time({service=~"{{pattern}}", level="{{level}}"}[{{window}}]))`,
+		Step:  "{{window}}",
+		Variables: []Variable{
+			{Name: "group", Type: TypeEnum, Values: []string{"service", "pod"}},
+			{Name: "pattern", Type: TypeRegex},
+			{Name: "level", Type: TypeEnum, Values: []string{"error", "warn"}, Default: "error"},
+			{Name: "window", Type: TypeDuration, Default: "1h"},
+		},
+	}
+	if err := tmpl.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	got, err := tmpl.Render(map[string]string{"group": "pod", "pattern": `api\.(v1|v2)`})
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if want := `sum by (pod) (count_over_time({service=~"api\\.(v1|v2)", level="error"}[1h]))`; got.Query != want {
+		t.Fatalf("query = %s, want %s", got.Query, want)
+	}
+	if got.Step != "1h" {
+		t.Fatalf("step = %q, want 1h", got.Step)
+	}
+
+	for name, values := range map[string]map[string]string{
+		"group":   {"group": "node", "pattern": "api"},
+		"pattern": {"group": "pod", "pattern": "api("},
+		"level":   {"group": "pod", "pattern": "api", "level": "debug"},
+	} {
+		_, err := tmpl.Render(values)
+		var tmplErr *Error
+		if !errors.As(err, &tmplErr) || tmplErr.Variable != name {
+			t.Fatalf("Render(%v) error = %v, want invalid %s", values, err, name)
+		}
+	}
+}
+
+func TestValidateRejectsUnsafePlaceholders(t *testing.T) {
+	cases := map[string]Template{
+		"unquoted label value": {
+			Name: "t", Lang: "promql", Query: `up{job={{job}}}`,
+			Variables: []Variable{{Name: "job", Type: TypeLabelValue}},
+		},
+		"single quoted": {Name: "t", Lang: "promql", Query: `up{job='{{job}}'}`},
+		"raw string":    {Name: "t", Lang: "logql", Query: "{job=`{{job}}`}"},
+		"unquoted enum value": {
+			Name: "t", Lang: "promql", Query: `sum by ({{by}}) (up)`,
+			Variables: []Variable{{Name: "by", Type: TypeEnum, Values: []string{"job) or (vector(1)"}}},
+		},
+		"unknown type": {
+			Name: "t", Lang: "promql", Query: `up{job="{{job}}"}`,
+			Variables: []Variable{{Name: "job", Type: "string"}},
+		},
+		"invalid default": {
+			Name: "t", Lang: "promql", Query: `rate(up[{{window}}])`,
+			Variables: []Variable{{Name: "window", Type: TypeDuration, Default: "soon"}},
+		},
+		"unsupported lang": {Name: "t", Lang: "sql", Query: "select 1"},
+		"invalid name":     {Name: "a b", Lang: "promql", Query: "up"},
+	}
+	for name, tmpl := range cases {
+		if err := tmpl.Validate(); err == nil {
+			t.Fatalf("%s: Validate() error = nil", name)
+		}
+	}
+}
+
+func TestGlobalsFromDefaultConfig(t *testing.T) {
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatalf("config.Load() error = %v", err)
+	}
+	globals, err := Globals(cfg.QueryTemplates)
+	if err != nil {
+		t.Fatalf("Globals() error = %v", err)
+	}
+	if len(globals) != len(cfg.QueryTemplates) {
+		t.Fatalf("globals = %d, want %d", len(globals), len(cfg.QueryTemplates))
+	}
+	got, err := globals["service_error_rate"].Render(map[string]string{"service": "checkout"})
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if !strings.Contains(got.Query, `service="checkout"`) || !strings.Contains(got.Query, "[5m]") {
+		t.Fatalf("query = %s, want service and default window", got.Query)
+	}
+}
+
+func TestRegistryVersionsAndRollback(t *testing.T) {
+	store := NewMemoryStore()
+	reg := NewRegistry(store, time.Minute)
+	ctx := context.Background()
+	tmpl := Template{Tenant: "tenant-a", Name: "errors", Lang: "promql", Query: `up{job="{{job}}"}`}
+
+	v1, err := reg.Save(ctx, tmpl)
+	if err != nil || v1.Version != 1 {
+		t.Fatalf("Save() = version %d, %v; want version 1", v1.Version, err)
+	}
+	got, found, err := reg.Lookup(ctx, "tenant-a", "errors")
+	if err != nil || !found || got.Version != 1 {
+		t.Fatalf("Lookup() = version %d, %v, %v; want version 1", got.Version, found, err)
+	}
+	if _, found, _ := reg.Lookup(ctx, "tenant-b", "errors"); found {
+		t.Fatal("Lookup(tenant-b) found another tenant's template")
+	}
+
+	tmpl.Query = `up{instance="{{job}}"}`
+	if v2, err := reg.Save(ctx, tmpl); err != nil || v2.Version != 2 {
+		t.Fatalf("Save() = version %d, %v; want version 2", v2.Version, err)
+	}
+	if got, _, _ := reg.Lookup(ctx, "tenant-a", "errors"); got.Version != 2 {
+		t.Fatalf("Lookup() after save = version %d, want 2", got.Version)
+	}
+
+	v3, err := reg.Rollback(ctx, "tenant-a", "errors", 1, "alice")
+	if err != nil || v3.Version != 3 || v3.Query != `up{job="{{job}}"}` || v3.CreatedBy != "alice" {
+		t.Fatalf("Rollback() = %+v, %v; want version 3 with the first query", v3, err)
+	}
+	versions, err := reg.Versions(ctx, "tenant-a", "errors")
+	if err != nil || len(versions) != 3 {
+		t.Fatalf("Versions() = %d, %v; want 3", len(versions), err)
+	}
+	if _, err := reg.Rollback(ctx, "tenant-a", "errors", 9, ""); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Rollback(9) error = %v, want ErrNotFound", err)
+	}
+
+	if err := reg.Delete(ctx, "tenant-a", "errors"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, found, _ := reg.Lookup(ctx, "tenant-a", "errors"); found {
+		t.Fatal("Lookup() after delete found the template")
+	}
+}
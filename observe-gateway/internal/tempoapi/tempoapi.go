@@ -0,0 +1,261 @@
+// Package tempoapi models the Tempo HTTP API responses served by the
+// gateway's Tempo facade: traces in OTLP/JSON and TraceQL search results.
+package tempoapi
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xscopehub/observe-gateway/internal/frame"
+)
+
+// resourceServiceName is the resource attribute naming a span's service.
+const resourceServiceName = "service.name"
+
+// rootNotReceived names the root of a trace whose root span is not among
+// the matched spans, as Tempo does.
+const rootNotReceived = "<root span not yet received>"
+
+// Trace is a trace in OTLP/JSON, grouped into one batch per service.
+type Trace struct {
+	Batches []ResourceSpans `json:"batches"`
+}
+
+// ResourceSpans are the spans of one resource.
+type ResourceSpans struct {
+	Resource   Resource     `json:"resource"`
+	ScopeSpans []ScopeSpans `json:"scopeSpans"`
+}
+
+// Resource describes the entity producing spans.
+type Resource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+// ScopeSpans are the spans of one instrumentation scope.
+type ScopeSpans struct {
+	Spans []Span `json:"spans"`
+}
+
+// Span is an OTLP span. IDs are hex encoded and enums are numeric, as the
+// OTLP/JSON encoding specifies.
+type Span struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []KeyValue `json:"attributes,omitempty"`
+	Status            Status     `json:"status"`
+}
+
+// Status is the status of a span: 0 unset, 1 ok, 2 error.
+type Status struct {
+	Code int `json:"code,omitempty"`
+}
+
+// KeyValue is a string attribute.
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+// AnyValue holds an attribute value. OpenObserve stores attributes as
+// strings, so only string values are produced.
+type AnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// NewTrace converts spans into an OTLP/JSON trace with one batch per
+// service. Batches are ordered by service and spans by start time.
+func NewTrace(spans []frame.Span) Trace {
+	spans = append([]frame.Span(nil), spans...)
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].Start.Before(spans[j].Start) })
+	byService := make(map[string][]Span)
+	for _, s := range spans {
+		byService[s.Service] = append(byService[s.Service], otlpSpan(s))
+	}
+	services := make([]string, 0, len(byService))
+	for service := range byService {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	trace := Trace{Batches: make([]ResourceSpans, 0, len(services))}
+	for _, service := range services {
+		resource := Resource{Attributes: []KeyValue{}}
+		if service != "" {
+			resource.Attributes = append(resource.Attributes, stringAttr(resourceServiceName, service))
+		}
+		trace.Batches = append(trace.Batches, ResourceSpans{Resource: resource, ScopeSpans: []ScopeSpans{{Spans: byService[service]}}})
+	}
+	return trace
+}
+
+func otlpSpan(s frame.Span) Span {
+	end := s.Start.Add(time.Duration(s.DurationUS) * time.Microsecond)
+	return Span{
+		TraceID:           s.TraceID,
+		SpanID:            s.SpanID,
+		ParentSpanID:      s.ParentSpanID,
+		Name:              s.Name,
+		Kind:              SpanKind(s.Kind),
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+		Attributes:        attributes(s.Attributes),
+		Status:            Status{Code: StatusCode(s.Status)},
+	}
+}
+
+// SpanKind maps an OpenObserve span kind, either the OTLP number or a name
+// such as SPAN_KIND_SERVER or server, to the OTLP enum.
+func SpanKind(kind string) int {
+	if n, err := strconv.Atoi(kind); err == nil {
+		return n
+	}
+	switch strings.TrimPrefix(strings.ToLower(kind), "span_kind_") {
+	case "internal":
+		return 1
+	case "server":
+		return 2
+	case "client":
+		return 3
+	case "producer":
+		return 4
+	case "consumer":
+		return 5
+	default:
+		return 0
+	}
+}
+
+// StatusCode maps an OpenObserve span status, either the OTLP number or a
+// name such as STATUS_CODE_ERROR or error, to the OTLP enum.
+func StatusCode(status string) int {
+	if n, err := strconv.Atoi(status); err == nil {
+		return n
+	}
+	switch strings.TrimPrefix(strings.ToLower(status), "status_code_") {
+	case "ok":
+		return 1
+	case "error":
+		return 2
+	default:
+		return 0
+	}
+}
+
+// SearchResponse is the result of a TraceQL search.
+type SearchResponse struct {
+	Traces  []TraceSearchMetadata `json:"traces"`
+	Metrics SearchMetrics         `json:"metrics"`
+}
+
+// TraceSearchMetadata summarizes a matching trace.
+type TraceSearchMetadata struct {
+	TraceID           string    `json:"traceID"`
+	RootServiceName   string    `json:"rootServiceName"`
+	RootTraceName     string    `json:"rootTraceName"`
+	StartTimeUnixNano string    `json:"startTimeUnixNano"`
+	DurationMs        int64     `json:"durationMs"`
+	SpanSet           *SpanSet  `json:"spanSet,omitempty"`
+	SpanSets          []SpanSet `json:"spanSets,omitempty"`
+}
+
+// SpanSet lists the matching spans of a trace.
+type SpanSet struct {
+	Spans   []SpanSetSpan `json:"spans"`
+	Matched int           `json:"matched"`
+}
+
+// SpanSetSpan is a matching span.
+type SpanSetSpan struct {
+	SpanID            string `json:"spanID"`
+	Name              string `json:"name,omitempty"`
+	StartTimeUnixNano string `json:"startTimeUnixNano"`
+	DurationNanos     string `json:"durationNanos"`
+}
+
+// SearchMetrics reports the work done by a search.
+type SearchMetrics struct {
+	InspectedSpans int `json:"inspectedSpans"`
+}
+
+// NewSearch summarizes the spans matched by a search into at most limit
+// traces, newest first, listing up to spansPerSet spans of each. Start,
+// duration and root of a trace are taken from its matched spans.
+func NewSearch(spans []frame.Span, limit, spansPerSet int) SearchResponse {
+	byTrace := make(map[string][]frame.Span)
+	var ids []string
+	for _, s := range spans {
+		if _, ok := byTrace[s.TraceID]; !ok {
+			ids = append(ids, s.TraceID)
+		}
+		byTrace[s.TraceID] = append(byTrace[s.TraceID], s)
+	}
+
+	traces := make([]TraceSearchMetadata, 0, len(ids))
+	starts := make(map[string]time.Time, len(ids))
+	for _, id := range ids {
+		matched := byTrace[id]
+		sort.SliceStable(matched, func(i, j int) bool { return matched[i].Start.Before(matched[j].Start) })
+		start, end := matched[0].Start, matched[0].Start
+		meta := TraceSearchMetadata{TraceID: id, RootServiceName: rootNotReceived}
+		set := SpanSet{Spans: []SpanSetSpan{}, Matched: len(matched)}
+		for _, s := range matched {
+			if e := s.Start.Add(time.Duration(s.DurationUS) * time.Microsecond); e.After(end) {
+				end = e
+			}
+			if s.ParentSpanID == "" {
+				meta.RootServiceName, meta.RootTraceName = s.Service, s.Name
+			}
+			if len(set.Spans) < spansPerSet {
+				set.Spans = append(set.Spans, SpanSetSpan{
+					SpanID:            s.SpanID,
+					Name:              s.Name,
+					StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+					DurationNanos:     strconv.FormatInt(s.DurationUS*int64(time.Microsecond), 10),
+				})
+			}
+		}
+		meta.StartTimeUnixNano = strconv.FormatInt(start.UnixNano(), 10)
+		meta.DurationMs = end.Sub(start).Milliseconds()
+		meta.SpanSet, meta.SpanSets = &set, []SpanSet{set}
+		starts[id] = start
+		traces = append(traces, meta)
+	}
+
+	sort.SliceStable(traces, func(i, j int) bool { return starts[traces[i].TraceID].After(starts[traces[j].TraceID]) })
+	if limit > 0 && len(traces) > limit {
+		traces = traces[:limit]
+	}
+	return SearchResponse{Traces: traces, Metrics: SearchMetrics{InspectedSpans: len(spans)}}
+}
+
+// attributes converts a string attribute map into sorted key-value pairs,
+// leaving out service.name, which is reported on the resource.
+func attributes(attrs map[string]string) []KeyValue {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		if k != resourceServiceName {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Strings(keys)
+	out := make([]KeyValue, len(keys))
+	for i, k := range keys {
+		out[i] = stringAttr(k, attrs[k])
+	}
+	return out
+}
+
+func stringAttr(key, value string) KeyValue {
+	return KeyValue{Key: key, Value: AnyValue{StringValue: value}}
+}
@@ -0,0 +1,16 @@
+package tempoapi
+
+import "testing"
+
+func TestSpanKindAndStatusCode(t *testing.T) {
+	for kind, want := range map[string]int{"2": 2, "SPAN_KIND_CLIENT": 3, "consumer": 5, "": 0, "bogus": 0} {
+		if got := SpanKind(kind); got != want {
+			t.Fatalf("SpanKind(%q) = %d, want %d", kind, got, want)
+		}
+	}
+	for status, want := range map[string]int{"1": 1, "STATUS_CODE_ERROR": 2, "Ok": 1, "UNSET": 0} {
+		if got := StatusCode(status); got != want {
+			t.Fatalf("StatusCode(%q) = %d, want %d", status, got, want)
+		}
+	}
+}
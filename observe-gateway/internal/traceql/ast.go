@@ -0,0 +1,261 @@
+package traceql
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Query is a parsed TraceQL query.
+type Query struct {
+	Spanset SpansetExpr
+	// Pipeline holds the raw text after the first top-level "|", e.g.
+	// "count() > 2". Pipelines are parsed for completeness only.
+	Pipeline string
+}
+
+func (q *Query) String() string {
+	if q.Pipeline == "" {
+		return q.Spanset.String()
+	}
+	return q.Spanset.String() + " | " + q.Pipeline
+}
+
+// SpansetExpr is a spanset filter or an operation combining spansets.
+type SpansetExpr interface {
+	fmt.Stringer
+	spanset()
+}
+
+// SpansetFilter selects spans matching Expr. A nil Expr matches every span.
+type SpansetFilter struct {
+	Expr FieldExpr
+}
+
+func (*SpansetFilter) spanset() {}
+
+func (f *SpansetFilter) String() string {
+	if f.Expr == nil {
+		return "{ }"
+	}
+	return "{ " + f.Expr.String() + " }"
+}
+
+// SpansetOp is an operator between two spansets.
+type SpansetOp int
+
+const (
+	SpansetAnd SpansetOp = iota
+	SpansetOr
+	SpansetChild
+	SpansetDescendant
+	SpansetParent
+	SpansetAncestor
+	SpansetSibling
+)
+
+func (op SpansetOp) String() string {
+	switch op {
+	case SpansetOr:
+		return "||"
+	case SpansetChild:
+		return ">"
+	case SpansetDescendant:
+		return ">>"
+	case SpansetParent:
+		return "<"
+	case SpansetAncestor:
+		return "<<"
+	case SpansetSibling:
+		return "~"
+	default:
+		return "&&"
+	}
+}
+
+// SpansetOperation combines two spansets. For structural operators the
+// result contains the spans of Right that stand in the relation to Left.
+type SpansetOperation struct {
+	Op    SpansetOp
+	Left  SpansetExpr
+	Right SpansetExpr
+}
+
+func (*SpansetOperation) spanset() {}
+
+func (o *SpansetOperation) String() string {
+	return "(" + o.Left.String() + " " + o.Op.String() + " " + o.Right.String() + ")"
+}
+
+// FieldExpr is a boolean expression evaluated against a single span.
+type FieldExpr interface {
+	fmt.Stringer
+	field()
+}
+
+// BinaryFieldExpr combines two field expressions with && or ||.
+type BinaryFieldExpr struct {
+	Or    bool
+	Left  FieldExpr
+	Right FieldExpr
+}
+
+func (*BinaryFieldExpr) field() {}
+
+func (e *BinaryFieldExpr) String() string {
+	op := " && "
+	if e.Or {
+		op = " || "
+	}
+	return "(" + e.Left.String() + op + e.Right.String() + ")"
+}
+
+// NotExpr negates a field expression.
+type NotExpr struct {
+	Expr FieldExpr
+}
+
+func (*NotExpr) field() {}
+
+func (e *NotExpr) String() string {
+	return "!(" + e.Expr.String() + ")"
+}
+
+// BoolLiteral is a constant true/false field expression.
+type BoolLiteral bool
+
+func (BoolLiteral) field() {}
+
+func (b BoolLiteral) String() string {
+	return strconv.FormatBool(bool(b))
+}
+
+// CompareOp is a comparison operator.
+type CompareOp int
+
+const (
+	CompareEqual CompareOp = iota
+	CompareNotEqual
+	CompareRegexp
+	CompareNotRegexp
+	CompareGreater
+	CompareGreaterEqual
+	CompareLess
+	CompareLessEqual
+)
+
+func (op CompareOp) String() string {
+	switch op {
+	case CompareNotEqual:
+		return "!="
+	case CompareRegexp:
+		return "=~"
+	case CompareNotRegexp:
+		return "!~"
+	case CompareGreater:
+		return ">"
+	case CompareGreaterEqual:
+		return ">="
+	case CompareLess:
+		return "<"
+	case CompareLessEqual:
+		return "<="
+	default:
+		return "="
+	}
+}
+
+// Comparison compares an attribute with a static value.
+type Comparison struct {
+	Attr  Attribute
+	Op    CompareOp
+	Value Static
+}
+
+func (*Comparison) field() {}
+
+func (c *Comparison) String() string {
+	return c.Attr.String() + " " + c.Op.String() + " " + c.Value.String()
+}
+
+// Scope is the attribute scope.
+type Scope int
+
+const (
+	ScopeUnscoped Scope = iota
+	ScopeSpan
+	ScopeResource
+	ScopeIntrinsic
+)
+
+// Intrinsic span fields.
+const (
+	IntrinsicName            = "name"
+	IntrinsicStatus          = "status"
+	IntrinsicStatusMessage   = "statusMessage"
+	IntrinsicKind            = "kind"
+	IntrinsicDuration        = "duration"
+	IntrinsicRootName        = "rootName"
+	IntrinsicRootServiceName = "rootServiceName"
+	IntrinsicTraceDuration   = "traceDuration"
+)
+
+var intrinsics = map[string]bool{
+	IntrinsicName: true, IntrinsicStatus: true, IntrinsicStatusMessage: true,
+	IntrinsicKind: true, IntrinsicDuration: true, IntrinsicRootName: true,
+	IntrinsicRootServiceName: true, IntrinsicTraceDuration: true,
+}
+
+// Attribute references a span attribute, resource attribute or intrinsic.
+type Attribute struct {
+	Scope Scope
+	Name  string
+}
+
+func (a Attribute) String() string {
+	switch a.Scope {
+	case ScopeSpan:
+		return "span." + a.Name
+	case ScopeResource:
+		return "resource." + a.Name
+	case ScopeIntrinsic:
+		return a.Name
+	default:
+		return "." + a.Name
+	}
+}
+
+// StaticKind is the type of a static value.
+type StaticKind int
+
+const (
+	StaticString StaticKind = iota
+	StaticNumber
+	StaticDuration
+	StaticBool
+	StaticStatus
+	StaticKindEnum
+	StaticNil
+)
+
+// Static is a typed literal on the right hand side of a comparison.
+type Static struct {
+	Kind     StaticKind
+	Raw      string
+	Number   float64
+	Duration time.Duration
+	Bool     bool
+}
+
+func (s Static) String() string {
+	if s.Kind == StaticString {
+		return strconv.Quote(s.Raw)
+	}
+	return s.Raw
+}
+
+// Span statuses and kinds accepted as bare enum values.
+var (
+	statusValues = map[string]bool{"ok": true, "error": true, "unset": true}
+	kindValues   = map[string]bool{"unspecified": true, "internal": true, "server": true, "client": true, "producer": true, "consumer": true}
+)
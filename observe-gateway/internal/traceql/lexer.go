@@ -0,0 +1,181 @@
+package traceql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// tokenKind enumerates the lexical tokens understood by the parser.
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokDuration
+	tokLBrace
+	tokRBrace
+	tokLParen
+	tokRParen
+	tokPipe
+	tokAnd     // &&
+	tokOr      // ||
+	tokNot     // !
+	tokEq      // =
+	tokNeq     // !=
+	tokRe      // =~
+	tokNre     // !~
+	tokGt      // >
+	tokGte     // >=
+	tokLt      // <
+	tokLte     // <=
+	tokDesc    // >>
+	tokAnc     // <<
+	tokSibling // ~
+)
+
+var tokenNames = map[tokenKind]string{
+	tokEOF: "end of query", tokIdent: "identifier", tokString: "string",
+	tokNumber: "number", tokDuration: "duration",
+	tokLBrace: "{", tokRBrace: "}", tokLParen: "(", tokRParen: ")", tokPipe: "|",
+	tokAnd: "&&", tokOr: "||", tokNot: "!",
+	tokEq: "=", tokNeq: "!=", tokRe: "=~", tokNre: "!~",
+	tokGt: ">", tokGte: ">=", tokLt: "<", tokLte: "<=",
+	tokDesc: ">>", tokAnc: "<<", tokSibling: "~",
+}
+
+func (k tokenKind) String() string {
+	if name, ok := tokenNames[k]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// ParseError reports a syntax error at a byte offset of the query.
+type ParseError struct {
+	Pos int
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("traceql: parse error at position %d: %s", e.Pos, e.Msg)
+}
+
+var twoCharTokens = map[string]tokenKind{
+	"&&": tokAnd, "||": tokOr,
+	"!=": tokNeq, "=~": tokRe, "!~": tokNre,
+	">=": tokGte, "<=": tokLte, ">>": tokDesc, "<<": tokAnc,
+}
+
+var singleCharTokens = map[byte]tokenKind{
+	'{': tokLBrace, '}': tokRBrace, '(': tokLParen, ')': tokRParen,
+	'|': tokPipe, '!': tokNot, '=': tokEq, '>': tokGt, '<': tokLt, '~': tokSibling,
+}
+
+func lex(input string) ([]token, error) {
+	var tokens []token
+	pos := 0
+	for {
+		for pos < len(input) {
+			r, size := utf8.DecodeRuneInString(input[pos:])
+			if !unicode.IsSpace(r) {
+				break
+			}
+			pos += size
+		}
+		if pos >= len(input) {
+			return append(tokens, token{kind: tokEOF, pos: pos}), nil
+		}
+
+		start := pos
+		ch := input[pos]
+		if pos+1 < len(input) {
+			if kind, ok := twoCharTokens[input[pos:pos+2]]; ok {
+				tokens = append(tokens, token{kind: kind, text: input[pos : pos+2], pos: start})
+				pos += 2
+				continue
+			}
+		}
+		if kind, ok := singleCharTokens[ch]; ok {
+			tokens = append(tokens, token{kind: kind, text: string(ch), pos: start})
+			pos++
+			continue
+		}
+
+		switch {
+		case ch == '"' || ch == '`':
+			text, end, err := lexString(input, pos)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokString, text: text, pos: start})
+			pos = end
+		case isDigit(ch) || (ch == '-' && pos+1 < len(input) && isDigit(input[pos+1])):
+			pos++
+			for pos < len(input) && (isDigit(input[pos]) || input[pos] == '.') {
+				pos++
+			}
+			kind := tokNumber
+			if pos < len(input) && isLetter(input[pos]) {
+				for pos < len(input) && (isLetter(input[pos]) || isDigit(input[pos]) || input[pos] == '.') {
+					pos++
+				}
+				if _, err := ParseDuration(input[start:pos]); err != nil {
+					return nil, &ParseError{Pos: start, Msg: fmt.Sprintf("invalid number literal %q", input[start:pos])}
+				}
+				kind = tokDuration
+			}
+			tokens = append(tokens, token{kind: kind, text: input[start:pos], pos: start})
+		case isLetter(ch) || ch == '_' || ch == '.':
+			pos++
+			for pos < len(input) && isAttrPart(input[pos]) {
+				pos++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: input[start:pos], pos: start})
+		default:
+			r, _ := utf8.DecodeRuneInString(input[pos:])
+			return nil, &ParseError{Pos: start, Msg: fmt.Sprintf("unexpected character %q", r)}
+		}
+	}
+}
+
+func lexString(input string, start int) (string, int, error) {
+	quote := input[start]
+	if quote == '`' {
+		end := strings.IndexByte(input[start+1:], '`')
+		if end == -1 {
+			return "", 0, &ParseError{Pos: start, Msg: "unterminated raw string"}
+		}
+		return input[start+1 : start+1+end], start + end + 2, nil
+	}
+	for pos := start + 1; pos < len(input); pos++ {
+		switch input[pos] {
+		case '\\':
+			pos++
+		case '"':
+			text, err := strconv.Unquote(input[start : pos+1])
+			if err != nil {
+				return "", 0, &ParseError{Pos: start, Msg: "invalid string literal"}
+			}
+			return text, pos + 1, nil
+		}
+	}
+	return "", 0, &ParseError{Pos: start, Msg: "unterminated string"}
+}
+
+func isDigit(ch byte) bool  { return ch >= '0' && ch <= '9' }
+func isLetter(ch byte) bool { return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') }
+
+func isAttrPart(ch byte) bool {
+	return isLetter(ch) || isDigit(ch) || ch == '_' || ch == '.' || ch == ':' || ch == '-'
+}
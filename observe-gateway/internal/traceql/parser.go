@@ -0,0 +1,335 @@
+// Package traceql implements a parser for the TraceQL query language that
+// produces an AST for the gateway translators.
+package traceql
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parse parses a TraceQL query into its AST.
+func Parse(input string) (*Query, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{input: input, tokens: tokens}
+	spanset, err := p.parseSpansetOr()
+	if err != nil {
+		return nil, err
+	}
+	q := &Query{Spanset: spanset}
+
+	switch tok := p.peek(); tok.kind {
+	case tokEOF:
+	case tokPipe:
+		q.Pipeline = strings.TrimSpace(input[tok.pos+1:])
+		if q.Pipeline == "" {
+			return nil, p.errorf(tok, "empty pipeline")
+		}
+	default:
+		return nil, p.errorf(tok, "unexpected %s after spanset", describe(tok))
+	}
+	return q, nil
+}
+
+type parser struct {
+	input  string
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) advance() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) expect(kind tokenKind) (token, error) {
+	tok := p.advance()
+	if tok.kind != kind {
+		return tok, p.errorf(tok, "expected %s, got %s", kind, describe(tok))
+	}
+	return tok, nil
+}
+
+func (p *parser) errorf(tok token, format string, args ...any) error {
+	return &ParseError{Pos: tok.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func describe(tok token) string {
+	switch tok.kind {
+	case tokEOF:
+		return tok.kind.String()
+	case tokString:
+		return "string " + strconv.Quote(tok.text)
+	default:
+		return fmt.Sprintf("%s %q", tok.kind, tok.text)
+	}
+}
+
+// Spanset operators bind, from loosest to tightest: ||, &&, structural.
+func (p *parser) parseSpansetOr() (SpansetExpr, error) {
+	left, err := p.parseSpansetAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOr {
+		p.advance()
+		right, err := p.parseSpansetAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &SpansetOperation{Op: SpansetOr, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseSpansetAnd() (SpansetExpr, error) {
+	left, err := p.parseStructural()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokAnd {
+		p.advance()
+		right, err := p.parseStructural()
+		if err != nil {
+			return nil, err
+		}
+		left = &SpansetOperation{Op: SpansetAnd, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+var structuralOps = map[tokenKind]SpansetOp{
+	tokGt:      SpansetChild,
+	tokDesc:    SpansetDescendant,
+	tokLt:      SpansetParent,
+	tokAnc:     SpansetAncestor,
+	tokSibling: SpansetSibling,
+}
+
+func (p *parser) parseStructural() (SpansetExpr, error) {
+	left, err := p.parseSpansetPrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := structuralOps[p.peek().kind]
+		if !ok {
+			return left, nil
+		}
+		p.advance()
+		right, err := p.parseSpansetPrimary()
+		if err != nil {
+			return nil, err
+		}
+		left = &SpansetOperation{Op: op, Left: left, Right: right}
+	}
+}
+
+func (p *parser) parseSpansetPrimary() (SpansetExpr, error) {
+	tok := p.advance()
+	switch tok.kind {
+	case tokLParen:
+		expr, err := p.parseSpansetOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	case tokLBrace:
+		if p.peek().kind == tokRBrace {
+			p.advance()
+			return &SpansetFilter{}, nil
+		}
+		expr, err := p.parseFieldOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRBrace); err != nil {
+			return nil, err
+		}
+		return &SpansetFilter{Expr: expr}, nil
+	default:
+		return nil, p.errorf(tok, "expected spanset, got %s", describe(tok))
+	}
+}
+
+func (p *parser) parseFieldOr() (FieldExpr, error) {
+	left, err := p.parseFieldAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOr {
+		p.advance()
+		right, err := p.parseFieldAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryFieldExpr{Or: true, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseFieldAnd() (FieldExpr, error) {
+	left, err := p.parseFieldUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokAnd {
+		p.advance()
+		right, err := p.parseFieldUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryFieldExpr{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseFieldUnary() (FieldExpr, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case tokNot:
+		p.advance()
+		expr, err := p.parseFieldUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &NotExpr{Expr: expr}, nil
+	case tokLParen:
+		p.advance()
+		expr, err := p.parseFieldOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	case tokIdent:
+		if tok.text == "true" || tok.text == "false" {
+			p.advance()
+			return BoolLiteral(tok.text == "true"), nil
+		}
+		return p.parseComparison()
+	default:
+		return nil, p.errorf(tok, "expected field expression, got %s", describe(tok))
+	}
+}
+
+var compareOps = map[tokenKind]CompareOp{
+	tokEq:  CompareEqual,
+	tokNeq: CompareNotEqual,
+	tokRe:  CompareRegexp,
+	tokNre: CompareNotRegexp,
+	tokGt:  CompareGreater,
+	tokGte: CompareGreaterEqual,
+	tokLt:  CompareLess,
+	tokLte: CompareLessEqual,
+}
+
+func (p *parser) parseComparison() (FieldExpr, error) {
+	attrTok := p.advance()
+	attr, err := parseAttribute(attrTok.text)
+	if err != nil {
+		return nil, p.errorf(attrTok, "%v", err)
+	}
+
+	opTok := p.advance()
+	op, ok := compareOps[opTok.kind]
+	if !ok {
+		return nil, p.errorf(opTok, "expected comparison operator after %s, got %s", attrTok.text, describe(opTok))
+	}
+
+	valTok := p.advance()
+	value, err := parseStatic(valTok)
+	if err != nil {
+		return nil, p.errorf(valTok, "%v", err)
+	}
+	if op == CompareRegexp || op == CompareNotRegexp {
+		if value.Kind != StaticString {
+			return nil, p.errorf(valTok, "regexp comparison requires a string")
+		}
+		if _, err := regexp.Compile(value.Raw); err != nil {
+			return nil, p.errorf(valTok, "invalid regexp %q: %v", value.Raw, err)
+		}
+	}
+	return &Comparison{Attr: attr, Op: op, Value: value}, nil
+}
+
+func parseAttribute(text string) (Attribute, error) {
+	switch {
+	case strings.HasPrefix(text, "span.") && len(text) > len("span."):
+		return Attribute{Scope: ScopeSpan, Name: text[len("span."):]}, nil
+	case strings.HasPrefix(text, "resource.") && len(text) > len("resource."):
+		return Attribute{Scope: ScopeResource, Name: text[len("resource."):]}, nil
+	case strings.HasPrefix(text, ".") && len(text) > 1:
+		return Attribute{Scope: ScopeUnscoped, Name: text[1:]}, nil
+	case strings.HasPrefix(text, "span:"), strings.HasPrefix(text, "trace:"):
+		name := text[strings.IndexByte(text, ':')+1:]
+		if intrinsics[name] {
+			return Attribute{Scope: ScopeIntrinsic, Name: name}, nil
+		}
+	case intrinsics[text]:
+		return Attribute{Scope: ScopeIntrinsic, Name: text}, nil
+	}
+	return Attribute{}, fmt.Errorf("unknown attribute %q; use span., resource. or . scopes or an intrinsic", text)
+}
+
+func parseStatic(tok token) (Static, error) {
+	switch tok.kind {
+	case tokString:
+		return Static{Kind: StaticString, Raw: tok.text}, nil
+	case tokNumber:
+		n, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return Static{}, fmt.Errorf("invalid number %q", tok.text)
+		}
+		return Static{Kind: StaticNumber, Raw: tok.text, Number: n}, nil
+	case tokDuration:
+		d, err := ParseDuration(tok.text)
+		if err != nil {
+			return Static{}, err
+		}
+		return Static{Kind: StaticDuration, Raw: tok.text, Duration: d}, nil
+	case tokIdent:
+		switch {
+		case tok.text == "true" || tok.text == "false":
+			return Static{Kind: StaticBool, Raw: tok.text, Bool: tok.text == "true"}, nil
+		case tok.text == "nil":
+			return Static{Kind: StaticNil, Raw: tok.text}, nil
+		case statusValues[tok.text]:
+			return Static{Kind: StaticStatus, Raw: tok.text}, nil
+		case kindValues[tok.text]:
+			return Static{Kind: StaticKindEnum, Raw: tok.text}, nil
+		}
+	}
+	return Static{}, fmt.Errorf("expected value, got %s", describe(tok))
+}
+
+// ParseDuration parses Go style durations and the d unit.
+func ParseDuration(s string) (time.Duration, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		if err == nil {
+			return time.Duration(n * float64(24*time.Hour)), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid duration %q", s)
+}
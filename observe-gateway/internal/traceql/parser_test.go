@@ -0,0 +1,81 @@
+package traceql
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseSpansetFilter(t *testing.T) {
+	q, err := Parse(`{ span.http.status_code >= 500 && duration > 2s || resource.service.name = "api" }`)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	filter, ok := q.Spanset.(*SpansetFilter)
+	if !ok {
+		t.Fatalf("spanset = %T, want *SpansetFilter", q.Spanset)
+	}
+	or, ok := filter.Expr.(*BinaryFieldExpr)
+	if !ok || !or.Or {
+		t.Fatalf("expr = %s, want ||", filter.Expr)
+	}
+	and := or.Left.(*BinaryFieldExpr)
+	status := and.Left.(*Comparison)
+	if status.Attr != (Attribute{Scope: ScopeSpan, Name: "http.status_code"}) || status.Op != CompareGreaterEqual || status.Value.Number != 500 {
+		t.Fatalf("status comparison = %s", status)
+	}
+	duration := and.Right.(*Comparison)
+	if duration.Attr.Scope != ScopeIntrinsic || duration.Value.Duration != 2*time.Second {
+		t.Fatalf("duration comparison = %s", duration)
+	}
+	if svc := or.Right.(*Comparison); svc.Attr.Scope != ScopeResource || svc.Value.Raw != "api" {
+		t.Fatalf("service comparison = %s", svc)
+	}
+}
+
+func TestParseStructuralOperators(t *testing.T) {
+	q, err := Parse(`{ resource.service.name = "api" } >> { kind = client && status = error } && { name = "db" }`)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	and, ok := q.Spanset.(*SpansetOperation)
+	if !ok || and.Op != SpansetAnd {
+		t.Fatalf("spanset = %s, want &&", q.Spanset)
+	}
+	desc, ok := and.Left.(*SpansetOperation)
+	if !ok || desc.Op != SpansetDescendant {
+		t.Fatalf("left = %s, want >>", and.Left)
+	}
+	cmp := desc.Right.(*SpansetFilter).Expr.(*BinaryFieldExpr).Left.(*Comparison)
+	if cmp.Value.Kind != StaticKindEnum || cmp.Value.Raw != "client" {
+		t.Fatalf("kind value = %+v", cmp.Value)
+	}
+}
+
+func TestParsePipeline(t *testing.T) {
+	q, err := Parse(`{ } | count() > 2`)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if q.Pipeline != "count() > 2" {
+		t.Fatalf("pipeline = %q", q.Pipeline)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, input := range []string{
+		``,
+		`FROM api WHERE error=true`,
+		`{ span.x = }`,
+		`{ foo = "bar" }`,
+		`{ span.x =~ "(" }`,
+		`{ span.x = "a" `,
+		`{ span.x = "a" } {`,
+	} {
+		_, err := Parse(input)
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Errorf("Parse(%q) error = %v, want *ParseError", input, err)
+		}
+	}
+}